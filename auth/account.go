@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+var (
+	ErrAccountSuspended   = errors.New("account is suspended")
+	ErrAccountDeactivated = errors.New("account is deactivated")
+	// ErrInvalidAccountChange is returned for a change that makes no sense,
+	// such as a suspension that has already ended.
+	ErrInvalidAccountChange = errors.New("invalid account change")
+)
+
+// Codes sent with a rejected account, for clients to tell the cases apart.
+const (
+	CodeAccountSuspended   = "account_suspended"
+	CodeAccountDeactivated = "account_deactivated"
+)
+
+// AccountError says why an account cannot be used. It wraps
+// ErrAccountSuspended or ErrAccountDeactivated.
+type AccountError struct {
+	err   error
+	state model.AccountState
+}
+
+func (e AccountError) Error() string {
+	if errors.Is(e.err, ErrAccountSuspended) {
+		msg := e.err.Error()
+		if e.state.SuspendedUntil != nil {
+			msg += " until " + e.state.SuspendedUntil.UTC().Format(time.RFC3339)
+		}
+		if e.state.SuspendedReason != "" {
+			msg += ". " + e.state.SuspendedReason
+		}
+		return msg
+	}
+	return e.err.Error()
+}
+
+func (e AccountError) Unwrap() error {
+	return e.err
+}
+
+// Code is CodeAccountSuspended or CodeAccountDeactivated.
+func (e AccountError) Code() string {
+	if errors.Is(e.err, ErrAccountSuspended) {
+		return CodeAccountSuspended
+	}
+	return CodeAccountDeactivated
+}
+
+// CheckAccount returns an AccountError unless the account is active as of
+// now.
+func CheckAccount(state model.AccountState, now time.Time) error {
+	switch state.Status(now) {
+	case model.AccountSuspended:
+		return AccountError{err: ErrAccountSuspended, state: state}
+	case model.AccountDeactivated:
+		return AccountError{err: ErrAccountDeactivated, state: state}
+	}
+	return nil
+}
+
+// RespondWithAccountError answers a request from an account CheckAccount
+// refused with 403 and the error's code.
+func RespondWithAccountError(w http.ResponseWriter, err error) {
+	accountErr := AccountError{}
+	if !errors.As(err, &accountErr) {
+		panic(fmt.Sprintf("not an account error. %s", err))
+	}
+	request.RespondWithErrorCode(w, http.StatusForbidden, accountErr.Code(), accountErr.Error())
+}
+
+// AccountService suspends, reactivates and deactivates accounts, and records
+// each change in the security log.
+type AccountService struct {
+	users    dao.UserRepository
+	sessions dao.SessionRepository
+	refresh  dao.RefreshTokenRepository
+	events   dao.SecurityRepository
+	now      func() time.Time
+}
+
+func NewAccountService(users dao.UserRepository, sessions dao.SessionRepository, refresh dao.RefreshTokenRepository, events dao.SecurityRepository) *AccountService {
+	return &AccountService{
+		users:    users,
+		sessions: sessions,
+		refresh:  refresh,
+		events:   events,
+		now:      time.Now,
+	}
+}
+
+// Suspend shuts the user out until the time given, or until they are
+// reactivated when until is nil. Their sessions are kept, and work again once
+// the suspension ends.
+func (s *AccountService) Suspend(ctx context.Context, userID string, actorID string, reason string, until *time.Time) error {
+	if until != nil && !until.After(s.now()) {
+		return fmt.Errorf("suspension must end in the future. %w", ErrInvalidAccountChange)
+	}
+
+	return s.change(ctx, userID, actorID, model.SecurityEventAccountSuspended, func() error {
+		return s.users.SuspendUser(ctx, userID, reason, until)
+	})
+}
+
+// Reactivate lifts a suspension or undoes a deactivation.
+func (s *AccountService) Reactivate(ctx context.Context, userID string, actorID string) error {
+	return s.change(ctx, userID, actorID, model.SecurityEventAccountReactivated, func() error {
+		return s.users.ReactivateUser(ctx, userID)
+	})
+}
+
+// Deactivate closes the user's account and revokes their sessions and refresh
+// tokens. Only an admin can reactivate it. It returns how many were revoked.
+func (s *AccountService) Deactivate(ctx context.Context, userID string) (int, error) {
+	err := s.change(ctx, userID, userID, model.SecurityEventAccountDeactivated, func() error {
+		return s.users.DeactivateUser(ctx, userID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := s.sessions.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to revoke sessions. %w", err)
+	}
+
+	tokens, err := s.refresh.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to revoke refresh tokens. %w", err)
+	}
+
+	return n + tokens, nil
+}
+
+func (s *AccountService) change(ctx context.Context, userID string, actorID string, kind string, f func() error) error {
+	return s.users.WithTx(ctx, func(ctx context.Context) error {
+		user, err := s.users.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := f(); err != nil {
+			return err
+		}
+
+		_, err = s.events.InsertSecurityEvent(ctx, model.SecurityEvent{
+			ID:      newSecurityEventID(),
+			Kind:    kind,
+			UserID:  user.ID,
+			Subject: normalizeUsername(user.Username),
+			ActorID: actorID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert security event. %w", err)
+		}
+
+		slog.InfoContext(ctx, "changed account state", "kind", kind, "user_id", user.ID, "actor_id", actorID)
+		return nil
+	})
+}
+
+// checkAccount stops the request if the account cannot be used.
+func checkAccount(w http.ResponseWriter, r *http.Request, userID string, state model.AccountState, now time.Time) bool {
+	err := CheckAccount(state, now)
+	if err == nil {
+		return true
+	}
+
+	ctx := r.Context()
+	slog.WarnContext(ctx, "refused request from inactive account", "user_id", userID, "err", err)
+	stop(r, ctx)
+	RespondWithAccountError(w, err)
+	return false
+}
@@ -0,0 +1,95 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAccount(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	assert.NoError(t, CheckAccount(model.AccountState{IsActive: true}, now))
+	assert.NoError(t, CheckAccount(model.AccountState{IsActive: true, IsSuspended: true, SuspendedUntil: &earlier}, now))
+	assert.ErrorIs(t, CheckAccount(model.AccountState{IsActive: false}, now), ErrAccountDeactivated)
+
+	err := CheckAccount(model.AccountState{IsActive: true, IsSuspended: true, SuspendedReason: "spam", SuspendedUntil: &later}, now)
+	assert.ErrorIs(t, err, ErrAccountSuspended)
+	assert.Contains(t, err.Error(), "spam")
+	assert.Contains(t, err.Error(), later.UTC().Format(time.RFC3339))
+
+	w := httptest.NewRecorder()
+	RespondWithAccountError(w, err)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	body := map[string]string{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, CodeAccountSuspended, body["code"])
+}
+
+func TestAccountService(t *testing.T) {
+	ctx := context.Background()
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+	repo := dao.NewMemory()
+	accounts := NewAccountService(repo, repo, repo, repo)
+	now := time.Now()
+	accounts.now = func() time.Time { return now }
+	tokens := NewTokenService(repo, repo, TokenConfig{})
+	sessions := NewPostgresSessionStore(repo, repo, 0)
+	store := NewBearerSessionStore(sessions, tokens, repo, repo, repo, repo)
+
+	civilian, err := repo.GetRoleByName(ctx, "CIVILIAN")
+	assert.NoError(t, err)
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c", Roles: []model.Role{civilian}})
+	assert.NoError(t, err)
+	cookie := login(t, sessions, alice)
+	pair, err := tokens.Issue(ctx, alice)
+	assert.NoError(t, err)
+
+	t.Run("suspension ends live sessions", func(t *testing.T) {
+		past := now.Add(-time.Minute)
+		assert.ErrorIs(t, accounts.Suspend(ctx, alice.ID, "user_admin", "spam", &past), ErrInvalidAccountChange)
+		assert.ErrorIs(t, accounts.Suspend(ctx, "user_nobody", "user_admin", "spam", nil), dao.ErrUserNotFound)
+
+		assert.NoError(t, accounts.Suspend(ctx, alice.ID, "user_admin", "spam", nil))
+		code, _ := verify(sessions, cookie, alice.ID)
+		assert.Equal(t, http.StatusForbidden, code)
+		code, _ = verifyBearer(store, pair.AccessToken, alice.ID)
+		assert.Equal(t, http.StatusForbidden, code)
+		_, err := tokens.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrAccountSuspended)
+
+		// reactivating lets the same session back in
+		assert.NoError(t, accounts.Reactivate(ctx, alice.ID, "user_admin"))
+		code, _ = verify(sessions, cookie, alice.ID)
+		assert.Equal(t, http.StatusOK, code)
+		code, _ = verifyBearer(store, pair.AccessToken, alice.ID)
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("deactivation revokes sessions", func(t *testing.T) {
+		n, err := accounts.Deactivate(ctx, alice.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		code, _ := verify(sessions, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = verifyBearer(store, pair.AccessToken, alice.ID)
+		assert.Equal(t, http.StatusForbidden, code)
+
+		state, err := repo.GetAccountState(ctx, alice.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, model.AccountDeactivated, state.Status(now))
+	})
+}
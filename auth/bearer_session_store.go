@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+// BearerSessionStore lets requests authenticate with an access token in the
+// Authorization header instead of the session cookie. The header may also hold
+// a personal access token. Requests without one are handled by the wrapped
+// store, which also starts and ends sessions.
+type BearerSessionStore struct {
+	SessionStore
+	tokens  *TokenService
+	pats    dao.PersonalAccessTokenRepository
+	users   dao.UserRepository
+	roles   dao.RoleRepository
+	clients dao.OAuthRepository
+	now     func() time.Time
+}
+
+var _ SessionStore = (*BearerSessionStore)(nil)
+
+func NewBearerSessionStore(store SessionStore, tokens *TokenService, pats dao.PersonalAccessTokenRepository, users dao.UserRepository, roles dao.RoleRepository, clients dao.OAuthRepository) *BearerSessionStore {
+	return &BearerSessionStore{
+		SessionStore: store,
+		tokens:       tokens,
+		pats:         pats,
+		users:        users,
+		roles:        roles,
+		clients:      clients,
+		now:          time.Now,
+	}
+}
+
+// VerifySession fills in the same user and roles from an access token as the
+// wrapped store does from a session. Access tokens outlive a suspension or a
+// change of roles, so the account and its permissions are looked up on every
+// request. Tokens issued to OAuth clients are held to their scopes and the
+// user's own resources, and stop working as soon as the user revokes the app.
+func (store *BearerSessionStore) VerifySession(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		store.SessionStore.VerifySession(w, r)
+		return
+	}
+	if isPersonalAccessToken(token) {
+		store.verifyPersonalAccessToken(w, r, token)
+		return
+	}
+
+	ctx := r.Context()
+	claims, err := store.tokens.Verify(ctx, token)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to verify access token", "err", err)
+		r = stop(r, ctx)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		return
+	}
+
+	state, err := store.users.GetAccountState(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, dao.ErrUserNotFound) {
+			slog.WarnContext(ctx, "access token for unknown user", "user_id", claims.Subject)
+			r = stop(r, ctx)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+			return
+		}
+		slog.ErrorContext(ctx, "failed to get account state", "user_id", claims.Subject, "err", err)
+		r = stop(r, ctx)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !checkAccount(w, r, claims.Subject, state, store.now()) {
+		return
+	}
+	var scopes []string
+	if claims.ClientID != "" {
+		if !store.checkGrant(w, r, claims) {
+			return
+		}
+		scopes = strings.Fields(claims.Scope)
+		if !requireScope(w, r, scopes) {
+			return
+		}
+	}
+	permissions, err := store.roles.GetUserPermissions(ctx, claims.Subject)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get permissions", "user_id", claims.Subject, "err", err)
+		r = stop(r, ctx)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if claims.ClientID != "" {
+		permissions = ownPermissions(permissions)
+	}
+	if !authorize(w, r, claims.Subject, claims.Roles, permissions) {
+		return
+	}
+	if scopes != nil {
+		request.GetRequestContext(ctx).Scopes = scopes
+	}
+
+	slog.InfoContext(ctx, "The cake is a lie!")
+}
+
+// checkGrant stops the request when the user has revoked the client's access.
+func (store *BearerSessionStore) checkGrant(w http.ResponseWriter, r *http.Request, claims AccessClaims) bool {
+	ctx := r.Context()
+	_, err := store.clients.GetOAuthGrant(ctx, claims.Subject, claims.ClientID)
+	if errors.Is(err, dao.ErrOAuthGrantNotFound) {
+		slog.WarnContext(ctx, "access token for revoked app", "user_id", claims.Subject, "client_id", claims.ClientID)
+		r = stop(r, ctx)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get oauth grant", "user_id", claims.Subject, "client_id", claims.ClientID, "err", err)
+		r = stop(r, ctx)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// ownPermissions drops the :any permissions, which reach other users.
+func ownPermissions(permissions []string) []string {
+	own := []string{}
+	for _, permission := range permissions {
+		if !strings.HasSuffix(permission, ":any") {
+			own = append(own, permission)
+		}
+	}
+	return own
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
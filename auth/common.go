@@ -1,9 +1,80 @@
 package auth
 
-import "net/http"
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
 
 type SessionStore interface {
 	EstablishSession(w http.ResponseWriter, r *http.Request)
 	VerifySession(w http.ResponseWriter, r *http.Request)
 	TerminateSession(w http.ResponseWriter, r *http.Request)
+	// StartSession logs the user in on the client making r.
+	StartSession(w http.ResponseWriter, r *http.Request, user model.User) error
+}
+
+func roleNames(user model.User) []string {
+	roles := make([]string, len(user.Roles))
+	for i := range user.Roles {
+		roles[i] = user.Roles[i].Name
+	}
+	return roles
+}
+
+// permissionNames returns every permission the user's roles grant.
+func permissionNames(user model.User) []string {
+	permissions := []string{}
+	for _, role := range user.Roles {
+		permissions = append(permissions, role.Permissions...)
+	}
+	slices.Sort(permissions)
+	return slices.Compact(permissions)
+}
+
+// authorize lets the session's user through when they have the permission the
+// route requires. On another user's resources they need its :any form, and
+// routes that require none are closed to them. On success the request context
+// is filled in with who is calling.
+func authorize(w http.ResponseWriter, r *http.Request, sessionUserID string, roles []string, permissions []string) bool {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+	rc := request.GetRequestContext(ctx)
+
+	required := ""
+	if rc != nil {
+		required = rc.RequiredPermission
+	}
+	if !permitted(required, sessionUserID, userID, permissions) {
+		slog.ErrorContext(ctx, "INTRUDER!", "session_user_id", sessionUserID, "client_user_id", userID, "permission", required)
+		r = stop(r, ctx)
+		http.Error(w, "FUCK OFF!", http.StatusForbidden)
+		return false
+	}
+
+	if rc != nil {
+		rc.UserID = sessionUserID
+		rc.RealUserID = sessionUserID
+		rc.ClientUserID = userID
+		rc.Roles = roles
+		rc.Permissions = permissions
+	}
+
+	return true
+}
+
+func permitted(required string, sessionUserID string, userID string, permissions []string) bool {
+	other := userID != "" && userID != sessionUserID
+	if required == "" {
+		return !other
+	}
+	if other {
+		required = model.AnyPermission(required)
+	}
+	return slices.Contains(permissions, required)
 }
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/slham/sandbox-api/crypt"
+)
+
+var ErrInvalidCSRFToken = errors.New("missing or invalid csrf token")
+
+// CodeCSRF is sent when a request is refused for lacking the CSRF token.
+const CodeCSRF = "csrf"
+
+const (
+	// CSRFHeader is where browsers send the token with state-changing
+	// requests.
+	CSRFHeader = "X-CSRF-Token"
+	// csrfCookieName holds the same token, so a request only passes when it
+	// comes from a page that could read the token from /auth/csrf. The __Host-
+	// prefix keeps subdomains from setting it for the whole site.
+	csrfCookieName = "__Host-sandbox-csrf"
+	csrfPurpose    = "csrf"
+)
+
+// CSRFToken returns the browser's CSRF token for its current session, minting
+// one and setting its cookie when it has none for that session yet. The token
+// is signed over the session cookie, so a token fetched with any other
+// session, or none, is never accepted.
+func CSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	session := sessionCookieValue(r)
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && validCSRFToken(cookie.Value, session) {
+		return cookie.Value, nil
+	}
+
+	nonce, err := newSessionToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to create csrf token. %w", err)
+	}
+	token := nonce + "." + base64.RawURLEncoding.EncodeToString(csrfSignature(nonce, session))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token, nil
+}
+
+// NeedsCSRF reports whether r changes state on the strength of a session
+// cookie. Requests with a bearer token are authenticated by it rather than the
+// cookie, so they skip the check, as do requests with no session. Any other
+// Authorization scheme still falls back to the cookie and is checked.
+func NeedsCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if _, ok := bearerToken(r); ok {
+		return false
+	}
+	for _, name := range []string{cookieName, impersonatorCookieName} {
+		if _, err := r.Cookie(name); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckCSRF fails unless the request sends the token from its cookie in the
+// CSRF header too, and the token was minted for the session cookie it is sent
+// with.
+func CheckCSRF(r *http.Request) error {
+	session := sessionCookieValue(r)
+	if session == "" {
+		return ErrInvalidCSRFToken
+	}
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || !validCSRFToken(cookie.Value, session) {
+		return ErrInvalidCSRFToken
+	}
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.Header.Get(CSRFHeader))) != 1 {
+		return ErrInvalidCSRFToken
+	}
+
+	return nil
+}
+
+func validCSRFToken(token string, session string) bool {
+	nonce, sig, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(csrfSignature(nonce, session), b)
+}
+
+// csrfSignature signs the nonce together with the hash of the session cookie,
+// so the token is only good for that session.
+func csrfSignature(nonce string, session string) []byte {
+	return crypt.Sign(csrfPurpose, []byte(nonce+"."+hashSessionToken(session)))
+}
+
+func sessionCookieValue(r *http.Request) string {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
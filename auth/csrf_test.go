@@ -0,0 +1,115 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRF(t *testing.T) {
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+
+	session := &http.Cookie{Name: cookieName, Value: "session"}
+	csrfRequest := func(cookies ...*http.Cookie) *http.Request {
+		r := httptest.NewRequest("GET", "/auth/csrf", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	token, err := CSRFToken(w, csrfRequest(session))
+	assert.NoError(t, err)
+	cookie := cookieNamed(w, csrfCookieName)
+	if assert.NotNil(t, cookie) {
+		assert.Equal(t, "__Host-sandbox-csrf", cookie.Name)
+		assert.Equal(t, token, cookie.Value)
+		assert.Equal(t, "/", cookie.Path)
+		assert.Empty(t, cookie.Domain)
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	}
+
+	// the browser keeps its token until it loses the cookie
+	w = httptest.NewRecorder()
+	again, err := CSRFToken(w, csrfRequest(session, cookie))
+	assert.NoError(t, err)
+	assert.Equal(t, token, again)
+	assert.Nil(t, cookieNamed(w, csrfCookieName))
+
+	// or starts another session, such as by logging in
+	w = httptest.NewRecorder()
+	other := &http.Cookie{Name: cookieName, Value: "other"}
+	otherToken, err := CSRFToken(w, csrfRequest(other, cookie))
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, otherToken)
+	otherCookie := cookieNamed(w, csrfCookieName)
+	assert.NotNil(t, otherCookie)
+
+	// anyone can fetch a token without a session, but it is good for none
+	w = httptest.NewRecorder()
+	anonToken, err := CSRFToken(w, csrfRequest())
+	assert.NoError(t, err)
+	anonCookie := cookieNamed(w, csrfCookieName)
+
+	impersonation := &http.Cookie{Name: cookieName, Value: "impersonation"}
+	w = httptest.NewRecorder()
+	impersonationToken, err := CSRFToken(w, csrfRequest(impersonation))
+	assert.NoError(t, err)
+	impersonationCookie := cookieNamed(w, csrfCookieName)
+	admin := &http.Cookie{Name: impersonatorCookieName, Value: "admin"}
+
+	tables := []struct {
+		name    string
+		method  string
+		cookies []*http.Cookie
+		header  http.Header
+		needs   bool
+		valid   bool
+	}{
+		{"safe method", "GET", []*http.Cookie{session}, nil, false, false},
+		{"no session", "POST", nil, nil, false, false},
+		{"bearer", "POST", []*http.Cookie{session}, http.Header{"Authorization": {"Bearer abc"}}, false, false},
+		{"basic", "POST", []*http.Cookie{session}, http.Header{"Authorization": {"Basic abc"}}, true, false},
+		{"empty bearer", "POST", []*http.Cookie{session}, http.Header{"Authorization": {"Bearer "}}, true, false},
+		{"no token", "POST", []*http.Cookie{session, cookie}, nil, true, false},
+		{"no cookie", "DELETE", []*http.Cookie{session}, http.Header{CSRFHeader: {token}}, true, false},
+		{"wrong token", "PATCH", []*http.Cookie{session, cookie}, http.Header{CSRFHeader: {token + "x"}}, true, false},
+		{"planted cookie", "POST", []*http.Cookie{session, {Name: csrfCookieName, Value: "abc.def"}}, http.Header{CSRFHeader: {"abc.def"}}, true, false},
+		{"other session's token", "POST", []*http.Cookie{session, otherCookie}, http.Header{CSRFHeader: {otherToken}}, true, false},
+		{"token for another session", "POST", []*http.Cookie{other, cookie}, http.Header{CSRFHeader: {token}}, true, false},
+		{"token without a session", "POST", []*http.Cookie{session, anonCookie}, http.Header{CSRFHeader: {anonToken}}, true, false},
+		{"old cookie name", "POST", []*http.Cookie{session, {Name: "sandbox-csrf", Value: token}}, http.Header{CSRFHeader: {token}}, true, false},
+		{"impersonator cookie only", "POST", []*http.Cookie{admin, impersonationCookie}, http.Header{CSRFHeader: {impersonationToken}}, true, false},
+		{"impersonating", "POST", []*http.Cookie{impersonation, admin, impersonationCookie}, http.Header{CSRFHeader: {impersonationToken}}, true, true},
+		{"valid", "PUT", []*http.Cookie{session, cookie}, http.Header{CSRFHeader: {token}}, true, true},
+		{"valid for other session", "POST", []*http.Cookie{other, otherCookie}, http.Header{CSRFHeader: {otherToken}}, true, true},
+	}
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/users/user_1", nil)
+			for _, c := range tt.cookies {
+				r.AddCookie(c)
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v[0])
+			}
+			assert.Equal(t, tt.needs, NeedsCSRF(r))
+			if tt.needs {
+				if tt.valid {
+					assert.NoError(t, CheckCSRF(r))
+				} else {
+					assert.ErrorIs(t, CheckCSRF(r), ErrInvalidCSRFToken)
+				}
+			}
+		})
+	}
+}
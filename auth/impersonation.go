@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+var (
+	ErrImpersonationNotAllowed = errors.New("impersonation not allowed")
+	ErrNotImpersonating        = errors.New("not impersonating anyone")
+)
+
+// CodeImpersonating is sent when a request is refused because it is made
+// during an impersonation.
+const CodeImpersonating = "impersonating"
+
+const (
+	defaultImpersonationTTL = 30 * time.Minute
+	// impersonatorCookieName holds the admin's own session token during an
+	// impersonation. It is only sent to the endpoint that ends it.
+	impersonatorCookieName = "sandbox-impersonator"
+	impersonatorCookiePath = "/auth/impersonation"
+)
+
+// ImpersonationService lets admins act as another user, to see what they
+// see. An impersonation is a session of the user's that also records the
+// admin, and lasts a fixed time however much it is used. Starting and ending
+// one are written to the security log.
+type ImpersonationService struct {
+	sessions dao.SessionRepository
+	roles    dao.RoleRepository
+	events   dao.SecurityRepository
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+func NewImpersonationService(sessions dao.SessionRepository, roles dao.RoleRepository, events dao.SecurityRepository) *ImpersonationService {
+	return &ImpersonationService{
+		sessions: sessions,
+		roles:    roles,
+		events:   events,
+		ttl:      defaultImpersonationTTL,
+		now:      time.Now,
+	}
+}
+
+// Start swaps the admin's session cookie for one acting as the user. The
+// admin's own cookie is kept aside for End to put back. Admins can only
+// impersonate users who have no permission they lack themselves.
+func (s *ImpersonationService) Start(w http.ResponseWriter, r *http.Request, userID string) (model.Session, error) {
+	ctx := r.Context()
+	rc := request.GetRequestContext(ctx)
+	if rc == nil || rc.UserID == "" {
+		return model.Session{}, fmt.Errorf("%w. no session", ErrImpersonationNotAllowed)
+	}
+	if request.IsImpersonating(ctx) {
+		return model.Session{}, fmt.Errorf("%w. already impersonating", ErrImpersonationNotAllowed)
+	}
+	if userID == rc.UserID {
+		return model.Session{}, fmt.Errorf("%w. cannot impersonate yourself", ErrImpersonationNotAllowed)
+	}
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || cookie.Value == "" || rc.SessionID == "" {
+		return model.Session{}, fmt.Errorf("%w. start it from a session cookie", ErrImpersonationNotAllowed)
+	}
+
+	permissions, err := s.roles.GetUserPermissions(ctx, userID)
+	if err != nil {
+		return model.Session{}, err
+	}
+	for _, permission := range permissions {
+		if !slices.Contains(rc.Permissions, permission) {
+			return model.Session{}, fmt.Errorf("%w. user has the %s permission, which you lack", ErrImpersonationNotAllowed, permission)
+		}
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		return model.Session{}, fmt.Errorf("failed to create session token. %w", err)
+	}
+
+	session, err := s.sessions.InsertSession(ctx, model.Session{
+		ID:             fmt.Sprintf("ses_%s", ksuid.New().String()),
+		TokenHash:      hashSessionToken(token),
+		UserID:         userID,
+		ImpersonatorID: rc.UserID,
+		UserAgent:      r.UserAgent(),
+		IP:             ClientIP(r),
+		Expires:        s.now().Add(s.ttl),
+	})
+	if err != nil {
+		return session, fmt.Errorf("failed to insert session. %w", err)
+	}
+
+	if err := s.record(r, model.SecurityEventImpersonationStart, userID, rc.UserID); err != nil {
+		return session, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonatorCookieName,
+		Value:    cookie.Value,
+		Path:     impersonatorCookiePath,
+		MaxAge:   int(s.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	setSessionCookie(w, token)
+
+	slog.InfoContext(ctx, "started impersonation", "user_id", userID, "impersonator_id", rc.UserID, "session_id", session.ID)
+	return session, nil
+}
+
+// End revokes the impersonation the request is made with and gives the admin
+// their own session back.
+func (s *ImpersonationService) End(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if !request.IsImpersonating(ctx) {
+		return ErrNotImpersonating
+	}
+	rc := request.GetRequestContext(ctx)
+
+	if err := s.sessions.RevokeSession(ctx, rc.UserID, rc.SessionID); err != nil && !errors.Is(err, dao.ErrSessionNotFound) {
+		return fmt.Errorf("failed to revoke impersonation. %w", err)
+	}
+
+	if err := s.record(r, model.SecurityEventImpersonationEnd, rc.UserID, rc.RealUserID); err != nil {
+		return err
+	}
+
+	if cookie, err := r.Cookie(impersonatorCookieName); err == nil && cookie.Value != "" {
+		setSessionCookie(w, cookie.Value)
+	} else {
+		clearSessionCookie(w)
+	}
+	clearImpersonatorCookie(w)
+
+	slog.InfoContext(ctx, "ended impersonation", "user_id", rc.UserID, "impersonator_id", rc.RealUserID, "session_id", rc.SessionID)
+	return nil
+}
+
+// impersonatorAllowed reports whether the admin running an impersonation
+// session is still active and allowed to impersonate.
+func impersonatorAllowed(session model.Session, now time.Time) bool {
+	return CheckAccount(session.ImpersonatorAccount, now) == nil && slices.Contains(session.ImpersonatorPermissions, model.PermissionUsersImpersonate)
+}
+
+// recordImpersonationEnd writes the end of an impersonation session that was
+// not ended through End, such as by logging out or expiring, to the security
+// log, so every start has a matching end.
+func recordImpersonationEnd(r *http.Request, events dao.SecurityRepository, session model.Session) error {
+	_, err := events.InsertSecurityEvent(r.Context(), model.SecurityEvent{
+		ID:      newSecurityEventID(),
+		Kind:    model.SecurityEventImpersonationEnd,
+		UserID:  session.UserID,
+		IP:      ClientIP(r),
+		ActorID: session.ImpersonatorID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert security event. %w", err)
+	}
+
+	slog.InfoContext(r.Context(), "ended impersonation", "user_id", session.UserID, "impersonator_id", session.ImpersonatorID, "session_id", session.ID)
+	return nil
+}
+
+func clearImpersonatorCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonatorCookieName,
+		Value:    "",
+		Path:     impersonatorCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (s *ImpersonationService) record(r *http.Request, kind string, userID string, actorID string) error {
+	_, err := s.events.InsertSecurityEvent(r.Context(), model.SecurityEvent{
+		ID:      newSecurityEventID(),
+		Kind:    kind,
+		UserID:  userID,
+		IP:      ClientIP(r),
+		ActorID: actorID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert security event. %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+// auditLog keeps the security events written through it.
+type auditLog struct {
+	dao.SecurityRepository
+	events []model.SecurityEvent
+}
+
+func (l *auditLog) InsertSecurityEvent(ctx context.Context, event model.SecurityEvent) (model.SecurityEvent, error) {
+	l.events = append(l.events, event)
+	return l.SecurityRepository.InsertSecurityEvent(ctx, event)
+}
+
+func cookieNamed(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestImpersonationService(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	audit := &auditLog{SecurityRepository: repo}
+	store := NewPostgresSessionStore(repo, audit, 0)
+	impersonations := NewImpersonationService(repo, repo, audit)
+	now := time.Now()
+	impersonations.now = func() time.Time { return now }
+
+	civilian, err := repo.GetRoleByName(ctx, "CIVILIAN")
+	assert.NoError(t, err)
+	admin, err := repo.GetRoleByName(ctx, "ADMIN")
+	assert.NoError(t, err)
+
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c", Roles: []model.Role{civilian}})
+	assert.NoError(t, err)
+	root, err := repo.InsertUser(ctx, model.User{ID: "user_root", Username: "root", Email: "r@b.c", Roles: []model.Role{admin}})
+	assert.NoError(t, err)
+	boss, err := repo.InsertUser(ctx, model.User{ID: "user_boss", Username: "boss", Email: "b@b.c", Roles: []model.Role{admin}})
+	assert.NoError(t, err)
+
+	// start verifies the caller's cookie first, the way the route does
+	start := func(cookie *http.Cookie, userID string) (*httptest.ResponseRecorder, model.Session, error) {
+		w := httptest.NewRecorder()
+		r := newRequest(cookie, userID)
+		store.VerifySession(w, r)
+		assert.False(t, request.GetStop(r.Context()))
+		session, err := impersonations.Start(w, r, userID)
+		return w, session, err
+	}
+
+	rootCookie := login(t, store, root)
+
+	t.Run("not allowed", func(t *testing.T) {
+		_, _, err := start(rootCookie, root.ID)
+		assert.ErrorIs(t, err, ErrImpersonationNotAllowed)
+		_, _, err = start(rootCookie, "user_nobody")
+		assert.ErrorIs(t, err, dao.ErrUserNotFound)
+
+		// admins cannot gain permissions by impersonating
+		restricted, err := repo.InsertRole(ctx, model.Role{Name: "SUPPORT", Permissions: []string{model.PermissionUsersReadAny, model.PermissionUsersImpersonate}})
+		assert.NoError(t, err)
+		helper, err := repo.InsertUser(ctx, model.User{ID: "user_helper", Username: "helper", Email: "h@b.c", Roles: []model.Role{restricted}})
+		assert.NoError(t, err)
+		_, _, err = start(login(t, store, helper), boss.ID)
+		assert.ErrorIs(t, err, ErrImpersonationNotAllowed)
+		assert.Empty(t, audit.events)
+	})
+
+	w, session, err := start(rootCookie, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, alice.ID, session.UserID)
+	assert.Equal(t, root.ID, session.ImpersonatorID)
+	assert.Equal(t, now.Add(defaultImpersonationTTL), session.Expires)
+	assert.Equal(t, rootCookie.Value, cookieNamed(w, impersonatorCookieName).Value)
+	aliceCookie := cookieNamed(w, cookieName)
+	assert.NotEqual(t, rootCookie.Value, aliceCookie.Value)
+
+	t.Run("acts as the user", func(t *testing.T) {
+		code, rc := verify(store, aliceCookie, alice.ID)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, alice.ID, rc.UserID)
+		assert.Equal(t, root.ID, rc.RealUserID)
+		assert.Equal(t, []string{"CIVILIAN"}, rc.Roles)
+
+		// only with the user's own permissions
+		code, _ = verify(store, aliceCookie, root.ID)
+		assert.Equal(t, http.StatusForbidden, code)
+
+		r := newRequest(aliceCookie, alice.ID)
+		store.VerifySession(httptest.NewRecorder(), r)
+		assert.True(t, request.IsImpersonating(r.Context()))
+		_, err := impersonations.Start(httptest.NewRecorder(), r, boss.ID)
+		assert.ErrorIs(t, err, ErrImpersonationNotAllowed)
+
+		r = newRequest(rootCookie, alice.ID)
+		store.VerifySession(httptest.NewRecorder(), r)
+		assert.False(t, request.IsImpersonating(r.Context()))
+	})
+
+	t.Run("end", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := newRequest(rootCookie, "")
+		store.VerifySession(w, r)
+		assert.ErrorIs(t, impersonations.End(w, r), ErrNotImpersonating)
+
+		w = httptest.NewRecorder()
+		r = newRequest(aliceCookie, "")
+		r.AddCookie(&http.Cookie{Name: impersonatorCookieName, Value: rootCookie.Value})
+		store.VerifySession(w, r)
+		assert.NoError(t, impersonations.End(w, r))
+		assert.Equal(t, rootCookie.Value, cookieNamed(w, cookieName).Value)
+		assert.Equal(t, -1, cookieNamed(w, impersonatorCookieName).MaxAge)
+
+		code, _ := verify(store, aliceCookie, "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, rc := verify(store, rootCookie, "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, root.ID, rc.UserID)
+	})
+
+	t.Run("audited", func(t *testing.T) {
+		if assert.Len(t, audit.events, 2) {
+			assert.Equal(t, model.SecurityEventImpersonationStart, audit.events[0].Kind)
+			assert.Equal(t, alice.ID, audit.events[0].UserID)
+			assert.Equal(t, root.ID, audit.events[0].ActorID)
+			assert.Equal(t, model.SecurityEventImpersonationEnd, audit.events[1].Kind)
+			assert.Equal(t, alice.ID, audit.events[1].UserID)
+			assert.Equal(t, root.ID, audit.events[1].ActorID)
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		_, _, err := start(rootCookie, alice.ID)
+		assert.NoError(t, err)
+		sessions, err := repo.GetUserSessions(ctx, alice.ID)
+		assert.NoError(t, err)
+		if assert.Len(t, sessions, 1) {
+			assert.Equal(t, root.ID, sessions[0].ImpersonatorID)
+			assert.WithinDuration(t, now.Add(defaultImpersonationTTL), sessions[0].Expires, time.Second)
+		}
+	})
+
+	t.Run("ends with the admin", func(t *testing.T) {
+		bossCookie := login(t, store, boss)
+		impersonate := func() *http.Cookie {
+			w, _, err := start(bossCookie, alice.ID)
+			assert.NoError(t, err)
+			cookie := cookieNamed(w, cookieName)
+			code, _ := verify(store, cookie, alice.ID)
+			assert.Equal(t, http.StatusOK, code)
+			return cookie
+		}
+
+		// suspending the admin ends it for good
+		cookie := impersonate()
+		assert.NoError(t, repo.SuspendUser(ctx, boss.ID, "", nil))
+		code, _ := verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.NoError(t, repo.ReactivateUser(ctx, boss.ID))
+		code, _ = verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		// as does losing the permission
+		cookie = impersonate()
+		assert.NoError(t, repo.RemoveUserRole(ctx, boss.ID, admin.ID))
+		code, _ = verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.NoError(t, repo.AddUserRole(ctx, boss.ID, admin.ID))
+		code, _ = verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		// and revoking the admin's sessions
+		cookie = impersonate()
+		_, err := repo.RevokeUserSessions(ctx, boss.ID)
+		assert.NoError(t, err)
+		code, _ = verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("logging out ends it", func(t *testing.T) {
+		w, _, err := start(rootCookie, alice.ID)
+		assert.NoError(t, err)
+		cookie := cookieNamed(w, cookieName)
+		events := len(audit.events)
+
+		w = httptest.NewRecorder()
+		store.TerminateSession(w, newRequest(cookie, ""))
+		assert.Equal(t, -1, cookieNamed(w, cookieName).MaxAge)
+		assert.Equal(t, -1, cookieNamed(w, impersonatorCookieName).MaxAge)
+		if assert.Len(t, audit.events, events+1) {
+			end := audit.events[events]
+			assert.Equal(t, model.SecurityEventImpersonationEnd, end.Kind)
+			assert.Equal(t, alice.ID, end.UserID)
+			assert.Equal(t, root.ID, end.ActorID)
+		}
+		code, _ := verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Len(t, audit.events, events+1)
+
+		// logging out of your own session records nothing
+		w = httptest.NewRecorder()
+		store.TerminateSession(w, newRequest(login(t, store, root), ""))
+		assert.Nil(t, cookieNamed(w, impersonatorCookieName))
+		assert.Len(t, audit.events, events+1)
+	})
+
+	t.Run("expiring ends it", func(t *testing.T) {
+		impersonations.now = func() time.Time { return now.Add(-2 * defaultImpersonationTTL) }
+		defer func() { impersonations.now = func() time.Time { return now } }()
+		w, _, err := start(rootCookie, alice.ID)
+		assert.NoError(t, err)
+		cookie := cookieNamed(w, cookieName)
+		events := len(audit.events)
+
+		code, _ := verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		if assert.Len(t, audit.events, events+1) {
+			end := audit.events[events]
+			assert.Equal(t, model.SecurityEventImpersonationEnd, end.Kind)
+			assert.Equal(t, alice.ID, end.UserID)
+			assert.Equal(t, root.ID, end.ActorID)
+		}
+
+		// it is only recorded once
+		code, _ = verify(store, cookie, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Len(t, audit.events, events+1)
+	})
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+)
+
+var ErrLoginLocked = errors.New("too many failed logins")
+
+const (
+	defaultMaxAccountFailures = 5
+	defaultMaxIPFailures      = 50
+	defaultLockout            = 15 * time.Minute
+	defaultFailureWindow      = time.Hour
+	defaultFailureDelay       = 250 * time.Millisecond
+	defaultMaxFailureDelay    = 4 * time.Second
+)
+
+// LockoutConfig configures brute force protection. Zero values take the
+// defaults: five failures in an hour lock a username and fifty lock a client
+// IP, for fifteen minutes. Failed logins are answered after a delay that
+// starts at a quarter second and doubles with each failure, up to four
+// seconds.
+type LockoutConfig struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	Lockout            time.Duration
+	// Window is how long a failure is counted for.
+	Window   time.Duration
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// LoginLockedError is returned for logins against a locked username or from a
+// locked IP.
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e LoginLockedError) Error() string {
+	return ErrLoginLocked.Error()
+}
+
+func (e LoginLockedError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+// LoginGuard counts failed password logins per username and per client IP,
+// slows down and then locks out whoever keeps failing, and records lockouts
+// in the security log. Usernames are counted whether or not anyone has them,
+// so a lockout does not tell that an account exists.
+type LoginGuard struct {
+	failures dao.SecurityRepository
+	users    dao.UserRepository
+	cfg      LockoutConfig
+	now      func() time.Time
+	sleep    func(ctx context.Context, d time.Duration)
+}
+
+func NewLoginGuard(failures dao.SecurityRepository, users dao.UserRepository, cfg LockoutConfig) *LoginGuard {
+	if cfg.MaxAccountFailures <= 0 {
+		cfg.MaxAccountFailures = defaultMaxAccountFailures
+	}
+	if cfg.MaxIPFailures <= 0 {
+		cfg.MaxIPFailures = defaultMaxIPFailures
+	}
+	if cfg.Lockout <= 0 {
+		cfg.Lockout = defaultLockout
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultFailureWindow
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = defaultFailureDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = defaultMaxFailureDelay
+	}
+
+	return &LoginGuard{
+		failures: failures,
+		users:    users,
+		cfg:      cfg,
+		now:      time.Now,
+		sleep:    sleep,
+	}
+}
+
+// Check fails with a LoginLockedError if the username or IP is locked. A nil
+// guard locks nothing.
+func (g *LoginGuard) Check(ctx context.Context, username string, ip string) error {
+	if g == nil {
+		return nil
+	}
+
+	failures, err := g.failures.GetLoginFailures(ctx, accountKey(username), ipKey(ip))
+	if err != nil {
+		return fmt.Errorf("failed to get login failures. %w", err)
+	}
+
+	now := g.now()
+	retry := time.Duration(0)
+	for _, f := range failures {
+		if f.LockedUntil != nil && f.LockedUntil.After(now) {
+			retry = max(retry, f.LockedUntil.Sub(now))
+		}
+	}
+	if retry > 0 {
+		return LoginLockedError{RetryAfter: retry}
+	}
+
+	return nil
+}
+
+// Failed counts a failed login, locks the username or IP once they reach their
+// limit, and then waits out the delay for the failure. userID is empty when
+// nobody has the username.
+func (g *LoginGuard) Failed(ctx context.Context, username string, userID string, ip string) error {
+	if g == nil {
+		return nil
+	}
+
+	now := g.now()
+	account, err := g.failures.RecordLoginFailure(ctx, accountKey(username), now, g.cfg.Window)
+	if err != nil {
+		return err
+	}
+	if account.Failures >= g.cfg.MaxAccountFailures {
+		err := g.lock(ctx, account.Key, model.SecurityEvent{Kind: model.SecurityEventAccountLocked, UserID: userID, Subject: normalizeUsername(username), IP: ip})
+		if err != nil {
+			return err
+		}
+	}
+
+	if ip != "" {
+		addr, err := g.failures.RecordLoginFailure(ctx, ipKey(ip), now, g.cfg.Window)
+		if err != nil {
+			return err
+		}
+		if addr.Failures >= g.cfg.MaxIPFailures {
+			if err := g.lock(ctx, addr.Key, model.SecurityEvent{Kind: model.SecurityEventIPLocked, Subject: ip, IP: ip}); err != nil {
+				return err
+			}
+		}
+	}
+
+	g.sleep(ctx, g.delay(account.Failures))
+	return nil
+}
+
+// Succeeded forgets the username's failures. Those of the IP are kept, so
+// one account the attacker owns does not reset the count for the others.
+func (g *LoginGuard) Succeeded(ctx context.Context, username string) error {
+	if g == nil {
+		return nil
+	}
+
+	return g.failures.ClearLoginFailures(ctx, accountKey(username))
+}
+
+// Unlock lifts the lock on the user's username and records that actorID did
+// so.
+func (g *LoginGuard) Unlock(ctx context.Context, userID string, actorID string) error {
+	user, err := g.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := g.failures.ClearLoginFailures(ctx, accountKey(user.Username)); err != nil {
+		return err
+	}
+
+	_, err = g.failures.InsertSecurityEvent(ctx, model.SecurityEvent{
+		ID:      newSecurityEventID(),
+		Kind:    model.SecurityEventAccountUnlocked,
+		UserID:  user.ID,
+		Subject: normalizeUsername(user.Username),
+		ActorID: actorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "unlocked account", "user_id", user.ID, "actor_id", actorID)
+	return nil
+}
+
+func (g *LoginGuard) lock(ctx context.Context, key string, event model.SecurityEvent) error {
+	if err := g.failures.LockLogin(ctx, key, g.now().Add(g.cfg.Lockout)); err != nil {
+		return err
+	}
+
+	event.ID = newSecurityEventID()
+	if _, err := g.failures.InsertSecurityEvent(ctx, event); err != nil {
+		return err
+	}
+
+	slog.WarnContext(ctx, "locked out logins", "kind", event.Kind, "subject", event.Subject, "user_id", event.UserID, "ip", event.IP)
+	return nil
+}
+
+// delay doubles with each failure up to the configured maximum.
+func (g *LoginGuard) delay(failures int) time.Duration {
+	d := g.cfg.Delay
+	for i := 1; i < failures && d < g.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxDelay)
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func accountKey(username string) string {
+	return "user:" + normalizeUsername(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func newSecurityEventID() string {
+	return fmt.Sprintf("event_%s", ksuid.New().String())
+}
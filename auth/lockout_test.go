@@ -0,0 +1,76 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginGuard(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	g := NewLoginGuard(repo, repo, LockoutConfig{MaxAccountFailures: 3, MaxIPFailures: 5})
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	delays := []time.Duration{}
+	g.sleep = func(ctx context.Context, d time.Duration) { delays = append(delays, d) }
+
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "Alice", Email: "a@b.c"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, (*LoginGuard)(nil).Check(ctx, "alice", "10.0.0.1"))
+	assert.NoError(t, (*LoginGuard)(nil).Failed(ctx, "alice", alice.ID, "10.0.0.1"))
+
+	// delays grow with each failure, and the limit locks the username
+	for range 3 {
+		assert.NoError(t, g.Check(ctx, "alice", "10.0.0.1"))
+		assert.NoError(t, g.Failed(ctx, "alice", alice.ID, "10.0.0.1"))
+	}
+	assert.Equal(t, []time.Duration{defaultFailureDelay, 2 * defaultFailureDelay, 4 * defaultFailureDelay}, delays)
+	err = g.Check(ctx, "ALICE ", "10.0.0.2")
+	assert.ErrorIs(t, err, ErrLoginLocked)
+	locked := LoginLockedError{}
+	assert.ErrorAs(t, err, &locked)
+	assert.Equal(t, defaultLockout, locked.RetryAfter)
+	assert.NoError(t, g.Check(ctx, "bob", "10.0.0.2"))
+
+	// the lock wears off, but the next failure locks again straight away
+	now = now.Add(defaultLockout)
+	assert.NoError(t, g.Check(ctx, "alice", "10.0.0.2"))
+	assert.NoError(t, g.Failed(ctx, "alice", alice.ID, "10.0.0.2"))
+	assert.ErrorIs(t, g.Check(ctx, "alice", "10.0.0.2"), ErrLoginLocked)
+
+	assert.NoError(t, g.Unlock(ctx, alice.ID, "user_admin"))
+	assert.NoError(t, g.Check(ctx, "alice", "10.0.0.2"))
+
+	// usernames nobody has lock the same way
+	for range 3 {
+		assert.NoError(t, g.Failed(ctx, "nobody", "", "10.0.0.3"))
+	}
+	assert.ErrorIs(t, g.Check(ctx, "nobody", "10.0.0.4"), ErrLoginLocked)
+
+	// the ip locks too, whichever usernames it tries, and logging in does not
+	// reset it
+	assert.NoError(t, g.Failed(ctx, "carol", "", "10.0.0.3"))
+	assert.NoError(t, g.Succeeded(ctx, "carol"))
+	assert.NoError(t, g.Failed(ctx, "dave", "", "10.0.0.3"))
+	assert.ErrorIs(t, g.Check(ctx, "erin", "10.0.0.3"), ErrLoginLocked)
+	assert.NoError(t, g.Check(ctx, "erin", "10.0.0.5"))
+
+	// failures outside the window are forgotten
+	assert.NoError(t, g.Failed(ctx, "frank", "", ""))
+	assert.NoError(t, g.Failed(ctx, "frank", "", ""))
+	now = now.Add(defaultFailureWindow)
+	assert.NoError(t, g.Failed(ctx, "frank", "", ""))
+	assert.NoError(t, g.Check(ctx, "frank", ""))
+
+	assert.Equal(t, 8*defaultFailureDelay, g.delay(4))
+	assert.Equal(t, defaultMaxFailureDelay, g.delay(10))
+}
@@ -0,0 +1,403 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"image/png"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrMFAInvalidCode      = errors.New("invalid mfa code")
+	ErrMFANotEnabled       = errors.New("mfa is not enabled")
+	ErrMFAEnabled          = errors.New("mfa is already enabled")
+	ErrMFAChallengeInvalid = errors.New("invalid mfa challenge")
+)
+
+const (
+	defaultMFAIssuer = "sandbox"
+	totpPeriod       = 30
+	// totpSkew is how many time steps either side of now are accepted, to
+	// allow for clock drift between the server and the authenticator.
+	totpSkew          = 1
+	totpDigits        = otp.DigitsSix
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+	// maxMFAChallengeAttempts is how many wrong codes end a challenge.
+	maxMFAChallengeAttempts = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Enrollment is a pending TOTP secret for the user to add to their
+// authenticator.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	key    *otp.Key
+}
+
+// QR renders the enrollment's otpauth URI as a PNG QR code.
+func (e Enrollment) QR(size int) ([]byte, error) {
+	img, err := e.key.Image(size, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render qr code. %w", err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode qr code. %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MFAChallenge is returned instead of a session or tokens when a user with
+// MFA on logs in with their password.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// MFAService enrolls users in TOTP and checks their codes. Secrets are kept
+// encrypted with the auth key, and recovery codes and challenge tokens only as
+// hashes.
+type MFAService struct {
+	repo   dao.MFARepository
+	issuer string
+	now    func() time.Time
+}
+
+// NewMFAService returns a service whose otpauth URIs name issuer. An empty
+// issuer means sandbox.
+func NewMFAService(repo dao.MFARepository, issuer string) *MFAService {
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+
+	return &MFAService{
+		repo:   repo,
+		issuer: issuer,
+		now:    time.Now,
+	}
+}
+
+// Status returns the user's enrollment. Users who never enrolled get
+// ErrMFANotEnabled.
+func (s *MFAService) Status(ctx context.Context, userID string) (model.MFA, error) {
+	mfa, err := s.repo.GetMFA(ctx, userID)
+	if errors.Is(err, dao.ErrMFANotFound) {
+		return mfa, ErrMFANotEnabled
+	}
+	if err != nil {
+		return mfa, fmt.Errorf("failed to get mfa. %w", err)
+	}
+
+	return mfa, nil
+}
+
+// Enabled reports whether logins of the user need a second factor. A nil
+// service never asks for one.
+func (s *MFAService) Enabled(ctx context.Context, userID string) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+
+	mfa, err := s.Status(ctx, userID)
+	if errors.Is(err, ErrMFANotEnabled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return mfa.Confirmed != nil, nil
+}
+
+// Enroll starts TOTP enrollment with a new secret, replacing any earlier one
+// that was not confirmed.
+func (s *MFAService) Enroll(ctx context.Context, user model.User) (Enrollment, error) {
+	enabled, err := s.Enabled(ctx, user.ID)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if enabled {
+		return Enrollment{}, ErrMFAEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.issuer,
+		AccountName: user.Username,
+		Period:      totpPeriod,
+		Digits:      totpDigits,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("failed to generate totp secret. %w", err)
+	}
+
+	secret, err := crypt.Encrypt(key.Secret())
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("failed to encrypt totp secret. %w", err)
+	}
+
+	if _, err := s.repo.SetMFA(ctx, model.MFA{UserID: user.ID, Secret: secret}); err != nil {
+		return Enrollment{}, fmt.Errorf("failed to store totp secret. %w", err)
+	}
+
+	return Enrollment{Secret: key.Secret(), URI: key.URL(), key: key}, nil
+}
+
+// Confirm turns MFA on once the user proves their authenticator works, and
+// returns their recovery codes.
+func (s *MFAService) Confirm(ctx context.Context, userID string, code string) ([]string, error) {
+	mfa, err := s.Status(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Confirmed != nil {
+		return nil, ErrMFAEnabled
+	}
+
+	counter, ok, err := s.validateTOTP(mfa, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.ConfirmMFA(ctx, userID, counter); err != nil {
+			if errors.Is(err, dao.ErrMFANotFound) {
+				return ErrMFAEnabled
+			}
+			return fmt.Errorf("failed to confirm mfa. %w", err)
+		}
+		return s.repo.SetRecoveryCodes(ctx, userID, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify checks a TOTP or recovery code of a user with MFA on. Each code
+// works once.
+func (s *MFAService) Verify(ctx context.Context, userID string, code string) error {
+	mfa, err := s.Status(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if mfa.Confirmed == nil {
+		return ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits.Length() {
+		err := s.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+		if errors.Is(err, dao.ErrRecoveryCodeNotFound) {
+			return ErrMFAInvalidCode
+		}
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code. %w", err)
+		}
+		slog.InfoContext(ctx, "used recovery code", "user_id", userID, "left", mfa.RecoveryCodes-1)
+		return nil
+	}
+
+	counter, ok, err := s.validateTOTP(mfa, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+
+	err = s.repo.UseMFACounter(ctx, userID, counter)
+	if errors.Is(err, dao.ErrMFACodeUsed) {
+		return ErrMFAInvalidCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to use mfa code. %w", err)
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a
+// code.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error) {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		return s.repo.SetRecoveryCodes(ctx, userID, hashes)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes. %w", err)
+	}
+
+	return codes, nil
+}
+
+// Reset turns MFA off for the user.
+func (s *MFAService) Reset(ctx context.Context, userID string) error {
+	err := s.repo.WithTx(ctx, func(ctx context.Context) error {
+		return s.repo.DeleteMFA(ctx, userID)
+	})
+	if errors.Is(err, dao.ErrMFANotFound) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset mfa. %w", err)
+	}
+
+	return nil
+}
+
+// Challenge starts the second step of a login for the user.
+func (s *MFAService) Challenge(ctx context.Context, userID string) (MFAChallenge, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return MFAChallenge{}, fmt.Errorf("failed to create mfa token. %w", err)
+	}
+
+	_, err = s.repo.InsertMFAChallenge(ctx, model.MFAChallenge{
+		ID:        fmt.Sprintf("mfc_%s", ksuid.New().String()),
+		TokenHash: hashSessionToken(token),
+		UserID:    userID,
+		Expires:   s.now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		return MFAChallenge{}, fmt.Errorf("failed to insert mfa challenge. %w", err)
+	}
+
+	return MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(mfaChallengeTTL / time.Second),
+	}, nil
+}
+
+// CompleteChallenge checks the code given for a challenge and returns the ID
+// of the user logging in. A challenge can be completed once, and too many
+// wrong codes end it.
+func (s *MFAService) CompleteChallenge(ctx context.Context, token string, code string) (string, error) {
+	challenge, err := s.repo.GetMFAChallenge(ctx, hashSessionToken(token))
+	if errors.Is(err, dao.ErrMFAChallengeNotFound) {
+		return "", ErrMFAChallengeInvalid
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get mfa challenge. %w", err)
+	}
+	if !challenge.Expires.After(s.now()) || challenge.Attempts >= maxMFAChallengeAttempts {
+		return "", ErrMFAChallengeInvalid
+	}
+
+	err = s.Verify(ctx, challenge.UserID, code)
+	if errors.Is(err, ErrMFAInvalidCode) {
+		attempts, ferr := s.repo.FailMFAChallenge(ctx, challenge.ID)
+		if ferr != nil && !errors.Is(ferr, dao.ErrMFAChallengeNotFound) {
+			return "", fmt.Errorf("failed to count mfa attempt. %w", ferr)
+		}
+		slog.WarnContext(ctx, "wrong mfa code", "user_id", challenge.UserID, "attempts", attempts)
+		if attempts >= maxMFAChallengeAttempts {
+			if err := s.repo.DeleteMFAChallenge(ctx, challenge.ID); err != nil && !errors.Is(err, dao.ErrMFAChallengeNotFound) {
+				return "", fmt.Errorf("failed to end mfa challenge. %w", err)
+			}
+			return "", ErrMFAChallengeInvalid
+		}
+		return "", err
+	}
+	if errors.Is(err, ErrMFANotEnabled) {
+		return "", ErrMFAChallengeInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = s.repo.DeleteMFAChallenge(ctx, challenge.ID)
+	if errors.Is(err, dao.ErrMFAChallengeNotFound) {
+		return "", ErrMFAChallengeInvalid
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to end mfa challenge. %w", err)
+	}
+
+	return challenge.UserID, nil
+}
+
+// validateTOTP returns the time step the code is for, if it is one of those
+// allowed around now.
+func (s *MFAService) validateTOTP(mfa model.MFA, code string) (int64, bool, error) {
+	secret, err := crypt.Decrypt(mfa.Secret)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to decrypt totp secret. %w", err)
+	}
+
+	code = strings.TrimSpace(code)
+	now := s.now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		want, err := hotp.GenerateCodeCustom(secret, uint64(step), hotp.ValidateOpts{Digits: totpDigits, Algorithm: otp.AlgorithmSHA1})
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to generate totp code. %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// newRecoveryCodes returns codes like abcde-fghij and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to create recovery code. %w", err)
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes the code ignoring case, spaces and dashes, so it can
+// be typed back however it was written down.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashSessionToken(code)
+}
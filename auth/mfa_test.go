@@ -0,0 +1,119 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMFAService(t *testing.T) {
+	ctx := context.Background()
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+	repo := dao.NewMemory()
+	mfa := NewMFAService(repo, "")
+	now := time.Now()
+	mfa.now = func() time.Time { return now }
+
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c"})
+	assert.NoError(t, err)
+
+	code := func(secret string) string {
+		c, err := totp.GenerateCode(secret, now)
+		assert.NoError(t, err)
+		return c
+	}
+
+	enabled, err := mfa.Enabled(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	enabled, err = (*MFAService)(nil).Enabled(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	enrollment, err := mfa.Enroll(ctx, alice)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/sandbox:alice?"))
+	png, err := enrollment.QR(64)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x89PNG", string(png[:4]))
+
+	// a pending enrollment does not guard logins
+	enabled, err = mfa.Enabled(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	assert.ErrorIs(t, mfa.Verify(ctx, alice.ID, code(enrollment.Secret)), ErrMFANotEnabled)
+
+	_, err = mfa.Confirm(ctx, alice.ID, "000000")
+	assert.ErrorIs(t, err, ErrMFAInvalidCode)
+	codes, err := mfa.Confirm(ctx, alice.ID, code(enrollment.Secret))
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	enabled, err = mfa.Enabled(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+	_, err = mfa.Enroll(ctx, alice)
+	assert.ErrorIs(t, err, ErrMFAEnabled)
+
+	t.Run("codes work once", func(t *testing.T) {
+		// the code used to confirm cannot log in
+		assert.ErrorIs(t, mfa.Verify(ctx, alice.ID, code(enrollment.Secret)), ErrMFAInvalidCode)
+		now = now.Add(30 * time.Second)
+		assert.NoError(t, mfa.Verify(ctx, alice.ID, code(enrollment.Secret)))
+		assert.ErrorIs(t, mfa.Verify(ctx, alice.ID, code(enrollment.Secret)), ErrMFAInvalidCode)
+
+		assert.NoError(t, mfa.Verify(ctx, alice.ID, strings.ToUpper(codes[0])))
+		assert.ErrorIs(t, mfa.Verify(ctx, alice.ID, codes[0]), ErrMFAInvalidCode)
+		status, err := mfa.Status(ctx, alice.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, recoveryCodeCount-1, status.RecoveryCodes)
+	})
+
+	t.Run("challenge", func(t *testing.T) {
+		challenge, err := mfa.Challenge(ctx, alice.ID)
+		assert.NoError(t, err)
+		assert.True(t, challenge.MFARequired)
+
+		_, err = mfa.CompleteChallenge(ctx, challenge.MFAToken, "000000")
+		assert.ErrorIs(t, err, ErrMFAInvalidCode)
+		userID, err := mfa.CompleteChallenge(ctx, challenge.MFAToken, codes[1])
+		assert.NoError(t, err)
+		assert.Equal(t, alice.ID, userID)
+		_, err = mfa.CompleteChallenge(ctx, challenge.MFAToken, codes[2])
+		assert.ErrorIs(t, err, ErrMFAChallengeInvalid)
+
+		// too many wrong codes end the challenge
+		challenge, err = mfa.Challenge(ctx, alice.ID)
+		assert.NoError(t, err)
+		for i := 1; i < maxMFAChallengeAttempts; i++ {
+			_, err = mfa.CompleteChallenge(ctx, challenge.MFAToken, "000000")
+			assert.ErrorIs(t, err, ErrMFAInvalidCode)
+		}
+		_, err = mfa.CompleteChallenge(ctx, challenge.MFAToken, "000000")
+		assert.ErrorIs(t, err, ErrMFAChallengeInvalid)
+		_, err = mfa.CompleteChallenge(ctx, challenge.MFAToken, codes[2])
+		assert.ErrorIs(t, err, ErrMFAChallengeInvalid)
+	})
+
+	t.Run("regenerate and reset", func(t *testing.T) {
+		fresh, err := mfa.RegenerateRecoveryCodes(ctx, alice.ID, codes[3])
+		assert.NoError(t, err)
+		assert.ErrorIs(t, mfa.Verify(ctx, alice.ID, codes[4]), ErrMFAInvalidCode)
+		assert.NoError(t, mfa.Verify(ctx, alice.ID, fresh[0]))
+
+		assert.NoError(t, mfa.Reset(ctx, alice.ID))
+		assert.ErrorIs(t, mfa.Reset(ctx, alice.ID), ErrMFANotEnabled)
+		enabled, err := mfa.Enabled(ctx, alice.ID)
+		assert.NoError(t, err)
+		assert.False(t, enabled)
+	})
+}
@@ -0,0 +1,471 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+)
+
+// ClientSecretPrefix marks OAuth client secrets for secret scanners.
+const ClientSecretPrefix = "sbx_cs_"
+
+const (
+	defaultAuthorizationCodeTTL = time.Minute
+	codeChallengeMethodS256     = "S256"
+	minCodeVerifier             = 43
+	maxCodeVerifier             = 128
+)
+
+// Error codes from RFC 6749 that OAuthError carries.
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthAccessDenied            = "access_denied"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+)
+
+// ErrInvalidRedirect means the authorization request names a client or
+// redirect uri that is not registered. The user is told rather than sent to
+// the uri, which may not be the client's.
+var ErrInvalidRedirect = errors.New("invalid client or redirect uri")
+
+// OAuthError is an error as RFC 6749 describes it, for clients to switch on
+// by its code.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("%s. %s", e.Code, e.Description)
+}
+
+func oauthError(code string, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// AuthorizationRequest is the client asking, through the user's browser, for
+// a code to swap for tokens. Only the authorization code flow with an S256
+// PKCE challenge is supported.
+type AuthorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state,omitempty"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// Consent is what the consent screen shows the user before they approve or
+// deny the request.
+type Consent struct {
+	Client model.OAuthClient `json:"client"`
+	Scopes []string          `json:"scopes"`
+	// Granted are the scopes the user already let the client have.
+	Granted []string             `json:"granted"`
+	Request AuthorizationRequest `json:"request"`
+}
+
+// ClientCredentials identify the client calling the token endpoints. Public
+// clients have no secret.
+type ClientCredentials struct {
+	ID     string
+	Secret string
+}
+
+// Introspection describes a token as RFC 7662 does. Inactive tokens say
+// nothing else.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// NewOAuthClient returns a client along with the secret to hand to its
+// developer, which is empty for public clients. Only the secret's hash is
+// kept in the client.
+func NewOAuthClient(name string, redirectURIs []string, scopes []string, public bool) (model.OAuthClient, string, error) {
+	client := model.OAuthClient{
+		ID:           fmt.Sprintf("cli_%s", ksuid.New().String()),
+		Name:         name,
+		Public:       public,
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+	}
+	if public {
+		return client, "", nil
+	}
+
+	secret, err := newSessionToken()
+	if err != nil {
+		return client, "", fmt.Errorf("failed to create client secret. %w", err)
+	}
+	secret = ClientSecretPrefix + secret
+	client.SecretHash = hashSessionToken(secret)
+
+	return client, secret, nil
+}
+
+// AuthorizationServer lets third-party apps act for users who consent to it,
+// within the scopes they consent to. Apps get access and refresh tokens from
+// the TokenService, which the bearer session store accepts until the user
+// revokes the app.
+type AuthorizationServer struct {
+	clients dao.OAuthRepository
+	users   dao.UserRepository
+	tokens  *TokenService
+	codeTTL time.Duration
+	now     func() time.Time
+}
+
+func NewAuthorizationServer(clients dao.OAuthRepository, users dao.UserRepository, tokens *TokenService) *AuthorizationServer {
+	return &AuthorizationServer{
+		clients: clients,
+		users:   users,
+		tokens:  tokens,
+		codeTTL: defaultAuthorizationCodeTTL,
+		now:     time.Now,
+	}
+}
+
+// Authorize checks the request and returns what the user is asked to consent
+// to.
+func (s *AuthorizationServer) Authorize(ctx context.Context, userID string, req AuthorizationRequest) (Consent, error) {
+	client, scopes, err := s.validate(ctx, req)
+	if err != nil {
+		return Consent{}, err
+	}
+
+	granted := []string{}
+	grant, err := s.clients.GetOAuthGrant(ctx, userID, client.ID)
+	if err == nil {
+		granted = grant.Scopes
+	} else if !errors.Is(err, dao.ErrOAuthGrantNotFound) {
+		return Consent{}, fmt.Errorf("failed to get oauth grant. %w", err)
+	}
+
+	return Consent{Client: client, Scopes: scopes, Granted: granted, Request: req}, nil
+}
+
+// Consent records the user's answer and returns where to send their browser:
+// back to the client with a code, or with access_denied.
+func (s *AuthorizationServer) Consent(ctx context.Context, userID string, req AuthorizationRequest, approve bool) (string, error) {
+	client, scopes, err := s.validate(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if !approve {
+		return req.ErrorRedirect(oauthError(OAuthAccessDenied, "the user denied the request")), nil
+	}
+
+	code, err := newSessionToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to create authorization code. %w", err)
+	}
+
+	err = s.clients.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.clients.SaveOAuthGrant(ctx, model.OAuthGrant{UserID: userID, ClientID: client.ID, Scopes: scopes}); err != nil {
+			return err
+		}
+		_, err := s.clients.InsertOAuthCode(ctx, model.OAuthCode{
+			ID:            fmt.Sprintf("oac_%s", ksuid.New().String()),
+			CodeHash:      hashSessionToken(code),
+			ClientID:      client.ID,
+			UserID:        userID,
+			RedirectURI:   req.RedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: req.CodeChallenge,
+			Expires:       s.now().Add(s.codeTTL),
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	slog.InfoContext(ctx, "authorized oauth client", "user_id", userID, "client_id", client.ID, "scopes", scopes)
+	return redirectWith(req.RedirectURI, url.Values{"code": {code}}, req.State), nil
+}
+
+// ErrorRedirect sends the user back to the client with the error.
+func (req AuthorizationRequest) ErrorRedirect(err *OAuthError) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	return redirectWith(req.RedirectURI, params, req.State)
+}
+
+func redirectWith(uri string, params url.Values, state string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// validate returns the client the request is for and the scopes it asks for.
+func (s *AuthorizationServer) validate(ctx context.Context, req AuthorizationRequest) (model.OAuthClient, []string, error) {
+	client, err := s.clients.GetOAuthClient(ctx, req.ClientID)
+	if errors.Is(err, dao.ErrOAuthClientNotFound) {
+		return client, nil, fmt.Errorf("%w. unknown client_id", ErrInvalidRedirect)
+	}
+	if err != nil {
+		return client, nil, fmt.Errorf("failed to get oauth client. %w", err)
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return client, nil, fmt.Errorf("%w. redirect_uri is not registered for the client", ErrInvalidRedirect)
+	}
+
+	if req.ResponseType != "code" {
+		return client, nil, oauthError(OAuthUnsupportedResponseType, "response_type must be code")
+	}
+	if req.CodeChallenge == "" {
+		return client, nil, oauthError(OAuthInvalidRequest, "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != codeChallengeMethodS256 {
+		return client, nil, oauthError(OAuthInvalidRequest, "code_challenge_method must be S256")
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		return client, nil, oauthError(OAuthInvalidScope, "scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return client, nil, oauthError(OAuthInvalidScope, fmt.Sprintf("the client cannot ask for %s", scope))
+		}
+	}
+	slices.Sort(scopes)
+
+	return client, slices.Compact(scopes), nil
+}
+
+// Exchange swaps an authorization code for tokens. The client must be the
+// one the code was issued to and prove it started the request with the PKCE
+// verifier. A code coming back a second time means it leaked, so the tokens
+// it was swapped for are revoked.
+func (s *AuthorizationServer) Exchange(ctx context.Context, creds ClientCredentials, code string, redirectURI string, verifier string) (TokenPair, error) {
+	client, err := s.authenticate(ctx, creds)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	c, err := s.clients.UseOAuthCode(ctx, hashSessionToken(code))
+	if errors.Is(err, dao.ErrOAuthCodeNotFound) {
+		return TokenPair{}, oauthError(OAuthInvalidGrant, "unknown authorization code")
+	}
+	if errors.Is(err, dao.ErrOAuthCodeUsed) {
+		if c.ClientID == client.ID {
+			slog.WarnContext(ctx, "authorization code reused. revoking tokens", "user_id", c.UserID, "client_id", c.ClientID)
+			if _, err := s.tokens.refresh.RevokeClientRefreshTokens(ctx, c.UserID, c.ClientID); err != nil {
+				return TokenPair{}, fmt.Errorf("failed to revoke client refresh tokens. %w", err)
+			}
+		}
+		return TokenPair{}, oauthError(OAuthInvalidGrant, "authorization code was already used")
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if c.ClientID != client.ID {
+		return TokenPair{}, oauthError(OAuthInvalidGrant, "authorization code was issued to another client")
+	}
+	if !c.Expires.After(s.now()) {
+		return TokenPair{}, oauthError(OAuthInvalidGrant, "authorization code expired")
+	}
+	if c.RedirectURI != redirectURI {
+		return TokenPair{}, oauthError(OAuthInvalidGrant, "redirect_uri does not match the authorization request")
+	}
+	if !verifyCodeChallenge(verifier, c.CodeChallenge) {
+		return TokenPair{}, oauthError(OAuthInvalidGrant, "code_verifier does not match the code_challenge")
+	}
+	if err := s.checkUser(ctx, c.UserID, client.ID); err != nil {
+		return TokenPair{}, err
+	}
+
+	pair, err := s.tokens.IssueClient(ctx, c.UserID, client.ID, c.Scopes)
+	if err != nil {
+		return pair, err
+	}
+
+	slog.InfoContext(ctx, "issued oauth tokens", "user_id", c.UserID, "client_id", client.ID)
+	return pair, nil
+}
+
+// Refresh swaps a refresh token issued to the client for a new pair.
+func (s *AuthorizationServer) Refresh(ctx context.Context, creds ClientCredentials, refreshToken string) (TokenPair, error) {
+	client, err := s.authenticate(ctx, creds)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	pair, err := s.tokens.RefreshClient(ctx, refreshToken, client.ID)
+	if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrRefreshTokenReused) || errors.Is(err, ErrAccountSuspended) || errors.Is(err, ErrAccountDeactivated) {
+		return pair, oauthError(OAuthInvalidGrant, err.Error())
+	}
+
+	return pair, err
+}
+
+// Introspect describes one of the client's tokens. Tokens issued to other
+// clients are reported inactive.
+func (s *AuthorizationServer) Introspect(ctx context.Context, creds ClientCredentials, token string) (Introspection, error) {
+	client, err := s.authenticate(ctx, creds)
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	if claims, err := s.tokens.Verify(ctx, token); err == nil {
+		if claims.ClientID != client.ID || s.checkUser(ctx, claims.Subject, client.ID) != nil {
+			return Introspection{}, nil
+		}
+		return Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "access_token",
+			ExpiresAt: claims.ExpiresAt,
+			IssuedAt:  claims.IssuedAt,
+		}, nil
+	}
+
+	refresh, err := s.tokens.lookup(ctx, token, client.ID)
+	if errors.Is(err, ErrInvalidToken) {
+		return Introspection{}, nil
+	}
+	if err != nil {
+		return Introspection{}, err
+	}
+	if refresh.Used != nil || refresh.Revoked != nil || !refresh.Expires.After(s.now()) || CheckAccount(refresh.Account, s.now()) != nil {
+		return Introspection{}, nil
+	}
+
+	return Introspection{
+		Active:    true,
+		Scope:     strings.Join(refresh.Scopes, " "),
+		ClientID:  refresh.ClientID,
+		Subject:   refresh.UserID,
+		TokenType: "refresh_token",
+		ExpiresAt: refresh.Expires.Unix(),
+		IssuedAt:  refresh.Created.Unix(),
+	}, nil
+}
+
+// Revoke ends the login a refresh token issued to the client belongs to.
+// Access tokens cannot be revoked one by one; they expire on their own, or
+// with the user revoking the app. Unknown tokens are not an error.
+func (s *AuthorizationServer) Revoke(ctx context.Context, creds ClientCredentials, token string) error {
+	client, err := s.authenticate(ctx, creds)
+	if err != nil {
+		return err
+	}
+
+	return s.tokens.RevokeClient(ctx, token, client.ID)
+}
+
+// RevokeApp takes the client's access to the user's account away, refresh
+// tokens and all, and returns how many logins were ended.
+func (s *AuthorizationServer) RevokeApp(ctx context.Context, userID string, clientID string) (int, error) {
+	n := 0
+	err := s.clients.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.clients.DeleteOAuthGrant(ctx, userID, clientID); err != nil {
+			return err
+		}
+		var err error
+		n, err = s.tokens.refresh.RevokeClientRefreshTokens(ctx, userID, clientID)
+		if err != nil {
+			return fmt.Errorf("failed to revoke client refresh tokens. %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	slog.InfoContext(ctx, "revoked oauth client", "user_id", userID, "client_id", clientID, "revoked_tokens", n)
+	return n, nil
+}
+
+// authenticate checks the client's secret. Public clients have none to check.
+func (s *AuthorizationServer) authenticate(ctx context.Context, creds ClientCredentials) (model.OAuthClient, error) {
+	client, err := s.clients.GetOAuthClient(ctx, creds.ID)
+	if errors.Is(err, dao.ErrOAuthClientNotFound) {
+		return client, oauthError(OAuthInvalidClient, "unknown client")
+	}
+	if err != nil {
+		return client, fmt.Errorf("failed to get oauth client. %w", err)
+	}
+	if client.Public {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSessionToken(creds.Secret)), []byte(client.SecretHash)) != 1 {
+		return client, oauthError(OAuthInvalidClient, "invalid client secret")
+	}
+
+	return client, nil
+}
+
+// checkUser fails unless the user's account is in good standing and they
+// still let the client in.
+func (s *AuthorizationServer) checkUser(ctx context.Context, userID string, clientID string) error {
+	state, err := s.users.GetAccountState(ctx, userID)
+	if errors.Is(err, dao.ErrUserNotFound) {
+		return oauthError(OAuthInvalidGrant, "the user no longer exists")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get account state. %w", err)
+	}
+	if err := CheckAccount(state, s.now()); err != nil {
+		return oauthError(OAuthInvalidGrant, err.Error())
+	}
+
+	_, err = s.clients.GetOAuthGrant(ctx, userID, clientID)
+	if errors.Is(err, dao.ErrOAuthGrantNotFound) {
+		return oauthError(OAuthInvalidGrant, "the user revoked the client's access")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get oauth grant. %w", err)
+	}
+
+	return nil
+}
+
+// verifyCodeChallenge checks the PKCE verifier against the S256 challenge.
+func verifyCodeChallenge(verifier string, challenge string) bool {
+	if len(verifier) < minCodeVerifier || len(verifier) > maxCodeVerifier {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
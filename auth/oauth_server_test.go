@@ -0,0 +1,227 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestAuthorizationServer(t *testing.T) {
+	ctx := context.Background()
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+	repo := dao.NewMemory()
+	tokens := NewTokenService(repo, repo, TokenConfig{})
+	server := NewAuthorizationServer(repo, repo, tokens)
+	store := NewBearerSessionStore(NewPostgresSessionStore(repo, repo, 0), tokens, repo, repo, repo, repo)
+
+	admin, err := repo.GetRoleByName(ctx, "ADMIN")
+	assert.NoError(t, err)
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c", Roles: []model.Role{admin}})
+	assert.NoError(t, err)
+	bob, err := repo.InsertUser(ctx, model.User{ID: "user_bob", Username: "bob", Email: "b@b.c"})
+	assert.NoError(t, err)
+
+	watch, secret, err := NewOAuthClient("Watch", []string{"https://watch.example/cb"}, []string{model.ScopeWorkoutsRead, model.ScopeProfileRead}, false)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, ClientSecretPrefix))
+	watch, err = repo.InsertOAuthClient(ctx, watch)
+	assert.NoError(t, err)
+	creds := ClientCredentials{ID: watch.ID, Secret: secret}
+	other, _, err := NewOAuthClient("Other", []string{"https://other.example/cb"}, []string{model.ScopeWorkoutsRead}, true)
+	assert.NoError(t, err)
+	other, err = repo.InsertOAuthClient(ctx, other)
+	assert.NoError(t, err)
+
+	verifier := strings.Repeat("v", 43)
+	req := AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            watch.ID,
+		RedirectURI:         "https://watch.example/cb",
+		Scope:               "workouts:read",
+		State:               "xyz",
+		CodeChallenge:       codeChallenge(verifier),
+		CodeChallengeMethod: "S256",
+	}
+	approve := func() string {
+		t.Helper()
+		redirectTo, err := server.Consent(ctx, alice.ID, req, true)
+		assert.NoError(t, err)
+		u, err := url.Parse(redirectTo)
+		assert.NoError(t, err)
+		assert.Equal(t, "xyz", u.Query().Get("state"))
+		return u.Query().Get("code")
+	}
+
+	t.Run("authorize", func(t *testing.T) {
+		consent, err := server.Authorize(ctx, alice.ID, req)
+		assert.NoError(t, err)
+		assert.Equal(t, watch.ID, consent.Client.ID)
+		assert.Equal(t, []string{model.ScopeWorkoutsRead}, consent.Scopes)
+		assert.Empty(t, consent.Granted)
+
+		bad := req
+		bad.RedirectURI = "https://evil.example/cb"
+		_, err = server.Authorize(ctx, alice.ID, bad)
+		assert.ErrorIs(t, err, ErrInvalidRedirect)
+
+		oauthErr := &OAuthError{}
+		bad = req
+		bad.Scope = "workouts:write"
+		_, err = server.Authorize(ctx, alice.ID, bad)
+		if assert.ErrorAs(t, err, &oauthErr) {
+			assert.Equal(t, OAuthInvalidScope, oauthErr.Code)
+			assert.Contains(t, bad.ErrorRedirect(oauthErr), "error=invalid_scope")
+		}
+		bad = req
+		bad.CodeChallengeMethod = "plain"
+		_, err = server.Authorize(ctx, alice.ID, bad)
+		if assert.ErrorAs(t, err, &oauthErr) {
+			assert.Equal(t, OAuthInvalidRequest, oauthErr.Code)
+		}
+
+		redirectTo, err := server.Consent(ctx, alice.ID, req, false)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://watch.example/cb?error=access_denied&error_description=the+user+denied+the+request&state=xyz", redirectTo)
+	})
+
+	t.Run("exchange", func(t *testing.T) {
+		code := approve()
+		_, err := server.Exchange(ctx, ClientCredentials{ID: watch.ID, Secret: "wrong"}, code, req.RedirectURI, verifier)
+		assert.ErrorContains(t, err, OAuthInvalidClient)
+		_, err = server.Exchange(ctx, ClientCredentials{ID: other.ID}, code, req.RedirectURI, verifier)
+		assert.ErrorContains(t, err, OAuthInvalidGrant)
+
+		code = approve()
+		_, err = server.Exchange(ctx, creds, code, req.RedirectURI, strings.Repeat("w", 43))
+		assert.ErrorContains(t, err, OAuthInvalidGrant)
+
+		code = approve()
+		pair, err := server.Exchange(ctx, creds, code, req.RedirectURI, verifier)
+		assert.NoError(t, err)
+		assert.Equal(t, "workouts:read", pair.Scope)
+
+		// a code coming back means it leaked
+		_, err = server.Exchange(ctx, creds, code, req.RedirectURI, verifier)
+		assert.ErrorContains(t, err, "already used")
+		_, err = server.Refresh(ctx, creds, pair.RefreshToken)
+		assert.ErrorContains(t, err, OAuthInvalidGrant)
+	})
+
+	code := approve()
+	pair, err := server.Exchange(ctx, creds, code, req.RedirectURI, verifier)
+	assert.NoError(t, err)
+
+	t.Run("bearer", func(t *testing.T) {
+		// routes without a scope are closed to apps
+		code, _ := verifyBearer(store, pair.AccessToken, alice.ID)
+		assert.Equal(t, http.StatusForbidden, code)
+
+		scoped := func(userID string) (int, *request.RequestContext) {
+			w := httptest.NewRecorder()
+			r := newRequest(nil, userID)
+			rc := request.GetRequestContext(r.Context())
+			rc.RequiredScope = model.ScopeWorkoutsRead
+			rc.RequiredPermission = model.PermissionWorkoutsRead
+			r.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+			store.VerifySession(w, r)
+			if rc.Stop {
+				return w.Code, rc
+			}
+			return http.StatusOK, rc
+		}
+		code, rc := scoped(alice.ID)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{model.ScopeWorkoutsRead}, rc.Scopes)
+		assert.NotContains(t, rc.Permissions, model.PermissionWorkoutsReadAny)
+
+		// apps only reach their own user, even when she is an admin
+		code, _ = scoped(bob.ID)
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+
+	t.Run("introspect", func(t *testing.T) {
+		info, err := server.Introspect(ctx, creds, pair.AccessToken)
+		assert.NoError(t, err)
+		assert.True(t, info.Active)
+		assert.Equal(t, "access_token", info.TokenType)
+		assert.Equal(t, alice.ID, info.Subject)
+		assert.Equal(t, "workouts:read", info.Scope)
+
+		info, err = server.Introspect(ctx, creds, pair.RefreshToken)
+		assert.NoError(t, err)
+		assert.True(t, info.Active)
+		assert.Equal(t, "refresh_token", info.TokenType)
+
+		// other clients' tokens are not theirs to see
+		info, err = server.Introspect(ctx, ClientCredentials{ID: other.ID}, pair.AccessToken)
+		assert.NoError(t, err)
+		assert.False(t, info.Active)
+		first, err := tokens.Issue(ctx, alice)
+		assert.NoError(t, err)
+		info, err = server.Introspect(ctx, creds, first.AccessToken)
+		assert.NoError(t, err)
+		assert.False(t, info.Active)
+		info, err = server.Introspect(ctx, creds, "garbage")
+		assert.NoError(t, err)
+		assert.False(t, info.Active)
+	})
+
+	t.Run("refresh and revoke", func(t *testing.T) {
+		_, err := tokens.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		_, err = server.Refresh(ctx, ClientCredentials{ID: other.ID}, pair.RefreshToken)
+		assert.ErrorContains(t, err, OAuthInvalidGrant)
+
+		next, err := server.Refresh(ctx, creds, pair.RefreshToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "workouts:read", next.Scope)
+
+		assert.NoError(t, server.Revoke(ctx, creds, next.RefreshToken))
+		assert.NoError(t, server.Revoke(ctx, creds, "unknown"))
+		info, err := server.Introspect(ctx, creds, next.RefreshToken)
+		assert.NoError(t, err)
+		assert.False(t, info.Active)
+	})
+
+	t.Run("revoke app", func(t *testing.T) {
+		_, err := server.Exchange(ctx, creds, approve(), req.RedirectURI, verifier)
+		assert.NoError(t, err)
+		grants, err := repo.GetUserOAuthGrants(ctx, alice.ID)
+		assert.NoError(t, err)
+		if assert.Len(t, grants, 1) {
+			assert.Equal(t, "Watch", grants[0].ClientName)
+			assert.Equal(t, []string{model.ScopeWorkoutsRead}, grants[0].Scopes)
+		}
+
+		n, err := server.RevokeApp(ctx, alice.ID, watch.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		_, err = server.RevokeApp(ctx, alice.ID, watch.ID)
+		assert.ErrorIs(t, err, dao.ErrOAuthGrantNotFound)
+
+		code, _ := verifyBearer(store, pair.AccessToken, alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		info, err := server.Introspect(ctx, creds, pair.AccessToken)
+		assert.NoError(t, err)
+		assert.False(t, info.Active)
+	})
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/mail"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrWrongPassword        = errors.New("current password is wrong")
+	ErrInvalidPasswordReset = errors.New("invalid or expired password reset token")
+)
+
+const (
+	defaultPasswordResetURL    = "http://localhost:3000/reset-password"
+	defaultPasswordResetTTL    = time.Hour
+	defaultPasswordResetResend = time.Minute
+)
+
+// PasswordResetConfig configures forgotten password emails. Zero values take
+// the defaults: links to http://localhost:3000/reset-password that last an
+// hour, and one email a minute per user.
+type PasswordResetConfig struct {
+	// URL is the frontend page that takes the new password. The token is
+	// added as a query parameter for it to send to POST /auth/password/reset.
+	URL            string
+	TTL            time.Duration
+	ResendInterval time.Duration
+}
+
+// PasswordService changes and resets passwords. Either way the user's
+// sessions, refresh tokens, personal access tokens and app grants are
+// revoked, so whoever knew the old password is locked out. Wrong current
+// passwords count towards the login lockout.
+type PasswordService struct {
+	users    dao.UserRepository
+	resets   dao.PasswordResetRepository
+	sessions dao.SessionRepository
+	refresh  dao.RefreshTokenRepository
+	pats     dao.PersonalAccessTokenRepository
+	grants   dao.OAuthRepository
+	guard    *LoginGuard
+	mailer   mail.Mailer
+	cfg      PasswordResetConfig
+	now      func() time.Time
+}
+
+func NewPasswordService(users dao.UserRepository, resets dao.PasswordResetRepository, sessions dao.SessionRepository, refresh dao.RefreshTokenRepository, pats dao.PersonalAccessTokenRepository, grants dao.OAuthRepository, guard *LoginGuard, mailer mail.Mailer, cfg PasswordResetConfig) *PasswordService {
+	if cfg.URL == "" {
+		cfg.URL = defaultPasswordResetURL
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultPasswordResetTTL
+	}
+	if cfg.ResendInterval <= 0 {
+		cfg.ResendInterval = defaultPasswordResetResend
+	}
+
+	return &PasswordService{
+		users:    users,
+		resets:   resets,
+		sessions: sessions,
+		refresh:  refresh,
+		pats:     pats,
+		grants:   grants,
+		guard:    guard,
+		mailer:   mailer,
+		cfg:      cfg,
+		now:      time.Now,
+	}
+}
+
+// Change replaces the user's password after checking their current one, and
+// returns how many sessions, tokens and grants were revoked. Wrong current
+// passwords are counted against the username and ip like failed logins, and
+// once they are locked it fails with a LoginLockedError.
+func (s *PasswordService) Change(ctx context.Context, userID string, current string, password string, ip string) (int, error) {
+	user, err := s.users.GetUser(ctx, dao.UserQuery{ID: userID, Primary: true})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.guard.Check(ctx, user.Username, ip); err != nil {
+		return 0, err
+	}
+
+	ok, _, err := crypt.VerifyPassword(current, user.Password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check password. %w", err)
+	}
+	if !ok {
+		slog.WarnContext(ctx, "wrong current password", "user_id", userID, "ip", ip)
+		if err := s.guard.Failed(ctx, user.Username, user.ID, ip); err != nil {
+			return 0, fmt.Errorf("failed to record wrong password. %w", err)
+		}
+		return 0, ErrWrongPassword
+	}
+
+	if err := s.guard.Succeeded(ctx, user.Username); err != nil {
+		return 0, fmt.Errorf("failed to clear failed logins. %w", err)
+	}
+
+	return s.setPassword(ctx, userID, password)
+}
+
+// Forgot mails a reset link to the user with the email. Nothing is sent for
+// unknown emails, or to users sent one less than ResendInterval ago, and the
+// caller is not told, so the endpoint cannot be used to find accounts or
+// flood an inbox.
+func (s *PasswordService) Forgot(ctx context.Context, email string) error {
+	user, err := s.users.GetUser(ctx, dao.UserQuery{Email: email, Primary: true})
+	if errors.Is(err, dao.ErrUserNotFound) {
+		slog.InfoContext(ctx, "password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user. %w", err)
+	}
+
+	now := s.now()
+	err = s.resets.MarkPasswordResetSent(ctx, user.ID, now, s.cfg.ResendInterval)
+	if errors.Is(err, dao.ErrPasswordResetRateLimited) {
+		slog.InfoContext(ctx, "password reset requested too soon after the last", "user_id", user.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mark password reset sent. %w", err)
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate password reset token. %w", err)
+	}
+
+	_, err = s.resets.InsertPasswordReset(ctx, model.PasswordReset{
+		ID:        fmt.Sprintf("reset_%s", ksuid.New().String()),
+		UserID:    user.ID,
+		TokenHash: hashSessionToken(token),
+		Expires:   now.Add(s.cfg.TTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert password reset. %w", err)
+	}
+
+	link, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse password reset url. %w", err)
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to choose a new password:\n\n%s\n\nIt expires in %s and works once. If you did not ask for this, you can ignore this email.\n",
+			user.Username, link.String(), s.cfg.TTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send password reset email. %w", err)
+	}
+
+	slog.InfoContext(ctx, "sent password reset email", "user_id", user.ID)
+	return nil
+}
+
+// Reset spends the reset token and sets the user's password. It returns the
+// user's ID and how many sessions, tokens and grants were revoked. The token
+// is only spent if the password is set.
+func (s *PasswordService) Reset(ctx context.Context, token string, password string) (string, int, error) {
+	userID, n := "", 0
+	err := s.users.WithTx(ctx, func(ctx context.Context) error {
+		reset, err := s.resets.UsePasswordReset(ctx, hashSessionToken(token))
+		if errors.Is(err, dao.ErrPasswordResetNotFound) {
+			return ErrInvalidPasswordReset
+		}
+		if err != nil {
+			return fmt.Errorf("failed to use password reset. %w", err)
+		}
+
+		userID = reset.UserID
+		n, err = s.setPassword(ctx, reset.UserID, password)
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return userID, n, nil
+}
+
+func (s *PasswordService) setPassword(ctx context.Context, userID string, password string) (int, error) {
+	hash, err := crypt.HashPassword(password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password. %w", err)
+	}
+
+	if err := s.users.UpdatePassword(ctx, userID, hash); err != nil {
+		return 0, fmt.Errorf("failed to update password. %w", err)
+	}
+
+	n, err := s.sessions.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to revoke sessions. %w", err)
+	}
+
+	tokens, err := s.refresh.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to revoke refresh tokens. %w", err)
+	}
+	n += tokens
+
+	pats, err := s.pats.RevokeUserPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to revoke personal access tokens. %w", err)
+	}
+	n += pats
+
+	grants, err := s.grants.DeleteUserOAuthGrants(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to delete oauth grants. %w", err)
+	}
+	n += grants
+
+	slog.InfoContext(ctx, "changed password", "user_id", userID, "revoked", n)
+	return n, nil
+}
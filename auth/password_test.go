@@ -0,0 +1,130 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// brokenUsers fails every password update.
+type brokenUsers struct {
+	*dao.Memory
+}
+
+func (u brokenUsers) UpdatePassword(ctx context.Context, id string, hash string) error {
+	return errors.New("boom")
+}
+
+func TestPasswordService(t *testing.T) {
+	ctx := context.Background()
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+	repo := dao.NewMemory()
+	box := &outbox{}
+	guard := NewLoginGuard(repo, repo, LockoutConfig{MaxAccountFailures: 3, Delay: time.Millisecond, MaxDelay: time.Millisecond})
+	s := NewPasswordService(repo, repo, repo, repo, repo, repo, guard, box, PasswordResetConfig{URL: "https://app.example.com/reset"})
+
+	hash, err := crypt.HashPassword("Old-passw0rd")
+	assert.NoError(t, err)
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c", Password: hash})
+	assert.NoError(t, err)
+	_, err = repo.InsertUser(ctx, model.User{ID: "user_bob", Username: "bob", Email: "b@b.c", Password: hash})
+	assert.NoError(t, err)
+
+	login := func() {
+		_, err := repo.InsertSession(ctx, model.Session{ID: "session_" + time.Now().String(), TokenHash: time.Now().String(), UserID: alice.ID, Expires: time.Now().Add(time.Hour)})
+		assert.NoError(t, err)
+	}
+	password := func(p string) bool {
+		u, err := repo.GetUser(ctx, dao.UserQuery{ID: alice.ID})
+		assert.NoError(t, err)
+		ok, _, err := crypt.VerifyPassword(p, u.Password)
+		assert.NoError(t, err)
+		return ok
+	}
+
+	login()
+	_, err = s.Change(ctx, alice.ID, "wrong", "New-passw0rd", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrWrongPassword)
+	n, err := s.Change(ctx, alice.ID, "Old-passw0rd", "New-passw0rd", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, password("New-passw0rd"))
+	sessions, err := repo.GetUserSessions(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// guessing the current password locks it out like failed logins do
+	for range 3 {
+		_, err = s.Change(ctx, alice.ID, "wrong", "Guess-passw0rd", "10.0.0.1")
+		assert.ErrorIs(t, err, ErrWrongPassword)
+	}
+	_, err = s.Change(ctx, alice.ID, "New-passw0rd", "Guess-passw0rd", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrLoginLocked)
+	assert.ErrorIs(t, guard.Check(ctx, "alice", "10.0.0.2"), ErrLoginLocked)
+	assert.True(t, password("New-passw0rd"))
+	assert.NoError(t, guard.Unlock(ctx, alice.ID, "user_admin"))
+
+	// unknown emails look the same to the caller but nothing is sent
+	assert.NoError(t, s.Forgot(ctx, "nobody@b.c"))
+	assert.Empty(t, box.sent)
+
+	// one email a user per resend interval, however often it is asked for
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	assert.NoError(t, s.Forgot(ctx, "a@b.c"))
+	assert.NoError(t, s.Forgot(ctx, "a@b.c"))
+	assert.Len(t, box.sent, 1)
+	now = now.Add(defaultPasswordResetResend)
+	assert.NoError(t, s.Forgot(ctx, "a@b.c"))
+	assert.Len(t, box.sent, 2)
+	assert.Equal(t, "a@b.c", box.sent[0].To)
+	first, second := linkToken(t, box.sent[0]), linkToken(t, box.sent[1])
+
+	_, _, err = s.Reset(ctx, "nope", "Reset-passw0rd")
+	assert.ErrorIs(t, err, ErrInvalidPasswordReset)
+
+	// a reset that fails to set the password leaves the token unspent
+	broken := NewPasswordService(brokenUsers{repo}, repo, repo, repo, repo, repo, guard, box, PasswordResetConfig{URL: "https://app.example.com/reset"})
+	_, _, err = broken.Reset(ctx, second, "Reset-passw0rd")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidPasswordReset)
+
+	// a reset locks out everything the old password could have handed out
+	login()
+	_, err = repo.InsertPersonalAccessToken(ctx, model.PersonalAccessToken{ID: "pat_alice", UserID: alice.ID, TokenHash: "pat_alice"})
+	assert.NoError(t, err)
+	_, err = repo.InsertOAuthClient(ctx, model.OAuthClient{ID: "client_app", Name: "app"})
+	assert.NoError(t, err)
+	_, err = repo.SaveOAuthGrant(ctx, model.OAuthGrant{UserID: alice.ID, ClientID: "client_app", Scopes: []string{"profile"}})
+	assert.NoError(t, err)
+	id, n, err := s.Reset(ctx, second, "Reset-passw0rd")
+	assert.NoError(t, err)
+	assert.Equal(t, alice.ID, id)
+	assert.Equal(t, 3, n)
+	assert.True(t, password("Reset-passw0rd"))
+	_, err = repo.GetPersonalAccessTokenByToken(ctx, "pat_alice")
+	assert.ErrorIs(t, err, dao.ErrPersonalAccessTokenNotFound)
+	_, err = repo.GetOAuthGrant(ctx, alice.ID, "client_app")
+	assert.ErrorIs(t, err, dao.ErrOAuthGrantNotFound)
+
+	// tokens work once, and using one spends the others
+	_, _, err = s.Reset(ctx, second, "Again-passw0rd")
+	assert.ErrorIs(t, err, ErrInvalidPasswordReset)
+	_, _, err = s.Reset(ctx, first, "Again-passw0rd")
+	assert.ErrorIs(t, err, ErrInvalidPasswordReset)
+
+	s.now = func() time.Time { return time.Now().Add(-2 * defaultPasswordResetTTL) }
+	assert.NoError(t, s.Forgot(ctx, "b@b.c"))
+	_, _, err = s.Reset(ctx, linkToken(t, box.sent[2]), "Again-passw0rd")
+	assert.ErrorIs(t, err, ErrInvalidPasswordReset)
+	assert.True(t, password("Reset-passw0rd"))
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so they can be told
+// apart from access tokens in the Authorization header, and spotted by secret
+// scanners.
+const PersonalAccessTokenPrefix = "sbx_pat_"
+
+// personalAccessTokenTouchInterval limits how often last_used is written for a
+// busy token.
+const personalAccessTokenTouchInterval = time.Minute
+
+// NewPersonalAccessToken returns a token for the user along with the secret to
+// hand to them. Only the secret's hash is kept in the token.
+func NewPersonalAccessToken(userID, name string, scopes []string, expires *time.Time) (model.PersonalAccessToken, string, error) {
+	secret, err := newSessionToken()
+	if err != nil {
+		return model.PersonalAccessToken{}, "", fmt.Errorf("failed to create personal access token. %w", err)
+	}
+	secret = PersonalAccessTokenPrefix + secret
+
+	return model.PersonalAccessToken{
+		ID:        fmt.Sprintf("pat_%s", ksuid.New().String()),
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashSessionToken(secret),
+		Scopes:    scopes,
+		Expires:   expires,
+	}, secret, nil
+}
+
+// verifyPersonalAccessToken lets a personal access token through to routes
+// that require one of its scopes. Routes without a scope are closed to them.
+func (store *BearerSessionStore) verifyPersonalAccessToken(w http.ResponseWriter, r *http.Request, secret string) {
+	ctx := r.Context()
+	token, err := store.pats.GetPersonalAccessTokenByToken(ctx, hashSessionToken(secret))
+	if err != nil {
+		if !errors.Is(err, dao.ErrPersonalAccessTokenNotFound) {
+			slog.ErrorContext(ctx, "failed to get personal access token", "err", err)
+		}
+		r = stop(r, ctx)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		return
+	}
+	if !checkAccount(w, r, token.UserID, token.Account, store.now()) {
+		return
+	}
+
+	if !requireScope(w, r, token.Scopes) {
+		slog.WarnContext(ctx, "personal access token lacks scope", "token_id", token.ID)
+		return
+	}
+
+	if !authorize(w, r, token.UserID, token.Roles, token.Permissions) {
+		return
+	}
+	request.GetRequestContext(ctx).Scopes = token.Scopes
+
+	store.touch(ctx, token)
+}
+
+// requireScope stops the request unless scopes include the one the route
+// requires. Routes without a scope are closed to scoped tokens.
+func requireScope(w http.ResponseWriter, r *http.Request, scopes []string) bool {
+	ctx := r.Context()
+	rc := request.GetRequestContext(ctx)
+	if rc != nil && rc.RequiredScope != "" && slices.Contains(scopes, rc.RequiredScope) {
+		return true
+	}
+
+	scope := ""
+	if rc != nil {
+		scope = rc.RequiredScope
+	}
+	slog.WarnContext(ctx, "token lacks scope", "scope", scope, "scopes", scopes)
+	r = stop(r, ctx)
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+	http.Error(w, "Insufficient Scope", http.StatusForbidden)
+	return false
+}
+
+func (store *BearerSessionStore) touch(ctx context.Context, token model.PersonalAccessToken) {
+	if token.LastUsed != nil && store.now().Sub(*token.LastUsed) < personalAccessTokenTouchInterval {
+		return
+	}
+	if err := store.pats.TouchPersonalAccessToken(ctx, token.ID); err != nil {
+		slog.WarnContext(ctx, "failed to touch personal access token", "err", err)
+	}
+}
+
+func isPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
@@ -0,0 +1,84 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func verifyScoped(store SessionStore, token string, userID string, scope string) (int, *request.RequestContext, http.Header) {
+	w := httptest.NewRecorder()
+	r := newRequest(nil, userID)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rc := request.GetRequestContext(r.Context())
+	rc.RequiredScope = scope
+	store.VerifySession(w, r)
+	if rc.Stop {
+		return w.Code, rc, w.Header()
+	}
+	return http.StatusOK, rc, w.Header()
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	now := time.Now()
+	store := NewBearerSessionStore(NewPostgresSessionStore(repo, repo, 0), NewTokenService(repo, repo, TokenConfig{}), repo, repo, repo, repo)
+	store.now = func() time.Time { return now }
+
+	civilian, err := repo.GetRoleByName(ctx, "CIVILIAN")
+	assert.NoError(t, err)
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c", Roles: []model.Role{civilian}})
+	assert.NoError(t, err)
+
+	token, secret, err := NewPersonalAccessToken(alice.ID, "spreadsheet", []string{model.ScopeWorkoutsRead}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, secret, PersonalAccessTokenPrefix)
+	assert.NotContains(t, token.TokenHash, secret)
+	token, err = repo.InsertPersonalAccessToken(ctx, token)
+	assert.NoError(t, err)
+
+	code, rc, _ := verifyScoped(store, secret, alice.ID, model.ScopeWorkoutsRead)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, alice.ID, rc.UserID)
+	assert.Equal(t, []string{"CIVILIAN"}, rc.Roles)
+	assert.Equal(t, []string{model.ScopeWorkoutsRead}, rc.Scopes)
+
+	tokens, err := repo.GetUserPersonalAccessTokens(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, tokens[0].LastUsed)
+
+	// a scope the token lacks, or a route without one, is forbidden
+	code, _, header := verifyScoped(store, secret, alice.ID, model.ScopeWorkoutsWrite)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, header.Get("WWW-Authenticate"), "insufficient_scope")
+	code, _, _ = verifyScoped(store, secret, alice.ID, "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _, _ = verifyScoped(store, secret, "user_someone_else", model.ScopeWorkoutsRead)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _, _ = verifyScoped(store, PersonalAccessTokenPrefix+"forged", alice.ID, model.ScopeWorkoutsRead)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	assert.NoError(t, repo.RevokePersonalAccessToken(ctx, alice.ID, token.ID))
+	code, _, _ = verifyScoped(store, secret, alice.ID, model.ScopeWorkoutsRead)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	expires := now.Add(-time.Second)
+	expired, secret, err := NewPersonalAccessToken(alice.ID, "old", []string{model.ScopeWorkoutsRead}, &expires)
+	assert.NoError(t, err)
+	_, err = repo.InsertPersonalAccessToken(ctx, expired)
+	assert.NoError(t, err)
+	code, _, _ = verifyScoped(store, secret, alice.ID, model.ScopeWorkoutsRead)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+const defaultSessionTTL = time.Hour
+
+// PostgresSessionStore keeps sessions on the server. The cookie carries only
+// an opaque random token, so a session can be revoked at any time and the
+// revocation is seen on the next request.
+type PostgresSessionStore struct {
+	sessions dao.SessionRepository
+	events   dao.SecurityRepository
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+var _ SessionStore = (*PostgresSessionStore)(nil)
+
+// NewPostgresSessionStore returns a store whose sessions expire after ttl
+// without use. A zero ttl means one hour. Impersonations it ends are recorded
+// in events.
+func NewPostgresSessionStore(sessions dao.SessionRepository, events dao.SecurityRepository, ttl time.Duration) *PostgresSessionStore {
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+
+	return &PostgresSessionStore{
+		sessions: sessions,
+		events:   events,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+// EstablishSession is a no op. Sessions are only created for a known user
+// with StartSession.
+func (store *PostgresSessionStore) EstablishSession(w http.ResponseWriter, r *http.Request) {
+}
+
+func (store *PostgresSessionStore) StartSession(w http.ResponseWriter, r *http.Request, user model.User) error {
+	ctx := r.Context()
+	token, err := newSessionToken()
+	if err != nil {
+		return fmt.Errorf("failed to create session token. %w", err)
+	}
+
+	session, err := store.sessions.InsertSession(ctx, model.Session{
+		ID:        fmt.Sprintf("ses_%s", ksuid.New().String()),
+		TokenHash: hashSessionToken(token),
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        ClientIP(r),
+		Expires:   store.now().Add(store.ttl),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to establish session")
+		return fmt.Errorf("failed to insert session. %w", err)
+	}
+
+	roles := roleNames(user)
+	if rc := request.GetRequestContext(ctx); rc != nil {
+		rc.UserID = user.ID
+		rc.RealUserID = user.ID
+		rc.Roles = roles
+		rc.Permissions = permissionNames(user)
+		rc.SessionID = session.ID
+	}
+
+	slog.DebugContext(ctx, "HYDRATING SESSION", "user_id", user.ID, "session_id", session.ID, "roles", roles)
+	setSessionCookie(w, token)
+
+	return nil
+}
+
+func (store *PostgresSessionStore) VerifySession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		slog.ErrorContext(ctx, "failed to verify session", "err", err)
+		r = stop(r, ctx)
+		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := store.sessions.GetSessionByToken(ctx, hashSessionToken(cookie.Value))
+	if err != nil {
+		if errors.Is(err, dao.ErrSessionNotFound) {
+			slog.WarnContext(ctx, "unknown, expired or revoked session")
+			store.endExpiredImpersonation(r, cookie.Value)
+			clearSessionCookie(w)
+		} else {
+			slog.ErrorContext(ctx, "failed to verify session", "err", err)
+		}
+		r = stop(r, ctx)
+		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		return
+	}
+
+	// an impersonation ends as soon as the admin could not start it again
+	if session.ImpersonatorID != "" && !impersonatorAllowed(session, store.now()) {
+		slog.WarnContext(ctx, "ended impersonation of an admin who can no longer impersonate", "session_id", session.ID, "impersonator_id", session.ImpersonatorID)
+		if err := store.sessions.RevokeSession(ctx, session.UserID, session.ID); err != nil && !errors.Is(err, dao.ErrSessionNotFound) {
+			slog.ErrorContext(ctx, "failed to revoke impersonation", "session_id", session.ID, "err", err)
+		} else if err == nil {
+			if err := recordImpersonationEnd(r, store.events, session); err != nil {
+				slog.ErrorContext(ctx, "failed to record end of impersonation", "session_id", session.ID, "err", err)
+			}
+		}
+		clearSessionCookie(w)
+		r = stop(r, ctx)
+		http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+		return
+	}
+	if !checkAccount(w, r, session.UserID, session.Account, store.now()) {
+		return
+	}
+	if !authorize(w, r, session.UserID, session.Roles, session.Permissions) {
+		return
+	}
+
+	if rc := request.GetRequestContext(ctx); rc != nil {
+		rc.SessionID = session.ID
+		if session.ImpersonatorID != "" {
+			rc.RealUserID = session.ImpersonatorID
+		}
+	}
+
+	// refreshing the expiry on every request would be a write per request, so
+	// it is only pushed out once a minute. Impersonations are never extended.
+	if now := store.now(); session.ImpersonatorID == "" && now.Sub(session.LastSeen) > time.Minute {
+		if err := store.sessions.TouchSession(ctx, session.ID, now.Add(store.ttl)); err != nil {
+			slog.WarnContext(ctx, "failed to touch session", "session_id", session.ID, "err", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "The cake is a lie!")
+}
+
+// TerminateSession revokes the session the request was made with and clears
+// its cookie. A missing or already ended session is not an error. Ending an
+// impersonation this way is recorded, and the admin's parked cookie is
+// cleared too.
+func (store *PostgresSessionStore) TerminateSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	defer clearSessionCookie(w)
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return
+	}
+
+	session, err := store.sessions.GetSessionByToken(ctx, hashSessionToken(cookie.Value))
+	if errors.Is(err, dao.ErrSessionNotFound) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to terminate session", "err", err)
+		r = stop(r, ctx)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := store.sessions.RevokeSession(ctx, session.UserID, session.ID); err != nil && !errors.Is(err, dao.ErrSessionNotFound) {
+		slog.ErrorContext(ctx, "failed to terminate session", "err", err)
+		r = stop(r, ctx)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if session.ImpersonatorID != "" {
+		clearImpersonatorCookie(w)
+		if err := recordImpersonationEnd(r, store.events, session); err != nil {
+			slog.ErrorContext(ctx, "failed to record end of impersonation", "session_id", session.ID, "err", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "terminated session", "session_id", session.ID)
+}
+
+// endExpiredImpersonation records the end of the impersonation the token was
+// for, if it expired and nobody has noticed yet.
+func (store *PostgresSessionStore) endExpiredImpersonation(r *http.Request, token string) {
+	ctx := r.Context()
+	session, err := store.sessions.EndExpiredImpersonation(ctx, hashSessionToken(token))
+	if errors.Is(err, dao.ErrSessionNotFound) {
+		return
+	}
+	if err == nil {
+		err = recordImpersonationEnd(r, store.events, session)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to end expired impersonation", "err", err)
+	}
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSessionToken is what is stored in place of the token, so reading the
+// session table does not hand out working cookies.
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClientIP is the address the request came from.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
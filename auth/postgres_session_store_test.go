@@ -0,0 +1,130 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRequest is a request to a route on the user's profile, which needs
+// users:read.
+func newRequest(cookie *http.Cookie, userID string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(request.WithRequestContext(r.Context(), &request.RequestContext{RequiredPermission: model.PermissionUsersRead}))
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	if userID != "" {
+		r = mux.SetURLVars(r, map[string]string{"user_id": userID})
+	}
+	return r
+}
+
+func login(t *testing.T, store *PostgresSessionStore, user model.User) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	assert.NoError(t, store.StartSession(w, newRequest(nil, ""), user))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != cookieName {
+		t.Fatalf("expected a session cookie. %v", cookies)
+	}
+	return cookies[0]
+}
+
+func verify(store SessionStore, cookie *http.Cookie, userID string) (int, *request.RequestContext) {
+	w := httptest.NewRecorder()
+	r := newRequest(cookie, userID)
+	store.VerifySession(w, r)
+	rc := request.GetRequestContext(r.Context())
+	if rc.Stop {
+		return w.Code, rc
+	}
+	return http.StatusOK, rc
+}
+
+func TestPostgresSessionStore(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	store := NewPostgresSessionStore(repo, repo, 0)
+
+	civilian, err := repo.GetRoleByName(ctx, "CIVILIAN")
+	assert.NoError(t, err)
+	admin, err := repo.GetRoleByName(ctx, "ADMIN")
+	assert.NoError(t, err)
+
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c", Roles: []model.Role{civilian}})
+	assert.NoError(t, err)
+	root, err := repo.InsertUser(ctx, model.User{ID: "user_root", Username: "root", Email: "r@b.c", Roles: []model.Role{admin}})
+	assert.NoError(t, err)
+
+	phone := login(t, store, alice)
+	laptop := login(t, store, alice)
+	rootCookie := login(t, store, root)
+
+	code, rc := verify(store, phone, alice.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, alice.ID, rc.UserID)
+	assert.Equal(t, []string{"CIVILIAN"}, rc.Roles)
+	assert.NotEmpty(t, rc.SessionID)
+
+	code, _ = verify(store, phone, root.ID)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, rc = verify(store, rootCookie, alice.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, rc.Permissions, model.PermissionUsersReadAny)
+
+	// routes without a permission are for their own user only
+	w := httptest.NewRecorder()
+	r := newRequest(rootCookie, alice.ID)
+	request.GetRequestContext(r.Context()).RequiredPermission = ""
+	store.VerifySession(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// role changes reach live sessions
+	assert.NoError(t, repo.AddUserRole(ctx, alice.ID, admin.ID))
+	code, _ = verify(store, phone, root.ID)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, repo.RemoveUserRole(ctx, alice.ID, admin.ID))
+	code, _ = verify(store, phone, root.ID)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = verify(store, nil, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = verify(store, &http.Cookie{Name: cookieName, Value: "forged"}, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// logout ends only the session it is made with
+	w = httptest.NewRecorder()
+	store.TerminateSession(w, newRequest(phone, ""))
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+	code, _ = verify(store, phone, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = verify(store, laptop, "")
+	assert.Equal(t, http.StatusOK, code)
+
+	// an admin revoking alice's sessions takes effect on her next request
+	sessions, err := repo.GetUserSessions(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	n, err := repo.RevokeUserSessions(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	code, _ = verify(store, laptop, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// deleted users lose their sessions
+	assert.NoError(t, repo.DeleteUser(ctx, root.ID))
+	code, _ = verify(store, rootCookie, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
@@ -2,13 +2,13 @@ package auth
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"slices"
 
-	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"github.com/slham/sandbox-api/model"
 	"github.com/slham/sandbox-api/request"
 )
 
@@ -16,6 +16,9 @@ var (
 	cookieName = "sandbox-cookie"
 )
 
+// StandardSessionStore keeps the session in a signed cookie. It never looks
+// the user up, so it does not see suspended or deactivated accounts, or
+// changes to their roles, until their cookie expires.
 type StandardSessionStore struct {
 	cookieStore *sessions.CookieStore
 }
@@ -89,23 +92,45 @@ func (store *StandardSessionStore) VerifySession(w http.ResponseWriter, r *http.
 		return
 	}
 
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
-
-	if userID != "" && userID != sessionUserID {
-		if !isAdmin(roles) {
-			slog.ErrorContext(ctx, "INTRUDER!", "session_user_id", sessionUserID, "client_user_id", userID)
-			r = stop(r, ctx)
-			http.Error(w, "FUCK OFF!", http.StatusForbidden)
-			return
-		} else {
-			slog.InfoContext(ctx, "SIR, YES, SIR!")
-		}
+	// cookies from before permissions carry none, and get their owner nowhere
+	permissions, _ := session.Values["permissions"].([]string)
+
+	if !authorize(w, r, sessionUserID, roles, permissions) {
+		return
 	}
 
 	slog.InfoContext(ctx, "The cake is a lie!")
 }
 
+func (store *StandardSessionStore) StartSession(w http.ResponseWriter, r *http.Request, user model.User) error {
+	ctx := r.Context()
+	session, err := store.cookieStore.Get(r, cookieName)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to establish session")
+		return fmt.Errorf("failed to establish session. %w", err)
+	}
+
+	roles := roleNames(user)
+	permissions := permissionNames(user)
+	if rc := request.GetRequestContext(ctx); rc != nil {
+		rc.UserID = user.ID
+		rc.RealUserID = user.ID
+		rc.Roles = roles
+		rc.Permissions = permissions
+	}
+
+	slog.DebugContext(ctx, "HYDRATING SESSION", "user_id", user.ID, "roles", roles)
+	session.Values["authenticated"] = true
+	session.Values["user_id"] = user.ID
+	session.Values["roles"] = roles
+	session.Values["permissions"] = permissions
+	if err := session.Save(r, w); err != nil {
+		return fmt.Errorf("failed to save session. %w", err)
+	}
+
+	return nil
+}
+
 func (store *StandardSessionStore) TerminateSession(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	session, err := store.cookieStore.Get(r, cookieName)
@@ -120,10 +145,6 @@ func (store *StandardSessionStore) TerminateSession(w http.ResponseWriter, r *ht
 	session.Save(r, w)
 }
 
-func isAdmin(roles []string) bool {
-	return slices.Contains(roles, "ADMIN")
-}
-
 func stop(r *http.Request, ctx context.Context) *http.Request {
 	ctx = request.SetStop(ctx)
 	r = r.WithContext(ctx)
@@ -0,0 +1,465 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/jwt"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+const (
+	defaultTokenIssuer     = "sandbox-api"
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	defaultKeyRotation     = 24 * time.Hour
+	// keyReloadInterval limits how often an unknown kid reloads the keys, which
+	// is how keys made by other instances are picked up.
+	keyReloadInterval = 10 * time.Second
+)
+
+// TokenConfig configures access and refresh tokens. Zero values take the
+// defaults: issuer sandbox-api, 15 minute access tokens, 30 day refresh
+// tokens and a new signing key every day.
+type TokenConfig struct {
+	Issuer      string
+	AccessTTL   time.Duration
+	RefreshTTL  time.Duration
+	KeyRotation time.Duration
+}
+
+// TokenPair is what a client gets from logging in or refreshing.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	// Scope is set on tokens issued to OAuth clients.
+	Scope string `json:"scope,omitempty"`
+}
+
+// AccessClaims are carried by an access token. They give the same user and
+// roles as the session would. Tokens issued to OAuth clients also name the
+// client and the space separated scopes it was granted.
+type AccessClaims struct {
+	jwt.Claims
+	Roles    []string `json:"roles"`
+	Scope    string   `json:"scope,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+}
+
+type signingKey struct {
+	signer  jwt.Signer
+	public  crypto.PublicKey
+	created time.Time
+}
+
+// TokenService issues signed access tokens and rotating refresh tokens. The
+// signing keys live in the database so every instance signs with and accepts
+// the same keys. A key signs for KeyRotation and is published for AccessTTL
+// longer so the last tokens it signed still verify.
+type TokenService struct {
+	refresh dao.RefreshTokenRepository
+	keys    dao.SigningKeyRepository
+	cfg     TokenConfig
+	now     func() time.Time
+
+	mu     sync.Mutex
+	ring   []signingKey
+	loaded time.Time
+}
+
+func NewTokenService(refresh dao.RefreshTokenRepository, keys dao.SigningKeyRepository, cfg TokenConfig) *TokenService {
+	if cfg.Issuer == "" {
+		cfg.Issuer = defaultTokenIssuer
+	}
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = defaultAccessTokenTTL
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = defaultRefreshTokenTTL
+	}
+	if cfg.KeyRotation <= 0 {
+		cfg.KeyRotation = defaultKeyRotation
+	}
+
+	return &TokenService{
+		refresh: refresh,
+		keys:    keys,
+		cfg:     cfg,
+		now:     time.Now,
+	}
+}
+
+// Issue logs the user in, starting a new family of refresh tokens.
+func (s *TokenService) Issue(ctx context.Context, user model.User) (TokenPair, error) {
+	return s.issue(ctx, model.RefreshToken{UserID: user.ID, Roles: roleNames(user), FamilyID: newRefreshTokenFamilyID()})
+}
+
+// IssueClient gives the OAuth client tokens limited to scopes on the user's
+// behalf, starting a new family of refresh tokens.
+func (s *TokenService) IssueClient(ctx context.Context, userID string, clientID string, scopes []string) (TokenPair, error) {
+	return s.issue(ctx, model.RefreshToken{UserID: userID, ClientID: clientID, Scopes: scopes, FamilyID: newRefreshTokenFamilyID()})
+}
+
+// Refresh swaps the refresh token for a new pair. A token that was already
+// swapped means it leaked, so the whole family is revoked and the client has
+// to log in again. Tokens issued to OAuth clients are refreshed with
+// RefreshClient.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	return s.refreshFor(ctx, refreshToken, "")
+}
+
+// RefreshClient is Refresh for the OAuth client the token was issued to.
+func (s *TokenService) RefreshClient(ctx context.Context, refreshToken string, clientID string) (TokenPair, error) {
+	return s.refreshFor(ctx, refreshToken, clientID)
+}
+
+func (s *TokenService) refreshFor(ctx context.Context, refreshToken string, clientID string) (TokenPair, error) {
+	token, err := s.lookup(ctx, refreshToken, clientID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if token.Used != nil {
+		return TokenPair{}, s.reused(ctx, token)
+	}
+	if token.Revoked != nil || !token.Expires.After(s.now()) {
+		return TokenPair{}, ErrInvalidToken
+	}
+	if err := CheckAccount(token.Account, s.now()); err != nil {
+		return TokenPair{}, err
+	}
+
+	err = s.refresh.UseRefreshToken(ctx, token.ID)
+	if errors.Is(err, dao.ErrRefreshTokenUsed) {
+		// someone else swapped it first
+		return TokenPair{}, s.reused(ctx, token)
+	}
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("failed to use refresh token. %w", err)
+	}
+	if token.ClientID != "" {
+		// clients go by their scopes, not the user's roles
+		token.Roles = nil
+	}
+
+	return s.issue(ctx, token)
+}
+
+func (s *TokenService) reused(ctx context.Context, token model.RefreshToken) error {
+	slog.WarnContext(ctx, "refresh token reused. revoking family", "user_id", token.UserID, "family_id", token.FamilyID)
+	if _, err := s.refresh.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family. %w", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+// Revoke ends the login the refresh token belongs to. Unknown tokens are not
+// an error.
+func (s *TokenService) Revoke(ctx context.Context, refreshToken string) error {
+	return s.revokeFor(ctx, refreshToken, "")
+}
+
+// RevokeClient is Revoke for the OAuth client the token was issued to.
+func (s *TokenService) RevokeClient(ctx context.Context, refreshToken string, clientID string) error {
+	return s.revokeFor(ctx, refreshToken, clientID)
+}
+
+func (s *TokenService) revokeFor(ctx context.Context, refreshToken string, clientID string) error {
+	token, err := s.lookup(ctx, refreshToken, clientID)
+	if errors.Is(err, ErrInvalidToken) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.refresh.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family. %w", err)
+	}
+
+	return nil
+}
+
+// lookup finds the refresh token, as long as it was issued to clientID. Login
+// tokens have no client.
+func (s *TokenService) lookup(ctx context.Context, refreshToken string, clientID string) (model.RefreshToken, error) {
+	token, err := s.refresh.GetRefreshToken(ctx, hashSessionToken(refreshToken))
+	if errors.Is(err, dao.ErrRefreshTokenNotFound) {
+		return token, ErrInvalidToken
+	}
+	if err != nil {
+		return token, fmt.Errorf("failed to get refresh token. %w", err)
+	}
+	if token.ClientID != clientID {
+		return token, ErrInvalidToken
+	}
+
+	return token, nil
+}
+
+// Verify checks the access token's signature, issuer, audience and expiry.
+func (s *TokenService) Verify(ctx context.Context, accessToken string) (AccessClaims, error) {
+	claims := AccessClaims{}
+	_, err := jwt.Parse(accessToken, &claims, func(h jwt.Header) (crypto.PublicKey, error) {
+		return s.publicKey(ctx, h.Kid)
+	})
+	if err != nil {
+		return claims, fmt.Errorf("%w. %w", ErrInvalidToken, err)
+	}
+	if err := claims.Validate(s.now(), s.cfg.Issuer, s.cfg.Issuer); err != nil {
+		return claims, fmt.Errorf("%w. %w", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return claims, fmt.Errorf("%w. missing sub", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys access tokens may be signed with.
+func (s *TokenService) JWKS(ctx context.Context) (jwt.JWKS, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set := jwt.JWKS{Keys: []jwt.JWK{}}
+	if _, err := s.currentLocked(ctx); err != nil {
+		return set, err
+	}
+	for _, k := range s.ring {
+		jwk, err := jwt.NewJWK(k.signer.Kid, k.signer.Alg, k.public)
+		if err != nil {
+			return set, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// issue signs an access token for the user the refresh token belongs to and
+// chains a new refresh token onto its family.
+func (s *TokenService) issue(ctx context.Context, token model.RefreshToken) (TokenPair, error) {
+	s.mu.Lock()
+	key, err := s.currentLocked(ctx)
+	s.mu.Unlock()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	now := s.now()
+	accessToken, err := key.signer.Sign(AccessClaims{
+		Claims: jwt.Claims{
+			Issuer:    s.cfg.Issuer,
+			Subject:   token.UserID,
+			Audience:  jwt.Audience{s.cfg.Issuer},
+			ExpiresAt: now.Add(s.cfg.AccessTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			ID:        ksuid.New().String(),
+		},
+		Roles:    token.Roles,
+		Scope:    strings.Join(token.Scopes, " "),
+		ClientID: token.ClientID,
+	})
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("failed to sign access token. %w", err)
+	}
+
+	refreshToken, err := newSessionToken()
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("failed to create refresh token. %w", err)
+	}
+	_, err = s.refresh.InsertRefreshToken(ctx, model.RefreshToken{
+		ID:        fmt.Sprintf("rt_%s", ksuid.New().String()),
+		TokenHash: hashSessionToken(refreshToken),
+		FamilyID:  token.FamilyID,
+		UserID:    token.UserID,
+		ClientID:  token.ClientID,
+		Scopes:    token.Scopes,
+		Expires:   now.Add(s.cfg.RefreshTTL),
+	})
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("failed to insert refresh token. %w", err)
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.cfg.AccessTTL / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(token.Scopes, " "),
+	}, nil
+}
+
+func newRefreshTokenFamilyID() string {
+	return fmt.Sprintf("rtf_%s", ksuid.New().String())
+}
+
+// currentLocked returns the key to sign with, making a new one once the
+// newest is older than KeyRotation.
+func (s *TokenService) currentLocked(ctx context.Context) (signingKey, error) {
+	if s.fresh() {
+		return s.ring[0], nil
+	}
+
+	// another instance may have rotated already
+	if err := s.loadLocked(ctx); err != nil {
+		return signingKey{}, err
+	}
+	if s.fresh() {
+		return s.ring[0], nil
+	}
+
+	if err := s.rotateLocked(ctx); err != nil {
+		return signingKey{}, err
+	}
+
+	return s.ring[0], nil
+}
+
+func (s *TokenService) fresh() bool {
+	return len(s.ring) > 0 && s.now().Sub(s.ring[0].created) < s.cfg.KeyRotation
+}
+
+func (s *TokenService) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	find := func() crypto.PublicKey {
+		for _, k := range s.ring {
+			if k.signer.Kid == kid {
+				return k.public
+			}
+		}
+		return nil
+	}
+
+	if pub := find(); pub != nil {
+		return pub, nil
+	}
+	if s.now().Sub(s.loaded) < keyReloadInterval {
+		return nil, jwt.ErrUnknownKey
+	}
+	if err := s.loadLocked(ctx); err != nil {
+		return nil, err
+	}
+	if pub := find(); pub != nil {
+		return pub, nil
+	}
+
+	return nil, jwt.ErrUnknownKey
+}
+
+// loadLocked reads the keys that are still published.
+func (s *TokenService) loadLocked(ctx context.Context) error {
+	since := s.now().Add(-s.cfg.KeyRotation - s.cfg.AccessTTL)
+	keys, err := s.keys.GetSigningKeys(ctx, since)
+	if err != nil {
+		return fmt.Errorf("failed to get signing keys. %w", err)
+	}
+
+	ring := make([]signingKey, 0, len(keys))
+	for _, k := range keys {
+		private, err := decodeSigningKey(k.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to decode signing key %s. %w", k.Kid, err)
+		}
+		ring = append(ring, signingKey{
+			signer:  jwt.Signer{Kid: k.Kid, Alg: k.Alg, Key: private},
+			public:  private.Public(),
+			created: k.Created,
+		})
+	}
+	s.ring = ring
+	s.loaded = s.now()
+
+	return nil
+}
+
+func (s *TokenService) rotateLocked(ctx context.Context) error {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key. %w", err)
+	}
+	encoded, err := encodeSigningKey(private)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.keys.InsertSigningKey(ctx, model.SigningKey{
+		Kid:        ksuid.New().String(),
+		Alg:        jwt.ES256,
+		PrivateKey: encoded,
+		Created:    s.now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert signing key. %w", err)
+	}
+	slog.InfoContext(ctx, "rotated signing key", "kid", key.Kid)
+
+	if n, err := s.keys.DeleteSigningKeys(ctx, s.now().Add(-s.cfg.KeyRotation-s.cfg.AccessTTL)); err != nil {
+		slog.WarnContext(ctx, "failed to delete old signing keys", "err", err)
+	} else if n > 0 {
+		slog.InfoContext(ctx, "deleted old signing keys", "keys", n)
+	}
+
+	s.ring = append([]signingKey{{
+		signer:  jwt.Signer{Kid: key.Kid, Alg: key.Alg, Key: private},
+		public:  private.Public(),
+		created: key.Created,
+	}}, s.ring...)
+
+	return nil
+}
+
+func encodeSigningKey(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal signing key. %w", err)
+	}
+	encrypted, err := crypt.Encrypt(base64.StdEncoding.EncodeToString(der))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt signing key. %w", err)
+	}
+	return encrypted, nil
+}
+
+func decodeSigningKey(s string) (crypto.Signer, error) {
+	decrypted, err := crypt.Decrypt(s)
+	if err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,142 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/jwt"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func verifyBearer(store SessionStore, token string, userID string) (int, *request.RequestContext) {
+	w := httptest.NewRecorder()
+	r := newRequest(nil, userID)
+	r.Header.Set("Authorization", "Bearer "+token)
+	store.VerifySession(w, r)
+	rc := request.GetRequestContext(r.Context())
+	if rc.Stop {
+		return w.Code, rc
+	}
+	return http.StatusOK, rc
+}
+
+func TestTokenService(t *testing.T) {
+	ctx := context.Background()
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+	repo := dao.NewMemory()
+	tokens := NewTokenService(repo, repo, TokenConfig{})
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+	sessions := NewPostgresSessionStore(repo, repo, 0)
+	store := NewBearerSessionStore(sessions, tokens, repo, repo, repo, repo)
+
+	civilian, err := repo.GetRoleByName(ctx, "CIVILIAN")
+	assert.NoError(t, err)
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c", Roles: []model.Role{civilian}})
+	assert.NoError(t, err)
+
+	pair, err := tokens.Issue(ctx, alice)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer", pair.TokenType)
+	assert.Equal(t, 900, pair.ExpiresIn)
+
+	t.Run("bearer and cookie agree", func(t *testing.T) {
+		code, fromToken := verifyBearer(store, pair.AccessToken, alice.ID)
+		assert.Equal(t, http.StatusOK, code)
+		code, fromCookie := verify(sessions, login(t, sessions, alice), alice.ID)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, fromCookie.UserID, fromToken.UserID)
+		assert.Equal(t, fromCookie.Roles, fromToken.Roles)
+
+		// without a bearer token the cookie is used
+		code, _ = verify(store, login(t, sessions, alice), alice.ID)
+		assert.Equal(t, http.StatusOK, code)
+
+		code, _ = verifyBearer(store, pair.AccessToken, "user_someone_else")
+		assert.Equal(t, http.StatusForbidden, code)
+		code, _ = verifyBearer(store, "forged", alice.ID)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("refresh rotates", func(t *testing.T) {
+		next, err := tokens.Refresh(ctx, pair.RefreshToken)
+		assert.NoError(t, err)
+		assert.NotEqual(t, pair.RefreshToken, next.RefreshToken)
+		_, err = tokens.Verify(ctx, next.AccessToken)
+		assert.NoError(t, err)
+
+		// the old token coming back means it leaked, so the family is revoked
+		_, err = tokens.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		_, err = tokens.Refresh(ctx, next.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		_, err = tokens.Refresh(ctx, "unknown")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		other, err := tokens.Issue(ctx, alice)
+		assert.NoError(t, err)
+		assert.NoError(t, tokens.Revoke(ctx, other.RefreshToken))
+		_, err = tokens.Refresh(ctx, other.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		assert.NoError(t, tokens.Revoke(ctx, "unknown"))
+
+		other, err = tokens.Issue(ctx, alice)
+		assert.NoError(t, err)
+		n, err := repo.RevokeUserRefreshTokens(ctx, alice.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		_, err = tokens.Refresh(ctx, other.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		keys, err := tokens.JWKS(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, keys.Keys, 1)
+
+		now = now.Add(defaultKeyRotation)
+		rotated, err := tokens.Issue(ctx, alice)
+		assert.NoError(t, err)
+		keys, err = tokens.JWKS(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, keys.Keys, 2)
+
+		header := jwt.Header{}
+		_, err = jwt.Parse(rotated.AccessToken, &AccessClaims{}, func(h jwt.Header) (crypto.PublicKey, error) {
+			header = h
+			return keys.Key(h.Kid)
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, keys.Keys[0].Kid, header.Kid)
+
+		// a second instance loads the same keys
+		other := NewTokenService(repo, repo, TokenConfig{})
+		other.now = tokens.now
+		_, err = other.Verify(ctx, rotated.AccessToken)
+		assert.NoError(t, err)
+
+		// the old key's tokens have expired by the time it is dropped
+		_, err = tokens.Verify(ctx, pair.AccessToken)
+		assert.ErrorIs(t, err, jwt.ErrExpired)
+		now = now.Add(defaultKeyRotation)
+		_, err = tokens.Issue(ctx, alice)
+		assert.NoError(t, err)
+		keys, err = tokens.JWKS(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, keys.Keys, 2)
+	})
+}
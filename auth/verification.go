@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/mail"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrAlreadyVerified          = errors.New("email is already verified")
+)
+
+const (
+	defaultVerifyURL          = "http://localhost:8000/auth/verify"
+	defaultVerificationTTL    = 24 * time.Hour
+	defaultVerificationResend = time.Minute
+	verificationPurpose       = "email-verification"
+)
+
+// VerificationConfig configures email verification. Zero values take the
+// defaults: links to http://localhost:8000/auth/verify that last a day, and
+// one email a minute per user.
+type VerificationConfig struct {
+	// URL is the GET /auth/verify endpoint as users reach it. The token is
+	// added as a query parameter.
+	URL            string
+	TTL            time.Duration
+	ResendInterval time.Duration
+}
+
+// verificationClaims are signed into a verification token. The email is
+// included so a link stops working once the address is changed.
+type verificationClaims struct {
+	UserID    string `json:"sub"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// EmailVerifier mails users a signed link that proves they own their email
+// address. The tokens are signed with the auth key, so nothing is stored
+// until the link is followed.
+type EmailVerifier struct {
+	users  dao.UserRepository
+	mailer mail.Mailer
+	cfg    VerificationConfig
+	now    func() time.Time
+}
+
+func NewEmailVerifier(users dao.UserRepository, mailer mail.Mailer, cfg VerificationConfig) *EmailVerifier {
+	if cfg.URL == "" {
+		cfg.URL = defaultVerifyURL
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultVerificationTTL
+	}
+	if cfg.ResendInterval <= 0 {
+		cfg.ResendInterval = defaultVerificationResend
+	}
+
+	return &EmailVerifier{
+		users:  users,
+		mailer: mailer,
+		cfg:    cfg,
+		now:    time.Now,
+	}
+}
+
+// ResendInterval is how long a user has to wait between emails.
+func (v *EmailVerifier) ResendInterval() time.Duration {
+	return v.cfg.ResendInterval
+}
+
+// Send mails the user a verification link. Users that were sent one less than
+// ResendInterval ago get dao.ErrVerificationRateLimited. A nil verifier sends
+// nothing.
+func (v *EmailVerifier) Send(ctx context.Context, user model.User) error {
+	if v == nil {
+		return nil
+	}
+	if user.IsVerified {
+		return ErrAlreadyVerified
+	}
+
+	now := v.now()
+	if err := v.users.MarkVerificationSent(ctx, user.ID, now, v.cfg.ResendInterval); err != nil {
+		return err
+	}
+
+	token, err := v.token(verificationClaims{UserID: user.ID, Email: user.Email, ExpiresAt: now.Add(v.cfg.TTL).Unix()})
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(v.cfg.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse verification url. %w", err)
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	err = v.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to verify your email address:\n\n%s\n\nIt expires in %s. If you did not sign up, you can ignore this email.\n",
+			user.Username, link.String(), v.cfg.TTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send verification email. %w", err)
+	}
+
+	slog.InfoContext(ctx, "sent verification email", "user_id", user.ID)
+	return nil
+}
+
+// Verify marks the user named by the token verified and returns their ID.
+func (v *EmailVerifier) Verify(ctx context.Context, token string) (string, error) {
+	claims, err := v.parse(token)
+	if err != nil {
+		return "", err
+	}
+
+	err = v.users.VerifyEmail(ctx, claims.UserID, claims.Email)
+	if errors.Is(err, dao.ErrUserNotFound) {
+		return "", ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to verify email. %w", err)
+	}
+
+	return claims.UserID, nil
+}
+
+func (v *EmailVerifier) token(claims verificationClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal verification claims. %w", err)
+	}
+
+	sig := crypt.Sign(verificationPurpose, payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (v *EmailVerifier) parse(token string) (verificationClaims, error) {
+	claims := verificationClaims{}
+	encoded, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, ErrInvalidVerificationToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, ErrInvalidVerificationToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !crypt.Verify(verificationPurpose, payload, sig) {
+		return claims, ErrInvalidVerificationToken
+	}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidVerificationToken
+	}
+	if claims.UserID == "" || v.now().Unix() >= claims.ExpiresAt {
+		return claims, ErrInvalidVerificationToken
+	}
+
+	return claims, nil
+}
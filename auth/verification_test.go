@@ -0,0 +1,99 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/mail"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type outbox struct {
+	sent []mail.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg mail.Message) error {
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+func linkToken(t *testing.T, msg mail.Message) string {
+	t.Helper()
+	link, err := url.Parse(linkPattern.FindString(msg.Body))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return link.Query().Get("token")
+}
+
+func TestEmailVerifier(t *testing.T) {
+	ctx := context.Background()
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+	repo := dao.NewMemory()
+	box := &outbox{}
+	v := NewEmailVerifier(repo, box, VerificationConfig{URL: "https://api.example.com/auth/verify?from=email"})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	alice, err := repo.InsertUser(ctx, model.User{ID: "user_alice", Username: "alice", Email: "a@b.c"})
+	assert.NoError(t, err)
+	assert.NoError(t, (*EmailVerifier)(nil).Send(ctx, alice))
+
+	assert.NoError(t, v.Send(ctx, alice))
+	assert.Len(t, box.sent, 1)
+	assert.Equal(t, "a@b.c", box.sent[0].To)
+	token := linkToken(t, box.sent[0])
+	assert.NotEmpty(t, token)
+	assert.Contains(t, box.sent[0].Body, "from=email")
+
+	// resends are limited
+	assert.ErrorIs(t, v.Send(ctx, alice), dao.ErrVerificationRateLimited)
+	now = now.Add(v.ResendInterval())
+	assert.NoError(t, v.Send(ctx, alice))
+	assert.Len(t, box.sent, 2)
+
+	// tampered tokens are refused
+	_, err = v.Verify(ctx, token+"x")
+	assert.ErrorIs(t, err, ErrInvalidVerificationToken)
+	_, err = v.Verify(ctx, "x"+token)
+	assert.ErrorIs(t, err, ErrInvalidVerificationToken)
+	_, err = v.Verify(ctx, "garbage")
+	assert.ErrorIs(t, err, ErrInvalidVerificationToken)
+
+	id, err := v.Verify(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, alice.ID, id)
+	alice, err = repo.GetUserByID(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.True(t, alice.IsVerified)
+	assert.ErrorIs(t, v.Send(ctx, alice), ErrAlreadyVerified)
+
+	// a link stops working once it expires or the address changes
+	bob, err := repo.InsertUser(ctx, model.User{ID: "user_bob", Username: "bob", Email: "b@b.c"})
+	assert.NoError(t, err)
+	assert.NoError(t, v.Send(ctx, bob))
+	token = linkToken(t, box.sent[len(box.sent)-1])
+	now = now.Add(defaultVerificationTTL)
+	_, err = v.Verify(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidVerificationToken)
+
+	now = now.Add(time.Hour)
+	assert.NoError(t, v.Send(ctx, bob))
+	token = linkToken(t, box.sent[len(box.sent)-1])
+	bob.Email = "bob@b.c"
+	_, err = repo.UpdateUser(ctx, bob)
+	assert.NoError(t, err)
+	_, err = v.Verify(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidVerificationToken)
+}
@@ -0,0 +1,39 @@
+// Command stub-issuer serves the test OpenID issuer so sign in can be tried
+// without a real provider. Every authorization is approved as the configured
+// user.
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/slham/sandbox-api/middlewares"
+	"github.com/slham/sandbox-api/oidc/oidctest"
+)
+
+func main() {
+	if ok := middlewares.Initialize(middlewares.INFO); !ok {
+		log.Fatalf("failed to initialize logging")
+	}
+
+	flags := flag.NewFlagSet("stub-issuer", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:9000", "address to listen on")
+	clientID := flags.String("client-id", "sandbox", "client id")
+	clientSecret := flags.String("client-secret", "sandbox-secret", "client secret")
+	subject := flags.String("sub", "stub-user", "subject of the signed in user")
+	email := flags.String("email", "stub@example.com", "email of the signed in user")
+	verified := flags.Bool("email-verified", true, "whether the email is verified")
+	flags.Parse(os.Args[1:])
+
+	issuer, err := oidctest.NewIssuer("http://"+*addr, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatalf("failed to create issuer. %s", err)
+	}
+	issuer.AddUser(oidctest.DefaultUser, oidctest.User{Subject: *subject, Email: *email, EmailVerified: *verified, Name: *subject})
+
+	slog.Info("stub issuer listening", "issuer", issuer.URL, "client_id", *clientID)
+	log.Fatal(http.ListenAndServe(*addr, issuer))
+}
@@ -0,0 +1,130 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Argon2idPrefix starts every hash made by HashPassword.
+const Argon2idPrefix = "$argon2id$"
+
+// Argon2Params tune the cost of hashing a password. Memory is in KiB.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+var params = DefaultArgon2Params
+
+// SetArgon2Params changes the parameters new hashes are made with. Stored
+// hashes made with other parameters still verify and are flagged for rehash.
+func SetArgon2Params(p Argon2Params) {
+	params = p
+}
+
+// HashPassword hashes s with argon2id and returns it in the PHC string format,
+// which carries the parameters and salt needed to verify it.
+func HashPassword(s string) (string, error) {
+	salt := make([]byte, params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to read salt. %w", err)
+	}
+
+	key := argon2.IDKey([]byte(s), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		Argon2idPrefix,
+		argon2.Version,
+		params.Memory,
+		params.Time,
+		params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// IsLegacyPassword reports whether stored is an AES-GCM ciphertext made by
+// Encrypt rather than a password hash.
+func IsLegacyPassword(stored string) bool {
+	return !strings.HasPrefix(stored, Argon2idPrefix)
+}
+
+// VerifyPassword checks s against a stored hash in constant time. Legacy
+// AES-GCM ciphertexts are still accepted. rehash is true when the password
+// matched but stored should be replaced with a fresh HashPassword, either
+// because it is legacy or because it was made with other parameters.
+func VerifyPassword(s string, stored string) (ok bool, rehash bool, err error) {
+	if IsLegacyPassword(stored) {
+		plainText, err := Decrypt(stored)
+		if err != nil {
+			return false, false, fmt.Errorf("failed to decrypt legacy password. %w", err)
+		}
+		ok := subtle.ConstantTimeCompare([]byte(plainText), []byte(s)) == 1
+		return ok, ok, nil
+	}
+
+	p, salt, key, err := decodeHash(stored)
+	if err != nil {
+		return false, false, err
+	}
+
+	other := argon2.IDKey([]byte(s), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	return true, p != params, nil
+}
+
+func decodeHash(stored string) (Argon2Params, []byte, []byte, error) {
+	p := Argon2Params{}
+
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version. %w", ErrInvalidHash)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, fmt.Errorf("failed to parse argon2 parameters. %w", ErrInvalidHash)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("failed to decode salt. %w", ErrInvalidHash)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("failed to decode key. %w", ErrInvalidHash)
+	}
+
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,57 @@
+//go:build unit
+// +build unit
+
+package crypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassword(t *testing.T) {
+	key = []byte("qwertyuiopasdfghjklzxcvbnm098765")
+	SetArgon2Params(Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+	defer SetArgon2Params(DefaultArgon2Params)
+
+	hash, err := HashPassword("hashMe!")
+	assert.NoError(t, err)
+	assert.False(t, IsLegacyPassword(hash))
+
+	again, err := HashPassword("hashMe!")
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, again, "salt must differ")
+
+	ok, rehash, err := VerifyPassword("hashMe!", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = VerifyPassword("hashme!", hash)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// raising the cost flags existing hashes for rehash
+	SetArgon2Params(Argon2Params{Memory: 2048, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+	ok, rehash, err = VerifyPassword("hashMe!", hash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	legacy, err := Encrypt("hashMe!")
+	assert.NoError(t, err)
+	assert.True(t, IsLegacyPassword(legacy))
+
+	ok, rehash, err = VerifyPassword("hashMe!", legacy)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, rehash, err = VerifyPassword("wrong", legacy)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, rehash)
+
+	_, _, err = VerifyPassword("hashMe!", "$argon2id$v=19$garbage")
+	assert.ErrorIs(t, err, ErrInvalidHash)
+}
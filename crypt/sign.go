@@ -0,0 +1,21 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Sign returns an HMAC-SHA256 of data under the auth key. The purpose is
+// mixed in so a signature made for one use is never valid for another.
+func Sign(purpose string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Verify reports in constant time whether sig is Sign(purpose, data).
+func Verify(purpose string, data []byte, sig []byte) bool {
+	return hmac.Equal(Sign(purpose, data), sig)
+}
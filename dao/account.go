@@ -0,0 +1,129 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/slham/sandbox-api/model"
+)
+
+// GetAccountState returns the state of the live user's account. It reads from
+// the primary so a suspension is seen straight away.
+func (p *Postgres) GetAccountState(ctx context.Context, id string) (model.AccountState, error) {
+	a := model.AccountState{}
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT `+accountStateColumns+`
+		FROM sandbox.user u
+		WHERE u.id = $1 AND u.deleted IS NULL`,
+		id,
+	).Scan(&a.IsActive, &a.IsSuspended, &a.SuspendedReason, &a.SuspendedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, ErrUserNotFound
+	}
+	if err != nil {
+		return a, fmt.Errorf("failed to get account state. %w", err)
+	}
+
+	return a, nil
+}
+
+// SuspendUser suspends the user for reason until the time given, or until
+// they are reactivated when until is nil.
+func (p *Postgres) SuspendUser(ctx context.Context, id string, reason string, until *time.Time) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user
+		SET is_suspended = true, suspended_reason = $2, suspended_until = $3
+		WHERE id = $1 AND deleted IS NULL`,
+		id,
+		reason,
+		until,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to suspend user. %w", err)
+	}
+
+	return expectRow(res, ErrUserNotFound)
+}
+
+// ReactivateUser lifts any suspension and undoes a deactivation.
+func (p *Postgres) ReactivateUser(ctx context.Context, id string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user
+		SET is_active = true, is_suspended = false, suspended_reason = '', suspended_until = NULL
+		WHERE id = $1 AND deleted IS NULL`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reactivate user. %w", err)
+	}
+
+	return expectRow(res, ErrUserNotFound)
+}
+
+// DeactivateUser marks the user's account inactive.
+func (p *Postgres) DeactivateUser(ctx context.Context, id string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user
+		SET is_active = false
+		WHERE id = $1 AND deleted IS NULL`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate user. %w", err)
+	}
+
+	return expectRow(res, ErrUserNotFound)
+}
+
+func (m *Memory) GetAccountState(ctx context.Context, id string) (model.AccountState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok || u.Deleted != nil {
+		return model.AccountState{}, ErrUserNotFound
+	}
+
+	return u.AccountState, nil
+}
+
+func (m *Memory) SuspendUser(ctx context.Context, id string, reason string, until *time.Time) error {
+	return m.setAccountState(id, func(a *model.AccountState) {
+		a.IsSuspended = true
+		a.SuspendedReason = reason
+		a.SuspendedUntil = nil
+		if until != nil {
+			t := until.UTC()
+			a.SuspendedUntil = &t
+		}
+	})
+}
+
+func (m *Memory) ReactivateUser(ctx context.Context, id string) error {
+	return m.setAccountState(id, func(a *model.AccountState) {
+		*a = model.AccountState{IsActive: true}
+	})
+}
+
+func (m *Memory) DeactivateUser(ctx context.Context, id string) error {
+	return m.setAccountState(id, func(a *model.AccountState) {
+		a.IsActive = false
+	})
+}
+
+func (m *Memory) setAccountState(id string, f func(a *model.AccountState)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.Deleted != nil {
+		return ErrUserNotFound
+	}
+	f(&u.AccountState)
+	m.users[id] = u
+
+	return nil
+}
@@ -1,8 +1,21 @@
 package dao
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
+)
+
+const defaultLimit = 100
+
+var (
+	ErrInvalidSortColumn = errors.New("invalid sort column")
+	ErrInvalidSortOrder  = errors.New("invalid sort order")
+	// ErrVersionConflict is returned by conditional updates when the row has
+	// changed since the caller read it.
+	ErrVersionConflict = errors.New("version does not match")
 )
 
 type Query struct {
@@ -10,37 +23,98 @@ type Query struct {
 	SortCol string
 	Limit   int
 	Offset  int
+	// Cursor continues a keyset paged listing from a previous page. Its sort
+	// takes precedence over Sort and SortCol. Only List calls use it.
+	Cursor *Cursor
+	// WithTotal makes List calls also count every matching row.
+	WithTotal bool
 }
 
-func checkWhereClause(stmt string) string {
-	if !strings.HasSuffix(stmt, "WHERE") {
-		return fmt.Sprintf("%s AND", stmt)
+// sortColumns maps the sort column names a caller may ask for to the sql
+// expression they sort by. Anything not in the map is rejected.
+type sortColumns map[string]string
+
+func (q Query) orderBy(allowed sortColumns) ([]string, error) {
+	col := "id"
+	if q.SortCol != "" {
+		var ok bool
+		col, ok = allowed[q.SortCol]
+		if !ok {
+			return nil, fmt.Errorf("%s. %w", q.SortCol, ErrInvalidSortColumn)
+		}
+	}
+
+	dir := "ASC"
+	switch strings.ToUpper(q.Sort) {
+	case "", "ASC":
+	case "DESC":
+		dir = "DESC"
+	default:
+		return nil, fmt.Errorf("%s. %w", q.Sort, ErrInvalidSortOrder)
+	}
+
+	order := []string{fmt.Sprintf("%s %s", col, dir)}
+	if col != "id" {
+		order = append(order, fmt.Sprintf("id %s", dir))
 	}
 
-	return stmt
+	return order, nil
 }
 
-func addDefaultQuery(stmt string, q Query) string {
-	if q.SortCol != "" {
-		stmt = fmt.Sprintf("%s ORDER BY %s", stmt, q.SortCol)
-	} else {
-		stmt = fmt.Sprintf("%s ORDER BY id", stmt)
+func (q Query) apply(b *selectBuilder, allowed sortColumns) error {
+	order, err := q.orderBy(allowed)
+	if err != nil {
+		return err
 	}
-	if q.Sort != "" {
-		stmt = fmt.Sprintf("%s %s", stmt, q.Sort)
-	} else {
-		stmt = fmt.Sprintf("%s ASC", stmt)
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
 	}
-	if q.Limit > 0 {
-		stmt = fmt.Sprintf("%s LIMIT %d", stmt, q.Limit)
-	} else {
-		stmt = fmt.Sprintf("%s LIMIT 100", stmt)
+
+	b.OrderBy(order...).Limit(limit).Offset(q.Offset)
+
+	return nil
+}
+
+// createdBetween filters on the created column, inclusive of both ends.
+// Zero times leave that end open.
+func createdBetween(after, before time.Time) condition {
+	switch {
+	case !after.IsZero() && !before.IsZero():
+		return inRange("created", after, before)
+	case !after.IsZero():
+		return gte("created", after)
+	case !before.IsZero():
+		return lte("created", before)
+	default:
+		return nil
 	}
-	if q.Offset > 0 {
-		stmt = fmt.Sprintf("%s OFFSET %d", stmt, q.Offset)
-	} else {
-		stmt = fmt.Sprintf("%s OFFSET 0", stmt)
+}
+
+func (p *Postgres) count(ctx context.Context, b *selectBuilder) (int, error) {
+	stmt, args := b.Count()
+
+	var n int
+	if err := p.readConn(ctx).QueryRowContext(ctx, stmt, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count. %w", err)
 	}
 
-	return stmt
+	return n, nil
+}
+
+// missingOrStale explains why a conditional update on table matched no row:
+// either the row is gone, in which case notFound is returned, or its version
+// has moved on.
+func (p *Postgres) missingOrStale(ctx context.Context, table string, id string, notFound error) error {
+	var exists bool
+	stmt := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1 AND deleted IS NULL)", table)
+	if err := p.conn(ctx).QueryRowContext(ctx, stmt, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check %s (%s). %w", table, id, err)
+	}
+
+	if !exists {
+		return notFound
+	}
+	return ErrVersionConflict
 }
@@ -0,0 +1,260 @@
+package dao
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a keyset paged listing. It records the sort the
+// listing was made with and the sort key and id of the row at the edge of a
+// page, so the next request can continue from that row even when rows have
+// been inserted or deleted in between.
+type Cursor struct {
+	SortCol string `json:"c,omitempty"`
+	Sort    string `json:"s,omitempty"`
+	Value   string `json:"v"`
+	ID      string `json:"i"`
+	// Before asks for the page preceding the row rather than following it.
+	Before bool `json:"b,omitempty"`
+}
+
+// Encode returns the cursor as an opaque url safe token.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cursor. %w", ErrInvalidCursor)
+	}
+
+	c := Cursor{}
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("failed to unmarshal cursor. %w", ErrInvalidCursor)
+	}
+
+	return &c, nil
+}
+
+// Page is one page of a keyset paged listing. Next and Prev are nil when there
+// is nothing further in that direction. Total is only set when asked for with
+// Query.WithTotal.
+type Page[T any] struct {
+	Items []T
+	Next  *Cursor
+	Prev  *Cursor
+	Total *int
+}
+
+// resolve lets a cursor's sort take over from the query's own.
+func (q Query) resolve() Query {
+	if q.Cursor != nil {
+		q.SortCol = q.Cursor.SortCol
+		q.Sort = q.Cursor.Sort
+	}
+	q.Offset = 0
+
+	return q
+}
+
+func (q Query) limit() int {
+	if q.Limit <= 0 {
+		return defaultLimit
+	}
+	return q.Limit
+}
+
+func (q Query) sortColumn(allowed sortColumns) string {
+	if q.SortCol == "" {
+		return "id"
+	}
+	return allowed[q.SortCol]
+}
+
+func (q Query) descending() bool {
+	return strings.EqualFold(q.Sort, "DESC")
+}
+
+// backward reports whether rows are fetched in the reverse of the listing
+// order, which is how the page before a cursor is found.
+func (q Query) backward() bool {
+	return q.Cursor != nil && q.Cursor.Before
+}
+
+// applyKeyset orders and limits b for a keyset page. One row more than the
+// page size is fetched so pageOf can tell whether another page follows.
+func (q Query) applyKeyset(b *selectBuilder, allowed sortColumns) error {
+	q = q.resolve()
+	if q.backward() {
+		q.Sort = "ASC"
+		if !q.Cursor.descending() {
+			q.Sort = "DESC"
+		}
+	}
+
+	order, err := q.orderBy(allowed)
+	if err != nil {
+		return err
+	}
+
+	if q.Cursor != nil {
+		col := q.sortColumn(allowed)
+		op := ">"
+		if q.descending() {
+			op = "<"
+		}
+
+		if col == "id" {
+			b.Where(raw("id "+op+" ?", q.Cursor.ID))
+		} else {
+			b.Where(raw(fmt.Sprintf("(%s, id) %s (?, ?)", col, op), q.Cursor.Value, q.Cursor.ID))
+		}
+	}
+
+	b.OrderBy(order...).Limit(q.limit() + 1)
+
+	return nil
+}
+
+func (c Cursor) descending() bool {
+	return strings.EqualFold(c.Sort, "DESC")
+}
+
+// pageOf turns the rows fetched by applyKeyset into a page with cursors.
+func pageOf[T any](items []T, q Query, allowed sortColumns, field func(T, string) any) Page[T] {
+	q = q.resolve()
+	limit := q.limit()
+	col := q.sortColumn(allowed)
+
+	cursorAt := func(item T, before bool) *Cursor {
+		return &Cursor{
+			SortCol: q.SortCol,
+			Sort:    q.Sort,
+			Value:   cursorValue(field(item, col)),
+			ID:      cursorValue(field(item, "id")),
+			Before:  before,
+		}
+	}
+
+	page := Page[T]{}
+	if q.backward() {
+		slices.Reverse(items)
+		hasMore := len(items) > limit
+		if hasMore {
+			items = items[len(items)-limit:]
+		}
+		if len(items) > 0 {
+			if hasMore {
+				page.Prev = cursorAt(items[0], true)
+			}
+			page.Next = cursorAt(items[len(items)-1], false)
+		}
+	} else {
+		hasMore := len(items) > limit
+		if hasMore {
+			items = items[:limit]
+		}
+		if len(items) > 0 {
+			if hasMore {
+				page.Next = cursorAt(items[len(items)-1], false)
+			}
+			if q.Cursor != nil {
+				page.Prev = cursorAt(items[0], true)
+			}
+		}
+	}
+
+	page.Items = items
+
+	return page
+}
+
+func cursorValue(v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	case string:
+		return t
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// keysetPage is the in memory counterpart of applyKeyset. It sorts items,
+// drops everything up to the cursor and returns up to one more row than the
+// page size.
+func keysetPage[T any](items []T, q Query, allowed sortColumns, field func(T, string) any) ([]T, error) {
+	q = q.resolve()
+	if _, err := q.orderBy(allowed); err != nil {
+		return nil, err
+	}
+
+	col := q.sortColumn(allowed)
+	desc := q.descending()
+	if q.backward() {
+		desc = !desc
+	}
+
+	compare := func(a, b T) int {
+		c := compareValues(field(a, col), field(b, col))
+		if c == 0 {
+			c = compareValues(field(a, "id"), field(b, "id"))
+		}
+		if desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortStableFunc(items, compare)
+
+	if q.Cursor != nil {
+		cursorKey, err := parseCursorValue(q.Cursor.Value, items, field, col)
+		if err != nil {
+			return nil, err
+		}
+
+		items = slices.DeleteFunc(items, func(item T) bool {
+			c := compareValues(field(item, col), cursorKey)
+			if c == 0 {
+				c = compareValues(field(item, "id"), q.Cursor.ID)
+			}
+			if desc {
+				return c >= 0
+			}
+			return c <= 0
+		})
+	}
+
+	if len(items) > q.limit()+1 {
+		items = items[:q.limit()+1]
+	}
+
+	return items, nil
+}
+
+// parseCursorValue converts a cursor's sort key back to the type of the column
+// it was taken from.
+func parseCursorValue[T any](value string, items []T, field func(T, string) any, col string) (any, error) {
+	if len(items) == 0 {
+		return value, nil
+	}
+
+	if _, ok := field(items[0], col).(time.Time); ok {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cursor value. %w", ErrInvalidCursor)
+		}
+		return t, nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,121 @@
+//go:build unit
+// +build unit
+
+package dao
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorEncoding(t *testing.T) {
+	c := Cursor{SortCol: "name", Sort: "DESC", Value: "Arms", ID: "workout_1", Before: true}
+
+	decoded, err := DecodeCursor(c.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, c, *decoded)
+
+	for _, s := range []string{"", "!!", "bm90IGpzb24", "e30"} {
+		_, err := DecodeCursor(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
+
+func TestApplyKeyset(t *testing.T) {
+	tables := []struct {
+		name     string
+		query    Query
+		expected string
+		args     []any
+	}{
+		{
+			name:     "first page",
+			query:    Query{SortCol: "name", Limit: 2},
+			expected: "SELECT id FROM sandbox.workout ORDER BY name ASC, id ASC LIMIT $1",
+			args:     []any{3},
+		},
+		{
+			name:     "after cursor",
+			query:    Query{Limit: 2, Cursor: &Cursor{SortCol: "name", Value: "b", ID: "w2"}},
+			expected: "SELECT id FROM sandbox.workout WHERE (name, id) > ($1, $2) ORDER BY name ASC, id ASC LIMIT $3",
+			args:     []any{"b", "w2", 3},
+		},
+		{
+			name:     "before cursor descending",
+			query:    Query{Limit: 2, Cursor: &Cursor{SortCol: "name", Sort: "DESC", Value: "b", ID: "w2", Before: true}},
+			expected: "SELECT id FROM sandbox.workout WHERE (name, id) > ($1, $2) ORDER BY name ASC, id ASC LIMIT $3",
+			args:     []any{"b", "w2", 3},
+		},
+		{
+			name:     "cursor on id",
+			query:    Query{Limit: 2, Cursor: &Cursor{Sort: "DESC", ID: "w2"}},
+			expected: "SELECT id FROM sandbox.workout WHERE id < $1 ORDER BY id DESC LIMIT $2",
+			args:     []any{"w2", 3},
+		},
+	}
+
+	for _, table := range tables {
+		b := newSelect("sandbox.workout", "id")
+		assert.NoError(t, table.query.applyKeyset(b, workoutSortColumns), table.name)
+		stmt, args := b.Build()
+		assert.Equal(t, table.expected, stmt, table.name)
+		assert.Equal(t, table.args, args, table.name)
+	}
+
+	err := Query{Cursor: &Cursor{SortCol: "password", ID: "w2"}}.applyKeyset(newSelect("sandbox.workout", "id"), workoutSortColumns)
+	assert.ErrorIs(t, err, ErrInvalidSortColumn)
+}
+
+func TestMemoryListWorkouts(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	_, err := m.InsertUser(ctx, model.User{ID: "user_1", Username: "user_1", Email: "a@b.c"})
+	assert.NoError(t, err)
+
+	for i := range 5 {
+		_, err := m.InsertWorkout(ctx, model.Workout{ID: fmt.Sprintf("workout_%d", i), UserID: "user_1", Name: fmt.Sprintf("w%d", 4-i)})
+		assert.NoError(t, err)
+	}
+
+	names := func(page Page[model.Workout]) []string {
+		out := []string{}
+		for _, w := range page.Items {
+			out = append(out, w.Name)
+		}
+		return out
+	}
+
+	q := WorkoutQuery{UserID: "user_1", Query: Query{SortCol: "name", Limit: 2, WithTotal: true}}
+	page, err := m.ListWorkouts(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"w0", "w1"}, names(page))
+	assert.Nil(t, page.Prev)
+	assert.Equal(t, 5, *page.Total)
+
+	q.Cursor = page.Next
+	page, err = m.ListWorkouts(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"w2", "w3"}, names(page))
+
+	q.Cursor = page.Next
+	page, err = m.ListWorkouts(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"w4"}, names(page))
+	assert.Nil(t, page.Next)
+
+	q.Cursor = page.Prev
+	page, err = m.ListWorkouts(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"w2", "w3"}, names(page))
+
+	q.Cursor = page.Prev
+	page, err = m.ListWorkouts(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"w0", "w1"}, names(page))
+	assert.Nil(t, page.Prev)
+	assert.NotNil(t, page.Next)
+}
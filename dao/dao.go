@@ -1,21 +1,26 @@
 package dao
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 
 	_ "github.com/lib/pq"
 )
 
 type Dao struct {
 	DB *sql.DB
+	// Replicas are read only copies of DB that list queries may be sent to.
+	Replicas []*sql.DB
 }
 
 var db Dao
 
-func Connect() (Dao, error) {
+// Open connects to the database without checking the schema version.
+func Open() (Dao, error) {
 	var err error
 	connectionString := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
@@ -43,11 +48,67 @@ func Connect() (Dao, error) {
 
 	slog.Info("successfully connected to db")
 
+	db.Replicas, err = openReplicas(os.Getenv("SBDB_REPLICA_DSNS"))
+	if err != nil {
+		return db, err
+	}
+
 	return db, nil
 }
 
-func getDB() *sql.DB {
-	return db.DB
+// openReplicas opens a pool for each comma separated replica dsn. Replicas
+// that cannot be reached yet are not an error; reads stay on the primary until
+// they pass a health check.
+func openReplicas(dsns string) ([]*sql.DB, error) {
+	replicas := []*sql.DB{}
+	for _, dsn := range strings.Split(dsns, ",") {
+		dsn = strings.TrimSpace(dsn)
+		if dsn == "" {
+			continue
+		}
+
+		replica, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return replicas, fmt.Errorf("failed to open replica connection. %w", err)
+		}
+		replicas = append(replicas, replica)
+	}
+
+	if len(replicas) > 0 {
+		slog.Info("configured read replicas", "count", len(replicas))
+	}
+
+	return replicas, nil
+}
+
+// Connect opens the database and makes sure the schema is up to date. Pending
+// migrations are applied when SBDB_AUTO_MIGRATE is true, otherwise they are
+// reported as an error.
+func Connect() (Dao, error) {
+	if _, err := Open(); err != nil {
+		return db, err
+	}
+
+	ctx := context.Background()
+	pending, err := db.PendingMigrations(ctx)
+	if err != nil {
+		return db, fmt.Errorf("failed to check migrations. %w", err)
+	}
+
+	if len(pending) == 0 {
+		return db, nil
+	}
+
+	if os.Getenv("SBDB_AUTO_MIGRATE") != "true" {
+		slog.Error("database has pending migrations", "pending", pending)
+		return db, fmt.Errorf("%d migrations to apply. run migrate up. %w", len(pending), ErrPendingMigrations)
+	}
+
+	if err := db.MigrateUp(ctx); err != nil {
+		return db, fmt.Errorf("failed to apply migrations. %w", err)
+	}
+
+	return db, nil
 }
 
 func GetDao() Dao {
@@ -0,0 +1,155 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity does not exist")
+	ErrConflictIdentity = errors.New("identity is already linked")
+)
+
+// InsertIdentity links the external account to the user. An account can only
+// be linked to one user.
+func (p *Postgres) InsertIdentity(ctx context.Context, identity model.Identity) (model.Identity, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.user_identity(
+			provider,
+			subject,
+			user_id,
+			email
+		) VALUES ($1, $2, $3, $4)
+		RETURNING created`,
+		identity.Provider,
+		identity.Subject,
+		identity.UserID,
+		identity.Email,
+	).Scan(&identity.Created)
+	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return identity, ErrConflictIdentity
+		}
+		return identity, fmt.Errorf("failed to insert identity. %w", err)
+	}
+
+	return identity, nil
+}
+
+// GetIdentity returns the link for the provider's account. Links to deleted
+// users are not found.
+func (p *Postgres) GetIdentity(ctx context.Context, provider string, subject string) (model.Identity, error) {
+	identity := model.Identity{}
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`SELECT i.provider, i.subject, i.user_id, i.email, i.created
+		FROM sandbox.user_identity i
+		JOIN sandbox.user u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2 AND u.deleted IS NULL`,
+		provider,
+		subject,
+	).Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return identity, ErrIdentityNotFound
+	}
+	if err != nil {
+		return identity, fmt.Errorf("failed to get identity. %w", err)
+	}
+
+	return identity, nil
+}
+
+// GetUserIdentities returns the external accounts linked to the user.
+func (p *Postgres) GetUserIdentities(ctx context.Context, userID string) ([]model.Identity, error) {
+	identities := []model.Identity{}
+	rows, err := p.readConn(ctx).QueryContext(ctx,
+		`SELECT provider, subject, user_id, email, created
+		FROM sandbox.user_identity
+		WHERE user_id = $1
+		ORDER BY provider, created`,
+		userID)
+	if err != nil {
+		return identities, fmt.Errorf("failed to query identities. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i model.Identity
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.Created); err != nil {
+			return identities, fmt.Errorf("failed to scan. %w", err)
+		}
+		identities = append(identities, i)
+	}
+
+	if err := rows.Err(); err != nil {
+		return identities, fmt.Errorf("failed to iterate identities. %w", err)
+	}
+
+	return identities, nil
+}
+
+func identityKey(provider string, subject string) string {
+	return provider + "|" + subject
+}
+
+func (m *Memory) InsertIdentity(ctx context.Context, identity model.Identity) (model.Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[identity.UserID]; !ok {
+		return identity, fmt.Errorf("failed to insert identity. %w", ErrUserNotFound)
+	}
+
+	key := identityKey(identity.Provider, identity.Subject)
+	if _, ok := m.identities[key]; ok {
+		return identity, ErrConflictIdentity
+	}
+
+	identity.Created = time.Now().UTC()
+	m.identities[key] = identity
+
+	return identity, nil
+}
+
+func (m *Memory) GetIdentity(ctx context.Context, provider string, subject string) (model.Identity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	identity, ok := m.identities[identityKey(provider, subject)]
+	if !ok {
+		return identity, ErrIdentityNotFound
+	}
+	if u, ok := m.users[identity.UserID]; !ok || u.Deleted != nil {
+		return model.Identity{}, ErrIdentityNotFound
+	}
+
+	return identity, nil
+}
+
+func (m *Memory) GetUserIdentities(ctx context.Context, userID string) ([]model.Identity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	identities := []model.Identity{}
+	for _, i := range m.identities {
+		if i.UserID == userID {
+			identities = append(identities, i)
+		}
+	}
+	slices.SortFunc(identities, func(a, b model.Identity) int {
+		if c := compareValues(a.Provider, b.Provider); c != 0 {
+			return c
+		}
+		return a.Created.Compare(b.Created)
+	})
+
+	return identities, nil
+}
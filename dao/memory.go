@@ -0,0 +1,1124 @@
+package dao
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slham/sandbox-api/model"
+)
+
+// Memory implements the repositories in process. It mirrors the constraints of
+// the postgres schema, unique usernames, emails, role names and workout names
+// per user, and cascading deletes, so it can stand in for a database in tests.
+type Memory struct {
+	txMu                 sync.Mutex
+	mu                   sync.RWMutex
+	users                map[string]model.User
+	roles                map[int]model.Role
+	userRoles            map[string][]int
+	workouts             map[string]model.Workout
+	revisions            map[string][]model.WorkoutRevision
+	sessions             map[string]model.Session
+	identities           map[string]model.Identity
+	refreshTokens        map[string]model.RefreshToken
+	signingKeys          map[string]model.SigningKey
+	personalAccessTokens map[string]model.PersonalAccessToken
+	mfa                  map[string]model.MFA
+	recoveryCodes        map[string]model.MFARecoveryCode
+	mfaChallenges        map[string]model.MFAChallenge
+	verificationSent     map[string]time.Time
+	passwordResets       map[string]model.PasswordReset
+	passwordResetSent    map[string]time.Time
+	loginFailures        map[string]model.LoginFailure
+	securityEvents       map[string]model.SecurityEvent
+	oauthClients         map[string]model.OAuthClient
+	oauthCodes           map[string]model.OAuthCode
+	oauthGrants          map[string]model.OAuthGrant
+	nextRoleID           int
+}
+
+var (
+	_ UserRepository                = (*Memory)(nil)
+	_ RoleRepository                = (*Memory)(nil)
+	_ WorkoutRepository             = (*Memory)(nil)
+	_ SessionRepository             = (*Memory)(nil)
+	_ IdentityRepository            = (*Memory)(nil)
+	_ RefreshTokenRepository        = (*Memory)(nil)
+	_ SigningKeyRepository          = (*Memory)(nil)
+	_ PersonalAccessTokenRepository = (*Memory)(nil)
+	_ MFARepository                 = (*Memory)(nil)
+	_ PasswordResetRepository       = (*Memory)(nil)
+	_ OAuthRepository               = (*Memory)(nil)
+	_ SecurityRepository            = (*Memory)(nil)
+	_ Purger                        = (*Memory)(nil)
+)
+
+// NewMemory returns an empty store seeded with the same roles as the
+// migrations.
+func NewMemory() *Memory {
+	m := &Memory{
+		users:                map[string]model.User{},
+		roles:                map[int]model.Role{},
+		userRoles:            map[string][]int{},
+		workouts:             map[string]model.Workout{},
+		revisions:            map[string][]model.WorkoutRevision{},
+		sessions:             map[string]model.Session{},
+		identities:           map[string]model.Identity{},
+		refreshTokens:        map[string]model.RefreshToken{},
+		signingKeys:          map[string]model.SigningKey{},
+		personalAccessTokens: map[string]model.PersonalAccessToken{},
+		mfa:                  map[string]model.MFA{},
+		recoveryCodes:        map[string]model.MFARecoveryCode{},
+		mfaChallenges:        map[string]model.MFAChallenge{},
+		verificationSent:     map[string]time.Time{},
+		passwordResets:       map[string]model.PasswordReset{},
+		passwordResetSent:    map[string]time.Time{},
+		loginFailures:        map[string]model.LoginFailure{},
+		securityEvents:       map[string]model.SecurityEvent{},
+		oauthClients:         map[string]model.OAuthClient{},
+		oauthCodes:           map[string]model.OAuthCode{},
+		oauthGrants:          map[string]model.OAuthGrant{},
+	}
+
+	seed := []model.Role{
+		{Name: "CIVILIAN", IsDefault: true, Permissions: []string{
+			model.PermissionUsersRead,
+			model.PermissionUsersWrite,
+			model.PermissionWorkoutsRead,
+			model.PermissionWorkoutsWrite,
+		}},
+		{Name: "ADMIN", Permissions: slices.Clone(model.Permissions)},
+	}
+	for _, role := range seed {
+		m.nextRoleID++
+		now := time.Now().UTC()
+		role.ID, role.Created, role.Updated = m.nextRoleID, now, now
+		m.roles[m.nextRoleID] = role
+	}
+
+	return m
+}
+
+type memoryTxKey struct{}
+
+// WithTx serializes transactions against each other and restores a snapshot
+// of the store when f fails. Plain calls made outside a transaction by other
+// goroutines while f runs are not isolated from it.
+func (m *Memory) WithTx(ctx context.Context, f func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) != nil {
+		return f(ctx)
+	}
+
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	snapshot := m.snapshot()
+	if err := f(context.WithValue(ctx, memoryTxKey{}, true)); err != nil {
+		m.restore(snapshot)
+		return err
+	}
+
+	return nil
+}
+
+type memorySnapshot struct {
+	users                map[string]model.User
+	roles                map[int]model.Role
+	userRoles            map[string][]int
+	workouts             map[string]model.Workout
+	revisions            map[string][]model.WorkoutRevision
+	sessions             map[string]model.Session
+	identities           map[string]model.Identity
+	refreshTokens        map[string]model.RefreshToken
+	signingKeys          map[string]model.SigningKey
+	personalAccessTokens map[string]model.PersonalAccessToken
+	mfa                  map[string]model.MFA
+	recoveryCodes        map[string]model.MFARecoveryCode
+	mfaChallenges        map[string]model.MFAChallenge
+	verificationSent     map[string]time.Time
+	passwordResets       map[string]model.PasswordReset
+	passwordResetSent    map[string]time.Time
+	loginFailures        map[string]model.LoginFailure
+	securityEvents       map[string]model.SecurityEvent
+	oauthClients         map[string]model.OAuthClient
+	oauthCodes           map[string]model.OAuthCode
+	oauthGrants          map[string]model.OAuthGrant
+	nextRoleID           int
+}
+
+func (m *Memory) snapshot() memorySnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := memorySnapshot{
+		users:                maps.Clone(m.users),
+		roles:                maps.Clone(m.roles),
+		userRoles:            make(map[string][]int, len(m.userRoles)),
+		workouts:             make(map[string]model.Workout, len(m.workouts)),
+		revisions:            make(map[string][]model.WorkoutRevision, len(m.revisions)),
+		sessions:             maps.Clone(m.sessions),
+		identities:           maps.Clone(m.identities),
+		refreshTokens:        maps.Clone(m.refreshTokens),
+		signingKeys:          maps.Clone(m.signingKeys),
+		personalAccessTokens: maps.Clone(m.personalAccessTokens),
+		mfa:                  maps.Clone(m.mfa),
+		recoveryCodes:        maps.Clone(m.recoveryCodes),
+		mfaChallenges:        maps.Clone(m.mfaChallenges),
+		verificationSent:     maps.Clone(m.verificationSent),
+		passwordResets:       maps.Clone(m.passwordResets),
+		passwordResetSent:    maps.Clone(m.passwordResetSent),
+		loginFailures:        maps.Clone(m.loginFailures),
+		securityEvents:       maps.Clone(m.securityEvents),
+		oauthClients:         maps.Clone(m.oauthClients),
+		oauthCodes:           maps.Clone(m.oauthCodes),
+		oauthGrants:          maps.Clone(m.oauthGrants),
+		nextRoleID:           m.nextRoleID,
+	}
+	for id, roleIDs := range m.userRoles {
+		s.userRoles[id] = slices.Clone(roleIDs)
+	}
+	for id, w := range m.workouts {
+		s.workouts[id] = cloneWorkout(w)
+	}
+	for id, revs := range m.revisions {
+		s.revisions[id] = slices.Clone(revs)
+	}
+
+	return s
+}
+
+func (m *Memory) restore(s memorySnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users = s.users
+	m.roles = s.roles
+	m.userRoles = s.userRoles
+	m.workouts = s.workouts
+	m.revisions = s.revisions
+	m.sessions = s.sessions
+	m.identities = s.identities
+	m.refreshTokens = s.refreshTokens
+	m.signingKeys = s.signingKeys
+	m.personalAccessTokens = s.personalAccessTokens
+	m.mfa = s.mfa
+	m.recoveryCodes = s.recoveryCodes
+	m.mfaChallenges = s.mfaChallenges
+	m.verificationSent = s.verificationSent
+	m.passwordResets = s.passwordResets
+	m.passwordResetSent = s.passwordResetSent
+	m.loginFailures = s.loginFailures
+	m.securityEvents = s.securityEvents
+	m.oauthClients = s.oauthClients
+	m.oauthCodes = s.oauthCodes
+	m.oauthGrants = s.oauthGrants
+	m.nextRoleID = s.nextRoleID
+}
+
+func (m *Memory) InsertUser(ctx context.Context, user model.User) (model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.ID]; ok {
+		return user, fmt.Errorf("failed to insert user. duplicate id %s", user.ID)
+	}
+
+	if err := m.checkUserConflict(user); err != nil {
+		return user, err
+	}
+
+	roleIDs := make([]int, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		if _, ok := m.roles[role.ID]; !ok {
+			return user, fmt.Errorf("faild to insert user roles. %w", ErrRoleNotFound)
+		}
+		roleIDs = append(roleIDs, role.ID)
+	}
+
+	now := time.Now().UTC()
+	user.Created = now
+	user.Updated = now
+	user.Version = 1
+	user.AccountState = model.AccountState{IsActive: true}
+
+	stored := user
+	stored.Roles = nil
+	m.users[user.ID] = stored
+	m.userRoles[user.ID] = roleIDs
+
+	return user, nil
+}
+
+func (m *Memory) checkUserConflict(user model.User) error {
+	for _, u := range m.users {
+		if u.ID == user.ID || u.Deleted != nil {
+			continue
+		}
+		if u.Username == user.Username {
+			return ErrConflictUsername
+		}
+		if u.Email == user.Email {
+			return ErrConflictEmail
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	u, err := m.GetUser(ctx, UserQuery{Email: email, WithRoles: true})
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get user by email. %w", err)
+	}
+	return u, nil
+}
+
+func (m *Memory) GetUserByUsername(ctx context.Context, username string) (model.User, error) {
+	u, err := m.GetUser(ctx, UserQuery{Username: username, WithRoles: true})
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get user by username. %w", err)
+	}
+	return u, nil
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id string) (model.User, error) {
+	u, err := m.GetUser(ctx, UserQuery{ID: id, HidePassword: true})
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get user by id. %w", err)
+	}
+	return u, nil
+}
+
+func (m *Memory) GetUser(ctx context.Context, q UserQuery) (model.User, error) {
+	users, err := m.GetUsers(ctx, q)
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get user. %w", err)
+	}
+
+	if len(users) != 1 {
+		return model.User{}, ErrUserNotFound
+	}
+
+	return users[0], nil
+}
+
+func (m *Memory) GetUsers(ctx context.Context, q UserQuery) ([]model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users, err := sortAndPage(m.matchUsersLocked(q), q.Query, userSortColumns, userField)
+	if err != nil {
+		return []model.User{}, fmt.Errorf("failed to build users query. %w", err)
+	}
+
+	return users, nil
+}
+
+func (m *Memory) ListUsers(ctx context.Context, q UserQuery) (Page[model.User], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := m.matchUsersLocked(q)
+	total := len(users)
+
+	users, err := keysetPage(users, q.Query, userSortColumns, userField)
+	if err != nil {
+		return Page[model.User]{}, fmt.Errorf("failed to build users query. %w", err)
+	}
+
+	page := pageOf(users, q.Query, userSortColumns, userField)
+	if q.WithTotal {
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+func (m *Memory) matchUsersLocked(q UserQuery) []model.User {
+	users := []model.User{}
+	for _, u := range m.users {
+		if (u.Deleted != nil) != q.Trashed {
+			continue
+		}
+		if q.ID != "" && u.ID != q.ID {
+			continue
+		}
+		if q.IDs != nil && !slices.Contains(q.IDs, u.ID) {
+			continue
+		}
+		if q.Username != "" && u.Username != q.Username {
+			continue
+		}
+		if q.Email != "" && u.Email != q.Email {
+			continue
+		}
+		if q.Search != "" && !containsFold(u.Username, q.Search) && !containsFold(u.Email, q.Search) {
+			continue
+		}
+		if !createdWithin(u.Created, q.CreatedAfter, q.CreatedBefore) {
+			continue
+		}
+
+		if q.HidePassword {
+			u.Password = ""
+		}
+		if q.WithRoles {
+			u.Roles = m.userRolesLocked(u.ID)
+		}
+		users = append(users, u)
+	}
+
+	return users
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[user.ID]
+	if !ok || stored.Deleted != nil {
+		return user, ErrUserNotFound
+	}
+	if user.Version != 0 && user.Version != stored.Version {
+		return user, ErrVersionConflict
+	}
+
+	if err := m.checkUserConflict(user); err != nil {
+		return user, err
+	}
+
+	roleIDs := m.userRoles[user.ID]
+	if user.Roles != nil {
+		roleIDs = make([]int, 0, len(user.Roles))
+		for _, role := range user.Roles {
+			if _, ok := m.roles[role.ID]; !ok {
+				return user, fmt.Errorf("failed to update user roles. %w", ErrRoleNotFound)
+			}
+			roleIDs = append(roleIDs, role.ID)
+		}
+	}
+
+	if stored.Email != user.Email {
+		stored.IsVerified = false
+		delete(m.verificationSent, user.ID)
+	}
+	stored.Username = user.Username
+	stored.Email = user.Email
+	stored.Updated = time.Now().UTC()
+	stored.Version++
+	m.users[user.ID] = stored
+	m.userRoles[user.ID] = roleIDs
+
+	user.Created = stored.Created
+	user.Updated = stored.Updated
+	user.Version = stored.Version
+	user.AccountState = stored.AccountState
+	user.IsVerified = stored.IsVerified
+
+	return user, nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.Deleted != nil {
+		return ErrUserNotFound
+	}
+
+	now := time.Now().UTC()
+	u.Deleted = &now
+	u.Version++
+	m.users[id] = u
+
+	for workoutID, w := range m.workouts {
+		if w.UserID == id && w.Deleted == nil {
+			w.Deleted = &now
+			w.Version++
+			m.workouts[workoutID] = w
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) RestoreUser(ctx context.Context, id string) (model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.Deleted == nil {
+		return model.User{}, ErrUserNotFound
+	}
+
+	deleted := *u.Deleted
+	u.Deleted = nil
+	if err := m.checkUserConflict(u); err != nil {
+		return model.User{}, err
+	}
+
+	restored := []model.Workout{}
+	for _, w := range m.workouts {
+		if w.UserID == id && w.Deleted != nil && w.Deleted.Equal(deleted) {
+			w.Deleted = nil
+			if err := m.checkWorkoutConflict(w); err != nil {
+				return model.User{}, err
+			}
+			restored = append(restored, w)
+		}
+	}
+
+	u.Version++
+	m.users[id] = u
+	for _, w := range restored {
+		w.Version++
+		m.workouts[w.ID] = w
+	}
+
+	u.Password = ""
+	u.Roles = m.userRolesLocked(id)
+
+	return u, nil
+}
+
+func (m *Memory) InsertRole(ctx context.Context, role model.Role) (model.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.roles {
+		if r.Name == role.Name {
+			return role, ErrConflictRoleName
+		}
+	}
+
+	m.nextRoleID++
+	now := time.Now().UTC()
+	role.ID = m.nextRoleID
+	role.Permissions = clonePermissions(role.Permissions)
+	role.Created = now
+	role.Updated = now
+	m.roles[role.ID] = role
+
+	return role, nil
+}
+
+func (m *Memory) UpdateRole(ctx context.Context, role model.Role) (model.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.roles[role.ID]
+	if !ok {
+		return role, ErrRoleNotFound
+	}
+	for _, r := range m.roles {
+		if r.ID != role.ID && r.Name == role.Name {
+			return role, ErrConflictRoleName
+		}
+	}
+
+	role.Permissions = clonePermissions(role.Permissions)
+	role.Created = stored.Created
+	role.Updated = time.Now().UTC()
+	m.roles[role.ID] = role
+
+	return role, nil
+}
+
+func (m *Memory) AddUserRole(ctx context.Context, userID string, roleID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUserRoleLocked(userID, roleID); err != nil {
+		return err
+	}
+	if !slices.Contains(m.userRoles[userID], roleID) {
+		m.userRoles[userID] = append(slices.Clone(m.userRoles[userID]), roleID)
+	}
+
+	return nil
+}
+
+func (m *Memory) RemoveUserRole(ctx context.Context, userID string, roleID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUserRoleLocked(userID, roleID); err != nil {
+		return err
+	}
+	m.userRoles[userID] = slices.DeleteFunc(slices.Clone(m.userRoles[userID]), func(id int) bool {
+		return id == roleID
+	})
+
+	return nil
+}
+
+func (m *Memory) checkUserRoleLocked(userID string, roleID int) error {
+	if u, ok := m.users[userID]; !ok || u.Deleted != nil {
+		return ErrUserNotFound
+	}
+	if _, ok := m.roles[roleID]; !ok {
+		return ErrRoleNotFound
+	}
+
+	return nil
+}
+
+func (m *Memory) GetUserPermissions(ctx context.Context, userID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if u, ok := m.users[userID]; !ok || u.Deleted != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return m.userPermissionsLocked(userID), nil
+}
+
+func (m *Memory) userPermissionsLocked(userID string) []string {
+	permissions := []string{}
+	for _, role := range m.userRolesLocked(userID) {
+		permissions = append(permissions, role.Permissions...)
+	}
+	slices.Sort(permissions)
+
+	return slices.Compact(permissions)
+}
+
+func (m *Memory) GetRoleByID(ctx context.Context, id int) (model.Role, error) {
+	role, err := m.GetRole(ctx, RoleQuery{ID: id})
+	if err != nil {
+		return model.Role{}, fmt.Errorf("failed to get role by id. %w", err)
+	}
+
+	return role, nil
+}
+
+func (m *Memory) GetRoleByName(ctx context.Context, name string) (model.Role, error) {
+	role, err := m.GetRole(ctx, RoleQuery{Name: name})
+	if err != nil {
+		return model.Role{}, fmt.Errorf("failed to get role by name. %w", err)
+	}
+
+	return role, nil
+}
+
+func (m *Memory) GetUserRoles(ctx context.Context, userID string) ([]model.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.userRolesLocked(userID), nil
+}
+
+func (m *Memory) userRolesLocked(userID string) []model.Role {
+	roles := []model.Role{}
+	for _, id := range m.userRoles[userID] {
+		if role, ok := m.roles[id]; ok {
+			role.Permissions = clonePermissions(role.Permissions)
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+func (m *Memory) GetRole(ctx context.Context, q RoleQuery) (model.Role, error) {
+	roles, err := m.GetRoles(ctx, q)
+	if err != nil {
+		return model.Role{}, fmt.Errorf("failed to get role. %w", err)
+	}
+
+	if len(roles) != 1 {
+		return model.Role{}, ErrRoleNotFound
+	}
+
+	return roles[0], nil
+}
+
+func (m *Memory) GetRoles(ctx context.Context, q RoleQuery) ([]model.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roles := []model.Role{}
+	for _, r := range m.roles {
+		if q.ID != 0 && r.ID != q.ID {
+			continue
+		}
+		if q.IDs != nil && !slices.Contains(q.IDs, r.ID) {
+			continue
+		}
+		if q.Name != "" && r.Name != q.Name {
+			continue
+		}
+		if q.Names != nil && !slices.Contains(q.Names, r.Name) {
+			continue
+		}
+		if q.UserID != "" && !slices.Contains(m.userRoles[q.UserID], r.ID) {
+			continue
+		}
+		if q.Default && !r.IsDefault {
+			continue
+		}
+		r.Permissions = clonePermissions(r.Permissions)
+		roles = append(roles, r)
+	}
+
+	roles, err := sortAndPage(roles, q.Query, roleSortColumns, func(r model.Role, col string) any {
+		switch col {
+		case "name":
+			return r.Name
+		case "created":
+			return r.Created
+		case "updated":
+			return r.Updated
+		default:
+			return r.ID
+		}
+	})
+	if err != nil {
+		return []model.Role{}, fmt.Errorf("failed to build roles query. %w", err)
+	}
+
+	return roles, nil
+}
+
+func (m *Memory) DeleteRole(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[id]; !ok {
+		return ErrRoleNotFound
+	}
+	delete(m.roles, id)
+	for userID, roleIDs := range m.userRoles {
+		m.userRoles[userID] = slices.DeleteFunc(roleIDs, func(roleID int) bool {
+			return roleID == id
+		})
+	}
+
+	return nil
+}
+
+func (m *Memory) InsertWorkout(ctx context.Context, workout model.Workout) (model.Workout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[workout.UserID]; !ok || u.Deleted != nil {
+		return workout, fmt.Errorf("failed to insert workout. %w", ErrUserNotFound)
+	}
+
+	if _, ok := m.workouts[workout.ID]; ok {
+		return workout, fmt.Errorf("failed to insert workout. duplicate id %s", workout.ID)
+	}
+
+	if err := m.checkWorkoutConflict(workout); err != nil {
+		return workout, err
+	}
+
+	now := time.Now().UTC()
+	workout.Created = now
+	workout.Updated = now
+	workout.Version = 1
+	m.workouts[workout.ID] = cloneWorkout(workout)
+
+	return workout, nil
+}
+
+func (m *Memory) checkWorkoutConflict(workout model.Workout) error {
+	for _, w := range m.workouts {
+		if w.ID != workout.ID && w.Deleted == nil && w.UserID == workout.UserID && w.Name == workout.Name {
+			return ErrConflictWorkoutName
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) GetWorkoutByID(ctx context.Context, userID string, workoutID string) (model.Workout, error) {
+	w, err := m.GetWorkout(ctx, WorkoutQuery{ID: workoutID, UserID: userID})
+	if err != nil {
+		return model.Workout{}, fmt.Errorf("failed to get workout by id. %w", err)
+	}
+	return w, nil
+}
+
+func (m *Memory) GetWorkout(ctx context.Context, q WorkoutQuery) (model.Workout, error) {
+	workouts, err := m.GetWorkouts(ctx, q)
+	if err != nil {
+		return model.Workout{}, fmt.Errorf("failed to get workouts. %w", err)
+	}
+
+	if len(workouts) != 1 {
+		return model.Workout{}, ErrWorkoutNotFound
+	}
+
+	return workouts[0], nil
+}
+
+func (m *Memory) GetWorkouts(ctx context.Context, q WorkoutQuery) ([]model.Workout, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	workouts, err := sortAndPage(m.matchWorkoutsLocked(q), q.Query, workoutSortColumns, workoutField)
+	if err != nil {
+		return []model.Workout{}, fmt.Errorf("failed to build workouts query. %w", err)
+	}
+
+	return workouts, nil
+}
+
+func (m *Memory) ListWorkouts(ctx context.Context, q WorkoutQuery) (Page[model.Workout], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	workouts := m.matchWorkoutsLocked(q)
+	total := len(workouts)
+
+	workouts, err := keysetPage(workouts, q.Query, workoutSortColumns, workoutField)
+	if err != nil {
+		return Page[model.Workout]{}, fmt.Errorf("failed to build workouts query. %w", err)
+	}
+
+	page := pageOf(workouts, q.Query, workoutSortColumns, workoutField)
+	if q.WithTotal {
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+func (m *Memory) matchWorkoutsLocked(q WorkoutQuery) []model.Workout {
+	workouts := []model.Workout{}
+	for _, w := range m.workouts {
+		if (w.Deleted != nil) != q.Trashed {
+			continue
+		}
+		if q.ID != "" && w.ID != q.ID {
+			continue
+		}
+		if q.IDs != nil && !slices.Contains(q.IDs, w.ID) {
+			continue
+		}
+		if q.UserID != "" && w.UserID != q.UserID {
+			continue
+		}
+		if q.Name != "" && w.Name != q.Name {
+			continue
+		}
+		if q.Search != "" && !containsFold(w.Name, q.Search) {
+			continue
+		}
+		if !createdWithin(w.Created, q.CreatedAfter, q.CreatedBefore) {
+			continue
+		}
+		workouts = append(workouts, cloneWorkout(w))
+	}
+
+	return workouts
+}
+
+func (m *Memory) UpdateWorkout(ctx context.Context, workout model.Workout) (model.Workout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.workouts[workout.ID]
+	if !ok || stored.Deleted != nil {
+		return workout, ErrWorkoutNotFound
+	}
+	if workout.Version != 0 && workout.Version != stored.Version {
+		return workout, ErrVersionConflict
+	}
+
+	workout.UserID = stored.UserID
+	if err := m.checkWorkoutConflict(workout); err != nil {
+		return workout, err
+	}
+
+	stored.Name = workout.Name
+	stored.Exercises = workout.Exercises
+	stored.Updated = time.Now().UTC()
+	stored.Version++
+	m.workouts[workout.ID] = cloneWorkout(stored)
+
+	workout.Created = stored.Created
+	workout.Updated = stored.Updated
+	workout.Version = stored.Version
+
+	return workout, nil
+}
+
+func (m *Memory) DeleteWorkout(ctx context.Context, userID string, workoutID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.workouts[workoutID]
+	if !ok || w.UserID != userID || w.Deleted != nil {
+		return ErrWorkoutNotFound
+	}
+
+	now := time.Now().UTC()
+	w.Deleted = &now
+	w.Version++
+	m.workouts[workoutID] = w
+
+	return nil
+}
+
+func (m *Memory) RestoreWorkout(ctx context.Context, userID string, workoutID string) (model.Workout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.workouts[workoutID]
+	if !ok || w.UserID != userID || w.Deleted == nil {
+		return model.Workout{}, ErrWorkoutNotFound
+	}
+
+	w.Deleted = nil
+	if err := m.checkWorkoutConflict(w); err != nil {
+		return model.Workout{}, err
+	}
+
+	w.Version++
+	m.workouts[workoutID] = w
+
+	return cloneWorkout(w), nil
+}
+
+func (m *Memory) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, w := range m.workouts {
+		if w.Deleted != nil && w.Deleted.Before(before) {
+			delete(m.workouts, id)
+			delete(m.revisions, id)
+			n++
+		}
+	}
+
+	for id, s := range m.sessions {
+		if s.Expires.Before(before) || (s.Revoked != nil && s.Revoked.Before(before)) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+
+	for id, t := range m.refreshTokens {
+		if t.Expires.Before(before) || (t.Revoked != nil && t.Revoked.Before(before)) {
+			delete(m.refreshTokens, id)
+			n++
+		}
+	}
+
+	for id, t := range m.personalAccessTokens {
+		if (t.Expires != nil && t.Expires.Before(before)) || (t.Revoked != nil && t.Revoked.Before(before)) {
+			delete(m.personalAccessTokens, id)
+			n++
+		}
+	}
+
+	for id, c := range m.mfaChallenges {
+		if c.Expires.Before(before) {
+			delete(m.mfaChallenges, id)
+			n++
+		}
+	}
+
+	for id, r := range m.passwordResets {
+		if r.Expires.Before(before) {
+			delete(m.passwordResets, id)
+			n++
+		}
+	}
+
+	for id, c := range m.oauthCodes {
+		if c.Expires.Before(before) {
+			delete(m.oauthCodes, id)
+			n++
+		}
+	}
+
+	for key, f := range m.loginFailures {
+		if f.LastFailure.Before(before) && (f.LockedUntil == nil || f.LockedUntil.Before(before)) {
+			delete(m.loginFailures, key)
+			n++
+		}
+	}
+
+	for id, u := range m.users {
+		if u.Deleted == nil || !u.Deleted.Before(before) {
+			continue
+		}
+		delete(m.users, id)
+		delete(m.userRoles, id)
+		n++
+		for sessionID, s := range m.sessions {
+			if s.UserID == id {
+				delete(m.sessions, sessionID)
+			}
+		}
+		for tokenID, t := range m.refreshTokens {
+			if t.UserID == id {
+				delete(m.refreshTokens, tokenID)
+			}
+		}
+		for tokenID, t := range m.personalAccessTokens {
+			if t.UserID == id {
+				delete(m.personalAccessTokens, tokenID)
+			}
+		}
+		delete(m.mfa, id)
+		delete(m.verificationSent, id)
+		for resetID, r := range m.passwordResets {
+			if r.UserID == id {
+				delete(m.passwordResets, resetID)
+			}
+		}
+		for eventID, e := range m.securityEvents {
+			if e.UserID == id {
+				e.UserID = ""
+				m.securityEvents[eventID] = e
+			}
+		}
+		for codeID, c := range m.recoveryCodes {
+			if c.UserID == id {
+				delete(m.recoveryCodes, codeID)
+			}
+		}
+		for challengeID, c := range m.mfaChallenges {
+			if c.UserID == id {
+				delete(m.mfaChallenges, challengeID)
+			}
+		}
+		for codeID, c := range m.oauthCodes {
+			if c.UserID == id {
+				delete(m.oauthCodes, codeID)
+			}
+		}
+		for key, g := range m.oauthGrants {
+			if g.UserID == id {
+				delete(m.oauthGrants, key)
+			}
+		}
+		for key, i := range m.identities {
+			if i.UserID == id {
+				delete(m.identities, key)
+			}
+		}
+		for workoutID, w := range m.workouts {
+			if w.UserID == id {
+				delete(m.workouts, workoutID)
+				delete(m.revisions, workoutID)
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// cloneWorkout copies the exercise tree so callers cannot mutate stored state.
+func cloneWorkout(w model.Workout) model.Workout {
+	if w.Exercises == nil {
+		return w
+	}
+
+	exercises := make(model.Exercises, len(w.Exercises))
+	for i, e := range w.Exercises {
+		e.Muscles = slices.Clone(e.Muscles)
+		e.Sets = slices.Clone(e.Sets)
+		e.SuperSets = slices.Clone(e.SuperSets)
+		exercises[i] = e
+	}
+	w.Exercises = exercises
+
+	return w
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func createdWithin(created, after, before time.Time) bool {
+	if !after.IsZero() && created.Before(after) {
+		return false
+	}
+	if !before.IsZero() && created.After(before) {
+		return false
+	}
+	return true
+}
+
+// sortAndPage applies the same sort whitelist, ordering and paging rules as
+// Query.apply does for sql.
+func sortAndPage[T any](items []T, q Query, allowed sortColumns, field func(T, string) any) ([]T, error) {
+	if _, err := q.orderBy(allowed); err != nil {
+		return nil, err
+	}
+
+	col := "id"
+	if q.SortCol != "" {
+		col = allowed[q.SortCol]
+	}
+	desc := strings.EqualFold(q.Sort, "DESC")
+
+	sort.SliceStable(items, func(i, j int) bool {
+		c := compareValues(field(items[i], col), field(items[j], col))
+		if c == 0 {
+			c = compareValues(field(items[i], "id"), field(items[j], "id"))
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if q.Offset > 0 {
+		if q.Offset >= len(items) {
+			return []T{}, nil
+		}
+		items = items[q.Offset:]
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items, nil
+}
+
+func compareValues(a, b any) int {
+	switch av := a.(type) {
+	case string:
+		bv, _ := b.(string)
+		return strings.Compare(av, bv)
+	case int:
+		bv, _ := b.(int)
+		return cmp.Compare(av, bv)
+	case time.Time:
+		bv, _ := b.(time.Time)
+		return av.Compare(bv)
+	default:
+		return 0
+	}
+}
+
+// clonePermissions copies a role's permissions so callers cannot change the
+// stored role, and so no permissions come back as an empty list.
+func clonePermissions(permissions []string) []string {
+	if permissions == nil {
+		return []string{}
+	}
+	return slices.Clone(permissions)
+}
@@ -0,0 +1,422 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrMFANotFound          = errors.New("mfa is not set up")
+	ErrMFACodeUsed          = errors.New("mfa code was already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code does not exist")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge does not exist")
+)
+
+// GetMFA returns the user's enrollment, confirmed or not, with the number of
+// recovery codes they have left.
+func (p *Postgres) GetMFA(ctx context.Context, userID string) (model.MFA, error) {
+	mfa := model.MFA{}
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT m.user_id, m.secret, m.created, m.confirmed, m.last_counter,
+			(SELECT count(*) FROM sandbox.mfa_recovery_code c WHERE c.user_id = m.user_id AND c.used IS NULL)
+		FROM sandbox.user_mfa m
+		WHERE m.user_id = $1`,
+		userID,
+	).Scan(&mfa.UserID, &mfa.Secret, &mfa.Created, &mfa.Confirmed, &mfa.LastCounter, &mfa.RecoveryCodes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return mfa, ErrMFANotFound
+	}
+	if err != nil {
+		return mfa, fmt.Errorf("failed to get mfa. %w", err)
+	}
+
+	return mfa, nil
+}
+
+// SetMFA starts a new, unconfirmed enrollment for the user, replacing any
+// earlier one.
+func (p *Postgres) SetMFA(ctx context.Context, mfa model.MFA) (model.MFA, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.user_mfa(user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = excluded.secret, created = now(), confirmed = NULL, last_counter = 0
+		RETURNING created`,
+		mfa.UserID,
+		mfa.Secret,
+	).Scan(&mfa.Created)
+	if err != nil {
+		return mfa, fmt.Errorf("failed to set mfa. %w", err)
+	}
+	mfa.Confirmed = nil
+	mfa.LastCounter = 0
+
+	return mfa, nil
+}
+
+// ConfirmMFA turns on the user's pending enrollment, recording the time step
+// of the code it was confirmed with.
+func (p *Postgres) ConfirmMFA(ctx context.Context, userID string, counter int64) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user_mfa
+		SET confirmed = now(), last_counter = $2
+		WHERE user_id = $1 AND confirmed IS NULL`,
+		userID,
+		counter,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to confirm mfa. %w", err)
+	}
+
+	return expectRow(res, ErrMFANotFound)
+}
+
+// UseMFACounter records that a code for the time step was accepted. Each time
+// step can be used once; later attempts get ErrMFACodeUsed.
+func (p *Postgres) UseMFACounter(ctx context.Context, userID string, counter int64) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user_mfa
+		SET last_counter = $2
+		WHERE user_id = $1 AND confirmed IS NOT NULL AND last_counter < $2`,
+		userID,
+		counter,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use mfa code. %w", err)
+	}
+
+	return expectRow(res, ErrMFACodeUsed)
+}
+
+// DeleteMFA turns MFA off for the user, dropping their recovery codes and
+// pending challenges with it.
+func (p *Postgres) DeleteMFA(ctx context.Context, userID string) error {
+	n := int64(0)
+	stmts := []string{
+		`DELETE FROM sandbox.mfa_challenge WHERE user_id = $1`,
+		`DELETE FROM sandbox.mfa_recovery_code WHERE user_id = $1`,
+		`DELETE FROM sandbox.user_mfa WHERE user_id = $1`,
+	}
+	for _, stmt := range stmts {
+		res, err := p.conn(ctx).ExecContext(ctx, stmt, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete mfa. %w", err)
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to get rows affected. %w", err)
+		}
+	}
+	if n == 0 {
+		return ErrMFANotFound
+	}
+
+	return nil
+}
+
+// SetRecoveryCodes replaces the user's recovery codes with the hashes.
+func (p *Postgres) SetRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	_, err := p.conn(ctx).ExecContext(ctx, `DELETE FROM sandbox.mfa_recovery_code WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes. %w", err)
+	}
+
+	for _, hash := range codeHashes {
+		_, err := p.conn(ctx).ExecContext(ctx,
+			`INSERT INTO sandbox.mfa_recovery_code(id, user_id, code_hash) VALUES ($1, $2, $3)`,
+			newRecoveryCodeID(),
+			userID,
+			hash,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert recovery code. %w", err)
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes.
+func (p *Postgres) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.mfa_recovery_code
+		SET used = now()
+		WHERE user_id = $1 AND code_hash = $2 AND used IS NULL`,
+		userID,
+		codeHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code. %w", err)
+	}
+
+	return expectRow(res, ErrRecoveryCodeNotFound)
+}
+
+func (p *Postgres) InsertMFAChallenge(ctx context.Context, challenge model.MFAChallenge) (model.MFAChallenge, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.mfa_challenge(id, token_hash, user_id, expires)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created`,
+		challenge.ID,
+		challenge.TokenHash,
+		challenge.UserID,
+		challenge.Expires,
+	).Scan(&challenge.Created)
+	if err != nil {
+		return challenge, fmt.Errorf("failed to insert mfa challenge. %w", err)
+	}
+
+	return challenge, nil
+}
+
+// GetMFAChallenge returns the live challenge with the hash. Expired
+// challenges and those of deleted users are not found.
+func (p *Postgres) GetMFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error) {
+	challenge := model.MFAChallenge{}
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT c.id, c.token_hash, c.user_id, c.created, c.expires, c.attempts
+		FROM sandbox.mfa_challenge c
+		JOIN sandbox.user u ON u.id = c.user_id
+		WHERE c.token_hash = $1 AND c.expires > now() AND u.deleted IS NULL`,
+		tokenHash,
+	).Scan(&challenge.ID, &challenge.TokenHash, &challenge.UserID, &challenge.Created, &challenge.Expires, &challenge.Attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return challenge, ErrMFAChallengeNotFound
+	}
+	if err != nil {
+		return challenge, fmt.Errorf("failed to get mfa challenge. %w", err)
+	}
+
+	return challenge, nil
+}
+
+// FailMFAChallenge counts a wrong code against the challenge and returns how
+// many there have been.
+func (p *Postgres) FailMFAChallenge(ctx context.Context, id string) (int, error) {
+	attempts := 0
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`UPDATE sandbox.mfa_challenge
+		SET attempts = attempts + 1
+		WHERE id = $1
+		RETURNING attempts`,
+		id,
+	).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrMFAChallengeNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail mfa challenge. %w", err)
+	}
+
+	return attempts, nil
+}
+
+// DeleteMFAChallenge ends the challenge. Only one caller can end it; the
+// others get ErrMFAChallengeNotFound.
+func (p *Postgres) DeleteMFAChallenge(ctx context.Context, id string) error {
+	res, err := p.conn(ctx).ExecContext(ctx, `DELETE FROM sandbox.mfa_challenge WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete mfa challenge. %w", err)
+	}
+
+	return expectRow(res, ErrMFAChallengeNotFound)
+}
+
+func expectRow(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+func newRecoveryCodeID() string {
+	return fmt.Sprintf("rec_%s", ksuid.New().String())
+}
+
+func (m *Memory) GetMFA(ctx context.Context, userID string) (model.MFA, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mfa, ok := m.mfa[userID]
+	if !ok {
+		return mfa, ErrMFANotFound
+	}
+	for _, c := range m.recoveryCodes {
+		if c.UserID == userID && c.Used == nil {
+			mfa.RecoveryCodes++
+		}
+	}
+
+	return mfa, nil
+}
+
+func (m *Memory) SetMFA(ctx context.Context, mfa model.MFA) (model.MFA, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[mfa.UserID]; !ok {
+		return mfa, fmt.Errorf("failed to set mfa. %w", ErrUserNotFound)
+	}
+
+	mfa.Created = time.Now().UTC()
+	mfa.Confirmed = nil
+	mfa.LastCounter = 0
+	mfa.RecoveryCodes = 0
+	m.mfa[mfa.UserID] = mfa
+
+	return mfa, nil
+}
+
+func (m *Memory) ConfirmMFA(ctx context.Context, userID string, counter int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.mfa[userID]
+	if !ok || mfa.Confirmed != nil {
+		return ErrMFANotFound
+	}
+	now := time.Now().UTC()
+	mfa.Confirmed = &now
+	mfa.LastCounter = counter
+	m.mfa[userID] = mfa
+
+	return nil
+}
+
+func (m *Memory) UseMFACounter(ctx context.Context, userID string, counter int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.mfa[userID]
+	if !ok || mfa.Confirmed == nil || mfa.LastCounter >= counter {
+		return ErrMFACodeUsed
+	}
+	mfa.LastCounter = counter
+	m.mfa[userID] = mfa
+
+	return nil
+}
+
+func (m *Memory) DeleteMFA(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.mfaChallenges {
+		if c.UserID == userID {
+			delete(m.mfaChallenges, id)
+		}
+	}
+	for id, c := range m.recoveryCodes {
+		if c.UserID == userID {
+			delete(m.recoveryCodes, id)
+		}
+	}
+	if _, ok := m.mfa[userID]; !ok {
+		return ErrMFANotFound
+	}
+	delete(m.mfa, userID)
+
+	return nil
+}
+
+func (m *Memory) SetRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.recoveryCodes {
+		if c.UserID == userID {
+			delete(m.recoveryCodes, id)
+		}
+	}
+	for _, hash := range codeHashes {
+		id := newRecoveryCodeID()
+		m.recoveryCodes[id] = model.MFARecoveryCode{ID: id, UserID: userID, CodeHash: hash}
+	}
+
+	return nil
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.recoveryCodes {
+		if c.UserID == userID && c.CodeHash == codeHash && c.Used == nil {
+			now := time.Now().UTC()
+			c.Used = &now
+			m.recoveryCodes[id] = c
+			return nil
+		}
+	}
+
+	return ErrRecoveryCodeNotFound
+}
+
+func (m *Memory) InsertMFAChallenge(ctx context.Context, challenge model.MFAChallenge) (model.MFAChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[challenge.UserID]; !ok {
+		return challenge, fmt.Errorf("failed to insert mfa challenge. %w", ErrUserNotFound)
+	}
+
+	challenge.Created = time.Now().UTC()
+	challenge.Attempts = 0
+	m.mfaChallenges[challenge.ID] = challenge
+
+	return challenge, nil
+}
+
+func (m *Memory) GetMFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, c := range m.mfaChallenges {
+		if c.TokenHash != tokenHash || !c.Expires.After(now) {
+			continue
+		}
+		if u, ok := m.users[c.UserID]; !ok || u.Deleted != nil {
+			break
+		}
+		return c, nil
+	}
+
+	return model.MFAChallenge{}, ErrMFAChallengeNotFound
+}
+
+func (m *Memory) FailMFAChallenge(ctx context.Context, id string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.mfaChallenges[id]
+	if !ok {
+		return 0, ErrMFAChallengeNotFound
+	}
+	c.Attempts++
+	m.mfaChallenges[id] = c
+
+	return c.Attempts, nil
+}
+
+func (m *Memory) DeleteMFAChallenge(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mfaChallenges[id]; !ok {
+		return ErrMFAChallengeNotFound
+	}
+	delete(m.mfaChallenges, id)
+
+	return nil
+}
@@ -0,0 +1,376 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migrationLockID is the postgres advisory lock key held while migrating so
+// that two instances starting at once do not race each other.
+const migrationLockID = 7412305
+
+var (
+	ErrMigrationNotFound = errors.New("migration does not exist")
+	ErrPendingMigrations = errors.New("database has pending migrations")
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version int        `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied,omitempty"`
+}
+
+func loadMigrations() ([]Migration, error) {
+	return parseMigrations(migrationFS, "migrations")
+}
+
+// parseMigrations reads files named <version>_<name>.<up|down>.sql from dir.
+func parseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations. %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, ".sql")
+		direction := path.Ext(base)
+		base = strings.TrimSuffix(base, direction)
+		if direction != ".up" && direction != ".down" {
+			return nil, fmt.Errorf("invalid migration file name %s. missing up or down", fileName)
+		}
+
+		versionString, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s. missing version", fileName)
+		}
+
+		version, err := strconv.Atoi(versionString)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s. bad version", fileName)
+		}
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s. %w", fileName, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, m.Name, name)
+		}
+
+		if direction == ".up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d is missing its up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func ensureMigrationTable(ctx context.Context, conn *sql.Conn) error {
+	stmt := `
+		CREATE TABLE IF NOT EXISTS public.sandbox_schema_migration (
+			version integer     PRIMARY KEY,
+			name    text        NOT NULL,
+			applied timestamptz NOT NULL DEFAULT now()
+		)`
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to create migration table. %w", err)
+	}
+
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	stmt := `
+		SELECT
+			version, applied
+		FROM
+			public.sandbox_schema_migration`
+
+	applied := map[int]time.Time{}
+	rows, err := conn.QueryContext(ctx, stmt)
+	if err != nil {
+		return applied, fmt.Errorf("failed to query migrations. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return applied, fmt.Errorf("failed to scan. %w", err)
+		}
+		applied[version] = at
+	}
+
+	if err := rows.Err(); err != nil {
+		return applied, fmt.Errorf("failed to query migrations. rows. %w", err)
+	}
+
+	return applied, nil
+}
+
+// withMigrationLock runs f on a single connection holding the migration
+// advisory lock.
+func (d Dao) withMigrationLock(ctx context.Context, f func(*sql.Conn) error) error {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection. %w", err)
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock. %w", err)
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			slog.ErrorContext(ctx, "failed to release migration lock", "err", err)
+		}
+	}()
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d. %w", m.Version, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to apply migration %d_%s. %w", m.Version, m.Name, err)
+	}
+
+	stmt := `
+		INSERT INTO public.sandbox_schema_migration
+			(version, name)
+		VALUES
+			($1, $2)`
+	if _, err := tx.ExecContext(ctx, stmt, m.Version, m.Name); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to record migration %d. %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d. %w", m.Version, err)
+	}
+
+	slog.InfoContext(ctx, "applied migration", "version", m.Version, "name", m.Name)
+
+	return nil
+}
+
+func revertMigration(ctx context.Context, conn *sql.Conn, m Migration) error {
+	if m.Down == "" {
+		return fmt.Errorf("migration %d_%s cannot be reverted. no down file", m.Version, m.Name)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d. %w", m.Version, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to revert migration %d_%s. %w", m.Version, m.Name, err)
+	}
+
+	stmt := `
+		DELETE FROM public.sandbox_schema_migration
+		WHERE version = $1`
+	if _, err := tx.ExecContext(ctx, stmt, m.Version); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to unrecord migration %d. %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d. %w", m.Version, err)
+	}
+
+	slog.InfoContext(ctx, "reverted migration", "version", m.Version, "name", m.Name)
+
+	return nil
+}
+
+// MigrateTo applies or reverts migrations until the schema is at version.
+// Version 0 reverts everything.
+func (d Dao) MigrateTo(ctx context.Context, version int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if version != 0 {
+		found := false
+		for _, m := range migrations {
+			if m.Version == version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("failed to migrate to %d. %w", version, ErrMigrationNotFound)
+		}
+	}
+
+	return d.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok || m.Version > version {
+				continue
+			}
+			if err := applyMigration(ctx, conn, m); err != nil {
+				return err
+			}
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok || m.Version <= version {
+				continue
+			}
+			if err := revertMigration(ctx, conn, m); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrateUp applies every pending migration.
+func (d Dao) MigrateUp(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		return nil
+	}
+
+	return d.MigrateTo(ctx, migrations[len(migrations)-1].Version)
+}
+
+// MigrateDown reverts the latest steps applied migrations.
+func (d Dao) MigrateDown(ctx context.Context, steps int) error {
+	status, err := d.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	applied := []int{}
+	for _, s := range status {
+		if s.Applied != nil {
+			applied = append(applied, s.Version)
+		}
+	}
+
+	if steps >= len(applied) {
+		return d.MigrateTo(ctx, 0)
+	}
+
+	return d.MigrateTo(ctx, applied[len(applied)-steps-1])
+}
+
+func (d Dao) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	err = d.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i, m := range migrations {
+			status[i] = MigrationStatus{Version: m.Version, Name: m.Name}
+			if at, ok := applied[m.Version]; ok {
+				status[i].Applied = &at
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration status. %w", err)
+	}
+
+	return status, nil
+}
+
+func (d Dao) PendingMigrations(ctx context.Context) ([]MigrationStatus, error) {
+	status, err := d.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []MigrationStatus{}
+	for _, s := range status {
+		if s.Applied == nil {
+			pending = append(pending, s)
+		}
+	}
+
+	return pending, nil
+}
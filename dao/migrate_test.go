@@ -0,0 +1,85 @@
+//go:build unit
+// +build unit
+
+package dao
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be contiguous")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+	tables := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		fail     bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"m/0002_second.up.sql":   {Data: []byte("SELECT 2")},
+				"m/0002_second.down.sql": {Data: []byte("SELECT -2")},
+				"m/0001_first.up.sql":    {Data: []byte("SELECT 1")},
+				"m/README.md":            {Data: []byte("ignored")},
+			},
+			versions: []int{1, 2},
+		},
+		{
+			name:  "missing direction",
+			files: fstest.MapFS{"m/0001_first.sql": {Data: []byte("SELECT 1")}},
+			fail:  true,
+		},
+		{
+			name:  "missing version",
+			files: fstest.MapFS{"m/first.up.sql": {Data: []byte("SELECT 1")}},
+			fail:  true,
+		},
+		{
+			name:  "missing up",
+			files: fstest.MapFS{"m/0001_first.down.sql": {Data: []byte("SELECT 1")}},
+			fail:  true,
+		},
+		{
+			name: "conflicting names",
+			files: fstest.MapFS{
+				"m/0001_first.up.sql": {Data: []byte("SELECT 1")},
+				"m/0001_other.up.sql": {Data: []byte("SELECT 1")},
+			},
+			fail: true,
+		},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			migrations, err := parseMigrations(table.files, "m")
+			if table.fail {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			versions := []int{}
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			assert.Equal(t, table.versions, versions)
+		})
+	}
+}
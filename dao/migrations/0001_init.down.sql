@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS sandbox.workout;
+DROP TABLE IF EXISTS sandbox.user_role;
+DROP TABLE IF EXISTS sandbox.role;
+DROP TABLE IF EXISTS sandbox.user;
+DROP FUNCTION IF EXISTS sandbox.set_updated();
+DROP SCHEMA IF EXISTS sandbox;
@@ -0,0 +1,60 @@
+CREATE SCHEMA IF NOT EXISTS sandbox;
+
+CREATE OR REPLACE FUNCTION sandbox.set_updated() RETURNS trigger AS $$
+BEGIN
+	NEW.updated = now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS sandbox.user (
+	id       text        PRIMARY KEY,
+	username text        NOT NULL,
+	password text        NOT NULL,
+	email    text        NOT NULL,
+	created  timestamptz NOT NULL DEFAULT now(),
+	updated  timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT u_username UNIQUE (username),
+	CONSTRAINT u_email UNIQUE (email)
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.role (
+	id      serial      PRIMARY KEY,
+	name    text        NOT NULL,
+	created timestamptz NOT NULL DEFAULT now(),
+	updated timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT u_role_name UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.user_role (
+	user_id text    NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	role_id integer NOT NULL REFERENCES sandbox.role (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.workout (
+	id        text        PRIMARY KEY,
+	name      text        NOT NULL,
+	user_id   text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	exercises jsonb       NOT NULL DEFAULT '[]',
+	created   timestamptz NOT NULL DEFAULT now(),
+	updated   timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT u_user_name UNIQUE (user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS i_workout_user_id ON sandbox.workout (user_id);
+
+DROP TRIGGER IF EXISTS t_user_updated ON sandbox.user;
+CREATE TRIGGER t_user_updated BEFORE UPDATE ON sandbox.user
+	FOR EACH ROW EXECUTE FUNCTION sandbox.set_updated();
+
+DROP TRIGGER IF EXISTS t_role_updated ON sandbox.role;
+CREATE TRIGGER t_role_updated BEFORE UPDATE ON sandbox.role
+	FOR EACH ROW EXECUTE FUNCTION sandbox.set_updated();
+
+DROP TRIGGER IF EXISTS t_workout_updated ON sandbox.workout;
+CREATE TRIGGER t_workout_updated BEFORE UPDATE ON sandbox.workout
+	FOR EACH ROW EXECUTE FUNCTION sandbox.set_updated();
+
+INSERT INTO sandbox.role (name) VALUES ('CIVILIAN'), ('ADMIN')
+	ON CONFLICT (name) DO NOTHING;
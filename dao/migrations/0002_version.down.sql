@@ -0,0 +1,2 @@
+ALTER TABLE sandbox.workout DROP COLUMN IF EXISTS version;
+ALTER TABLE sandbox.user DROP COLUMN IF EXISTS version;
@@ -0,0 +1,2 @@
+ALTER TABLE sandbox.user ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE sandbox.workout ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
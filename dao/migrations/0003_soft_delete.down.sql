@@ -0,0 +1,14 @@
+DELETE FROM sandbox.workout WHERE deleted IS NOT NULL;
+DELETE FROM sandbox.user WHERE deleted IS NOT NULL;
+
+DROP INDEX IF EXISTS sandbox.i_workout_deleted;
+DROP INDEX IF EXISTS sandbox.i_user_deleted;
+DROP INDEX IF EXISTS sandbox.u_user_name;
+DROP INDEX IF EXISTS sandbox.u_email;
+DROP INDEX IF EXISTS sandbox.u_username;
+ALTER TABLE sandbox.workout ADD CONSTRAINT u_user_name UNIQUE (user_id, name);
+ALTER TABLE sandbox.user ADD CONSTRAINT u_email UNIQUE (email);
+ALTER TABLE sandbox.user ADD CONSTRAINT u_username UNIQUE (username);
+
+ALTER TABLE sandbox.workout DROP COLUMN IF EXISTS deleted;
+ALTER TABLE sandbox.user DROP COLUMN IF EXISTS deleted;
@@ -0,0 +1,13 @@
+ALTER TABLE sandbox.user ADD COLUMN IF NOT EXISTS deleted timestamptz;
+ALTER TABLE sandbox.workout ADD COLUMN IF NOT EXISTS deleted timestamptz;
+
+-- trashed rows keep their names but must not block new rows from reusing them
+ALTER TABLE sandbox.user DROP CONSTRAINT IF EXISTS u_username;
+ALTER TABLE sandbox.user DROP CONSTRAINT IF EXISTS u_email;
+ALTER TABLE sandbox.workout DROP CONSTRAINT IF EXISTS u_user_name;
+CREATE UNIQUE INDEX IF NOT EXISTS u_username ON sandbox.user(username) WHERE deleted IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS u_email ON sandbox.user(email) WHERE deleted IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS u_user_name ON sandbox.workout(user_id, name) WHERE deleted IS NULL;
+
+CREATE INDEX IF NOT EXISTS i_user_deleted ON sandbox.user(deleted) WHERE deleted IS NOT NULL;
+CREATE INDEX IF NOT EXISTS i_workout_deleted ON sandbox.workout(deleted) WHERE deleted IS NOT NULL;
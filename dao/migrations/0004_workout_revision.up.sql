@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sandbox.workout_revision (
+	workout_id text        NOT NULL REFERENCES sandbox.workout (id) ON DELETE CASCADE,
+	revision   integer     NOT NULL,
+	author     text        NOT NULL,
+	name       text        NOT NULL,
+	exercises  jsonb       NOT NULL DEFAULT '[]',
+	created    timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (workout_id, revision)
+);
+
+-- existing workouts start their history from their current state
+INSERT INTO sandbox.workout_revision (workout_id, revision, author, name, exercises, created)
+SELECT id, 1, user_id, name, exercises, updated FROM sandbox.workout
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sandbox.session (
+	id         text        PRIMARY KEY,
+	token_hash text        NOT NULL,
+	user_id    text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	user_agent text        NOT NULL DEFAULT '',
+	ip         text        NOT NULL DEFAULT '',
+	created    timestamptz NOT NULL DEFAULT now(),
+	last_seen  timestamptz NOT NULL DEFAULT now(),
+	expires    timestamptz NOT NULL,
+	revoked    timestamptz,
+	CONSTRAINT u_session_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS i_session_user_id ON sandbox.session (user_id) WHERE revoked IS NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS sandbox.user_identity (
+	provider text        NOT NULL,
+	subject  text        NOT NULL,
+	user_id  text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	email    text        NOT NULL DEFAULT '',
+	created  timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS i_user_identity_user_id ON sandbox.user_identity (user_id);
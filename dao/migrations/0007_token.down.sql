@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sandbox.signing_key;
+DROP TABLE IF EXISTS sandbox.refresh_token;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS sandbox.refresh_token (
+	id         text        PRIMARY KEY,
+	token_hash text        NOT NULL,
+	family_id  text        NOT NULL,
+	user_id    text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	created    timestamptz NOT NULL DEFAULT now(),
+	expires    timestamptz NOT NULL,
+	used       timestamptz,
+	revoked    timestamptz,
+	CONSTRAINT u_refresh_token_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS i_refresh_token_family_id ON sandbox.refresh_token (family_id);
+CREATE INDEX IF NOT EXISTS i_refresh_token_user_id ON sandbox.refresh_token (user_id) WHERE revoked IS NULL;
+
+CREATE TABLE IF NOT EXISTS sandbox.signing_key (
+	kid         text        PRIMARY KEY,
+	alg         text        NOT NULL,
+	private_key text        NOT NULL,
+	created     timestamptz NOT NULL DEFAULT now()
+);
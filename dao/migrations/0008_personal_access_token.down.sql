@@ -0,0 +1 @@
+DROP TABLE IF EXISTS sandbox.personal_access_token;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sandbox.personal_access_token (
+	id         text        PRIMARY KEY,
+	user_id    text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	name       text        NOT NULL,
+	token_hash text        NOT NULL,
+	scopes     text[]      NOT NULL,
+	created    timestamptz NOT NULL DEFAULT now(),
+	expires    timestamptz,
+	last_used  timestamptz,
+	revoked    timestamptz,
+	CONSTRAINT u_personal_access_token_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS i_personal_access_token_user_id ON sandbox.personal_access_token (user_id) WHERE revoked IS NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS sandbox.mfa_challenge;
+DROP TABLE IF EXISTS sandbox.mfa_recovery_code;
+DROP TABLE IF EXISTS sandbox.user_mfa;
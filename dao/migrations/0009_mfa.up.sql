@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS sandbox.user_mfa (
+	user_id      text        PRIMARY KEY REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	secret       text        NOT NULL,
+	created      timestamptz NOT NULL DEFAULT now(),
+	confirmed    timestamptz,
+	last_counter bigint      NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.mfa_recovery_code (
+	id        text        PRIMARY KEY,
+	user_id   text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	code_hash text        NOT NULL,
+	used      timestamptz,
+	CONSTRAINT u_mfa_recovery_code_user_id_code_hash UNIQUE (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.mfa_challenge (
+	id         text        PRIMARY KEY,
+	token_hash text        NOT NULL,
+	user_id    text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	created    timestamptz NOT NULL DEFAULT now(),
+	expires    timestamptz NOT NULL,
+	attempts   int         NOT NULL DEFAULT 0,
+	CONSTRAINT u_mfa_challenge_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS i_mfa_challenge_user_id ON sandbox.mfa_challenge (user_id);
@@ -0,0 +1,5 @@
+ALTER TABLE sandbox.user
+	DROP COLUMN IF EXISTS verification_sent,
+	DROP COLUMN IF EXISTS is_verified,
+	DROP COLUMN IF EXISTS is_suspended,
+	DROP COLUMN IF EXISTS is_active;
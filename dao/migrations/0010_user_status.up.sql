@@ -0,0 +1,5 @@
+ALTER TABLE sandbox.user
+	ADD COLUMN IF NOT EXISTS is_active         boolean     NOT NULL DEFAULT true,
+	ADD COLUMN IF NOT EXISTS is_suspended      boolean     NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS is_verified       boolean     NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS verification_sent timestamptz;
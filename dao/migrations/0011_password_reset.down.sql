@@ -0,0 +1 @@
+DROP TABLE IF EXISTS sandbox.password_reset;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS sandbox.password_reset (
+	id         text        PRIMARY KEY,
+	user_id    text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	token_hash text        NOT NULL,
+	created    timestamptz NOT NULL DEFAULT now(),
+	expires    timestamptz NOT NULL,
+	used       timestamptz,
+	CONSTRAINT u_password_reset_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS i_password_reset_user_id ON sandbox.password_reset (user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sandbox.security_event;
+DROP TABLE IF EXISTS sandbox.login_failure;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS sandbox.login_failure (
+	key          text        PRIMARY KEY,
+	failures     int         NOT NULL DEFAULT 0,
+	last_failure timestamptz NOT NULL,
+	locked_until timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.security_event (
+	id       text        PRIMARY KEY,
+	kind     text        NOT NULL,
+	user_id  text        REFERENCES sandbox.user (id) ON DELETE SET NULL,
+	subject  text        NOT NULL DEFAULT '',
+	ip       text        NOT NULL DEFAULT '',
+	actor_id text        NOT NULL DEFAULT '',
+	created  timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS i_security_event_user_id ON sandbox.security_event (user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE sandbox.user DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE sandbox.user DROP COLUMN IF EXISTS suspended_reason;
@@ -0,0 +1,2 @@
+ALTER TABLE sandbox.user ADD COLUMN IF NOT EXISTS suspended_reason text NOT NULL DEFAULT '';
+ALTER TABLE sandbox.user ADD COLUMN IF NOT EXISTS suspended_until timestamptz;
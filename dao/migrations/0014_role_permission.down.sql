@@ -0,0 +1,2 @@
+ALTER TABLE sandbox.role DROP COLUMN IF EXISTS is_default;
+ALTER TABLE sandbox.role DROP COLUMN IF EXISTS permissions;
@@ -0,0 +1,15 @@
+ALTER TABLE sandbox.role ADD COLUMN IF NOT EXISTS permissions text[] NOT NULL DEFAULT '{}';
+ALTER TABLE sandbox.role ADD COLUMN IF NOT EXISTS is_default boolean NOT NULL DEFAULT false;
+
+UPDATE sandbox.role
+SET is_default = true,
+	permissions = ARRAY['users:read', 'users:write', 'workouts:read', 'workouts:write']
+WHERE name = 'CIVILIAN';
+
+UPDATE sandbox.role
+SET permissions = ARRAY[
+	'users:list', 'users:read', 'users:read:any', 'users:write', 'users:write:any', 'users:manage',
+	'workouts:read', 'workouts:read:any', 'workouts:write', 'workouts:write:any',
+	'passwords:report', 'roles:read', 'roles:write'
+]
+WHERE name = 'ADMIN';
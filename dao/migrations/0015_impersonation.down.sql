@@ -0,0 +1,3 @@
+UPDATE sandbox.role SET permissions = array_remove(permissions, 'users:impersonate');
+
+ALTER TABLE sandbox.session DROP COLUMN IF EXISTS impersonator_id;
@@ -0,0 +1,5 @@
+ALTER TABLE sandbox.session ADD COLUMN IF NOT EXISTS impersonator_id text REFERENCES sandbox.user (id) ON DELETE CASCADE;
+
+UPDATE sandbox.role
+SET permissions = array_append(permissions, 'users:impersonate')
+WHERE name = 'ADMIN' AND NOT 'users:impersonate' = ANY (permissions);
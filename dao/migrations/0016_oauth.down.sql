@@ -0,0 +1,8 @@
+UPDATE sandbox.role SET permissions = array_remove(array_remove(permissions, 'clients:read'), 'clients:write');
+
+ALTER TABLE sandbox.refresh_token DROP COLUMN IF EXISTS scopes;
+ALTER TABLE sandbox.refresh_token DROP COLUMN IF EXISTS client_id;
+
+DROP TABLE IF EXISTS sandbox.oauth_grant;
+DROP TABLE IF EXISTS sandbox.oauth_code;
+DROP TABLE IF EXISTS sandbox.oauth_client;
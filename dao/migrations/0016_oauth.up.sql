@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS sandbox.oauth_client (
+	id            text        PRIMARY KEY,
+	name          text        NOT NULL,
+	secret_hash   text,
+	redirect_uris text[]      NOT NULL,
+	scopes        text[]      NOT NULL,
+	created       timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.oauth_code (
+	id             text        PRIMARY KEY,
+	code_hash      text        NOT NULL,
+	client_id      text        NOT NULL REFERENCES sandbox.oauth_client (id) ON DELETE CASCADE,
+	user_id        text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	redirect_uri   text        NOT NULL,
+	scopes         text[]      NOT NULL,
+	code_challenge text        NOT NULL,
+	created        timestamptz NOT NULL DEFAULT now(),
+	expires        timestamptz NOT NULL,
+	used           timestamptz,
+	CONSTRAINT u_oauth_code_code_hash UNIQUE (code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS sandbox.oauth_grant (
+	user_id   text        NOT NULL REFERENCES sandbox.user (id) ON DELETE CASCADE,
+	client_id text        NOT NULL REFERENCES sandbox.oauth_client (id) ON DELETE CASCADE,
+	scopes    text[]      NOT NULL,
+	created   timestamptz NOT NULL DEFAULT now(),
+	updated   timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (user_id, client_id)
+);
+
+CREATE INDEX IF NOT EXISTS i_oauth_grant_client_id ON sandbox.oauth_grant (client_id);
+
+ALTER TABLE sandbox.refresh_token ADD COLUMN IF NOT EXISTS client_id text REFERENCES sandbox.oauth_client (id) ON DELETE CASCADE;
+ALTER TABLE sandbox.refresh_token ADD COLUMN IF NOT EXISTS scopes text[];
+
+UPDATE sandbox.role
+SET permissions = array_cat(permissions, ARRAY['clients:read', 'clients:write'])
+WHERE name = 'ADMIN' AND NOT 'clients:write' = ANY (permissions);
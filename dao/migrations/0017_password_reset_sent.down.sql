@@ -0,0 +1 @@
+ALTER TABLE sandbox.user DROP COLUMN IF EXISTS password_reset_sent;
@@ -0,0 +1 @@
+ALTER TABLE sandbox.user ADD COLUMN IF NOT EXISTS password_reset_sent timestamptz;
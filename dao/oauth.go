@@ -0,0 +1,490 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrOAuthClientNotFound = errors.New("oauth client does not exist")
+	ErrOAuthCodeNotFound   = errors.New("authorization code does not exist")
+	ErrOAuthCodeUsed       = errors.New("authorization code was already used")
+	ErrOAuthGrantNotFound  = errors.New("oauth grant does not exist")
+)
+
+func (p *Postgres) InsertOAuthClient(ctx context.Context, client model.OAuthClient) (model.OAuthClient, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.oauth_client(
+			id,
+			name,
+			secret_hash,
+			redirect_uris,
+			scopes
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+		RETURNING created`,
+		client.ID,
+		client.Name,
+		client.SecretHash,
+		pq.Array(client.RedirectURIs),
+		pq.Array(client.Scopes),
+	).Scan(&client.Created)
+	if err != nil {
+		return client, fmt.Errorf("failed to insert oauth client. %w", err)
+	}
+	client.Public = client.SecretHash == ""
+
+	return client, nil
+}
+
+func (p *Postgres) GetOAuthClient(ctx context.Context, id string) (model.OAuthClient, error) {
+	client := model.OAuthClient{}
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT id, name, COALESCE(secret_hash, ''), redirect_uris, scopes, created
+		FROM sandbox.oauth_client
+		WHERE id = $1`,
+		id,
+	).Scan(&client.ID, &client.Name, &client.SecretHash, pq.Array(&client.RedirectURIs), pq.Array(&client.Scopes), &client.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return client, ErrOAuthClientNotFound
+	}
+	if err != nil {
+		return client, fmt.Errorf("failed to get oauth client. %w", err)
+	}
+	client.Public = client.SecretHash == ""
+
+	return client, nil
+}
+
+// GetOAuthClients returns every registered client, newest first.
+func (p *Postgres) GetOAuthClients(ctx context.Context) ([]model.OAuthClient, error) {
+	clients := []model.OAuthClient{}
+	rows, err := p.readConn(ctx).QueryContext(ctx,
+		`SELECT id, name, secret_hash IS NULL, redirect_uris, scopes, created
+		FROM sandbox.oauth_client
+		ORDER BY created DESC, id DESC`)
+	if err != nil {
+		return clients, fmt.Errorf("failed to query oauth clients. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var c model.OAuthClient
+		if err := rows.Scan(&c.ID, &c.Name, &c.Public, pq.Array(&c.RedirectURIs), pq.Array(&c.Scopes), &c.Created); err != nil {
+			return clients, fmt.Errorf("failed to scan. %w", err)
+		}
+		clients = append(clients, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return clients, fmt.Errorf("failed to iterate oauth clients. %w", err)
+	}
+
+	return clients, nil
+}
+
+// DeleteOAuthClient removes the client along with its codes, grants and
+// refresh tokens.
+func (p *Postgres) DeleteOAuthClient(ctx context.Context, id string) error {
+	res, err := p.conn(ctx).ExecContext(ctx, `DELETE FROM sandbox.oauth_client WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete oauth client. %w", err)
+	}
+
+	return expectRow(res, ErrOAuthClientNotFound)
+}
+
+func (p *Postgres) InsertOAuthCode(ctx context.Context, code model.OAuthCode) (model.OAuthCode, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.oauth_code(
+			id,
+			code_hash,
+			client_id,
+			user_id,
+			redirect_uri,
+			scopes,
+			code_challenge,
+			expires
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created`,
+		code.ID,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		pq.Array(code.Scopes),
+		code.CodeChallenge,
+		code.Expires,
+	).Scan(&code.Created)
+	if err != nil {
+		return code, fmt.Errorf("failed to insert authorization code. %w", err)
+	}
+
+	return code, nil
+}
+
+// UseOAuthCode marks the code with the hash used and returns it. A code that
+// was used before is returned with ErrOAuthCodeUsed, so the tokens it was
+// swapped for can be revoked. Expired codes are returned for the caller to
+// refuse.
+func (p *Postgres) UseOAuthCode(ctx context.Context, codeHash string) (model.OAuthCode, error) {
+	code := model.OAuthCode{}
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`WITH old AS (
+			SELECT id, used FROM sandbox.oauth_code WHERE code_hash = $1 FOR UPDATE
+		)
+		UPDATE sandbox.oauth_code c
+		SET used = COALESCE(c.used, now())
+		FROM old
+		WHERE c.id = old.id
+		RETURNING c.id, c.code_hash, c.client_id, c.user_id, c.redirect_uri, c.scopes, c.code_challenge, c.created, c.expires, old.used`,
+		codeHash,
+	).Scan(&code.ID, &code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI, pq.Array(&code.Scopes), &code.CodeChallenge, &code.Created, &code.Expires, &code.Used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return code, ErrOAuthCodeNotFound
+	}
+	if err != nil {
+		return code, fmt.Errorf("failed to use authorization code. %w", err)
+	}
+	if code.Used != nil {
+		return code, ErrOAuthCodeUsed
+	}
+
+	return code, nil
+}
+
+// SaveOAuthGrant records the user's consent to the client. Scopes are added
+// to any the user granted before.
+func (p *Postgres) SaveOAuthGrant(ctx context.Context, grant model.OAuthGrant) (model.OAuthGrant, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.oauth_grant AS g (user_id, client_id, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE
+		SET scopes = ARRAY(SELECT DISTINCT s FROM unnest(g.scopes || EXCLUDED.scopes) AS s ORDER BY s),
+			updated = now()
+		RETURNING scopes, created, updated`,
+		grant.UserID,
+		grant.ClientID,
+		pq.Array(grant.Scopes),
+	).Scan(pq.Array(&grant.Scopes), &grant.Created, &grant.Updated)
+	if err != nil {
+		return grant, fmt.Errorf("failed to save oauth grant. %w", err)
+	}
+
+	return grant, nil
+}
+
+func (p *Postgres) GetOAuthGrant(ctx context.Context, userID string, clientID string) (model.OAuthGrant, error) {
+	grants, err := p.getOAuthGrants(ctx, p.primaryConn(ctx), `g.user_id = $1 AND g.client_id = $2`, userID, clientID)
+	if err != nil {
+		return model.OAuthGrant{}, err
+	}
+	if len(grants) == 0 {
+		return model.OAuthGrant{}, ErrOAuthGrantNotFound
+	}
+
+	return grants[0], nil
+}
+
+// GetUserOAuthGrants returns the apps the user has authorized, most recently
+// authorized first.
+func (p *Postgres) GetUserOAuthGrants(ctx context.Context, userID string) ([]model.OAuthGrant, error) {
+	return p.getOAuthGrants(ctx, p.readConn(ctx), `g.user_id = $1`, userID)
+}
+
+func (p *Postgres) getOAuthGrants(ctx context.Context, conn querier, where string, args ...any) ([]model.OAuthGrant, error) {
+	grants := []model.OAuthGrant{}
+	rows, err := conn.QueryContext(ctx,
+		`SELECT g.user_id, g.client_id, c.name, g.scopes, g.created, g.updated
+		FROM sandbox.oauth_grant g
+		JOIN sandbox.oauth_client c ON c.id = g.client_id
+		WHERE `+where+`
+		ORDER BY g.updated DESC, g.client_id DESC`,
+		args...)
+	if err != nil {
+		return grants, fmt.Errorf("failed to query oauth grants. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var g model.OAuthGrant
+		if err := rows.Scan(&g.UserID, &g.ClientID, &g.ClientName, pq.Array(&g.Scopes), &g.Created, &g.Updated); err != nil {
+			return grants, fmt.Errorf("failed to scan. %w", err)
+		}
+		grants = append(grants, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return grants, fmt.Errorf("failed to iterate oauth grants. %w", err)
+	}
+
+	return grants, nil
+}
+
+func (p *Postgres) DeleteOAuthGrant(ctx context.Context, userID string, clientID string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`DELETE FROM sandbox.oauth_grant WHERE user_id = $1 AND client_id = $2`,
+		userID,
+		clientID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete oauth grant. %w", err)
+	}
+
+	return expectRow(res, ErrOAuthGrantNotFound)
+}
+
+// DeleteUserOAuthGrants deletes every grant the user has given and returns
+// how many there were. Access tokens issued under them stop working with
+// them.
+func (p *Postgres) DeleteUserOAuthGrants(ctx context.Context, userID string) (int, error) {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`DELETE FROM sandbox.oauth_grant WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete oauth grants. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected. %w", err)
+	}
+
+	return int(n), nil
+}
+
+func oauthGrantKey(userID string, clientID string) string {
+	return userID + "/" + clientID
+}
+
+func (m *Memory) InsertOAuthClient(ctx context.Context, client model.OAuthClient) (model.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.oauthClients[client.ID]; ok {
+		return client, fmt.Errorf("failed to insert oauth client. duplicate id %s", client.ID)
+	}
+
+	client.Created = time.Now().UTC()
+	client.Public = client.SecretHash == ""
+	client.RedirectURIs = slices.Clone(client.RedirectURIs)
+	client.Scopes = slices.Clone(client.Scopes)
+	m.oauthClients[client.ID] = client
+
+	return client, nil
+}
+
+func (m *Memory) GetOAuthClient(ctx context.Context, id string) (model.OAuthClient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	client, ok := m.oauthClients[id]
+	if !ok {
+		return model.OAuthClient{}, ErrOAuthClientNotFound
+	}
+	client.RedirectURIs = slices.Clone(client.RedirectURIs)
+	client.Scopes = slices.Clone(client.Scopes)
+
+	return client, nil
+}
+
+func (m *Memory) GetOAuthClients(ctx context.Context) ([]model.OAuthClient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	clients := []model.OAuthClient{}
+	for _, c := range m.oauthClients {
+		c.SecretHash = ""
+		c.RedirectURIs = slices.Clone(c.RedirectURIs)
+		c.Scopes = slices.Clone(c.Scopes)
+		clients = append(clients, c)
+	}
+	slices.SortFunc(clients, func(a, b model.OAuthClient) int {
+		if c := b.Created.Compare(a.Created); c != 0 {
+			return c
+		}
+		return compareValues(b.ID, a.ID)
+	})
+
+	return clients, nil
+}
+
+func (m *Memory) DeleteOAuthClient(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.oauthClients[id]; !ok {
+		return ErrOAuthClientNotFound
+	}
+	delete(m.oauthClients, id)
+	for codeID, c := range m.oauthCodes {
+		if c.ClientID == id {
+			delete(m.oauthCodes, codeID)
+		}
+	}
+	for key, g := range m.oauthGrants {
+		if g.ClientID == id {
+			delete(m.oauthGrants, key)
+		}
+	}
+	for tokenID, t := range m.refreshTokens {
+		if t.ClientID == id {
+			delete(m.refreshTokens, tokenID)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) InsertOAuthCode(ctx context.Context, code model.OAuthCode) (model.OAuthCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[code.UserID]; !ok {
+		return code, fmt.Errorf("failed to insert authorization code. %w", ErrUserNotFound)
+	}
+	if _, ok := m.oauthClients[code.ClientID]; !ok {
+		return code, fmt.Errorf("failed to insert authorization code. %w", ErrOAuthClientNotFound)
+	}
+	for _, c := range m.oauthCodes {
+		if c.CodeHash == code.CodeHash {
+			return code, fmt.Errorf("failed to insert authorization code. duplicate code")
+		}
+	}
+
+	code.Created = time.Now().UTC()
+	code.Scopes = slices.Clone(code.Scopes)
+	m.oauthCodes[code.ID] = code
+
+	return code, nil
+}
+
+func (m *Memory) UseOAuthCode(ctx context.Context, codeHash string) (model.OAuthCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.oauthCodes {
+		if c.CodeHash != codeHash {
+			continue
+		}
+		used := c.Used
+		if used == nil {
+			now := time.Now().UTC()
+			c.Used = &now
+			m.oauthCodes[id] = c
+		}
+		c.Used = used
+		c.Scopes = slices.Clone(c.Scopes)
+		if used != nil {
+			return c, ErrOAuthCodeUsed
+		}
+		return c, nil
+	}
+
+	return model.OAuthCode{}, ErrOAuthCodeNotFound
+}
+
+func (m *Memory) SaveOAuthGrant(ctx context.Context, grant model.OAuthGrant) (model.OAuthGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[grant.UserID]; !ok {
+		return grant, fmt.Errorf("failed to save oauth grant. %w", ErrUserNotFound)
+	}
+	if _, ok := m.oauthClients[grant.ClientID]; !ok {
+		return grant, fmt.Errorf("failed to save oauth grant. %w", ErrOAuthClientNotFound)
+	}
+
+	now := time.Now().UTC()
+	key := oauthGrantKey(grant.UserID, grant.ClientID)
+	scopes := slices.Clone(grant.Scopes)
+	created := now
+	if old, ok := m.oauthGrants[key]; ok {
+		scopes = append(scopes, old.Scopes...)
+		created = old.Created
+	}
+	slices.Sort(scopes)
+	grant.Scopes = slices.Compact(scopes)
+	grant.ClientName = ""
+	grant.Created, grant.Updated = created, now
+	m.oauthGrants[key] = grant
+
+	grant.Scopes = slices.Clone(grant.Scopes)
+	return grant, nil
+}
+
+func (m *Memory) GetOAuthGrant(ctx context.Context, userID string, clientID string) (model.OAuthGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	grant, ok := m.oauthGrants[oauthGrantKey(userID, clientID)]
+	if !ok {
+		return model.OAuthGrant{}, ErrOAuthGrantNotFound
+	}
+
+	return m.oauthGrantLocked(grant), nil
+}
+
+func (m *Memory) GetUserOAuthGrants(ctx context.Context, userID string) ([]model.OAuthGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	grants := []model.OAuthGrant{}
+	for _, g := range m.oauthGrants {
+		if g.UserID == userID {
+			grants = append(grants, m.oauthGrantLocked(g))
+		}
+	}
+	slices.SortFunc(grants, func(a, b model.OAuthGrant) int {
+		if c := b.Updated.Compare(a.Updated); c != 0 {
+			return c
+		}
+		return compareValues(b.ClientID, a.ClientID)
+	})
+
+	return grants, nil
+}
+
+// oauthGrantLocked fills in the client name the way the join does.
+func (m *Memory) oauthGrantLocked(grant model.OAuthGrant) model.OAuthGrant {
+	grant.ClientName = m.oauthClients[grant.ClientID].Name
+	grant.Scopes = slices.Clone(grant.Scopes)
+	return grant
+}
+
+func (m *Memory) DeleteOAuthGrant(ctx context.Context, userID string, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := oauthGrantKey(userID, clientID)
+	if _, ok := m.oauthGrants[key]; !ok {
+		return ErrOAuthGrantNotFound
+	}
+	delete(m.oauthGrants, key)
+
+	return nil
+}
+
+func (m *Memory) DeleteUserOAuthGrants(ctx context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for key, grant := range m.oauthGrants {
+		if grant.UserID == userID {
+			delete(m.oauthGrants, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/slham/sandbox-api/crypt"
+)
+
+// UpdatePassword replaces the user's stored password hash. It leaves the
+// version alone since the password is not part of the user's representation.
+func (p *Postgres) UpdatePassword(ctx context.Context, id string, hash string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user
+		SET password = $1
+		WHERE id = $2 AND deleted IS NULL`,
+		hash,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update password. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// CountLegacyPasswords counts the live users whose password is still stored
+// in the reversible legacy format, along with all live users.
+func (p *Postgres) CountLegacyPasswords(ctx context.Context) (legacy int, total int, err error) {
+	err = p.readConn(ctx).QueryRowContext(ctx,
+		`SELECT count(*) FILTER (WHERE password NOT LIKE $1 || '%'), count(*)
+		FROM sandbox.user
+		WHERE deleted IS NULL`,
+		crypt.Argon2idPrefix,
+	).Scan(&legacy, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count legacy passwords. %w", err)
+	}
+
+	return legacy, total, nil
+}
+
+func (m *Memory) UpdatePassword(ctx context.Context, id string, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[id]
+	if !ok || stored.Deleted != nil {
+		return ErrUserNotFound
+	}
+
+	stored.Password = hash
+	stored.Updated = time.Now().UTC()
+	m.users[id] = stored
+
+	return nil
+}
+
+func (m *Memory) CountLegacyPasswords(ctx context.Context) (legacy int, total int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Deleted != nil {
+			continue
+		}
+		total++
+		if crypt.IsLegacyPassword(u.Password) {
+			legacy++
+		}
+	}
+
+	return legacy, total, nil
+}
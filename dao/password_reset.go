@@ -0,0 +1,165 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrPasswordResetNotFound    = errors.New("password reset does not exist")
+	ErrPasswordResetRateLimited = errors.New("password reset email was sent too recently")
+)
+
+func (p *Postgres) InsertPasswordReset(ctx context.Context, reset model.PasswordReset) (model.PasswordReset, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.password_reset(
+			id,
+			user_id,
+			token_hash,
+			expires
+		) VALUES ($1, $2, $3, $4)
+		RETURNING created`,
+		reset.ID,
+		reset.UserID,
+		reset.TokenHash,
+		reset.Expires,
+	).Scan(&reset.Created)
+	if err != nil {
+		return reset, fmt.Errorf("failed to insert password reset. %w", err)
+	}
+
+	return reset, nil
+}
+
+// MarkPasswordResetSent records that a password reset email is being sent to
+// the user at sent, unless one was sent less than interval before, in which
+// case it returns ErrPasswordResetRateLimited.
+func (p *Postgres) MarkPasswordResetSent(ctx context.Context, userID string, sent time.Time, interval time.Duration) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user
+		SET password_reset_sent = $2
+		WHERE id = $1 AND deleted IS NULL AND (password_reset_sent IS NULL OR password_reset_sent <= $3)`,
+		userID,
+		sent,
+		sent.Add(-interval),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark password reset sent. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		if _, err := p.GetUserByID(ctx, userID); err != nil {
+			return err
+		}
+		return ErrPasswordResetRateLimited
+	}
+
+	return nil
+}
+
+// UsePasswordReset spends the live reset with the hash, along with every other
+// reset of its user, and returns it. Used, expired and unknown resets, and
+// those of deleted users, are not found.
+func (p *Postgres) UsePasswordReset(ctx context.Context, tokenHash string) (model.PasswordReset, error) {
+	reset := model.PasswordReset{}
+	err := p.WithTx(ctx, func(ctx context.Context) error {
+		err := p.conn(ctx).QueryRowContext(ctx,
+			`UPDATE sandbox.password_reset r
+			SET used = now()
+			FROM sandbox.user u
+			WHERE r.token_hash = $1 AND r.used IS NULL AND r.expires > now() AND u.id = r.user_id AND u.deleted IS NULL
+			RETURNING r.id, r.user_id, r.token_hash, r.created, r.expires, r.used`,
+			tokenHash,
+		).Scan(&reset.ID, &reset.UserID, &reset.TokenHash, &reset.Created, &reset.Expires, &reset.Used)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPasswordResetNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to use password reset. %w", err)
+		}
+
+		_, err = p.conn(ctx).ExecContext(ctx,
+			`UPDATE sandbox.password_reset
+			SET used = now()
+			WHERE user_id = $1 AND used IS NULL`,
+			reset.UserID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to use other password resets. %w", err)
+		}
+
+		return nil
+	})
+
+	return reset, err
+}
+
+func (m *Memory) InsertPasswordReset(ctx context.Context, reset model.PasswordReset) (model.PasswordReset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[reset.UserID]; !ok {
+		return reset, fmt.Errorf("failed to insert password reset. %w", ErrUserNotFound)
+	}
+	for _, r := range m.passwordResets {
+		if r.TokenHash == reset.TokenHash {
+			return reset, fmt.Errorf("failed to insert password reset. duplicate token")
+		}
+	}
+
+	reset.Created = time.Now().UTC()
+	reset.Used = nil
+	m.passwordResets[reset.ID] = reset
+
+	return reset, nil
+}
+
+func (m *Memory) MarkPasswordResetSent(ctx context.Context, userID string, sent time.Time, interval time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[userID]; !ok || u.Deleted != nil {
+		return ErrUserNotFound
+	}
+	if last, ok := m.passwordResetSent[userID]; ok && sent.Sub(last) < interval {
+		return ErrPasswordResetRateLimited
+	}
+	m.passwordResetSent[userID] = sent.UTC()
+
+	return nil
+}
+
+func (m *Memory) UsePasswordReset(ctx context.Context, tokenHash string) (model.PasswordReset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, r := range m.passwordResets {
+		if r.TokenHash != tokenHash || r.Used != nil || !r.Expires.After(now) {
+			continue
+		}
+		if u, ok := m.users[r.UserID]; !ok || u.Deleted != nil {
+			break
+		}
+
+		for id, other := range m.passwordResets {
+			if other.UserID == r.UserID && other.Used == nil {
+				other.Used = &now
+				m.passwordResets[id] = other
+			}
+		}
+		r.Used = &now
+		return r, nil
+	}
+
+	return model.PasswordReset{}, ErrPasswordResetNotFound
+}
@@ -0,0 +1,281 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slham/sandbox-api/model"
+)
+
+var ErrPersonalAccessTokenNotFound = errors.New("personal access token does not exist")
+
+func (p *Postgres) InsertPersonalAccessToken(ctx context.Context, token model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.personal_access_token(
+			id,
+			user_id,
+			name,
+			token_hash,
+			scopes,
+			expires
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created`,
+		token.ID,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		pq.Array(token.Scopes),
+		token.Expires,
+	).Scan(&token.Created)
+	if err != nil {
+		return token, fmt.Errorf("failed to insert personal access token. %w", err)
+	}
+
+	return token, nil
+}
+
+// GetPersonalAccessTokenByToken returns the live token with the hash along
+// with its user's role names. Tokens that are revoked, expired or belong to a
+// deleted user are not found.
+func (p *Postgres) GetPersonalAccessTokenByToken(ctx context.Context, tokenHash string) (model.PersonalAccessToken, error) {
+	token := model.PersonalAccessToken{}
+	var roles, permissions []byte
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT s.id, s.user_id, s.name, s.token_hash, s.scopes, s.created, s.expires, s.last_used, `+sessionRoleNamesColumn+`, `+sessionPermissionsColumn+`, `+accountStateColumns+`
+		FROM sandbox.personal_access_token s
+		JOIN sandbox.user u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.revoked IS NULL AND (s.expires IS NULL OR s.expires > now()) AND u.deleted IS NULL`,
+		tokenHash,
+	).Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, pq.Array(&token.Scopes), &token.Created, &token.Expires, &token.LastUsed, &roles, &permissions, &token.Account.IsActive, &token.Account.IsSuspended, &token.Account.SuspendedReason, &token.Account.SuspendedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, ErrPersonalAccessTokenNotFound
+	}
+	if err != nil {
+		return token, fmt.Errorf("failed to get personal access token. %w", err)
+	}
+
+	if err := json.Unmarshal(roles, &token.Roles); err != nil {
+		return token, fmt.Errorf("failed to unmarshal personal access token roles. %w", err)
+	}
+	if err := json.Unmarshal(permissions, &token.Permissions); err != nil {
+		return token, fmt.Errorf("failed to unmarshal personal access token permissions. %w", err)
+	}
+
+	return token, nil
+}
+
+// GetUserPersonalAccessTokens returns the user's tokens that have not been
+// revoked, newest first. Expired tokens are included so their owner can see
+// why automation stopped.
+func (p *Postgres) GetUserPersonalAccessTokens(ctx context.Context, userID string) ([]model.PersonalAccessToken, error) {
+	tokens := []model.PersonalAccessToken{}
+	rows, err := p.readConn(ctx).QueryContext(ctx,
+		`SELECT id, user_id, name, scopes, created, expires, last_used
+		FROM sandbox.personal_access_token
+		WHERE user_id = $1 AND revoked IS NULL
+		ORDER BY created DESC, id DESC`,
+		userID)
+	if err != nil {
+		return tokens, fmt.Errorf("failed to query personal access tokens. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t model.PersonalAccessToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, pq.Array(&t.Scopes), &t.Created, &t.Expires, &t.LastUsed); err != nil {
+			return tokens, fmt.Errorf("failed to scan. %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return tokens, fmt.Errorf("failed to iterate personal access tokens. %w", err)
+	}
+
+	return tokens, nil
+}
+
+// TouchPersonalAccessToken records that the token was used.
+func (p *Postgres) TouchPersonalAccessToken(ctx context.Context, id string) error {
+	_, err := p.primaryConn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.personal_access_token
+		SET last_used = now()
+		WHERE id = $1 AND revoked IS NULL`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to touch personal access token. %w", err)
+	}
+
+	return nil
+}
+
+// RevokePersonalAccessToken revokes one of the user's tokens.
+func (p *Postgres) RevokePersonalAccessToken(ctx context.Context, userID string, id string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.personal_access_token
+		SET revoked = now()
+		WHERE id = $1 AND user_id = $2 AND revoked IS NULL`,
+		id,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke personal access token. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+
+	return nil
+}
+
+// RevokeUserPersonalAccessTokens revokes all of the user's live tokens and
+// returns how many there were.
+func (p *Postgres) RevokeUserPersonalAccessTokens(ctx context.Context, userID string) (int, error) {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.personal_access_token
+		SET revoked = now()
+		WHERE user_id = $1 AND revoked IS NULL AND (expires IS NULL OR expires > now())`,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke personal access tokens. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected. %w", err)
+	}
+
+	return int(n), nil
+}
+
+func (m *Memory) InsertPersonalAccessToken(ctx context.Context, token model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[token.UserID]; !ok {
+		return token, fmt.Errorf("failed to insert personal access token. %w", ErrUserNotFound)
+	}
+	for _, t := range m.personalAccessTokens {
+		if t.TokenHash == token.TokenHash {
+			return token, fmt.Errorf("failed to insert personal access token. duplicate token")
+		}
+	}
+
+	token.Created = time.Now().UTC()
+	token.Scopes = slices.Clone(token.Scopes)
+	token.Roles = nil
+	m.personalAccessTokens[token.ID] = token
+
+	return token, nil
+}
+
+func (m *Memory) GetPersonalAccessTokenByToken(ctx context.Context, tokenHash string) (model.PersonalAccessToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, t := range m.personalAccessTokens {
+		if t.TokenHash != tokenHash || t.Revoked != nil || (t.Expires != nil && !t.Expires.After(now)) {
+			continue
+		}
+		u, ok := m.users[t.UserID]
+		if !ok || u.Deleted != nil {
+			break
+		}
+
+		t.Account = u.AccountState
+		t.Scopes = slices.Clone(t.Scopes)
+		t.Roles = []string{}
+		for _, role := range m.userRolesLocked(t.UserID) {
+			t.Roles = append(t.Roles, role.Name)
+		}
+		t.Permissions = m.userPermissionsLocked(t.UserID)
+		return t, nil
+	}
+
+	return model.PersonalAccessToken{}, ErrPersonalAccessTokenNotFound
+}
+
+func (m *Memory) GetUserPersonalAccessTokens(ctx context.Context, userID string) ([]model.PersonalAccessToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tokens := []model.PersonalAccessToken{}
+	for _, t := range m.personalAccessTokens {
+		if t.UserID == userID && t.Revoked == nil {
+			t.TokenHash = ""
+			t.Scopes = slices.Clone(t.Scopes)
+			tokens = append(tokens, t)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b model.PersonalAccessToken) int {
+		if c := b.Created.Compare(a.Created); c != 0 {
+			return c
+		}
+		return compareValues(b.ID, a.ID)
+	})
+
+	return tokens, nil
+}
+
+func (m *Memory) TouchPersonalAccessToken(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.personalAccessTokens[id]
+	if !ok || t.Revoked != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	t.LastUsed = &now
+	m.personalAccessTokens[id] = t
+
+	return nil
+}
+
+func (m *Memory) RevokePersonalAccessToken(ctx context.Context, userID string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.personalAccessTokens[id]
+	if !ok || t.UserID != userID || t.Revoked != nil {
+		return ErrPersonalAccessTokenNotFound
+	}
+	now := time.Now().UTC()
+	t.Revoked = &now
+	m.personalAccessTokens[id] = t
+
+	return nil
+}
+
+func (m *Memory) RevokeUserPersonalAccessTokens(ctx context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	now := time.Now().UTC()
+	for id, t := range m.personalAccessTokens {
+		if t.UserID != userID || t.Revoked != nil || (t.Expires != nil && !t.Expires.After(now)) {
+			continue
+		}
+		t.Revoked = &now
+		m.personalAccessTokens[id] = t
+		n++
+	}
+
+	return n, nil
+}
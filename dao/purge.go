@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Purger permanently removes rows that have been in the trash since before a
+// cutoff.
+type Purger interface {
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+}
+
+// RunPurge purges rows trashed longer than retention every interval until ctx
+// is done. Failures are logged and retried on the next tick.
+func RunPurge(ctx context.Context, p Purger, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.PurgeDeleted(ctx, time.Now().Add(-retention))
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to purge trash", "err", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "purged trash", "rows", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDeleted hard deletes workouts and users trashed before the cutoff, and
+// sessions, tokens, mfa challenges, password resets, authorization codes and
+// login failures that expired or were revoked before it. Role assignments and
+// any workouts left on a purged user go with it through the foreign key
+// cascades.
+func (p *Postgres) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	total := 0
+	err := p.WithTx(ctx, func(ctx context.Context) error {
+		total = 0
+		stmts := []string{
+			`DELETE FROM sandbox.workout WHERE deleted < $1`,
+			`DELETE FROM sandbox.user WHERE deleted < $1`,
+			`DELETE FROM sandbox.session WHERE expires < $1 OR revoked < $1`,
+			`DELETE FROM sandbox.refresh_token WHERE expires < $1 OR revoked < $1`,
+			`DELETE FROM sandbox.personal_access_token WHERE expires < $1 OR revoked < $1`,
+			`DELETE FROM sandbox.mfa_challenge WHERE expires < $1`,
+			`DELETE FROM sandbox.password_reset WHERE expires < $1`,
+			`DELETE FROM sandbox.oauth_code WHERE expires < $1`,
+			`DELETE FROM sandbox.login_failure WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < $1)`,
+		}
+
+		for _, stmt := range stmts {
+			res, err := p.conn(ctx).ExecContext(ctx, stmt, before)
+			if err != nil {
+				return fmt.Errorf("failed to purge deleted rows. %w", err)
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to count purged rows. %w", err)
+			}
+			total += int(n)
+		}
+
+		return nil
+	})
+
+	return total, err
+}
@@ -0,0 +1,221 @@
+package dao
+
+import (
+	"fmt"
+	"strings"
+)
+
+// condition renders a WHERE clause fragment. Values are never written into
+// the sql, they are appended to args and referenced by placeholder. Column
+// names always come from this package, never from a caller.
+type condition interface {
+	build(args *[]any) string
+}
+
+func placeholder(args *[]any, v any) string {
+	*args = append(*args, v)
+	return fmt.Sprintf("$%d", len(*args))
+}
+
+type comparison struct {
+	col string
+	op  string
+	val any
+}
+
+func (c comparison) build(args *[]any) string {
+	return fmt.Sprintf("%s %s %s", c.col, c.op, placeholder(args, c.val))
+}
+
+func eq(col string, v any) condition  { return comparison{col, "=", v} }
+func gt(col string, v any) condition  { return comparison{col, ">", v} }
+func gte(col string, v any) condition { return comparison{col, ">=", v} }
+func lt(col string, v any) condition  { return comparison{col, "<", v} }
+func lte(col string, v any) condition { return comparison{col, "<=", v} }
+
+// ilike matches col case insensitively against a substring. Wildcards in s
+// are escaped so they match literally.
+func ilike(col string, s string) condition {
+	return comparison{col, "ILIKE", "%" + escapeLike(s) + "%"}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type nullCheck struct {
+	col  string
+	null bool
+}
+
+func (n nullCheck) build(args *[]any) string {
+	if n.null {
+		return n.col + " IS NULL"
+	}
+	return n.col + " IS NOT NULL"
+}
+
+func isNull(col string) condition  { return nullCheck{col, true} }
+func notNull(col string) condition { return nullCheck{col, false} }
+
+type membership[T any] struct {
+	col  string
+	vals []T
+}
+
+func (m membership[T]) build(args *[]any) string {
+	if len(m.vals) == 0 {
+		return "FALSE"
+	}
+
+	ps := make([]string, len(m.vals))
+	for i, v := range m.vals {
+		ps[i] = placeholder(args, v)
+	}
+
+	return fmt.Sprintf("%s IN (%s)", m.col, strings.Join(ps, ", "))
+}
+
+func in[T any](col string, vals ...T) condition {
+	return membership[T]{col, vals}
+}
+
+type between struct {
+	col    string
+	lo, hi any
+}
+
+func (b between) build(args *[]any) string {
+	return fmt.Sprintf("%s BETWEEN %s AND %s", b.col, placeholder(args, b.lo), placeholder(args, b.hi))
+}
+
+func inRange(col string, lo, hi any) condition {
+	return between{col, lo, hi}
+}
+
+// expr is a raw fragment for things the other conditions cannot say, such as
+// subqueries. Each ? in the fragment is replaced by the next value.
+type expr struct {
+	fragment string
+	vals     []any
+}
+
+func (e expr) build(args *[]any) string {
+	var sb strings.Builder
+	i := 0
+	for _, r := range e.fragment {
+		if r == '?' && i < len(e.vals) {
+			sb.WriteString(placeholder(args, e.vals[i]))
+			i++
+			continue
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+func raw(fragment string, vals ...any) condition {
+	return expr{fragment, vals}
+}
+
+type junction struct {
+	op    string
+	conds []condition
+}
+
+func (j junction) build(args *[]any) string {
+	parts := make([]string, 0, len(j.conds))
+	for _, c := range j.conds {
+		if c == nil {
+			continue
+		}
+		if s := c.build(args); s != "" {
+			parts = append(parts, s)
+		}
+	}
+
+	switch len(parts) {
+	case 0:
+		return ""
+	case 1:
+		return parts[0]
+	default:
+		return "(" + strings.Join(parts, " "+j.op+" ") + ")"
+	}
+}
+
+func and(conds ...condition) condition { return junction{"AND", conds} }
+func or(conds ...condition) condition  { return junction{"OR", conds} }
+
+type selectBuilder struct {
+	columns []string
+	from    string
+	where   []condition
+	orderBy []string
+	limit   int
+	offset  int
+}
+
+func newSelect(from string, columns ...string) *selectBuilder {
+	return &selectBuilder{from: from, columns: columns}
+}
+
+// Where ands c onto the existing conditions. A nil c is ignored so optional
+// filters can be added without checks at every call site.
+func (b *selectBuilder) Where(c condition) *selectBuilder {
+	if c != nil {
+		b.where = append(b.where, c)
+	}
+	return b
+}
+
+func (b *selectBuilder) OrderBy(order ...string) *selectBuilder {
+	b.orderBy = order
+	return b
+}
+
+func (b *selectBuilder) Limit(limit int) *selectBuilder {
+	b.limit = limit
+	return b
+}
+
+func (b *selectBuilder) Offset(offset int) *selectBuilder {
+	b.offset = offset
+	return b
+}
+
+func (b *selectBuilder) Build() (string, []any) {
+	args := []any{}
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "SELECT %s FROM %s", strings.Join(b.columns, ", "), b.from)
+	if where := and(b.where...).build(&args); where != "" {
+		fmt.Fprintf(&sb, " WHERE %s", where)
+	}
+	if len(b.orderBy) > 0 {
+		fmt.Fprintf(&sb, " ORDER BY %s", strings.Join(b.orderBy, ", "))
+	}
+	if b.limit > 0 {
+		fmt.Fprintf(&sb, " LIMIT %s", placeholder(&args, b.limit))
+	}
+	if b.offset > 0 {
+		fmt.Fprintf(&sb, " OFFSET %s", placeholder(&args, b.offset))
+	}
+
+	return sb.String(), args
+}
+
+// Count builds a statement counting every row the conditions match,
+// ignoring order, limit and offset.
+func (b *selectBuilder) Count() (string, []any) {
+	args := []any{}
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "SELECT count(*) FROM %s", b.from)
+	if where := and(b.where...).build(&args); where != "" {
+		fmt.Fprintf(&sb, " WHERE %s", where)
+	}
+
+	return sb.String(), args
+}
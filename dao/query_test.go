@@ -0,0 +1,114 @@
+//go:build unit
+// +build unit
+
+package dao
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectBuilder(t *testing.T) {
+	tables := []struct {
+		name     string
+		builder  *selectBuilder
+		expected string
+		args     []any
+	}{
+		{
+			name:     "no conditions",
+			builder:  newSelect("sandbox.role", "id", "name"),
+			expected: "SELECT id, name FROM sandbox.role",
+			args:     []any{},
+		},
+		{
+			name: "and with nil conditions",
+			builder: newSelect("sandbox.user", "id").
+				Where(eq("id", "user_1")).
+				Where(nil).
+				Where(ilike("email", "50%_off")),
+			expected: "SELECT id FROM sandbox.user WHERE (id = $1 AND email ILIKE $2)",
+			args:     []any{"user_1", `%50\%\_off%`},
+		},
+		{
+			name: "or in and range",
+			builder: newSelect("sandbox.workout", "id").
+				Where(or(in("id", "a", "b"), inRange("created", 1, 2))).
+				OrderBy("name ASC", "id ASC").
+				Limit(10).
+				Offset(20),
+			expected: "SELECT id FROM sandbox.workout WHERE (id IN ($1, $2) OR created BETWEEN $3 AND $4) ORDER BY name ASC, id ASC LIMIT $5 OFFSET $6",
+			args:     []any{"a", "b", 1, 2, 10, 20},
+		},
+		{
+			name:     "empty in matches nothing",
+			builder:  newSelect("sandbox.user", "id").Where(in[string]("id")),
+			expected: "SELECT id FROM sandbox.user WHERE FALSE",
+			args:     []any{},
+		},
+		{
+			name: "raw subquery",
+			builder: newSelect("sandbox.role", "id").
+				Where(gt("id", 3)).
+				Where(raw("id IN (SELECT role_id FROM sandbox.user_role WHERE user_id = ?)", "user_1")),
+			expected: "SELECT id FROM sandbox.role WHERE (id > $1 AND id IN (SELECT role_id FROM sandbox.user_role WHERE user_id = $2))",
+			args:     []any{3, "user_1"},
+		},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			stmt, args := table.builder.Build()
+			assert.Equal(t, table.expected, stmt)
+			assert.Equal(t, table.args, args)
+		})
+	}
+}
+
+func TestUserQueryBuild(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := UserQuery{
+		Email:        "a@b.c' OR '1'='1",
+		CreatedAfter: after,
+		Query:        Query{SortCol: "username", Sort: "desc", Limit: 5},
+	}
+
+	stmt, args, err := q.build()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT id, username, password, email, created, updated, version, deleted, is_active, is_suspended, suspended_reason, suspended_until, is_verified FROM sandbox.user WHERE (deleted IS NULL AND email = $1 AND created >= $2) ORDER BY username DESC, id DESC LIMIT $3", stmt)
+	assert.Equal(t, []any{"a@b.c' OR '1'='1", after, 5}, args)
+
+	q.WithRoles = true
+	stmt, _, err = q.build()
+	assert.NoError(t, err)
+	assert.Contains(t, stmt, "json_agg")
+	assert.Contains(t, stmt, "WHERE ur.user_id = sandbox.user.id")
+}
+
+func TestQuerySortValidation(t *testing.T) {
+	tables := []struct {
+		name string
+		q    Query
+		err  error
+	}{
+		{"default", Query{}, nil},
+		{"allowed", Query{SortCol: "created", Sort: "ASC"}, nil},
+		{"injected column", Query{SortCol: "id; DROP TABLE sandbox.user"}, ErrInvalidSortColumn},
+		{"unknown column", Query{SortCol: "password"}, ErrInvalidSortColumn},
+		{"injected order", Query{Sort: "ASC, (SELECT 1)"}, ErrInvalidSortOrder},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			_, _, err := UserQuery{Query: table.q}.build()
+			if table.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, table.err))
+		})
+	}
+}
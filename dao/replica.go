@@ -0,0 +1,156 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReadYourWritesWindow = 5 * time.Second
+
+// readRouter sends reads to healthy replicas in turn, and to the primary when
+// none is healthy or when the caller wrote recently enough that a replica may
+// not have caught up yet.
+type readRouter struct {
+	primary  *sql.DB
+	replicas []*replica
+	next     atomic.Uint64
+
+	window time.Duration
+	key    func(ctx context.Context) string
+
+	mu     sync.Mutex
+	writes map[string]time.Time
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+func newReadRouter(primary *sql.DB, replicas []*sql.DB) *readRouter {
+	r := &readRouter{
+		primary: primary,
+		window:  defaultReadYourWritesWindow,
+		writes:  map[string]time.Time{},
+	}
+	for _, db := range replicas {
+		r.replicas = append(r.replicas, &replica{db: db})
+	}
+
+	return r
+}
+
+func (r *readRouter) pick(ctx context.Context) *sql.DB {
+	if len(r.replicas) == 0 || r.wroteRecently(ctx) {
+		return r.primary
+	}
+
+	start := r.next.Add(1)
+	for i := range uint64(len(r.replicas)) {
+		rep := r.replicas[(start+i)%uint64(len(r.replicas))]
+		if rep.healthy.Load() {
+			return rep.db
+		}
+	}
+
+	return r.primary
+}
+
+func (r *readRouter) keyOf(ctx context.Context) string {
+	if r.key == nil {
+		return ""
+	}
+	return r.key(ctx)
+}
+
+func (r *readRouter) wrote(ctx context.Context) {
+	key := r.keyOf(ctx)
+	if key == "" || len(r.replicas) == 0 {
+		return
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writes[key] = now
+	if len(r.writes) > 1024 {
+		for k, t := range r.writes {
+			if now.Sub(t) > r.window {
+				delete(r.writes, k)
+			}
+		}
+	}
+}
+
+func (r *readRouter) wroteRecently(ctx context.Context) bool {
+	key := r.keyOf(ctx)
+	if key == "" {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.writes[key]
+	if !ok {
+		return false
+	}
+	if time.Since(t) > r.window {
+		delete(r.writes, key)
+		return false
+	}
+
+	return true
+}
+
+// check pings every replica and records whether it answered in time.
+func (r *readRouter) check(ctx context.Context, timeout time.Duration) {
+	for i, rep := range r.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := rep.db.PingContext(pingCtx)
+		cancel()
+
+		healthy := err == nil
+		if was := rep.healthy.Swap(healthy); was != healthy {
+			if healthy {
+				slog.InfoContext(ctx, "replica is healthy", "replica", i)
+			} else {
+				slog.WarnContext(ctx, "replica is unhealthy. reading from primary", "replica", i, "err", err)
+			}
+		}
+	}
+}
+
+// ReadYourWrites keeps reads on the primary for window after a write made
+// with a context that key maps to the same non empty value, typically the
+// id of the user making the request.
+func (p *Postgres) ReadYourWrites(window time.Duration, key func(ctx context.Context) string) {
+	p.reads.window = window
+	p.reads.key = key
+}
+
+// MonitorReplicas checks replica health every interval until ctx is done.
+// Replicas are not read from until they have passed a check.
+func (p *Postgres) MonitorReplicas(ctx context.Context, interval time.Duration) {
+	if len(p.reads.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.reads.check(ctx, min(interval, 2*time.Second))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+//go:build unit
+// +build unit
+
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type replicaKey struct{}
+
+func TestReadReplicaRouting(t *testing.T) {
+	primary := &countingConnector{users: 1}
+	replica := &countingConnector{users: 1}
+	primaryDB, replicaDB := sql.OpenDB(primary), sql.OpenDB(replica)
+	replicaDB.SetMaxIdleConns(0)
+
+	p := NewPostgres(Dao{DB: primaryDB, Replicas: []*sql.DB{replicaDB}})
+	p.ReadYourWrites(50*time.Millisecond, func(ctx context.Context) string {
+		key, _ := ctx.Value(replicaKey{}).(string)
+		return key
+	})
+
+	ctx := context.Background()
+	alice := context.WithValue(ctx, replicaKey{}, "alice")
+	bob := context.WithValue(ctx, replicaKey{}, "bob")
+
+	read := func(ctx context.Context) {
+		t.Helper()
+		_, err := p.GetUsers(ctx, UserQuery{})
+		assert.NoError(t, err)
+	}
+	counts := func() (int64, int64) {
+		return primary.queries.Swap(0), replica.queries.Swap(0)
+	}
+
+	// replicas are not trusted until they pass a check
+	read(ctx)
+	got, _ := counts()
+	assert.Equal(t, int64(1), got)
+
+	p.reads.check(ctx, time.Second)
+	read(ctx)
+	read(alice)
+	got, rep := counts()
+	assert.Equal(t, int64(0), got)
+	assert.Equal(t, int64(2), rep)
+
+	// a write keeps that caller on the primary for the window only
+	p.conn(alice)
+	read(alice)
+	read(bob)
+	got, rep = counts()
+	assert.Equal(t, int64(1), got)
+	assert.Equal(t, int64(1), rep)
+
+	time.Sleep(60 * time.Millisecond)
+	read(alice)
+	got, rep = counts()
+	assert.Equal(t, int64(0), got)
+	assert.Equal(t, int64(1), rep)
+
+	// an unhealthy replica falls back to the primary until it recovers
+	replica.down.Store(true)
+	p.reads.check(ctx, time.Second)
+	read(bob)
+	got, rep = counts()
+	assert.Equal(t, int64(1), got)
+	assert.Equal(t, int64(0), rep)
+
+	replica.down.Store(false)
+	p.reads.check(ctx, time.Second)
+	read(bob)
+	got, rep = counts()
+	assert.Equal(t, int64(0), got)
+	assert.Equal(t, int64(1), rep)
+
+	// credential checks never read from a replica
+	_, err := p.GetUsers(ctx, UserQuery{Username: "alice", Primary: true})
+	assert.NoError(t, err)
+	got, rep = counts()
+	assert.Equal(t, int64(1), got)
+	assert.Equal(t, int64(0), rep)
+}
@@ -0,0 +1,172 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/slham/sandbox-api/model"
+)
+
+type UserRepository interface {
+	Transactor
+	InsertUser(ctx context.Context, user model.User) (model.User, error)
+	GetUserByEmail(ctx context.Context, email string) (model.User, error)
+	GetUserByUsername(ctx context.Context, username string) (model.User, error)
+	GetUserByID(ctx context.Context, id string) (model.User, error)
+	GetUser(ctx context.Context, q UserQuery) (model.User, error)
+	GetUsers(ctx context.Context, q UserQuery) ([]model.User, error)
+	ListUsers(ctx context.Context, q UserQuery) (Page[model.User], error)
+	UpdateUser(ctx context.Context, user model.User) (model.User, error)
+	DeleteUser(ctx context.Context, id string) error
+	RestoreUser(ctx context.Context, id string) (model.User, error)
+	UpdatePassword(ctx context.Context, id string, hash string) error
+	CountLegacyPasswords(ctx context.Context) (legacy int, total int, err error)
+	VerifyEmail(ctx context.Context, id string, email string) error
+	MarkVerificationSent(ctx context.Context, id string, sent time.Time, interval time.Duration) error
+	GetAccountState(ctx context.Context, id string) (model.AccountState, error)
+	SuspendUser(ctx context.Context, id string, reason string, until *time.Time) error
+	ReactivateUser(ctx context.Context, id string) error
+	DeactivateUser(ctx context.Context, id string) error
+}
+
+type RoleRepository interface {
+	Transactor
+	InsertRole(ctx context.Context, role model.Role) (model.Role, error)
+	GetRoleByID(ctx context.Context, id int) (model.Role, error)
+	GetRoleByName(ctx context.Context, name string) (model.Role, error)
+	GetUserRoles(ctx context.Context, userID string) ([]model.Role, error)
+	GetRole(ctx context.Context, q RoleQuery) (model.Role, error)
+	GetRoles(ctx context.Context, q RoleQuery) ([]model.Role, error)
+	UpdateRole(ctx context.Context, role model.Role) (model.Role, error)
+	DeleteRole(ctx context.Context, id int) error
+	AddUserRole(ctx context.Context, userID string, roleID int) error
+	RemoveUserRole(ctx context.Context, userID string, roleID int) error
+	GetUserPermissions(ctx context.Context, userID string) ([]string, error)
+}
+
+type WorkoutRepository interface {
+	Transactor
+	InsertWorkout(ctx context.Context, workout model.Workout) (model.Workout, error)
+	GetWorkoutByID(ctx context.Context, userID string, workoutID string) (model.Workout, error)
+	GetWorkout(ctx context.Context, q WorkoutQuery) (model.Workout, error)
+	GetWorkouts(ctx context.Context, q WorkoutQuery) ([]model.Workout, error)
+	ListWorkouts(ctx context.Context, q WorkoutQuery) (Page[model.Workout], error)
+	UpdateWorkout(ctx context.Context, workout model.Workout) (model.Workout, error)
+	DeleteWorkout(ctx context.Context, userID string, workoutID string) error
+	RestoreWorkout(ctx context.Context, userID string, workoutID string) (model.Workout, error)
+	InsertWorkoutRevision(ctx context.Context, rev model.WorkoutRevision) (model.WorkoutRevision, error)
+	GetWorkoutRevisions(ctx context.Context, workoutID string) ([]model.WorkoutRevision, error)
+	GetWorkoutRevision(ctx context.Context, workoutID string, revision int) (model.WorkoutRevision, error)
+}
+
+type SessionRepository interface {
+	InsertSession(ctx context.Context, session model.Session) (model.Session, error)
+	GetSessionByToken(ctx context.Context, tokenHash string) (model.Session, error)
+	GetUserSessions(ctx context.Context, userID string) ([]model.Session, error)
+	TouchSession(ctx context.Context, id string, expires time.Time) error
+	RevokeSession(ctx context.Context, userID string, id string) error
+	RevokeUserSessions(ctx context.Context, userID string) (int, error)
+	EndExpiredImpersonation(ctx context.Context, tokenHash string) (model.Session, error)
+}
+
+type IdentityRepository interface {
+	InsertIdentity(ctx context.Context, identity model.Identity) (model.Identity, error)
+	GetIdentity(ctx context.Context, provider string, subject string) (model.Identity, error)
+	GetUserIdentities(ctx context.Context, userID string) ([]model.Identity, error)
+}
+
+type RefreshTokenRepository interface {
+	InsertRefreshToken(ctx context.Context, token model.RefreshToken) (model.RefreshToken, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, error)
+	UseRefreshToken(ctx context.Context, id string) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int, error)
+	RevokeUserRefreshTokens(ctx context.Context, userID string) (int, error)
+	RevokeClientRefreshTokens(ctx context.Context, userID string, clientID string) (int, error)
+}
+
+type SigningKeyRepository interface {
+	InsertSigningKey(ctx context.Context, key model.SigningKey) (model.SigningKey, error)
+	GetSigningKeys(ctx context.Context, since time.Time) ([]model.SigningKey, error)
+	DeleteSigningKeys(ctx context.Context, before time.Time) (int, error)
+}
+
+type PersonalAccessTokenRepository interface {
+	InsertPersonalAccessToken(ctx context.Context, token model.PersonalAccessToken) (model.PersonalAccessToken, error)
+	GetPersonalAccessTokenByToken(ctx context.Context, tokenHash string) (model.PersonalAccessToken, error)
+	GetUserPersonalAccessTokens(ctx context.Context, userID string) ([]model.PersonalAccessToken, error)
+	TouchPersonalAccessToken(ctx context.Context, id string) error
+	RevokePersonalAccessToken(ctx context.Context, userID string, id string) error
+	RevokeUserPersonalAccessTokens(ctx context.Context, userID string) (int, error)
+}
+
+type MFARepository interface {
+	Transactor
+	GetMFA(ctx context.Context, userID string) (model.MFA, error)
+	SetMFA(ctx context.Context, mfa model.MFA) (model.MFA, error)
+	ConfirmMFA(ctx context.Context, userID string, counter int64) error
+	UseMFACounter(ctx context.Context, userID string, counter int64) error
+	DeleteMFA(ctx context.Context, userID string) error
+	SetRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID string, codeHash string) error
+	InsertMFAChallenge(ctx context.Context, challenge model.MFAChallenge) (model.MFAChallenge, error)
+	GetMFAChallenge(ctx context.Context, tokenHash string) (model.MFAChallenge, error)
+	FailMFAChallenge(ctx context.Context, id string) (int, error)
+	DeleteMFAChallenge(ctx context.Context, id string) error
+}
+
+type PasswordResetRepository interface {
+	InsertPasswordReset(ctx context.Context, reset model.PasswordReset) (model.PasswordReset, error)
+	MarkPasswordResetSent(ctx context.Context, userID string, sent time.Time, interval time.Duration) error
+	UsePasswordReset(ctx context.Context, tokenHash string) (model.PasswordReset, error)
+}
+
+type OAuthRepository interface {
+	Transactor
+	InsertOAuthClient(ctx context.Context, client model.OAuthClient) (model.OAuthClient, error)
+	GetOAuthClient(ctx context.Context, id string) (model.OAuthClient, error)
+	GetOAuthClients(ctx context.Context) ([]model.OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, id string) error
+	InsertOAuthCode(ctx context.Context, code model.OAuthCode) (model.OAuthCode, error)
+	UseOAuthCode(ctx context.Context, codeHash string) (model.OAuthCode, error)
+	SaveOAuthGrant(ctx context.Context, grant model.OAuthGrant) (model.OAuthGrant, error)
+	GetOAuthGrant(ctx context.Context, userID string, clientID string) (model.OAuthGrant, error)
+	GetUserOAuthGrants(ctx context.Context, userID string) ([]model.OAuthGrant, error)
+	DeleteOAuthGrant(ctx context.Context, userID string, clientID string) error
+	DeleteUserOAuthGrants(ctx context.Context, userID string) (int, error)
+}
+
+type SecurityRepository interface {
+	GetLoginFailures(ctx context.Context, keys ...string) ([]model.LoginFailure, error)
+	RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (model.LoginFailure, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, key string) error
+	InsertSecurityEvent(ctx context.Context, event model.SecurityEvent) (model.SecurityEvent, error)
+}
+
+// Postgres implements the repositories on top of a database opened with
+// Connect.
+type Postgres struct {
+	db    *sql.DB
+	reads *readRouter
+}
+
+var (
+	_ UserRepository                = (*Postgres)(nil)
+	_ RoleRepository                = (*Postgres)(nil)
+	_ WorkoutRepository             = (*Postgres)(nil)
+	_ SessionRepository             = (*Postgres)(nil)
+	_ IdentityRepository            = (*Postgres)(nil)
+	_ RefreshTokenRepository        = (*Postgres)(nil)
+	_ SigningKeyRepository          = (*Postgres)(nil)
+	_ PersonalAccessTokenRepository = (*Postgres)(nil)
+	_ MFARepository                 = (*Postgres)(nil)
+	_ PasswordResetRepository       = (*Postgres)(nil)
+	_ OAuthRepository               = (*Postgres)(nil)
+	_ SecurityRepository            = (*Postgres)(nil)
+	_ Purger                        = (*Postgres)(nil)
+)
+
+func NewPostgres(d Dao) *Postgres {
+	return &Postgres{db: d.DB, reads: newReadRouter(d.DB, d.Replicas)}
+}
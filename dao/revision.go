@@ -0,0 +1,132 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/slham/sandbox-api/model"
+)
+
+var ErrRevisionNotFound = errors.New("revision does not exist")
+
+// InsertWorkoutRevision records rev as the workout's next revision. The
+// revision number is assigned here and returned.
+func (p *Postgres) InsertWorkoutRevision(ctx context.Context, rev model.WorkoutRevision) (model.WorkoutRevision, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.workout_revision(
+			workout_id,
+			revision,
+			author,
+			name,
+			exercises
+		)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4
+		FROM sandbox.workout_revision
+		WHERE workout_id = $1
+		RETURNING revision, created`,
+		rev.WorkoutID,
+		rev.Author,
+		rev.Name,
+		rev.Exercises,
+	).Scan(&rev.Revision, &rev.Created)
+	if err != nil {
+		return rev, fmt.Errorf("failed to insert workout revision. %w", err)
+	}
+
+	return rev, nil
+}
+
+// GetWorkoutRevisions returns the workout's revisions, newest first.
+func (p *Postgres) GetWorkoutRevisions(ctx context.Context, workoutID string) ([]model.WorkoutRevision, error) {
+	revs := []model.WorkoutRevision{}
+	rows, err := p.readConn(ctx).QueryContext(ctx,
+		`SELECT workout_id, revision, author, name, exercises, created
+		FROM sandbox.workout_revision
+		WHERE workout_id = $1
+		ORDER BY revision DESC`,
+		workoutID)
+	if err != nil {
+		return revs, fmt.Errorf("failed to query workout revisions. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rev model.WorkoutRevision
+		if err := rows.Scan(&rev.WorkoutID, &rev.Revision, &rev.Author, &rev.Name, &rev.Exercises, &rev.Created); err != nil {
+			return revs, fmt.Errorf("failed to scan. %w", err)
+		}
+		revs = append(revs, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return revs, fmt.Errorf("failed to query workout revisions. rows. %w", err)
+	}
+
+	return revs, nil
+}
+
+func (p *Postgres) GetWorkoutRevision(ctx context.Context, workoutID string, revision int) (model.WorkoutRevision, error) {
+	rev := model.WorkoutRevision{}
+	err := p.readConn(ctx).QueryRowContext(ctx,
+		`SELECT workout_id, revision, author, name, exercises, created
+		FROM sandbox.workout_revision
+		WHERE workout_id = $1 AND revision = $2`,
+		workoutID, revision,
+	).Scan(&rev.WorkoutID, &rev.Revision, &rev.Author, &rev.Name, &rev.Exercises, &rev.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rev, ErrRevisionNotFound
+	}
+	if err != nil {
+		return rev, fmt.Errorf("failed to get workout revision. %w", err)
+	}
+
+	return rev, nil
+}
+
+func (m *Memory) InsertWorkoutRevision(ctx context.Context, rev model.WorkoutRevision) (model.WorkoutRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.workouts[rev.WorkoutID]; !ok {
+		return rev, fmt.Errorf("failed to insert workout revision. %w", ErrWorkoutNotFound)
+	}
+
+	rev.Revision = len(m.revisions[rev.WorkoutID]) + 1
+	rev.Created = time.Now().UTC()
+	m.revisions[rev.WorkoutID] = append(m.revisions[rev.WorkoutID], cloneRevision(rev))
+
+	return rev, nil
+}
+
+func (m *Memory) GetWorkoutRevisions(ctx context.Context, workoutID string) ([]model.WorkoutRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revs := make([]model.WorkoutRevision, 0, len(m.revisions[workoutID]))
+	for i := len(m.revisions[workoutID]) - 1; i >= 0; i-- {
+		revs = append(revs, cloneRevision(m.revisions[workoutID][i]))
+	}
+
+	return revs, nil
+}
+
+func (m *Memory) GetWorkoutRevision(ctx context.Context, workoutID string, revision int) (model.WorkoutRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revs := m.revisions[workoutID]
+	if revision < 1 || revision > len(revs) {
+		return model.WorkoutRevision{}, ErrRevisionNotFound
+	}
+
+	return cloneRevision(revs[revision-1]), nil
+}
+
+func cloneRevision(rev model.WorkoutRevision) model.WorkoutRevision {
+	rev.Exercises = cloneWorkout(model.Workout{Exercises: rev.Exercises}).Exercises
+	return rev
+}
@@ -2,20 +2,31 @@ package dao
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/lib/pq"
 	"github.com/slham/sandbox-api/model"
 )
 
-func InsertRole(ctx context.Context, role model.Role) (model.Role, error) {
+func (p *Postgres) InsertRole(ctx context.Context, role model.Role) (model.Role, error) {
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
 	stmt := `
 		INSERT INTO sandbox.role
-			(name)
+			(name, permissions, is_default)
 		VALUES
-			($1)
+			($1, $2, $3)
 		RETURNING id, created, updated`
-	err := getDB().QueryRow(stmt, role.Name).Scan(&role.ID, &role.Created, &role.Updated)
+	err := p.conn(ctx).QueryRowContext(ctx, stmt, role.Name, pq.Array(role.Permissions), role.IsDefault).Scan(&role.ID, &role.Created, &role.Updated)
 	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return role, ErrConflictRoleName
+		}
 		return role, fmt.Errorf("failed to insert role. %w", err)
 	}
 
@@ -24,14 +35,55 @@ func InsertRole(ctx context.Context, role model.Role) (model.Role, error) {
 
 type RoleQuery struct {
 	ID     int
+	IDs    []int
 	Name   string
+	Names  []string
 	UserID string
+	// Default limits the roles to those given to new users.
+	Default bool
 	Query
 }
 
-func GetRoleByID(ctx context.Context, id int) (model.Role, error) {
+var roleSortColumns = sortColumns{
+	"id":      "id",
+	"name":    "name",
+	"created": "created",
+	"updated": "updated",
+}
+
+func (q RoleQuery) build() (string, []any, error) {
+	b := newSelect("sandbox.role", "id", "name", "permissions", "is_default", "created", "updated")
+
+	if q.ID != 0 {
+		b.Where(eq("id", q.ID))
+	}
+	if q.IDs != nil {
+		b.Where(in("id", q.IDs...))
+	}
+	if q.Name != "" {
+		b.Where(eq("name", q.Name))
+	}
+	if q.Names != nil {
+		b.Where(in("name", q.Names...))
+	}
+	if q.UserID != "" {
+		b.Where(raw("id IN (SELECT role_id FROM sandbox.user_role WHERE user_id = ?)", q.UserID))
+	}
+	if q.Default {
+		b.Where(eq("is_default", true))
+	}
+
+	if err := q.Query.apply(b, roleSortColumns); err != nil {
+		return "", nil, err
+	}
+
+	stmt, args := b.Build()
+	return stmt, args, nil
+}
+
+func (p *Postgres) GetRoleByID(ctx context.Context, id int) (model.Role, error) {
 	q := RoleQuery{ID: id}
-	role, err := GetRole(ctx, q)
+	role, err := p.GetRole(ctx, q)
 	if err != nil {
 		return model.Role{}, fmt.Errorf("failed to get role by id. %w", err)
 	}
@@ -39,9 +91,9 @@ func GetRoleByID(ctx context.Context, id int) (model.Role, error) {
 	return role, nil
 }
 
-func GetRoleByName(ctx context.Context, name string) (model.Role, error) {
+func (p *Postgres) GetRoleByName(ctx context.Context, name string) (model.Role, error) {
 	q := RoleQuery{Name: name}
-	role, err := GetRole(ctx, q)
+	role, err := p.GetRole(ctx, q)
 	if err != nil {
 		return model.Role{}, fmt.Errorf("failed to get role by name. %w", err)
 	}
@@ -49,10 +101,10 @@ func GetRoleByName(ctx context.Context, name string) (model.Role, error) {
 	return role, nil
 }
 
-func GetUserRoles(ctx context.Context, userID string) ([]model.Role, error) {
+func (p *Postgres) GetUserRoles(ctx context.Context, userID string) ([]model.Role, error) {
 	stmt := `
 		SELECT 
-			r.id, r.name, r.created, r.updated
+			r.id, r.name, r.permissions, r.is_default, r.created, r.updated
 		FROM
 			sandbox.role r
 		INNER JOIN
@@ -67,7 +119,7 @@ func GetUserRoles(ctx context.Context, userID string) ([]model.Role, error) {
 			u.id = $1`
 
 	roles := []model.Role{}
-	rows, err := getDB().QueryContext(ctx, stmt, userID)
+	rows, err := p.readConn(ctx).QueryContext(ctx, stmt, userID)
 	if err != nil {
 		return roles, fmt.Errorf("failed to query roles. %w", err)
 	}
@@ -80,7 +132,7 @@ func GetUserRoles(ctx context.Context, userID string) ([]model.Role, error) {
 
 	for rows.Next() {
 		var role model.Role
-		if err := rows.Scan(&role.ID, &role.Name, &role.Created, &role.Updated); err != nil {
+		if err := rows.Scan(&role.ID, &role.Name, pq.Array(&role.Permissions), &role.IsDefault, &role.Created, &role.Updated); err != nil {
 			return roles, fmt.Errorf("failed to scan.  %w", err)
 		}
 
@@ -91,8 +143,8 @@ func GetUserRoles(ctx context.Context, userID string) ([]model.Role, error) {
 
 }
 
-func GetRole(ctx context.Context, q RoleQuery) (model.Role, error) {
-	roles, err := GetRoles(ctx, q)
+func (p *Postgres) GetRole(ctx context.Context, q RoleQuery) (model.Role, error) {
+	roles, err := p.GetRoles(ctx, q)
 	if err != nil {
 		return model.Role{}, fmt.Errorf("failed to get role. %w", err)
 	}
@@ -104,51 +156,69 @@ func GetRole(ctx context.Context, q RoleQuery) (model.Role, error) {
 	return roles[0], nil
 }
 
-func GetRoles(ctx context.Context, q RoleQuery) ([]model.Role, error) {
-	stmt := `
-		SELECT
-			id, name, created, updated
-		FROM
-			sandbox.role`
-
-	if q.ID != 0 || q.Name != "" || q.UserID != "" {
-		stmt = fmt.Sprintf("%s %s", stmt, "WHERE")
-		if q.ID != 0 {
-			stmt = fmt.Sprintf("%s %s='%d'", stmt, "id", q.ID)
-		}
-		if q.Name != "" {
-			stmt = fmt.Sprintf("%s %s='%s'", stmt, "name", q.Name)
-		}
+func (p *Postgres) GetRoles(ctx context.Context, q RoleQuery) ([]model.Role, error) {
+	roles := []model.Role{}
+	stmt, args, err := q.build()
+	if err != nil {
+		return roles, fmt.Errorf("failed to build roles query. %w", err)
 	}
 
-	stmt = addDefaultQuery(stmt, q.Query)
-
-	roles := []model.Role{}
-	rows, err := getDB().QueryContext(ctx, stmt)
+	rows, err := p.readConn(ctx).QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return roles, fmt.Errorf("failed to query roles. %w", err)
 	}
 
 	defer rows.Close()
 
-	if rows.Err() != nil {
-		return roles, fmt.Errorf("failed to query roles. rows. %w", err)
-	}
-
 	for rows.Next() {
 		var role model.Role
-		if err := rows.Scan(&role.ID, &role.Name, &role.Created, &role.Updated); err != nil {
+		if err := rows.Scan(&role.ID, &role.Name, pq.Array(&role.Permissions), &role.IsDefault, &role.Created, &role.Updated); err != nil {
 			return roles, fmt.Errorf("failed to scan.  %w", err)
 		}
 
 		roles = append(roles, role)
 	}
 
+	if err := rows.Err(); err != nil {
+		return roles, fmt.Errorf("failed to query roles. rows. %w", err)
+	}
+
 	return roles, nil
 }
 
-func DeleteRole(ctx context.Context, id string) error {
-	_, err := getDB().ExecContext(ctx,
+// UpdateRole replaces the role's name, permissions and whether it is a
+// default role.
+func (p *Postgres) UpdateRole(ctx context.Context, role model.Role) (model.Role, error) {
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`UPDATE sandbox.role
+		SET name = $2, permissions = $3, is_default = $4
+		WHERE id = $1
+		RETURNING created, updated`,
+		role.ID,
+		role.Name,
+		pq.Array(role.Permissions),
+		role.IsDefault,
+	).Scan(&role.Created, &role.Updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return role, ErrRoleNotFound
+	}
+	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return role, ErrConflictRoleName
+		}
+		return role, fmt.Errorf("failed to update role. %w", err)
+	}
+
+	return role, nil
+}
+
+// DeleteRole deletes the role, taking it away from everyone who has it.
+func (p *Postgres) DeleteRole(ctx context.Context, id int) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
 		`DELETE FROM sandbox.role 
 		WHERE id = $1`,
 		id)
@@ -156,5 +226,85 @@ func DeleteRole(ctx context.Context, id string) error {
 		return fmt.Errorf("failed to delete role. %w", err)
 	}
 
+	return expectRow(res, ErrRoleNotFound)
+}
+
+// AddUserRole gives the live user the role. Giving a role they already have
+// is not an error.
+func (p *Postgres) AddUserRole(ctx context.Context, userID string, roleID int) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`INSERT INTO sandbox.user_role (user_id, role_id)
+		SELECT u.id, r.id
+		FROM sandbox.user u, sandbox.role r
+		WHERE u.id = $1 AND u.deleted IS NULL AND r.id = $2
+		ON CONFLICT DO NOTHING`,
+		userID,
+		roleID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add user role. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		return p.checkUserRole(ctx, userID, roleID)
+	}
+
+	return nil
+}
+
+// RemoveUserRole takes the role away from the user. Taking away a role they
+// do not have is not an error.
+func (p *Postgres) RemoveUserRole(ctx context.Context, userID string, roleID int) error {
+	_, err := p.conn(ctx).ExecContext(ctx,
+		`DELETE FROM sandbox.user_role
+		WHERE user_id = $1 AND role_id = $2`,
+		userID,
+		roleID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove user role. %w", err)
+	}
+
+	return p.checkUserRole(ctx, userID, roleID)
+}
+
+// checkUserRole says which of the user and role does not exist, if either.
+func (p *Postgres) checkUserRole(ctx context.Context, userID string, roleID int) error {
+	if _, err := p.GetAccountState(ctx, userID); err != nil {
+		return err
+	}
+	if _, err := p.GetRole(ctx, RoleQuery{ID: roleID}); err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// GetUserPermissions returns every permission the live user's roles grant,
+// read from the primary so a change is seen straight away.
+func (p *Postgres) GetUserPermissions(ctx context.Context, userID string) ([]string, error) {
+	var permissions []byte
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT `+permissionsColumn("u.id")+`
+		FROM sandbox.user u
+		WHERE u.id = $1 AND u.deleted IS NULL`,
+		userID,
+	).Scan(&permissions)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user permissions. %w", err)
+	}
+
+	perms := []string{}
+	if err := json.Unmarshal(permissions, &perms); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user permissions. %w", err)
+	}
+
+	return perms, nil
+}
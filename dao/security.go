@@ -0,0 +1,181 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slham/sandbox-api/model"
+)
+
+// GetLoginFailures returns the failure counters of the keys that have one.
+func (p *Postgres) GetLoginFailures(ctx context.Context, keys ...string) ([]model.LoginFailure, error) {
+	failures := []model.LoginFailure{}
+	rows, err := p.primaryConn(ctx).QueryContext(ctx,
+		`SELECT key, failures, last_failure, locked_until
+		FROM sandbox.login_failure
+		WHERE key = ANY($1)`,
+		pq.Array(keys),
+	)
+	if err != nil {
+		return failures, fmt.Errorf("failed to query login failures. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var f model.LoginFailure
+		if err := rows.Scan(&f.Key, &f.Failures, &f.LastFailure, &f.LockedUntil); err != nil {
+			return failures, fmt.Errorf("failed to scan. %w", err)
+		}
+		failures = append(failures, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return failures, fmt.Errorf("failed to iterate login failures. %w", err)
+	}
+
+	return failures, nil
+}
+
+// RecordLoginFailure counts a failed login against the key at the time given
+// and returns the counter. Failures older than window are forgotten first.
+func (p *Postgres) RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (model.LoginFailure, error) {
+	f := model.LoginFailure{}
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.login_failure(key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failure.last_failure <= $3 THEN 1 ELSE login_failure.failures + 1 END,
+			last_failure = $2
+		RETURNING key, failures, last_failure, locked_until`,
+		key,
+		at,
+		at.Add(-window),
+	).Scan(&f.Key, &f.Failures, &f.LastFailure, &f.LockedUntil)
+	if err != nil {
+		return f, fmt.Errorf("failed to record login failure. %w", err)
+	}
+
+	return f, nil
+}
+
+// LockLogin refuses logins against the key until the time given.
+func (p *Postgres) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.login_failure
+		SET locked_until = $2
+		WHERE key = $1`,
+		key,
+		until,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to lock login. %w", err)
+	}
+
+	return nil
+}
+
+// ClearLoginFailures forgets the key's failures and lifts any lock on it.
+func (p *Postgres) ClearLoginFailures(ctx context.Context, key string) error {
+	_, err := p.conn(ctx).ExecContext(ctx,
+		`DELETE FROM sandbox.login_failure WHERE key = $1`,
+		key,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to clear login failures. %w", err)
+	}
+
+	return nil
+}
+
+func (p *Postgres) InsertSecurityEvent(ctx context.Context, event model.SecurityEvent) (model.SecurityEvent, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.security_event(
+			id,
+			kind,
+			user_id,
+			subject,
+			ip,
+			actor_id
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created`,
+		event.ID,
+		event.Kind,
+		sql.NullString{String: event.UserID, Valid: event.UserID != ""},
+		event.Subject,
+		event.IP,
+		event.ActorID,
+	).Scan(&event.Created)
+	if err != nil {
+		return event, fmt.Errorf("failed to insert security event. %w", err)
+	}
+
+	return event, nil
+}
+
+func (m *Memory) GetLoginFailures(ctx context.Context, keys ...string) ([]model.LoginFailure, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	failures := []model.LoginFailure{}
+	for _, key := range keys {
+		if f, ok := m.loginFailures[key]; ok {
+			failures = append(failures, f)
+		}
+	}
+
+	return failures, nil
+}
+
+func (m *Memory) RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (model.LoginFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.loginFailures[key]
+	if !ok || !f.LastFailure.After(at.Add(-window)) {
+		f.Key = key
+		f.Failures = 0
+	}
+	f.Failures++
+	f.LastFailure = at.UTC()
+	m.loginFailures[key] = f
+
+	return f, nil
+}
+
+func (m *Memory) LockLogin(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.loginFailures[key]
+	if !ok {
+		return nil
+	}
+	until = until.UTC()
+	f.LockedUntil = &until
+	m.loginFailures[key] = f
+
+	return nil
+}
+
+func (m *Memory) ClearLoginFailures(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginFailures, key)
+
+	return nil
+}
+
+func (m *Memory) InsertSecurityEvent(ctx context.Context, event model.SecurityEvent) (model.SecurityEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.Created = time.Now().UTC()
+	m.securityEvents[event.ID] = event
+
+	return event, nil
+}
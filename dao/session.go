@@ -0,0 +1,367 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/slham/sandbox-api/model"
+)
+
+var ErrSessionNotFound = errors.New("session does not exist")
+
+// sessionRoleNamesColumn aggregates the session user's role names so a
+// session and the roles it grants are loaded in one query.
+const sessionRoleNamesColumn = `COALESCE((
+		SELECT json_agg(r.name ORDER BY r.id)
+		FROM sandbox.role r
+		JOIN sandbox.user_role ur ON ur.role_id = r.id
+		WHERE ur.user_id = s.user_id
+	), '[]')`
+
+// sessionPermissionsColumn aggregates the permissions the session user's
+// roles grant, so changes to roles reach live sessions.
+var sessionPermissionsColumn = permissionsColumn("s.user_id")
+
+// permissionsColumn aggregates the permissions granted to the user whose id is
+// in userIDColumn.
+func permissionsColumn(userIDColumn string) string {
+	return `COALESCE((
+		SELECT json_agg(DISTINCT p.permission ORDER BY p.permission)
+		FROM sandbox.role r
+		JOIN sandbox.user_role ur ON ur.role_id = r.id
+		CROSS JOIN LATERAL unnest(r.permissions) AS p(permission)
+		WHERE ur.user_id = ` + userIDColumn + `
+	), '[]')`
+}
+
+// accountStateColumns loads the account state of the user joined in as u.
+const accountStateColumns = `u.is_active, u.is_suspended, u.suspended_reason, u.suspended_until`
+
+// impersonatorColumns load the permissions and account state of the admin
+// joined in as i, if the session is an impersonation.
+var impersonatorColumns = permissionsColumn("s.impersonator_id") + `, COALESCE(i.is_active, false), COALESCE(i.is_suspended, false), COALESCE(i.suspended_reason, ''), i.suspended_until`
+
+func (p *Postgres) InsertSession(ctx context.Context, session model.Session) (model.Session, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.session(
+			id,
+			token_hash,
+			user_id,
+			user_agent,
+			ip,
+			expires,
+			impersonator_id
+		) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+		RETURNING created, last_seen`,
+		session.ID,
+		session.TokenHash,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.Expires,
+		session.ImpersonatorID,
+	).Scan(&session.Created, &session.LastSeen)
+	if err != nil {
+		return session, fmt.Errorf("failed to insert session. %w", err)
+	}
+
+	return session, nil
+}
+
+// GetSessionByToken returns the live session with the token hash along with
+// its user's role names, and for an impersonation the admin's permissions and
+// account state. Sessions that are revoked, expired or belong to a deleted
+// user, or to one impersonated by a deleted admin, are not found.
+func (p *Postgres) GetSessionByToken(ctx context.Context, tokenHash string) (model.Session, error) {
+	session := model.Session{}
+	var roles, permissions, impersonatorPermissions []byte
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT s.id, s.token_hash, s.user_id, COALESCE(s.impersonator_id, ''), s.user_agent, s.ip, s.created, s.last_seen, s.expires, `+sessionRoleNamesColumn+`, `+sessionPermissionsColumn+`, `+accountStateColumns+`, `+impersonatorColumns+`
+		FROM sandbox.session s
+		JOIN sandbox.user u ON u.id = s.user_id
+		LEFT JOIN sandbox.user i ON i.id = s.impersonator_id
+		WHERE s.token_hash = $1 AND s.revoked IS NULL AND s.expires > now() AND u.deleted IS NULL
+		AND (s.impersonator_id IS NULL OR i.deleted IS NULL)`,
+		tokenHash,
+	).Scan(&session.ID, &session.TokenHash, &session.UserID, &session.ImpersonatorID, &session.UserAgent, &session.IP, &session.Created, &session.LastSeen, &session.Expires, &roles, &permissions, &session.Account.IsActive, &session.Account.IsSuspended, &session.Account.SuspendedReason, &session.Account.SuspendedUntil, &impersonatorPermissions, &session.ImpersonatorAccount.IsActive, &session.ImpersonatorAccount.IsSuspended, &session.ImpersonatorAccount.SuspendedReason, &session.ImpersonatorAccount.SuspendedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, ErrSessionNotFound
+	}
+	if err != nil {
+		return session, fmt.Errorf("failed to get session. %w", err)
+	}
+
+	if err := json.Unmarshal(roles, &session.Roles); err != nil {
+		return session, fmt.Errorf("failed to unmarshal session roles. %w", err)
+	}
+	if err := json.Unmarshal(permissions, &session.Permissions); err != nil {
+		return session, fmt.Errorf("failed to unmarshal session permissions. %w", err)
+	}
+	if session.ImpersonatorID != "" {
+		if err := json.Unmarshal(impersonatorPermissions, &session.ImpersonatorPermissions); err != nil {
+			return session, fmt.Errorf("failed to unmarshal impersonator permissions. %w", err)
+		}
+	}
+
+	return session, nil
+}
+
+// GetUserSessions returns the user's live sessions, most recently used first.
+func (p *Postgres) GetUserSessions(ctx context.Context, userID string) ([]model.Session, error) {
+	sessions := []model.Session{}
+	rows, err := p.readConn(ctx).QueryContext(ctx,
+		`SELECT id, user_id, COALESCE(impersonator_id, ''), user_agent, ip, created, last_seen, expires
+		FROM sandbox.session
+		WHERE user_id = $1 AND revoked IS NULL AND expires > now()
+		ORDER BY last_seen DESC, id DESC`,
+		userID)
+	if err != nil {
+		return sessions, fmt.Errorf("failed to query sessions. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var s model.Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.ImpersonatorID, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen, &s.Expires); err != nil {
+			return sessions, fmt.Errorf("failed to scan. %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return sessions, fmt.Errorf("failed to iterate sessions. %w", err)
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that the session was used and pushes its expiry out
+// to expires.
+func (p *Postgres) TouchSession(ctx context.Context, id string, expires time.Time) error {
+	_, err := p.primaryConn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.session
+		SET last_seen = now(), expires = $2
+		WHERE id = $1 AND revoked IS NULL`,
+		id,
+		expires,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to touch session. %w", err)
+	}
+
+	return nil
+}
+
+// RevokeSession ends one of the user's sessions.
+func (p *Postgres) RevokeSession(ctx context.Context, userID string, id string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.session
+		SET revoked = now()
+		WHERE id = $1 AND user_id = $2 AND revoked IS NULL`,
+		id,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeUserSessions ends every live session of the user, and every
+// impersonation they are running, and returns how many there were.
+func (p *Postgres) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.session
+		SET revoked = now()
+		WHERE (user_id = $1 OR impersonator_id = $1) AND revoked IS NULL`,
+		userID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected. %w", err)
+	}
+
+	return int(n), nil
+}
+
+// EndExpiredImpersonation revokes the impersonation session with the token
+// hash if it has expired without being revoked, and returns it, so its end can
+// be recorded once. Any other session is not found.
+func (p *Postgres) EndExpiredImpersonation(ctx context.Context, tokenHash string) (model.Session, error) {
+	session := model.Session{}
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`UPDATE sandbox.session
+		SET revoked = now()
+		WHERE token_hash = $1 AND impersonator_id IS NOT NULL AND revoked IS NULL AND expires <= now()
+		RETURNING id, user_id, impersonator_id, user_agent, ip, created, last_seen, expires, revoked`,
+		tokenHash,
+	).Scan(&session.ID, &session.UserID, &session.ImpersonatorID, &session.UserAgent, &session.IP, &session.Created, &session.LastSeen, &session.Expires, &session.Revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, ErrSessionNotFound
+	}
+	if err != nil {
+		return session, fmt.Errorf("failed to end expired impersonation. %w", err)
+	}
+
+	return session, nil
+}
+
+func (m *Memory) InsertSession(ctx context.Context, session model.Session) (model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[session.UserID]; !ok {
+		return session, fmt.Errorf("failed to insert session. %w", ErrUserNotFound)
+	}
+	for _, s := range m.sessions {
+		if s.TokenHash == session.TokenHash {
+			return session, fmt.Errorf("failed to insert session. duplicate token")
+		}
+	}
+
+	now := time.Now().UTC()
+	session.Created = now
+	session.LastSeen = now
+	session.Roles = nil
+	m.sessions[session.ID] = session
+
+	return session, nil
+}
+
+func (m *Memory) GetSessionByToken(ctx context.Context, tokenHash string) (model.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, s := range m.sessions {
+		if s.TokenHash != tokenHash || s.Revoked != nil || !s.Expires.After(now) {
+			continue
+		}
+		u, ok := m.users[s.UserID]
+		if !ok || u.Deleted != nil {
+			break
+		}
+
+		s.Account = u.AccountState
+		s.Roles = []string{}
+		for _, role := range m.userRolesLocked(s.UserID) {
+			s.Roles = append(s.Roles, role.Name)
+		}
+		s.Permissions = m.userPermissionsLocked(s.UserID)
+		if s.ImpersonatorID != "" {
+			i, ok := m.users[s.ImpersonatorID]
+			if !ok || i.Deleted != nil {
+				break
+			}
+			s.ImpersonatorAccount = i.AccountState
+			s.ImpersonatorPermissions = m.userPermissionsLocked(s.ImpersonatorID)
+		}
+		return s, nil
+	}
+
+	return model.Session{}, ErrSessionNotFound
+}
+
+func (m *Memory) GetUserSessions(ctx context.Context, userID string) ([]model.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	sessions := []model.Session{}
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.Revoked == nil && s.Expires.After(now) {
+			sessions = append(sessions, s)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b model.Session) int {
+		if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+			return c
+		}
+		return compareValues(b.ID, a.ID)
+	})
+
+	return sessions, nil
+}
+
+func (m *Memory) TouchSession(ctx context.Context, id string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.Revoked != nil {
+		return nil
+	}
+	s.LastSeen = time.Now().UTC()
+	s.Expires = expires
+	m.sessions[id] = s
+
+	return nil
+}
+
+func (m *Memory) RevokeSession(ctx context.Context, userID string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID || s.Revoked != nil {
+		return ErrSessionNotFound
+	}
+	now := time.Now().UTC()
+	s.Revoked = &now
+	m.sessions[id] = s
+
+	return nil
+}
+
+func (m *Memory) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	now := time.Now().UTC()
+	for id, s := range m.sessions {
+		if (s.UserID == userID || s.ImpersonatorID == userID) && s.Revoked == nil {
+			s.Revoked = &now
+			m.sessions[id] = s
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (m *Memory) EndExpiredImpersonation(ctx context.Context, tokenHash string) (model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for id, s := range m.sessions {
+		if s.TokenHash != tokenHash || s.ImpersonatorID == "" || s.Revoked != nil || s.Expires.After(now) {
+			continue
+		}
+		s.Revoked = &now
+		m.sessions[id] = s
+		return s, nil
+	}
+
+	return model.Session{}, ErrSessionNotFound
+}
@@ -0,0 +1,343 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slham/sandbox-api/model"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token does not exist")
+	ErrRefreshTokenUsed     = errors.New("refresh token was already used")
+)
+
+func (p *Postgres) InsertRefreshToken(ctx context.Context, token model.RefreshToken) (model.RefreshToken, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`INSERT INTO sandbox.refresh_token(
+			id,
+			token_hash,
+			family_id,
+			user_id,
+			expires,
+			client_id,
+			scopes
+		) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+		RETURNING created`,
+		token.ID,
+		token.TokenHash,
+		token.FamilyID,
+		token.UserID,
+		token.Expires,
+		token.ClientID,
+		pq.Array(token.Scopes),
+	).Scan(&token.Created)
+	if err != nil {
+		return token, fmt.Errorf("failed to insert refresh token. %w", err)
+	}
+
+	return token, nil
+}
+
+// GetRefreshToken returns the token with the hash along with its user's role
+// names, and the client it was issued to if any. Used, revoked and expired tokens are returned too so reuse can be
+// told apart from a bad token. Tokens of deleted users are not found.
+func (p *Postgres) GetRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, error) {
+	token := model.RefreshToken{}
+	var roles []byte
+	err := p.primaryConn(ctx).QueryRowContext(ctx,
+		`SELECT s.id, s.token_hash, s.family_id, s.user_id, COALESCE(s.client_id, ''), s.scopes, s.created, s.expires, s.used, s.revoked, `+sessionRoleNamesColumn+`, `+accountStateColumns+`
+		FROM sandbox.refresh_token s
+		JOIN sandbox.user u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND u.deleted IS NULL`,
+		tokenHash,
+	).Scan(&token.ID, &token.TokenHash, &token.FamilyID, &token.UserID, &token.ClientID, pq.Array(&token.Scopes), &token.Created, &token.Expires, &token.Used, &token.Revoked, &roles, &token.Account.IsActive, &token.Account.IsSuspended, &token.Account.SuspendedReason, &token.Account.SuspendedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return token, fmt.Errorf("failed to get refresh token. %w", err)
+	}
+
+	if err := json.Unmarshal(roles, &token.Roles); err != nil {
+		return token, fmt.Errorf("failed to unmarshal refresh token roles. %w", err)
+	}
+
+	return token, nil
+}
+
+// UseRefreshToken marks the token used. Only one caller can use a token; the
+// others get ErrRefreshTokenUsed.
+func (p *Postgres) UseRefreshToken(ctx context.Context, id string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.refresh_token
+		SET used = now()
+		WHERE id = $1 AND used IS NULL AND revoked IS NULL`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use refresh token. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		return ErrRefreshTokenUsed
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token descended from the same login.
+func (p *Postgres) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int, error) {
+	return p.revokeRefreshTokens(ctx, `family_id = $1`, familyID)
+}
+
+// RevokeUserRefreshTokens revokes all of the user's refresh tokens and
+// returns how many logins were ended.
+func (p *Postgres) RevokeUserRefreshTokens(ctx context.Context, userID string) (int, error) {
+	return p.revokeRefreshTokens(ctx, `user_id = $1`, userID)
+}
+
+// RevokeClientRefreshTokens revokes the refresh tokens the user's grant to the
+// client has produced.
+func (p *Postgres) RevokeClientRefreshTokens(ctx context.Context, userID string, clientID string) (int, error) {
+	return p.revokeRefreshTokens(ctx, `user_id = $1 AND client_id = $2`, userID, clientID)
+}
+
+// revokeRefreshTokens revokes the matching tokens. Used tokens are revoked
+// too but not counted, since their login lives on in the token they were
+// swapped for.
+func (p *Postgres) revokeRefreshTokens(ctx context.Context, where string, args ...any) (int, error) {
+	n := 0
+	err := p.conn(ctx).QueryRowContext(ctx,
+		`WITH revoked AS (
+			UPDATE sandbox.refresh_token
+			SET revoked = now()
+			WHERE `+where+` AND revoked IS NULL
+			RETURNING used, expires
+		)
+		SELECT count(*) FROM revoked WHERE used IS NULL AND expires > now()`,
+		args...,
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke refresh tokens. %w", err)
+	}
+
+	return n, nil
+}
+
+// InsertSigningKey stores the key. Created is taken from the key, since
+// rotation is timed by the caller's clock.
+func (p *Postgres) InsertSigningKey(ctx context.Context, key model.SigningKey) (model.SigningKey, error) {
+	_, err := p.conn(ctx).ExecContext(ctx,
+		`INSERT INTO sandbox.signing_key(kid, alg, private_key, created)
+		VALUES ($1, $2, $3, $4)`,
+		key.Kid,
+		key.Alg,
+		key.PrivateKey,
+		key.Created,
+	)
+	if err != nil {
+		return key, fmt.Errorf("failed to insert signing key. %w", err)
+	}
+
+	return key, nil
+}
+
+// GetSigningKeys returns keys created after since, newest first.
+func (p *Postgres) GetSigningKeys(ctx context.Context, since time.Time) ([]model.SigningKey, error) {
+	keys := []model.SigningKey{}
+	rows, err := p.primaryConn(ctx).QueryContext(ctx,
+		`SELECT kid, alg, private_key, created
+		FROM sandbox.signing_key
+		WHERE created > $1
+		ORDER BY created DESC, kid DESC`,
+		since)
+	if err != nil {
+		return keys, fmt.Errorf("failed to query signing keys. %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var k model.SigningKey
+		if err := rows.Scan(&k.Kid, &k.Alg, &k.PrivateKey, &k.Created); err != nil {
+			return keys, fmt.Errorf("failed to scan. %w", err)
+		}
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return keys, fmt.Errorf("failed to iterate signing keys. %w", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteSigningKeys removes keys created before the cutoff.
+func (p *Postgres) DeleteSigningKeys(ctx context.Context, before time.Time) (int, error) {
+	res, err := p.conn(ctx).ExecContext(ctx, `DELETE FROM sandbox.signing_key WHERE created < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete signing keys. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected. %w", err)
+	}
+
+	return int(n), nil
+}
+
+func (m *Memory) InsertRefreshToken(ctx context.Context, token model.RefreshToken) (model.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[token.UserID]; !ok {
+		return token, fmt.Errorf("failed to insert refresh token. %w", ErrUserNotFound)
+	}
+	for _, t := range m.refreshTokens {
+		if t.TokenHash == token.TokenHash {
+			return token, fmt.Errorf("failed to insert refresh token. duplicate token")
+		}
+	}
+
+	if _, ok := m.oauthClients[token.ClientID]; token.ClientID != "" && !ok {
+		return token, fmt.Errorf("failed to insert refresh token. %w", ErrOAuthClientNotFound)
+	}
+
+	token.Created = time.Now().UTC()
+	token.Roles = nil
+	token.Scopes = slices.Clone(token.Scopes)
+	m.refreshTokens[token.ID] = token
+
+	return token, nil
+}
+
+func (m *Memory) GetRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.refreshTokens {
+		if t.TokenHash != tokenHash {
+			continue
+		}
+		u, ok := m.users[t.UserID]
+		if !ok || u.Deleted != nil {
+			break
+		}
+
+		t.Account = u.AccountState
+		t.Scopes = slices.Clone(t.Scopes)
+		t.Roles = []string{}
+		for _, role := range m.userRolesLocked(t.UserID) {
+			t.Roles = append(t.Roles, role.Name)
+		}
+		return t, nil
+	}
+
+	return model.RefreshToken{}, ErrRefreshTokenNotFound
+}
+
+func (m *Memory) UseRefreshToken(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.refreshTokens[id]
+	if !ok || t.Used != nil || t.Revoked != nil {
+		return ErrRefreshTokenUsed
+	}
+	now := time.Now().UTC()
+	t.Used = &now
+	m.refreshTokens[id] = t
+
+	return nil
+}
+
+func (m *Memory) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int, error) {
+	return m.revokeRefreshTokens(func(t model.RefreshToken) bool { return t.FamilyID == familyID }), nil
+}
+
+func (m *Memory) RevokeUserRefreshTokens(ctx context.Context, userID string) (int, error) {
+	return m.revokeRefreshTokens(func(t model.RefreshToken) bool { return t.UserID == userID }), nil
+}
+
+func (m *Memory) RevokeClientRefreshTokens(ctx context.Context, userID string, clientID string) (int, error) {
+	return m.revokeRefreshTokens(func(t model.RefreshToken) bool { return t.UserID == userID && t.ClientID == clientID }), nil
+}
+
+func (m *Memory) revokeRefreshTokens(match func(model.RefreshToken) bool) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	now := time.Now().UTC()
+	for id, t := range m.refreshTokens {
+		if !match(t) || t.Revoked != nil {
+			continue
+		}
+		if t.Used == nil && t.Expires.After(now) {
+			n++
+		}
+		t.Revoked = &now
+		m.refreshTokens[id] = t
+	}
+
+	return n
+}
+
+func (m *Memory) InsertSigningKey(ctx context.Context, key model.SigningKey) (model.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.signingKeys[key.Kid]; ok {
+		return key, fmt.Errorf("failed to insert signing key. duplicate kid")
+	}
+	m.signingKeys[key.Kid] = key
+
+	return key, nil
+}
+
+func (m *Memory) GetSigningKeys(ctx context.Context, since time.Time) ([]model.SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []model.SigningKey{}
+	for _, k := range m.signingKeys {
+		if k.Created.After(since) {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b model.SigningKey) int {
+		if c := b.Created.Compare(a.Created); c != 0 {
+			return c
+		}
+		return compareValues(b.Kid, a.Kid)
+	})
+
+	return keys, nil
+}
+
+func (m *Memory) DeleteSigningKeys(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for kid, k := range m.signingKeys {
+		if k.Created.Before(before) {
+			delete(m.signingKeys, kid)
+			n++
+		}
+	}
+
+	return n, nil
+}
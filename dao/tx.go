@@ -0,0 +1,141 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxTxAttempts = 4
+	txBackoffBase = 10 * time.Millisecond
+)
+
+// Transactor runs f as a single unit of work. The context handed to f carries
+// the transaction, so every repository call made with it joins the same
+// transaction regardless of which repository it is on. Calling WithTx with a
+// context that is already inside a transaction reuses it rather than nesting.
+//
+// f may be invoked more than once when the database aborts the transaction
+// with a serialization failure or deadlock, so it must not have side effects
+// outside the database.
+type Transactor interface {
+	WithTx(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txKey struct{}
+
+func txFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+// conn returns the transaction carried by ctx, or the primary when there is
+// none. It is for writes, and reads that must see them.
+func (p *Postgres) conn(ctx context.Context) querier {
+	if p.reads != nil {
+		p.reads.wrote(ctx)
+	}
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	return p.db
+}
+
+// primaryConn is conn without counting as a write for read-your-writes. It is
+// for reads that must not lag, such as session checks, and for bookkeeping
+// writes the caller will not read back.
+func (p *Postgres) primaryConn(ctx context.Context) querier {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	return p.db
+}
+
+// readConn returns the transaction carried by ctx, or a connection that may
+// be a replica when there is none.
+func (p *Postgres) readConn(ctx context.Context) querier {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	if p.reads == nil {
+		return p.db
+	}
+	return p.reads.pick(ctx)
+}
+
+func (p *Postgres) WithTx(ctx context.Context, f func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); ok {
+		return f(ctx)
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = p.runTx(ctx, f)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		slog.WarnContext(ctx, "retrying transaction", "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to retry transaction. %w", ctx.Err())
+		case <-time.After(txBackoff(attempt)):
+		}
+	}
+
+	return fmt.Errorf("failed transaction after %d attempts. %w", maxTxAttempts, err)
+}
+
+func (p *Postgres) runTx(ctx context.Context, f func(ctx context.Context) error) error {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction. %w", err)
+	}
+
+	if err := f(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			slog.ErrorContext(ctx, "failed to roll back transaction", "err", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction. %w", err)
+	}
+
+	return nil
+}
+
+// isRetryable reports whether postgres aborted the transaction because of a
+// conflict with a concurrent one, in which case running it again can succeed.
+func isRetryable(err error) bool {
+	var pgErr *pq.Error
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	switch pgErr.Code {
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return true
+	default:
+		return false
+	}
+}
+
+func txBackoff(attempt int) time.Duration {
+	backoff := txBackoffBase << attempt
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
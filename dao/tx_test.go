@@ -0,0 +1,101 @@
+//go:build unit
+// +build unit
+
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tables := []struct {
+		err      error
+		expected bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{fmt.Errorf("wrapped. %w", &pq.Error{Code: "40P01"}), true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("boom"), false},
+	}
+
+	for _, table := range tables {
+		assert.Equal(t, table.expected, isRetryable(table.err), table.err.Error())
+	}
+}
+
+func TestMemoryWithTxRollsBack(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	role, err := m.GetRoleByName(ctx, "CIVILIAN")
+	assert.NoError(t, err)
+
+	boom := errors.New("boom")
+	err = m.WithTx(ctx, func(ctx context.Context) error {
+		user := model.User{ID: "user_1", Username: "user_1", Email: "a@b.c", Roles: []model.Role{role}}
+		if _, err := m.InsertUser(ctx, user); err != nil {
+			return err
+		}
+
+		return m.WithTx(ctx, func(ctx context.Context) error {
+			return boom
+		})
+	})
+	assert.True(t, errors.Is(err, boom))
+
+	_, err = m.GetUserByID(ctx, "user_1")
+	assert.True(t, errors.Is(err, ErrUserNotFound), "insert must be rolled back")
+
+	roles, err := m.GetUserRoles(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+}
+
+func TestMemoryPurgeDeleted(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	_, err := m.InsertUser(ctx, model.User{ID: "user_1", Username: "user_1", Email: "a@b.c"})
+	assert.NoError(t, err)
+	_, err = m.InsertWorkout(ctx, model.Workout{ID: "workout_1", UserID: "user_1", Name: "Arms"})
+	assert.NoError(t, err)
+	_, err = m.InsertWorkout(ctx, model.Workout{ID: "workout_2", UserID: "user_1", Name: "Legs"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.DeleteWorkout(ctx, "user_1", "workout_1"))
+
+	// the name of a trashed workout is free again
+	_, err = m.InsertWorkout(ctx, model.Workout{ID: "workout_3", UserID: "user_1", Name: "Arms"})
+	assert.NoError(t, err)
+	_, err = m.RestoreWorkout(ctx, "user_1", "workout_1")
+	assert.ErrorIs(t, err, ErrConflictWorkoutName)
+
+	n, err := m.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n, "nothing is past retention yet")
+
+	n, err = m.PurgeDeleted(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = m.RestoreWorkout(ctx, "user_1", "workout_1")
+	assert.ErrorIs(t, err, ErrWorkoutNotFound)
+
+	assert.NoError(t, m.DeleteUser(ctx, "user_1"))
+	trashed, err := m.GetWorkouts(ctx, WorkoutQuery{UserID: "user_1", Trashed: true})
+	assert.NoError(t, err)
+	assert.Len(t, trashed, 2, "the user's workouts are trashed with them")
+
+	user, err := m.RestoreUser(ctx, "user_1")
+	assert.NoError(t, err)
+	assert.Nil(t, user.Deleted)
+	workouts, err := m.GetWorkouts(ctx, WorkoutQuery{UserID: "user_1"})
+	assert.NoError(t, err)
+	assert.Len(t, workouts, 2)
+}
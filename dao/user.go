@@ -2,9 +2,12 @@ package dao
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/slham/sandbox-api/model"
@@ -16,65 +19,87 @@ var (
 	ErrUserNotFound     = errors.New("user does not exist")
 	ErrWorkoutNotFound  = errors.New("workout does not exist")
 	ErrRoleNotFound     = errors.New("role does not exist")
+	ErrConflictRoleName = errors.New("role name already exists")
+
+	ErrVerificationRateLimited = errors.New("verification email was sent too recently")
 )
 
-func InsertUser(ctx context.Context, user model.User) (model.User, error) {
-	_, err := getDB().ExecContext(ctx,
-		`INSERT INTO sandbox.user(
-			id,
-			username,
-			password,
-			email
-		)
-		VALUES(
-			$1,
-			$2,
-			$3,
-			$4
-		)`,
-		user.ID,
-		user.Username,
-		user.Password,
-		user.Email,
-	)
-	if err != nil {
-		if pgErr, ok := err.(*pq.Error); ok {
-			if pgErr.Code == "23505" {
-				if strings.Contains(pgErr.Message, "username") {
-					return user, ErrConflictUsername
-				} else if strings.Contains(pgErr.Message, "email") {
-					return user, ErrConflictEmail
-				}
-				return user, fmt.Errorf("failed to insert user. conflict. %w", err)
+// InsertUser inserts the user and their roles in one transaction.
+func (p *Postgres) InsertUser(ctx context.Context, user model.User) (model.User, error) {
+	err := p.WithTx(ctx, func(ctx context.Context) error {
+		err := p.conn(ctx).QueryRowContext(ctx,
+			`INSERT INTO sandbox.user(
+				id,
+				username,
+				password,
+				email,
+				is_verified
+			)
+			VALUES(
+				$1,
+				$2,
+				$3,
+				$4,
+				$5
+			)
+			RETURNING created, updated, version, is_active, is_suspended, suspended_reason, suspended_until`,
+			user.ID,
+			user.Username,
+			user.Password,
+			user.Email,
+			user.IsVerified,
+		).Scan(&user.Created, &user.Updated, &user.Version, &user.IsActive, &user.IsSuspended, &user.SuspendedReason, &user.SuspendedUntil)
+		if err != nil {
+			if err := userConflict(err); err != nil {
+				return err
 			}
+			return fmt.Errorf("failed to insert user. %w", err)
 		}
-		return user, fmt.Errorf("failed to insert user. %w", err)
+
+		if err := p.insertUserRoles(ctx, user); err != nil {
+			return fmt.Errorf("faild to insert user roles. %w", err)
+		}
+
+		return nil
+	})
+
+	return user, err
+}
+
+// userConflict maps a unique violation on the user table to the matching
+// conflict error. It returns nil for any other error.
+func userConflict(err error) error {
+	var pgErr *pq.Error
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return nil
 	}
 
-	if err := insertUserRoles(ctx, user); err != nil {
-		return user, fmt.Errorf("faild to insert user roles. %w", err)
+	if strings.Contains(pgErr.Message, "username") {
+		return ErrConflictUsername
+	} else if strings.Contains(pgErr.Message, "email") {
+		return ErrConflictEmail
 	}
 
-	return user, nil
+	return fmt.Errorf("conflict. %w", err)
 }
 
-func insertUserRoles(ctx context.Context, user model.User) error {
+func (p *Postgres) insertUserRoles(ctx context.Context, user model.User) error {
 	for i := range user.Roles {
 		role := user.Roles[i]
-		if err := insertUserRole(ctx, user.ID, role.ID); err != nil {
+		if err := p.insertUserRole(ctx, user.ID, role.ID); err != nil {
 			return fmt.Errorf("failed to insert user role. %w", err)
 		}
 	}
 	return nil
 }
 
-func insertUserRole(ctx context.Context, userID string, roleID int) error {
+func (p *Postgres) insertUserRole(ctx context.Context, userID string, roleID int) error {
 	stmt := `
 		INSERT INTO sandbox.user_role
 			(user_id, role_id)
 		VALUES
 			($1, $2)`
-	_, err := getDB().ExecContext(ctx, stmt, userID, roleID)
+	_, err := p.conn(ctx).ExecContext(ctx, stmt, userID, roleID)
 	if err != nil {
 		return fmt.Errorf("failed to insert user role. %w", err)
 	}
@@ -83,42 +108,136 @@ func insertUserRole(ctx context.Context, userID string, roleID int) error {
 }
 
 type UserQuery struct {
-	ID           string
-	Username     string
-	Email        string
-	HidePassword bool
+	ID            string
+	IDs           []string
+	Username      string
+	Email         string
+	Search        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	HidePassword  bool
+	// Trashed selects deleted users instead of live ones.
+	Trashed bool
+	// WithRoles loads each user's roles in the same query. Without it Roles
+	// is left nil.
+	WithRoles bool
+	// Primary reads from the primary even when a replica is healthy. Password
+	// and account state checks use it, so a reset or suspension is seen
+	// straight away by callers with no writes of their own.
+	Primary bool
 	Query
 }
 
-func GetUserByEmail(ctx context.Context, email string) (model.User, error) {
-	q := UserQuery{Email: email}
-	u, err := GetUser(ctx, q)
+// userRolesColumn aggregates a user's roles into a json array so they are
+// loaded alongside the user rather than with a query per user.
+const userRolesColumn = `COALESCE((
+		SELECT json_agg(json_build_object(
+			'id', r.id,
+			'name', r.name,
+			'permissions', r.permissions,
+			'isDefault', r.is_default,
+			'created', r.created,
+			'updated', r.updated
+		) ORDER BY r.id)
+		FROM sandbox.role r
+		JOIN sandbox.user_role ur ON ur.role_id = r.id
+		WHERE ur.user_id = sandbox.user.id
+	), '[]') AS roles`
+
+var userSortColumns = sortColumns{
+	"id":       "id",
+	"username": "username",
+	"email":    "email",
+	"created":  "created",
+	"updated":  "updated",
+}
+
+func (q UserQuery) build() (string, []any, error) {
+	b := q.filter()
+	if err := q.Query.apply(b, userSortColumns); err != nil {
+		return "", nil, err
+	}
+
+	stmt, args := b.Build()
+	return stmt, args, nil
+}
+
+func (q UserQuery) filter() *selectBuilder {
+	b := newSelect("sandbox.user", "id", "username", "password", "email", "created", "updated", "version", "deleted", "is_active", "is_suspended", "suspended_reason", "suspended_until", "is_verified")
+	if q.WithRoles {
+		b.columns = append(b.columns, userRolesColumn)
+	}
+
+	if q.Trashed {
+		b.Where(notNull("deleted"))
+	} else {
+		b.Where(isNull("deleted"))
+	}
+	if q.ID != "" {
+		b.Where(eq("id", q.ID))
+	}
+	if q.IDs != nil {
+		b.Where(in("id", q.IDs...))
+	}
+	if q.Username != "" {
+		b.Where(eq("username", q.Username))
+	}
+	if q.Email != "" {
+		b.Where(eq("email", q.Email))
+	}
+	if q.Search != "" {
+		b.Where(or(ilike("username", q.Search), ilike("email", q.Search)))
+	}
+	b.Where(createdBetween(q.CreatedAfter, q.CreatedBefore))
+
+	return b
+}
+
+// userField returns the value of the user's sort column col.
+func userField(u model.User, col string) any {
+	switch col {
+	case "username":
+		return u.Username
+	case "email":
+		return u.Email
+	case "created":
+		return u.Created
+	case "updated":
+		return u.Updated
+	default:
+		return u.ID
+	}
+}
+
+func (p *Postgres) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	q := UserQuery{Email: email, WithRoles: true}
+	u, err := p.GetUser(ctx, q)
 	if err != nil {
 		return model.User{}, fmt.Errorf("failed to get user by email. %w", err)
 	}
 	return u, nil
 }
 
-func GetUserByUsername(ctx context.Context, username string) (model.User, error) {
-	q := UserQuery{Username: username}
-	u, err := GetUser(ctx, q)
+func (p *Postgres) GetUserByUsername(ctx context.Context, username string) (model.User, error) {
+	q := UserQuery{Username: username, WithRoles: true}
+	u, err := p.GetUser(ctx, q)
 	if err != nil {
 		return model.User{}, fmt.Errorf("failed to get user by username. %w", err)
 	}
 	return u, nil
 }
 
-func GetUserByID(ctx context.Context, id string) (model.User, error) {
+func (p *Postgres) GetUserByID(ctx context.Context, id string) (model.User, error) {
 	q := UserQuery{ID: id, HidePassword: true}
-	u, err := GetUser(ctx, q)
+	u, err := p.GetUser(ctx, q)
 	if err != nil {
 		return model.User{}, fmt.Errorf("failed to get user by id. %w", err)
 	}
 	return u, nil
 }
 
-func GetUser(ctx context.Context, q UserQuery) (model.User, error) {
-	users, err := GetUsers(ctx, q)
+func (p *Postgres) GetUser(ctx context.Context, q UserQuery) (model.User, error) {
+	users, err := p.GetUsers(ctx, q)
 	if err != nil {
 		return model.User{}, fmt.Errorf("failed to get user. %w", err)
 	}
@@ -130,96 +249,217 @@ func GetUser(ctx context.Context, q UserQuery) (model.User, error) {
 	return users[0], nil
 }
 
-func GetUsers(ctx context.Context, q UserQuery) ([]model.User, error) {
-	stmt := `
-		SELECT
-			id, username, password, email, created, updated
-		FROM
-			sandbox.user`
+func (p *Postgres) GetUsers(ctx context.Context, q UserQuery) ([]model.User, error) {
+	stmt, args, err := q.build()
+	if err != nil {
+		return []model.User{}, fmt.Errorf("failed to build users query. %w", err)
+	}
 
-	if q.ID != "" || q.Username != "" || q.Email != "" {
-		stmt = fmt.Sprintf("%s %s", stmt, "WHERE")
-		if q.ID != "" {
-			stmt = fmt.Sprintf("%s %s='%s'", stmt, "id", q.ID)
-		}
-		if q.Username != "" {
-			stmt = checkWhereClause(stmt)
-			stmt = fmt.Sprintf("%s %s='%s'", stmt, "username", q.Username)
-		}
-		if q.Email != "" {
-			stmt = checkWhereClause(stmt)
-			stmt = fmt.Sprintf("%s %s='%s'", stmt, "email", q.Email)
+	return p.queryUsers(ctx, stmt, args, q)
+}
+
+// ListUsers returns one keyset page of the users matching q.
+func (p *Postgres) ListUsers(ctx context.Context, q UserQuery) (Page[model.User], error) {
+	b := q.filter()
+
+	var total *int
+	if q.WithTotal {
+		n, err := p.count(ctx, b)
+		if err != nil {
+			return Page[model.User]{}, fmt.Errorf("failed to count users. %w", err)
 		}
+		total = &n
+	}
+
+	if err := q.Query.applyKeyset(b, userSortColumns); err != nil {
+		return Page[model.User]{}, fmt.Errorf("failed to build users query. %w", err)
+	}
+
+	stmt, args := b.Build()
+	users, err := p.queryUsers(ctx, stmt, args, q)
+	if err != nil {
+		return Page[model.User]{}, err
 	}
 
-	stmt = addDefaultQuery(stmt, q.Query)
+	page := pageOf(users, q.Query, userSortColumns, userField)
+	page.Total = total
+
+	return page, nil
+}
 
+func (p *Postgres) queryUsers(ctx context.Context, stmt string, args []any, q UserQuery) ([]model.User, error) {
 	users := []model.User{}
-	rows, err := getDB().QueryContext(ctx, stmt)
+	db := p.readConn(ctx)
+	if q.Primary {
+		db = p.primaryConn(ctx)
+	}
+	rows, err := db.QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return users, fmt.Errorf("failed to query users. %w", err)
 	}
 
 	defer rows.Close()
 
-	if rows.Err() != nil {
-		return users, fmt.Errorf("failed to query users. rows. %w", err)
-	}
-
 	for rows.Next() {
 		var user model.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.Email, &user.Created, &user.Updated); err != nil {
+		dest := []any{&user.ID, &user.Username, &user.Password, &user.Email, &user.Created, &user.Updated, &user.Version, &user.Deleted, &user.IsActive, &user.IsSuspended, &user.SuspendedReason, &user.SuspendedUntil, &user.IsVerified}
+		var roles []byte
+		if q.WithRoles {
+			dest = append(dest, &roles)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return users, fmt.Errorf("failed to scan.  %w", err)
 		}
 
+		if q.WithRoles {
+			if err := json.Unmarshal(roles, &user.Roles); err != nil {
+				return users, fmt.Errorf("failed to unmarshal user (%s) roles. %w", user.ID, err)
+			}
+		}
+
 		if q.HidePassword {
 			user.Password = ""
 		}
-		roles, err := GetUserRoles(ctx, user.ID)
-		if err != nil {
-			return users, fmt.Errorf("failed to get user (%s) roles. %w", user.ID, err)
-		}
-		user.Roles = roles
 		users = append(users, user)
 	}
 
+	if err := rows.Err(); err != nil {
+		return users, fmt.Errorf("failed to query users. rows. %w", err)
+	}
+
 	return users, nil
 }
 
-func UpdateUser(ctx context.Context, user model.User) error {
-	_, err := getDB().ExecContext(ctx,
-		`UPDATE sandbox.user 
-		SET username = $1, email = $2
-		WHERE id = $3`,
-		user.Username,
-		user.Email,
-		user.ID,
-	)
-	if err != nil {
-		if pgErr, ok := err.(*pq.Error); ok {
-			if pgErr.Code == "23505" {
-				if strings.Contains(pgErr.Message, "username") {
-					return ErrConflictUsername
-				} else if strings.Contains(pgErr.Message, "email") {
-					return ErrConflictEmail
-				}
-				return fmt.Errorf("failed to update user. conflict. %w", err)
+// UpdateUser updates the user's profile and returns it with its new version.
+// When user.Version is set the update only applies if the stored version still
+// matches it, otherwise ErrVersionConflict is returned. When user.Roles is not
+// nil the user's roles are replaced with it in the same transaction. Changing
+// the email takes away the user's verified status, and lets a verification
+// email go to the new address straight away.
+func (p *Postgres) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	err := p.WithTx(ctx, func(ctx context.Context) error {
+		err := p.conn(ctx).QueryRowContext(ctx,
+			`UPDATE sandbox.user
+			SET username = $1, email = $2, is_verified = is_verified AND email = $2,
+				verification_sent = CASE WHEN email = $2 THEN verification_sent END, version = version + 1
+			WHERE id = $3 AND deleted IS NULL AND ($4::integer = 0 OR version = $4)
+			RETURNING created, updated, version, is_active, is_suspended, suspended_reason, suspended_until, is_verified`,
+			user.Username,
+			user.Email,
+			user.ID,
+			user.Version,
+		).Scan(&user.Created, &user.Updated, &user.Version, &user.IsActive, &user.IsSuspended, &user.SuspendedReason, &user.SuspendedUntil, &user.IsVerified)
+		if errors.Is(err, sql.ErrNoRows) {
+			return p.missingOrStale(ctx, "sandbox.user", user.ID, ErrUserNotFound)
+		}
+		if err != nil {
+			if err := userConflict(err); err != nil {
+				return err
 			}
+			return fmt.Errorf("failed to update user. %w", err)
 		}
-		return fmt.Errorf("failed to update user. %w", err)
-	}
 
-	return nil
+		if user.Roles == nil {
+			return nil
+		}
+
+		_, err = p.conn(ctx).ExecContext(ctx,
+			`DELETE FROM sandbox.user_role
+			WHERE user_id = $1`,
+			user.ID)
+		if err != nil {
+			return fmt.Errorf("failed to clear user roles. %w", err)
+		}
+
+		if err := p.insertUserRoles(ctx, user); err != nil {
+			return fmt.Errorf("failed to update user roles. %w", err)
+		}
+
+		return nil
+	})
+
+	return user, err
 }
 
-func DeleteUser(ctx context.Context, id string) error {
-	_, err := getDB().ExecContext(ctx,
-		`DELETE FROM sandbox.user 
-		WHERE id = $1`,
-		id)
-	if err != nil {
-		return fmt.Errorf("failed to delete user. %w", err)
-	}
+// DeleteUser moves the user to the trash along with their workouts. The
+// workouts are stamped with the same deletion time so RestoreUser can bring
+// back exactly those and not workouts that were trashed on their own.
+func (p *Postgres) DeleteUser(ctx context.Context, id string) error {
+	return p.WithTx(ctx, func(ctx context.Context) error {
+		var deleted time.Time
+		err := p.conn(ctx).QueryRowContext(ctx,
+			`UPDATE sandbox.user
+			SET deleted = now(), version = version + 1
+			WHERE id = $1 AND deleted IS NULL
+			RETURNING deleted`,
+			id,
+		).Scan(&deleted)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete user. %w", err)
+		}
 
-	return nil
+		_, err = p.conn(ctx).ExecContext(ctx,
+			`UPDATE sandbox.workout
+			SET deleted = $2, version = version + 1
+			WHERE user_id = $1 AND deleted IS NULL`,
+			id, deleted)
+		if err != nil {
+			return fmt.Errorf("failed to delete user workouts. %w", err)
+		}
+
+		return nil
+	})
+}
+
+// RestoreUser takes the user out of the trash along with the workouts that
+// were trashed with them. It fails with a conflict when the username or email
+// has been taken in the meantime.
+func (p *Postgres) RestoreUser(ctx context.Context, id string) (model.User, error) {
+	var user model.User
+	err := p.WithTx(ctx, func(ctx context.Context) error {
+		var deleted time.Time
+		err := p.conn(ctx).QueryRowContext(ctx,
+			`SELECT deleted FROM sandbox.user
+			WHERE id = $1 AND deleted IS NOT NULL`,
+			id,
+		).Scan(&deleted)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find deleted user. %w", err)
+		}
+
+		_, err = p.conn(ctx).ExecContext(ctx,
+			`UPDATE sandbox.user
+			SET deleted = NULL, version = version + 1
+			WHERE id = $1`,
+			id)
+		if err != nil {
+			if err := userConflict(err); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to restore user. %w", err)
+		}
+
+		_, err = p.conn(ctx).ExecContext(ctx,
+			`UPDATE sandbox.workout
+			SET deleted = NULL, version = version + 1
+			WHERE user_id = $1 AND deleted = $2`,
+			id, deleted)
+		if err != nil {
+			if err := workoutConflict(err); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to restore user workouts. %w", err)
+		}
+
+		user, err = p.GetUser(ctx, UserQuery{ID: id, HidePassword: true, WithRoles: true})
+		return err
+	})
+
+	return user, err
 }
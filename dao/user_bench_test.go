@@ -0,0 +1,138 @@
+//go:build unit
+// +build unit
+
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingConnector is a database/sql driver that answers user and role
+// selects with canned rows and counts the queries it receives. Setting down
+// makes new connections fail, as an unreachable server would.
+type countingConnector struct {
+	users   int
+	queries atomic.Int64
+	down    atomic.Bool
+}
+
+func (c *countingConnector) Connect(context.Context) (driver.Conn, error) {
+	if c.down.Load() {
+		return nil, driver.ErrBadConn
+	}
+	return &countingConn{c: c}, nil
+}
+
+func (c *countingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type countingConn struct {
+	c *countingConnector
+}
+
+func (conn *countingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare not supported")
+}
+
+func (conn *countingConn) Close() error {
+	return nil
+}
+
+func (conn *countingConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions not supported")
+}
+
+func (conn *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn.c.queries.Add(1)
+	now := time.Now()
+
+	if strings.HasPrefix(query, "SELECT id, username") {
+		cols := []string{"id", "username", "password", "email", "created", "updated", "version", "deleted", "is_active", "is_suspended", "suspended_reason", "suspended_until", "is_verified"}
+		withRoles := strings.Contains(query, "json_agg")
+		if withRoles {
+			cols = append(cols, "roles")
+		}
+
+		rows := make([][]driver.Value, conn.c.users)
+		for i := range rows {
+			rows[i] = []driver.Value{fmt.Sprintf("user_%d", i), fmt.Sprintf("user_%d", i), "", fmt.Sprintf("%d@b.c", i), now, now, int64(1), nil, true, false, "", nil, false}
+			if withRoles {
+				rows[i] = append(rows[i], []byte(`[{"id":1,"name":"CIVILIAN","created":"2024-01-01T00:00:00Z","updated":"2024-01-01T00:00:00Z"}]`))
+			}
+		}
+		return &cannedRows{cols: cols, rows: rows}, nil
+	}
+
+	return &cannedRows{
+		cols: []string{"id", "name", "created", "updated"},
+		rows: [][]driver.Value{{int64(1), "CIVILIAN", now, now}},
+	}, nil
+}
+
+type cannedRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *cannedRows) Columns() []string {
+	return r.cols
+}
+
+func (r *cannedRows) Close() error {
+	return nil
+}
+
+func (r *cannedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// BenchmarkGetUsersRoles compares loading 100 users with their roles in one
+// query against loading each user's roles separately.
+func BenchmarkGetUsersRoles(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("batched", func(b *testing.B) {
+		c := &countingConnector{users: 100}
+		p := &Postgres{db: sql.OpenDB(c)}
+
+		for range b.N {
+			users, err := p.GetUsers(ctx, UserQuery{WithRoles: true})
+			if err != nil || len(users) != 100 || len(users[99].Roles) != 1 {
+				b.Fatalf("unexpected users. %d %v", len(users), err)
+			}
+		}
+		b.ReportMetric(float64(c.queries.Load())/float64(b.N), "queries/op")
+	})
+
+	b.Run("per_user", func(b *testing.B) {
+		c := &countingConnector{users: 100}
+		p := &Postgres{db: sql.OpenDB(c)}
+
+		for range b.N {
+			users, err := p.GetUsers(ctx, UserQuery{})
+			if err != nil || len(users) != 100 {
+				b.Fatalf("unexpected users. %d %v", len(users), err)
+			}
+			for i := range users {
+				if users[i].Roles, err = p.GetUserRoles(ctx, users[i].ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(c.queries.Load())/float64(b.N), "queries/op")
+	})
+}
@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// VerifyEmail marks the user verified, as long as their email is still the
+// one that was verified.
+func (p *Postgres) VerifyEmail(ctx context.Context, id string, email string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user
+		SET is_verified = true
+		WHERE id = $1 AND email = $2 AND deleted IS NULL`,
+		id,
+		email,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify email. %w", err)
+	}
+
+	return expectRow(res, ErrUserNotFound)
+}
+
+// MarkVerificationSent records that a verification email is being sent to the
+// user at sent, unless one was already sent less than interval before, in
+// which case it returns ErrVerificationRateLimited.
+func (p *Postgres) MarkVerificationSent(ctx context.Context, id string, sent time.Time, interval time.Duration) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.user
+		SET verification_sent = $2
+		WHERE id = $1 AND deleted IS NULL AND (verification_sent IS NULL OR verification_sent <= $3)`,
+		id,
+		sent,
+		sent.Add(-interval),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark verification sent. %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected. %w", err)
+	}
+	if n == 0 {
+		if _, err := p.GetUserByID(ctx, id); err != nil {
+			return err
+		}
+		return ErrVerificationRateLimited
+	}
+
+	return nil
+}
+
+func (m *Memory) VerifyEmail(ctx context.Context, id string, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.Deleted != nil || u.Email != email {
+		return ErrUserNotFound
+	}
+	u.IsVerified = true
+	m.users[id] = u
+
+	return nil
+}
+
+func (m *Memory) MarkVerificationSent(ctx context.Context, id string, sent time.Time, interval time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; !ok || u.Deleted != nil {
+		return ErrUserNotFound
+	}
+	if last, ok := m.verificationSent[id]; ok && sent.Sub(last) < interval {
+		return ErrVerificationRateLimited
+	}
+	m.verificationSent[id] = sent.UTC()
+
+	return nil
+}
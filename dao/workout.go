@@ -2,9 +2,11 @@ package dao
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/slham/sandbox-api/model"
@@ -12,8 +14,8 @@ import (
 
 var ErrConflictWorkoutName = errors.New("workout name already exists")
 
-func InsertWorkout(ctx context.Context, workout model.Workout) (model.Workout, error) {
-	_, err := getDB().ExecContext(ctx,
+func (p *Postgres) InsertWorkout(ctx context.Context, workout model.Workout) (model.Workout, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
 		`INSERT INTO sandbox.workout(
 			id,
 			name,
@@ -25,52 +27,120 @@ func InsertWorkout(ctx context.Context, workout model.Workout) (model.Workout, e
 			$2,
 			$3,
 			$4
-		)`,
+		)
+		RETURNING created, updated, version`,
 		workout.ID,
 		workout.Name,
 		workout.UserID,
 		workout.Exercises,
-	)
+	).Scan(&workout.Created, &workout.Updated, &workout.Version)
 	if err != nil {
-		if pgErr, ok := err.(*pq.Error); ok {
-			if pgErr.Code == "23505" {
-				if strings.Contains(pgErr.Message, "u_user_name") {
-					return workout, ErrConflictWorkoutName
-				}
-				return workout, fmt.Errorf("failed to insert workout. conflict. %w", err)
-			}
+		if err := workoutConflict(err); err != nil {
+			return workout, err
 		}
 		return workout, fmt.Errorf("failed to insert workout. %w", err)
 	}
 	return workout, nil
 }
 
+// workoutConflict maps a unique violation on the workout table to the
+// matching conflict error. It returns nil for any other error.
+func workoutConflict(err error) error {
+	var pgErr *pq.Error
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return nil
+	}
+
+	if strings.Contains(pgErr.Message, "u_user_name") {
+		return ErrConflictWorkoutName
+	}
+
+	return fmt.Errorf("conflict. %w", err)
+}
+
 type WorkoutQuery struct {
-	ID     string
-	UserID string
+	ID            string
+	IDs           []string
+	UserID        string
+	Name          string
+	Search        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Trashed selects deleted workouts instead of live ones.
+	Trashed bool
 	Query
 }
 
-func GetWorkoutByUserID(ctx context.Context, userID string) (model.Workout, error) {
-	q := WorkoutQuery{UserID: userID}
-	w, err := GetWorkout(ctx, q)
-	if err != nil {
-		return model.Workout{}, fmt.Errorf("failed to get workout by user id. %w", err)
+var workoutSortColumns = sortColumns{
+	"id":      "id",
+	"name":    "name",
+	"created": "created",
+	"updated": "updated",
+}
+
+func (q WorkoutQuery) build() (string, []any, error) {
+	b := q.filter()
+	if err := q.Query.apply(b, workoutSortColumns); err != nil {
+		return "", nil, err
 	}
-	return w, nil
+
+	stmt, args := b.Build()
+	return stmt, args, nil
 }
 
-func GetWorkoutByID(ctx context.Context, userID string, workoutID string) (model.Workout, error) {
+func (q WorkoutQuery) filter() *selectBuilder {
+	b := newSelect("sandbox.workout", "id", "name", "user_id", "exercises", "created", "updated", "version", "deleted")
+
+	if q.Trashed {
+		b.Where(notNull("deleted"))
+	} else {
+		b.Where(isNull("deleted"))
+	}
+	if q.ID != "" {
+		b.Where(eq("id", q.ID))
+	}
+	if q.IDs != nil {
+		b.Where(in("id", q.IDs...))
+	}
+	if q.UserID != "" {
+		b.Where(eq("user_id", q.UserID))
+	}
+	if q.Name != "" {
+		b.Where(eq("name", q.Name))
+	}
+	if q.Search != "" {
+		b.Where(ilike("name", q.Search))
+	}
+	b.Where(createdBetween(q.CreatedAfter, q.CreatedBefore))
+
+	return b
+}
+
+// workoutField returns the value of the workout's sort column col.
+func workoutField(w model.Workout, col string) any {
+	switch col {
+	case "name":
+		return w.Name
+	case "created":
+		return w.Created
+	case "updated":
+		return w.Updated
+	default:
+		return w.ID
+	}
+}
+
+func (p *Postgres) GetWorkoutByID(ctx context.Context, userID string, workoutID string) (model.Workout, error) {
 	q := WorkoutQuery{ID: workoutID, UserID: userID}
-	w, err := GetWorkout(ctx, q)
+	w, err := p.GetWorkout(ctx, q)
 	if err != nil {
 		return model.Workout{}, fmt.Errorf("failed to get workout by id. %w", err)
 	}
 	return w, nil
 }
 
-func GetWorkout(ctx context.Context, q WorkoutQuery) (model.Workout, error) {
-	workouts, err := GetWorkouts(ctx, q)
+func (p *Postgres) GetWorkout(ctx context.Context, q WorkoutQuery) (model.Workout, error) {
+	workouts, err := p.GetWorkouts(ctx, q)
 	if err != nil {
 		return model.Workout{}, fmt.Errorf("failed to get workouts. %w", err)
 	}
@@ -82,81 +152,139 @@ func GetWorkout(ctx context.Context, q WorkoutQuery) (model.Workout, error) {
 	return workouts[0], nil
 }
 
-func GetWorkouts(ctx context.Context, q WorkoutQuery) ([]model.Workout, error) {
-	stmt := `
-		SELECT
-			id,
-			name,
-			user_id,
-			exercises,
-			created,
-			updated
-		FROM
-			sandbox.workout
-		WHERE`
+func (p *Postgres) GetWorkouts(ctx context.Context, q WorkoutQuery) ([]model.Workout, error) {
+	stmt, args, err := q.build()
+	if err != nil {
+		return []model.Workout{}, fmt.Errorf("failed to build workouts query. %w", err)
+	}
 
-	if q.ID != "" {
-		stmt = fmt.Sprintf("%s %s='%s'", stmt, "id", q.ID)
+	return p.queryWorkouts(ctx, stmt, args)
+}
+
+// ListWorkouts returns one keyset page of the workouts matching q.
+func (p *Postgres) ListWorkouts(ctx context.Context, q WorkoutQuery) (Page[model.Workout], error) {
+	b := q.filter()
+
+	var total *int
+	if q.WithTotal {
+		n, err := p.count(ctx, b)
+		if err != nil {
+			return Page[model.Workout]{}, fmt.Errorf("failed to count workouts. %w", err)
+		}
+		total = &n
 	}
-	if q.UserID != "" {
-		stmt = checkWhereClause(stmt)
-		stmt = fmt.Sprintf("%s %s='%s'", stmt, "user_id", q.UserID)
+
+	if err := q.Query.applyKeyset(b, workoutSortColumns); err != nil {
+		return Page[model.Workout]{}, fmt.Errorf("failed to build workouts query. %w", err)
+	}
+
+	stmt, args := b.Build()
+	workouts, err := p.queryWorkouts(ctx, stmt, args)
+	if err != nil {
+		return Page[model.Workout]{}, err
 	}
 
-	stmt = addDefaultQuery(stmt, q.Query)
+	page := pageOf(workouts, q.Query, workoutSortColumns, workoutField)
+	page.Total = total
 
+	return page, nil
+}
+
+func (p *Postgres) queryWorkouts(ctx context.Context, stmt string, args []any) ([]model.Workout, error) {
 	workouts := []model.Workout{}
-	rows, err := getDB().QueryContext(ctx, stmt)
+	rows, err := p.readConn(ctx).QueryContext(ctx, stmt, args...)
 	if err != nil {
-		return workouts, fmt.Errorf("failed to query users. %w", err)
+		return workouts, fmt.Errorf("failed to query workouts. %w", err)
 	}
 
 	defer rows.Close()
 
 	for rows.Next() {
 		var w model.Workout
-		if err := rows.Scan(&w.ID, &w.Name, &w.UserID, &w.Exercises, &w.Created, &w.Updated); err != nil {
+		if err := rows.Scan(&w.ID, &w.Name, &w.UserID, &w.Exercises, &w.Created, &w.Updated, &w.Version, &w.Deleted); err != nil {
 			return workouts, fmt.Errorf("failed to scan. %w", err)
 		}
 
 		workouts = append(workouts, w)
 	}
 
+	if err := rows.Err(); err != nil {
+		return workouts, fmt.Errorf("failed to query workouts. rows. %w", err)
+	}
+
 	return workouts, nil
 }
 
-func UpdateWorkout(ctx context.Context, workout model.Workout) error {
-	_, err := getDB().ExecContext(ctx,
+// UpdateWorkout updates the workout and returns it with its new version. When
+// workout.Version is set the update only applies if the stored version still
+// matches it, otherwise ErrVersionConflict is returned.
+func (p *Postgres) UpdateWorkout(ctx context.Context, workout model.Workout) (model.Workout, error) {
+	err := p.conn(ctx).QueryRowContext(ctx,
 		`UPDATE sandbox.workout
-		SET name = $1, exercises = $2
-		WHERE id = $3`,
+		SET name = $1, exercises = $2, version = version + 1
+		WHERE id = $3 AND deleted IS NULL AND ($4::integer = 0 OR version = $4)
+		RETURNING user_id, created, updated, version`,
 		workout.Name,
 		workout.Exercises,
 		workout.ID,
-	)
+		workout.Version,
+	).Scan(&workout.UserID, &workout.Created, &workout.Updated, &workout.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return workout, p.missingOrStale(ctx, "sandbox.workout", workout.ID, ErrWorkoutNotFound)
+	}
 	if err != nil {
-		if pgErr, ok := err.(*pq.Error); ok {
-			if pgErr.Code == "23505" {
-				if strings.Contains(pgErr.Message, "u_user_name") {
-					return ErrConflictWorkoutName
-				}
-				return fmt.Errorf("failed to update workout. conflict. %w", err)
-			}
+		if err := workoutConflict(err); err != nil {
+			return workout, err
 		}
-		return fmt.Errorf("failed to update workout. %w", err)
+		return workout, fmt.Errorf("failed to update workout. %w", err)
 	}
 
-	return nil
+	return workout, nil
 }
 
-func DeleteWorkout(ctx context.Context, userID string, workoutID string) error {
-	_, err := getDB().ExecContext(ctx,
-		`DELETE FROM sandbox.workout
-		WHERE user_id = $1 AND id = $2`,
+// DeleteWorkout moves the workout to the trash. It stays there until it is
+// restored or purged.
+func (p *Postgres) DeleteWorkout(ctx context.Context, userID string, workoutID string) error {
+	res, err := p.conn(ctx).ExecContext(ctx,
+		`UPDATE sandbox.workout
+		SET deleted = now(), version = version + 1
+		WHERE user_id = $1 AND id = $2 AND deleted IS NULL`,
 		userID, workoutID)
 	if err != nil {
 		return fmt.Errorf("failed to delete workout. %w", err)
 	}
 
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrWorkoutNotFound
+	}
+
 	return nil
 }
+
+// RestoreWorkout takes the workout out of the trash. It fails with
+// ErrConflictWorkoutName when another workout has taken its name since.
+func (p *Postgres) RestoreWorkout(ctx context.Context, userID string, workoutID string) (model.Workout, error) {
+	var workout model.Workout
+	err := p.WithTx(ctx, func(ctx context.Context) error {
+		res, err := p.conn(ctx).ExecContext(ctx,
+			`UPDATE sandbox.workout
+			SET deleted = NULL, version = version + 1
+			WHERE user_id = $1 AND id = $2 AND deleted IS NOT NULL`,
+			userID, workoutID)
+		if err != nil {
+			if err := workoutConflict(err); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to restore workout. %w", err)
+		}
+
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return ErrWorkoutNotFound
+		}
+
+		workout, err = p.GetWorkoutByID(ctx, userID, workoutID)
+		return err
+	})
+
+	return workout, err
+}
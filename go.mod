@@ -11,6 +11,7 @@ require (
 	github.com/kabukky/httpscerts v0.0.0-20150320125433-617593d7dcb3
 	github.com/lib/pq v1.10.9
 	github.com/panta/go-json-matcher v0.9.1
+	github.com/pquerna/otp v1.4.0
 	github.com/rs/cors v1.11.1
 	github.com/samber/lo v1.47.0
 	github.com/segmentio/ksuid v1.0.4
@@ -18,12 +19,14 @@ require (
 	github.com/stretchr/testify v1.9.0
 	github.com/tamathecxder/randomail v1.2.0
 	github.com/throttled/throttled/v2 v2.12.0
+	golang.org/x/crypto v0.32.0
 	golang.org/x/oauth2 v0.25.0
 	gopkg.in/go-playground/assert.v1 v1.2.1
 )
 
 require (
 	cloud.google.com/go/compute/metadata v0.6.0 // indirect
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/google/uuid v1.6.0 // indirect
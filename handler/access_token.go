@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"github.com/slham/sandbox-api/dao"
+)
+
+type AccessTokenController struct {
+	pats dao.PersonalAccessTokenRepository
+}
+
+func NewAccessTokenController(pats dao.PersonalAccessTokenRepository) AccessTokenController {
+	return AccessTokenController{
+		pats: pats,
+	}
+}
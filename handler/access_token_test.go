@@ -0,0 +1,73 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	repo := dao.NewMemory()
+	c := NewAccessTokenController(repo)
+	user := createUser(t, NewUserController(repo, repo, nil), "pat_user", "p@b.c")
+	ctx := request.WithRequestContext(context.Background(), &request.RequestContext{UserID: user.ID})
+	past := time.Now().Add(-time.Hour)
+
+	tables := []struct {
+		name string
+		req  CreatePersonalAccessTokenRequest
+	}{
+		{"no name", CreatePersonalAccessTokenRequest{Scopes: []string{model.ScopeWorkoutsRead}}},
+		{"no scopes", CreatePersonalAccessTokenRequest{Name: "sheet"}},
+		{"unknown scope", CreatePersonalAccessTokenRequest{Name: "sheet", Scopes: []string{"users:write"}}},
+		{"expired", CreatePersonalAccessTokenRequest{Name: "sheet", Scopes: []string{model.ScopeWorkoutsRead}, Expires: &past}},
+	}
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.createPersonalAccessToken(ctx, user.ID, tt.req)
+			assert.ErrorIs(t, err, ApiErrBadRequest)
+		})
+	}
+
+	// not even admins mint tokens for someone else
+	adminCtx := request.WithRequestContext(context.Background(), &request.RequestContext{UserID: "user_admin", Roles: []string{"ADMIN"}, Permissions: model.Permissions})
+	_, err := c.createPersonalAccessToken(adminCtx, user.ID, CreatePersonalAccessTokenRequest{Name: "sheet", Scopes: []string{model.ScopeWorkoutsRead}})
+	assert.ErrorIs(t, err, ApiErrForbidden)
+
+	created, err := c.createPersonalAccessToken(ctx, user.ID, CreatePersonalAccessTokenRequest{
+		Name:   " sheet ",
+		Scopes: []string{model.ScopeWorkoutsWrite, model.ScopeWorkoutsRead, model.ScopeWorkoutsRead},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "sheet", created.Name)
+	assert.Equal(t, []string{model.ScopeWorkoutsRead, model.ScopeWorkoutsWrite}, created.Scopes)
+	assert.NotEmpty(t, created.Token)
+
+	vars := map[string]string{"user_id": user.ID}
+	w := serve(c.GetPersonalAccessTokens, "GET", "/users/"+user.ID+"/tokens", vars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Token)
+	listed := []model.PersonalAccessToken{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0].ID)
+
+	vars["token_id"] = created.ID
+	w = serve(c.RevokePersonalAccessToken, "DELETE", "/users/"+user.ID+"/tokens/"+created.ID, vars, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(c.RevokePersonalAccessToken, "DELETE", "/users/"+user.ID+"/tokens/"+created.ID, vars, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(c.GetPersonalAccessTokens, "GET", "/users/"+user.ID+"/tokens", vars, "")
+	assert.Equal(t, "[]", w.Body.String())
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+type AccountController struct {
+	accounts *auth.AccountService
+}
+
+func NewAccountController(accounts *auth.AccountService) AccountController {
+	return AccountController{
+		accounts: accounts,
+	}
+}
+
+func handleAccountError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error account", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error account", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	} else if errors.Is(err, ApiErrNotFound) || errors.Is(err, dao.ErrUserNotFound) {
+		slog.WarnContext(ctx, "error account", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, "user not found")
+		return
+	} else if errors.Is(err, auth.ErrInvalidAccountChange) {
+		slog.WarnContext(ctx, "error account", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error account", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
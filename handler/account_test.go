@@ -0,0 +1,65 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountStates(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	c := NewAuthController(auth.NewPostgresSessionStore(repo, repo, 0), auth.NewTokenService(repo, repo, auth.TokenConfig{}), nil, repo, repo, repo, nil)
+	a := NewAccountController(auth.NewAccountService(repo, repo, repo, repo))
+	user := createUser(t, NewUserController(repo, repo, nil), "state_user", "state@b.c")
+	userCtx := request.WithRequestContext(ctx, &request.RequestContext{UserID: user.ID})
+	adminCtx := request.WithRequestContext(ctx, &request.RequestContext{UserID: "user_admin", Roles: []string{"ADMIN"}, Permissions: model.Permissions})
+	login := func() (int, map[string]string) {
+		w := serve(c.Login, "POST", "/auth/login", nil, `{"username": "state_user", "password": "thisIsAG00dPassword!"}`)
+		body := map[string]string{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	until := time.Now().Add(time.Hour)
+	assert.ErrorIs(t, a.suspendUser(userCtx, user.ID, suspendUserRequest{Reason: "spam"}), ApiErrForbidden)
+	assert.ErrorIs(t, a.suspendUser(adminCtx, user.ID, suspendUserRequest{}), ApiErrBadRequest)
+	assert.ErrorIs(t, a.suspendUser(adminCtx, "user_admin", suspendUserRequest{Reason: "spam"}), ApiErrBadRequest)
+	assert.ErrorIs(t, a.suspendUser(adminCtx, "user_nobody", suspendUserRequest{Reason: "spam"}), dao.ErrUserNotFound)
+	assert.NoError(t, a.suspendUser(adminCtx, user.ID, suspendUserRequest{Reason: "spam", Until: &until}))
+
+	code, body := login()
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, auth.CodeAccountSuspended, body["code"])
+	w := serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "password", "username": "state_user", "password": "thisIsAG00dPassword!"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// a wrong password does not say the account is suspended
+	w = serve(c.Login, "POST", "/auth/login", nil, `{"username": "state_user", "password": "wrong"}`)
+	assert.NotContains(t, w.Body.String(), auth.CodeAccountSuspended)
+
+	assert.ErrorIs(t, a.reactivateUser(userCtx, user.ID), ApiErrForbidden)
+	assert.NoError(t, a.reactivateUser(adminCtx, user.ID))
+	code, _ = login()
+	assert.Equal(t, http.StatusOK, code)
+
+	_, err := a.deactivateUser(adminCtx, user.ID)
+	assert.ErrorIs(t, err, ApiErrForbidden)
+	n, err := a.deactivateUser(userCtx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	code, body = login()
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, auth.CodeAccountDeactivated, body["code"])
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"context"
+	"slices"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+type AdminController struct {
+	users dao.UserRepository
+	guard *auth.LoginGuard
+}
+
+func NewAdminController(users dao.UserRepository, guard *auth.LoginGuard) AdminController {
+	return AdminController{
+		users: users,
+		guard: guard,
+	}
+}
+
+// requirePermission fails unless the caller's roles grant the permission.
+func requirePermission(ctx context.Context, permission string) error {
+	rc := request.GetRequestContext(ctx)
+	if rc == nil || !slices.Contains(rc.Permissions, permission) {
+		return NewApiError(403, ApiErrForbidden).Append(permission + " permission required")
+	}
+
+	return nil
+}
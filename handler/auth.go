@@ -1,20 +1,28 @@
 package handler
 
 import (
-	"github.com/gorilla/sessions"
 	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
 )
 
 type AuthController struct {
-	cookieStore *sessions.CookieStore
+	sessions   auth.SessionStore
+	tokens     *auth.TokenService
+	mfa        *auth.MFAService
+	users      dao.UserRepository
+	roles      dao.RoleRepository
+	identities dao.IdentityRepository
+	guard      *auth.LoginGuard
 }
 
-func NewAuthController(store *auth.StandardSessionStore) AuthController {
+func NewAuthController(store auth.SessionStore, tokens *auth.TokenService, mfa *auth.MFAService, users dao.UserRepository, roles dao.RoleRepository, identities dao.IdentityRepository, guard *auth.LoginGuard) AuthController {
 	return AuthController{
-		cookieStore: store.GetCookieStore(),
+		sessions:   store,
+		tokens:     tokens,
+		mfa:        mfa,
+		users:      users,
+		roles:      roles,
+		identities: identities,
+		guard:      guard,
 	}
 }
-
-func (c *AuthController) GetCookieStore() *sessions.CookieStore {
-	return c.cookieStore
-}
@@ -0,0 +1,150 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/jwt"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	users := NewUserController(repo, repo, nil)
+	c := NewAuthController(auth.NewStandardSessionStore(), nil, nil, repo, repo, repo, nil)
+	admin := NewAdminController(repo, nil)
+	adminCtx := request.WithRequestContext(ctx, &request.RequestContext{Roles: []string{"ADMIN"}, Permissions: model.Permissions})
+
+	createUser(t, users, "hashed_user", "h@b.c")
+
+	legacy, err := crypt.Encrypt("thisIsAG00dPassword!")
+	assert.NoError(t, err)
+	_, err = repo.InsertUser(ctx, model.User{ID: newUserID(), Username: "legacy_user", Email: "l@b.c", Password: legacy})
+	assert.NoError(t, err)
+
+	report, err := admin.getPasswordReport(adminCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, passwordReport{Total: 2, Legacy: 1, Hashed: 1}, report)
+
+	_, err = admin.getPasswordReport(ctx)
+	assert.ErrorIs(t, err, ApiErrForbidden)
+
+	// a wrong password neither logs in nor migrates the hash
+	_, err = c.handleLogin(ctx, LoginRequest{Username: "legacy_user", Password: "wrong"})
+	assert.ErrorIs(t, err, ApiErrForbidden)
+	stored, err := repo.GetUserByUsername(ctx, "legacy_user")
+	assert.NoError(t, err)
+	assert.Equal(t, legacy, stored.Password)
+
+	user, err := c.handleLogin(ctx, LoginRequest{Username: "legacy_user", Password: "thisIsAG00dPassword!"})
+	assert.NoError(t, err)
+	assert.Empty(t, user.Password)
+
+	stored, err = repo.GetUserByUsername(ctx, "legacy_user")
+	assert.NoError(t, err)
+	assert.False(t, crypt.IsLegacyPassword(stored.Password))
+
+	report, err = admin.getPasswordReport(adminCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, passwordReport{Total: 2, Legacy: 0, Hashed: 2}, report)
+
+	// the rehashed password still logs in
+	_, err = c.handleLogin(ctx, LoginRequest{Username: "legacy_user", Password: "thisIsAG00dPassword!"})
+	assert.NoError(t, err)
+	_, err = c.handleLogin(ctx, LoginRequest{Username: "hashed_user", Password: "thisIsAG00dPassword!"})
+	assert.NoError(t, err)
+}
+
+func TestToken(t *testing.T) {
+	repo := dao.NewMemory()
+	tokens := auth.NewTokenService(repo, repo, auth.TokenConfig{})
+	c := NewAuthController(auth.NewPostgresSessionStore(repo, repo, 0), tokens, nil, repo, repo, repo, nil)
+	user := createUser(t, NewUserController(repo, repo, nil), "token_user", "t@b.c")
+
+	w := serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "password", "username": "token_user", "password": "wrong"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "client_credentials"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "password", "username": "token_user", "password": "thisIsAG00dPassword!"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	pair := auth.TokenPair{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pair))
+	claims, err := tokens.Verify(context.Background(), pair.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, claims.Subject)
+	assert.Equal(t, []string{"CIVILIAN"}, claims.Roles)
+
+	refresh := `{"grant_type": "refresh_token", "refresh_token": "` + pair.RefreshToken + `"}`
+	w = serve(c.Token, "POST", "/auth/token", nil, refresh)
+	assert.Equal(t, http.StatusOK, w.Code)
+	next := auth.TokenPair{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &next))
+
+	// replaying the first refresh token ends the login
+	w = serve(c.Token, "POST", "/auth/token", nil, refresh)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "reuse")
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "refresh_token", "refresh_token": "`+next.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "password", "username": "token_user", "password": "thisIsAG00dPassword!"}`)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pair))
+	w = serve(c.RevokeToken, "POST", "/auth/token/revoke", nil, `{"refresh_token": "`+pair.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "refresh_token", "refresh_token": "`+pair.RefreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve(c.JWKS, "GET", "/.well-known/jwks.json", nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	keys := jwt.JWKS{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &keys))
+	assert.Len(t, keys.Keys, 1)
+	assert.Equal(t, "ES256", keys.Keys[0].Alg)
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	guard := auth.NewLoginGuard(repo, repo, auth.LockoutConfig{MaxAccountFailures: 3, Delay: time.Millisecond, MaxDelay: time.Millisecond})
+	c := NewAuthController(auth.NewPostgresSessionStore(repo, repo, 0), nil, nil, repo, repo, repo, guard)
+	admin := NewAdminController(repo, guard)
+	user := createUser(t, NewUserController(repo, repo, nil), "locked_user", "lock@b.c")
+	login := func(username, password string) *httptest.ResponseRecorder {
+		return serve(c.Login, "POST", "/auth/login", nil, `{"username": "`+username+`", "password": "`+password+`"}`)
+	}
+
+	// unknown usernames and wrong passwords get the same answer
+	unknown, wrong := login("nobody", "thisIsAG00dPassword!"), login("locked_user", "wrong")
+	assert.Equal(t, http.StatusForbidden, unknown.Code)
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+
+	login("locked_user", "wrong")
+	login("locked_user", "wrong")
+	w := login("locked_user", "thisIsAG00dPassword!")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "password", "username": "locked_user", "password": "thisIsAG00dPassword!"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	assert.ErrorIs(t, admin.unlockUser(request.WithRequestContext(ctx, &request.RequestContext{UserID: user.ID}), user.ID), ApiErrForbidden)
+	adminCtx := request.WithRequestContext(ctx, &request.RequestContext{UserID: "user_admin", Roles: []string{"ADMIN"}, Permissions: model.Permissions})
+	assert.ErrorIs(t, admin.unlockUser(adminCtx, "user_nobody"), ApiErrNotFound)
+	assert.NoError(t, admin.unlockUser(adminCtx, user.ID))
+	assert.Equal(t, http.StatusOK, login("locked_user", "thisIsAG00dPassword!").Code)
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+// AuthorizationController is the OAuth authorization server third-party apps
+// use to act for users, and where admins register those apps.
+type AuthorizationController struct {
+	server  *auth.AuthorizationServer
+	clients dao.OAuthRepository
+}
+
+func NewAuthorizationController(server *auth.AuthorizationServer, clients dao.OAuthRepository) AuthorizationController {
+	return AuthorizationController{
+		server:  server,
+		clients: clients,
+	}
+}
+
+// authorizationResponse tells the consent screen where to send the browser.
+type authorizationResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// authorizationErrorResponse is an error the client should hear about. The
+// consent screen sends the browser to RedirectTo to tell it.
+type authorizationErrorResponse struct {
+	*auth.OAuthError
+	RedirectTo string `json:"redirect_to"`
+}
+
+// handleAuthorizationError answers the consent screen. Errors about the
+// request itself go back to the client, except when the client or redirect
+// uri cannot be trusted.
+func handleAuthorizationError(ctx context.Context, w http.ResponseWriter, req auth.AuthorizationRequest, err error) {
+	oauthErr := &auth.OAuthError{}
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error authorization", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if errors.Is(err, auth.ErrInvalidRedirect) {
+		slog.WarnContext(ctx, "error authorization", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if errors.As(err, &oauthErr) {
+		slog.WarnContext(ctx, "error authorization", "err", err)
+		request.RespondWithJSON(w, http.StatusBadRequest, authorizationErrorResponse{OAuthError: oauthErr, RedirectTo: req.ErrorRedirect(oauthErr)})
+		return
+	}
+
+	slog.ErrorContext(ctx, "error authorization", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// handleOAuthTokenError answers the token endpoints the way RFC 6749 has
+// clients expect.
+func handleOAuthTokenError(ctx context.Context, w http.ResponseWriter, err error) {
+	oauthErr := &auth.OAuthError{}
+	if errors.As(err, &oauthErr) {
+		slog.WarnContext(ctx, "error oauth token", "err", err)
+		status := http.StatusBadRequest
+		if oauthErr.Code == auth.OAuthInvalidClient {
+			status = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", `Basic realm="sandbox-api"`)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		request.RespondWithJSON(w, status, oauthErr)
+		return
+	}
+
+	slog.ErrorContext(ctx, "error oauth token", "err", err)
+	request.RespondWithJSON(w, http.StatusInternalServerError, &auth.OAuthError{Code: "server_error"})
+}
+
+// clientCredentials reads the client's id and secret from basic auth, or
+// from the form for clients that cannot send it.
+func clientCredentials(r *http.Request) auth.ClientCredentials {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return auth.ClientCredentials{ID: id, Secret: secret}
+	}
+	return auth.ClientCredentials{ID: r.PostFormValue("client_id"), Secret: r.PostFormValue("client_secret")}
+}
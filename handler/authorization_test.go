@@ -0,0 +1,194 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOAuthClient(t *testing.T) {
+	repo := dao.NewMemory()
+	c := NewAuthorizationController(auth.NewAuthorizationServer(repo, repo, auth.NewTokenService(repo, repo, auth.TokenConfig{})), repo)
+
+	tables := []struct {
+		name string
+		req  CreateOAuthClientRequest
+	}{
+		{"no name", CreateOAuthClientRequest{RedirectURIs: []string{"https://a.example/cb"}, Scopes: []string{model.ScopeWorkoutsRead}}},
+		{"no redirect uris", CreateOAuthClientRequest{Name: "app", Scopes: []string{model.ScopeWorkoutsRead}}},
+		{"relative redirect uri", CreateOAuthClientRequest{Name: "app", RedirectURIs: []string{"/cb"}, Scopes: []string{model.ScopeWorkoutsRead}}},
+		{"plain http", CreateOAuthClientRequest{Name: "app", RedirectURIs: []string{"http://a.example/cb"}, Scopes: []string{model.ScopeWorkoutsRead}}},
+		{"fragment", CreateOAuthClientRequest{Name: "app", RedirectURIs: []string{"https://a.example/cb#x"}, Scopes: []string{model.ScopeWorkoutsRead}}},
+		{"no scopes", CreateOAuthClientRequest{Name: "app", RedirectURIs: []string{"https://a.example/cb"}}},
+		{"unknown scope", CreateOAuthClientRequest{Name: "app", RedirectURIs: []string{"https://a.example/cb"}, Scopes: []string{"users:write"}}},
+	}
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.createOAuthClient(context.Background(), tt.req)
+			assert.ErrorIs(t, err, ApiErrBadRequest)
+		})
+	}
+
+	w := serve(c.CreateOAuthClient, "POST", "/admin/oauth/clients", nil, `{"name": "cli", "redirect_uris": ["http://localhost:8080/cb"], "scopes": ["workouts:read"], "public": true}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "client_secret")
+
+	w = serve(c.CreateOAuthClient, "POST", "/admin/oauth/clients", nil, `{"name": "watch", "redirect_uris": ["https://watch.example/cb"], "scopes": ["workouts:read"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	created := oauthClientResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Secret, auth.ClientSecretPrefix))
+
+	w = serve(c.GetOAuthClients, "GET", "/admin/oauth/clients", nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+	listed := []model.OAuthClient{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed, 2)
+
+	vars := map[string]string{"client_id": created.ID}
+	w = serve(c.DeleteOAuthClient, "DELETE", "/admin/oauth/clients/"+created.ID, vars, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(c.DeleteOAuthClient, "DELETE", "/admin/oauth/clients/"+created.ID, vars, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	repo := dao.NewMemory()
+	c := NewAuthorizationController(auth.NewAuthorizationServer(repo, repo, auth.NewTokenService(repo, repo, auth.TokenConfig{})), repo)
+	user := createUser(t, NewUserController(repo, repo, nil), "oauth_user", "o@b.c")
+	ctx := request.WithRequestContext(context.Background(), &request.RequestContext{UserID: user.ID})
+
+	client, err := c.createOAuthClient(ctx, CreateOAuthClientRequest{Name: "watch", RedirectURIs: []string{"https://watch.example/cb"}, Scopes: []string{model.ScopeWorkoutsRead}})
+	assert.NoError(t, err)
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {"https://watch.example/cb"},
+		"scope":                 {"workouts:read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	get := func(query url.Values) *http.Response {
+		r, _ := http.NewRequestWithContext(ctx, "GET", "/oauth/authorize?"+query.Encode(), nil)
+		return serveRequest(c.GetAuthorization, r, nil).Result()
+	}
+
+	resp := get(query)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	consent := auth.Consent{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&consent))
+	assert.Equal(t, "watch", consent.Client.Name)
+	assert.Equal(t, []string{model.ScopeWorkoutsRead}, consent.Scopes)
+
+	// a redirect uri the client did not register is never followed
+	bad := url.Values{}
+	for k, v := range query {
+		bad[k] = v
+	}
+	bad.Set("redirect_uri", "https://evil.example/cb")
+	resp = get(bad)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errResp := authorizationErrorResponse{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	assert.Empty(t, errResp.RedirectTo)
+
+	bad.Set("redirect_uri", "https://watch.example/cb")
+	bad.Set("scope", "profile:read")
+	resp = get(bad)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	assert.Equal(t, auth.OAuthInvalidScope, errResp.Code)
+	assert.True(t, strings.HasPrefix(errResp.RedirectTo, "https://watch.example/cb?error=invalid_scope"))
+
+	body, _ := json.Marshal(AuthorizeRequest{
+		AuthorizationRequest: auth.AuthorizationRequest{
+			ResponseType:        "code",
+			ClientID:            client.ID,
+			RedirectURI:         "https://watch.example/cb",
+			Scope:               "workouts:read",
+			State:               "xyz",
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: "S256",
+		},
+		Approve: true,
+	})
+	r, _ := http.NewRequestWithContext(ctx, "POST", "/oauth/authorize", strings.NewReader(string(body)))
+	w := serveRequest(c.Authorize, r, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	authorized := authorizationResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &authorized))
+	redirect, err := url.Parse(authorized.RedirectTo)
+	assert.NoError(t, err)
+	assert.Equal(t, "xyz", redirect.Query().Get("state"))
+
+	token := func(form url.Values, id, secret string) *http.Response {
+		r, _ := http.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if id != "" {
+			r.SetBasicAuth(id, secret)
+		}
+		return serveRequest(c.OAuthToken, r, nil).Result()
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirect.Query().Get("code")},
+		"redirect_uri":  {"https://watch.example/cb"},
+		"code_verifier": {verifier},
+	}
+
+	resp = token(form, client.ID, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	resp = token(url.Values{"grant_type": {"password"}}, client.ID, client.Secret)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	oauthErr := auth.OAuthError{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&oauthErr))
+	assert.Equal(t, auth.OAuthUnsupportedGrantType, oauthErr.Code)
+
+	resp = token(form, client.ID, client.Secret)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	pair := auth.TokenPair{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&pair))
+	assert.NotEmpty(t, pair.AccessToken)
+	assert.Equal(t, "workouts:read", pair.Scope)
+
+	vars := map[string]string{"user_id": user.ID}
+	w = serve(c.GetAuthorizedApps, "GET", "/users/"+user.ID+"/apps", vars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	grants := []model.OAuthGrant{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &grants))
+	if assert.Len(t, grants, 1) {
+		assert.Equal(t, client.ID, grants[0].ClientID)
+	}
+
+	vars["client_id"] = client.ID
+	w = serve(c.RevokeAuthorizedApp, "DELETE", "/users/"+user.ID+"/apps/"+client.ID, vars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revoked": 1}`, w.Body.String())
+	w = serve(c.RevokeAuthorizedApp, "DELETE", "/users/"+user.ID+"/apps/"+client.ID, vars, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	resp = token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {pair.RefreshToken}}, client.ID, client.Secret)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
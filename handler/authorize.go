@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+// AuthorizeRequest is the user's answer on the consent screen to the request
+// it was shown.
+type AuthorizeRequest struct {
+	auth.AuthorizationRequest
+	Approve bool `json:"approve"`
+}
+
+// Authorize records the user's answer. Either way the consent screen sends the
+// browser on to the app, with a code if the user approved.
+func (c *AuthorizationController) Authorize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "authorize request")
+	req := AuthorizeRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding authorize request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	redirectTo, err := c.server.Consent(ctx, request.GetRequestContext(ctx).UserID, req.AuthorizationRequest, req.Approve)
+	if err != nil {
+		handleAuthorizationError(ctx, w, req.AuthorizationRequest, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusOK, authorizationResponse{RedirectTo: redirectTo})
+}
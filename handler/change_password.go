@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	// IP is the client's address, which wrong passwords are counted against.
+	IP string `json:"-"`
+}
+
+// ChangePassword replaces the user's password. It takes the current one, so
+// a stolen session alone cannot lock the user out, and wrong ones count
+// towards the login lockout, so it cannot be used to guess it either. It logs
+// the user out everywhere, including the session the request was made with.
+func (c *PasswordController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "change password request")
+	vars := mux.Vars(r)
+	req := changePasswordRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding change password request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	req.IP = auth.ClientIP(r)
+
+	n, err := c.changePassword(ctx, vars["user_id"], req)
+	if err != nil {
+		handlePasswordError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, revokedSessions{Revoked: n})
+}
+
+func (c *PasswordController) changePassword(ctx context.Context, userID string, req changePasswordRequest) (int, error) {
+	if err := requireSelf(ctx, userID); err != nil {
+		return 0, err
+	}
+	if req.CurrentPassword == "" {
+		return 0, NewApiError(400, ApiErrBadRequest).Append("current_password must be present")
+	}
+	if err := validateNewPassword(req.NewPassword); err != nil {
+		return 0, err
+	}
+
+	n, err := c.passwords.Change(ctx, userID, req.CurrentPassword, req.NewPassword, req.IP)
+	if errors.Is(err, dao.ErrUserNotFound) {
+		return 0, NewApiError(404, ApiErrNotFound).Append("user not found")
+	}
+
+	return n, err
+}
@@ -7,6 +7,9 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/slham/sandbox-api/dao"
 )
 
 var (
@@ -14,6 +17,9 @@ var (
 	ApiErrForbidden  = errors.New("forbidden")
 	ApiErrNotFound   = errors.New("not found")
 	ApiErrConflict   = errors.New("conflict")
+
+	ApiErrPreconditionFailed   = errors.New("precondition failed")
+	ApiErrPreconditionRequired = errors.New("precondition required")
 )
 
 type ApiError struct {
@@ -48,10 +54,18 @@ func (a *ApiError) HasError() bool {
 }
 
 type APIQuery struct {
-	SortCol string
-	Sort    string
-	Limit   int
-	Offset  int
+	SortCol       string
+	Sort          string
+	Limit         int
+	Offset        int
+	Search        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Paginate is set when the caller asked for keyset paging by passing a
+	// cursor parameter. An empty cursor asks for the first page.
+	Paginate  bool
+	Cursor    *dao.Cursor
+	WithTotal bool
 }
 
 func getStandardQueryParams(ctx context.Context, query url.Values) (APIQuery, error) {
@@ -68,7 +82,7 @@ func getStandardQueryParams(ctx context.Context, query url.Values) (APIQuery, er
 			slog.WarnContext(ctx, "invalid limit", "limit", qLimit)
 			return apiQuery, NewApiError(400, ApiErrBadRequest).Append("invalid limit")
 		}
-		apiQuery.Limit = limit
+		apiQuery.Limit = min(limit, maxPageSize)
 	}
 	if qOffset := query.Get("offset"); qOffset != "" {
 		offset, err := strconv.Atoi(qOffset)
@@ -78,5 +92,75 @@ func getStandardQueryParams(ctx context.Context, query url.Values) (APIQuery, er
 		}
 		apiQuery.Offset = offset
 	}
+	if qSearch := query.Get("search"); qSearch != "" {
+		apiQuery.Search = qSearch
+	}
+	if qCreatedAfter := query.Get("created_after"); qCreatedAfter != "" {
+		createdAfter, err := time.Parse(time.RFC3339, qCreatedAfter)
+		if err != nil {
+			slog.WarnContext(ctx, "invalid created_after", "created_after", qCreatedAfter)
+			return apiQuery, NewApiError(400, ApiErrBadRequest).Append("invalid created_after. must be RFC3339")
+		}
+		apiQuery.CreatedAfter = createdAfter
+	}
+	if qCreatedBefore := query.Get("created_before"); qCreatedBefore != "" {
+		createdBefore, err := time.Parse(time.RFC3339, qCreatedBefore)
+		if err != nil {
+			slog.WarnContext(ctx, "invalid created_before", "created_before", qCreatedBefore)
+			return apiQuery, NewApiError(400, ApiErrBadRequest).Append("invalid created_before. must be RFC3339")
+		}
+		apiQuery.CreatedBefore = createdBefore
+	}
+	if query.Has("cursor") {
+		if query.Has("offset") {
+			return apiQuery, NewApiError(400, ApiErrBadRequest).Append("cursor and offset cannot be combined")
+		}
+		apiQuery.Paginate = true
+		if qCursor := query.Get("cursor"); qCursor != "" {
+			cursor, err := dao.DecodeCursor(qCursor)
+			if err != nil {
+				slog.WarnContext(ctx, "invalid cursor", "cursor", qCursor)
+				return apiQuery, NewApiError(400, ApiErrBadRequest).Append("invalid cursor")
+			}
+			apiQuery.Cursor = cursor
+		}
+	}
+	if qTotal := query.Get("total"); qTotal != "" {
+		total, err := strconv.ParseBool(qTotal)
+		if err != nil {
+			slog.WarnContext(ctx, "invalid total", "total", qTotal)
+			return apiQuery, NewApiError(400, ApiErrBadRequest).Append("invalid total. must be true or false")
+		}
+		apiQuery.WithTotal = total
+	}
 	return apiQuery, nil
 }
+
+func (q APIQuery) toDaoQuery() dao.Query {
+	if q.Limit <= 0 && maxPageSize < defaultMaxPageSize {
+		q.Limit = maxPageSize
+	}
+	return dao.Query{
+		SortCol:   q.SortCol,
+		Sort:      q.Sort,
+		Limit:     q.Limit,
+		Offset:    q.Offset,
+		Cursor:    q.Cursor,
+		WithTotal: q.WithTotal,
+	}
+}
+
+// queryError turns the dao's rejection of a caller supplied sort into a bad
+// request. Other errors are returned as is.
+func queryError(err error) error {
+	if errors.Is(err, dao.ErrInvalidSortColumn) {
+		return NewApiError(400, ApiErrBadRequest).Append("invalid sort_column")
+	}
+	if errors.Is(err, dao.ErrInvalidSortOrder) {
+		return NewApiError(400, ApiErrBadRequest).Append("invalid sort. must be asc or desc")
+	}
+	if errors.Is(err, dao.ErrInvalidCursor) {
+		return NewApiError(400, ApiErrBadRequest).Append("invalid cursor")
+	}
+	return err
+}
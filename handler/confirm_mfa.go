@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+// ConfirmMFA turns MFA on with a first code from the authenticator and
+// returns the recovery codes. They are not shown again.
+func (c *MFAController) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "confirm mfa request")
+	vars := mux.Vars(r)
+	req := MFACodeRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding confirm mfa request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	codes, err := c.confirmMFA(ctx, vars["user_id"], req)
+	if err != nil {
+		handleMFAError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "turned on mfa", "user_id", vars["user_id"])
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (c *MFAController) confirmMFA(ctx context.Context, userID string, req MFACodeRequest) ([]string, error) {
+	if err := requireSelf(ctx, userID); err != nil {
+		return nil, err
+	}
+	if err := validateMFACodeRequest(req); err != nil {
+		return nil, err
+	}
+
+	return c.mfa.Confirm(ctx, userID, req.Code)
+}
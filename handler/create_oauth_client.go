@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+const maxOAuthClientName = 100
+
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	// Public clients, such as mobile apps, get no secret.
+	Public bool `json:"public"`
+}
+
+// oauthClientResponse is the only time the client secret is sent.
+type oauthClientResponse struct {
+	model.OAuthClient
+	Secret string `json:"client_secret,omitempty"`
+}
+
+func handleCreateOAuthClientError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error creating oauth client", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error creating oauth client", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// CreateOAuthClient registers a third-party app.
+func (c *AuthorizationController) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "create oauth client request")
+	req := CreateOAuthClientRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding create oauth client request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	resp, err := c.createOAuthClient(ctx, req)
+	if err != nil {
+		handleCreateOAuthClientError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "created oauth client", "client_id", resp.ID, "scopes", resp.Scopes)
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (c *AuthorizationController) createOAuthClient(ctx context.Context, req CreateOAuthClientRequest) (oauthClientResponse, error) {
+	if err := validateCreateOAuthClientRequest(&req); err != nil {
+		return oauthClientResponse{}, fmt.Errorf("failed to validate create oauth client request. %w", err)
+	}
+
+	client, secret, err := auth.NewOAuthClient(req.Name, req.RedirectURIs, req.Scopes, req.Public)
+	if err != nil {
+		return oauthClientResponse{}, err
+	}
+
+	client, err = c.clients.InsertOAuthClient(ctx, client)
+	if err != nil {
+		return oauthClientResponse{}, fmt.Errorf("failed to insert oauth client. %w", err)
+	}
+
+	return oauthClientResponse{OAuthClient: client, Secret: secret}, nil
+}
+
+func validateCreateOAuthClientRequest(req *CreateOAuthClientRequest) error {
+	apiErr := NewApiError(400, ApiErrBadRequest)
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		apiErr.Append("name is required")
+	} else if len(req.Name) > maxOAuthClientName {
+		apiErr.Append(fmt.Sprintf("name must be at most %d characters", maxOAuthClientName))
+	}
+
+	if len(req.RedirectURIs) == 0 {
+		apiErr.Append("at least one redirect uri is required")
+	}
+	for _, uri := range req.RedirectURIs {
+		if err := validateRedirectURI(uri); err != "" {
+			apiErr.Append(fmt.Sprintf("redirect uri %q %s", uri, err))
+		}
+	}
+	slices.Sort(req.RedirectURIs)
+	req.RedirectURIs = slices.Compact(req.RedirectURIs)
+
+	if len(req.Scopes) == 0 {
+		apiErr.Append("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !model.ValidScope(scope) {
+			apiErr.Append(fmt.Sprintf("unknown scope %q, must be one of %s", scope, strings.Join(model.Scopes, ", ")))
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	if apiErr.HasError() {
+		return apiErr
+	}
+
+	return nil
+}
+
+// validateRedirectURI says what is wrong with the uri, if anything. Codes are
+// only sent over https, or plain http to the developer's own machine.
+func validateRedirectURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return "must be an absolute url"
+	}
+	if u.Fragment != "" {
+		return "must not have a fragment"
+	}
+	local := u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"
+	if u.Scheme != "https" && (u.Scheme != "http" || !local) {
+		return "must use https"
+	}
+
+	return ""
+}
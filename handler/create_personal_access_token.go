@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+const maxPersonalAccessTokenName = 100
+
+type CreatePersonalAccessTokenRequest struct {
+	Name    string     `json:"name"`
+	Scopes  []string   `json:"scopes"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// personalAccessTokenResponse is the only time the token itself is sent.
+type personalAccessTokenResponse struct {
+	model.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+func handleCreatePersonalAccessTokenError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error creating personal access token", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error creating personal access token", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error creating personal access token", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// CreatePersonalAccessToken mints a token for the caller. Admins cannot mint
+// tokens for other users.
+func (c *AccessTokenController) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "create personal access token request")
+	vars := mux.Vars(r)
+	req := CreatePersonalAccessTokenRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding create personal access token request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	resp, err := c.createPersonalAccessToken(ctx, vars["user_id"], req)
+	if err != nil {
+		handleCreatePersonalAccessTokenError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "created personal access token", "user_id", resp.UserID, "token_id", resp.ID, "scopes", resp.Scopes)
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (c *AccessTokenController) createPersonalAccessToken(ctx context.Context, userID string, req CreatePersonalAccessTokenRequest) (personalAccessTokenResponse, error) {
+	if rc := request.GetRequestContext(ctx); rc == nil || rc.UserID != userID {
+		return personalAccessTokenResponse{}, NewApiError(403, ApiErrForbidden).Append("tokens can only be created for yourself")
+	}
+
+	if err := validateCreatePersonalAccessTokenRequest(&req, time.Now()); err != nil {
+		return personalAccessTokenResponse{}, fmt.Errorf("failed to validate create personal access token request. %w", err)
+	}
+
+	token, secret, err := auth.NewPersonalAccessToken(userID, req.Name, req.Scopes, req.Expires)
+	if err != nil {
+		return personalAccessTokenResponse{}, err
+	}
+
+	token, err = c.pats.InsertPersonalAccessToken(ctx, token)
+	if err != nil {
+		return personalAccessTokenResponse{}, fmt.Errorf("failed to insert personal access token. %w", err)
+	}
+
+	return personalAccessTokenResponse{PersonalAccessToken: token, Token: secret}, nil
+}
+
+func validateCreatePersonalAccessTokenRequest(req *CreatePersonalAccessTokenRequest, now time.Time) error {
+	apiErr := NewApiError(400, ApiErrBadRequest)
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		apiErr.Append("name is required")
+	} else if len(req.Name) > maxPersonalAccessTokenName {
+		apiErr.Append(fmt.Sprintf("name must be at most %d characters", maxPersonalAccessTokenName))
+	}
+
+	if len(req.Scopes) == 0 {
+		apiErr.Append("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !model.ValidScope(scope) {
+			apiErr.Append(fmt.Sprintf("unknown scope %q, must be one of %s", scope, strings.Join(model.Scopes, ", ")))
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	if req.Expires != nil && !req.Expires.After(now) {
+		apiErr.Append("expires must be in the future")
+	}
+
+	if apiErr.HasError() {
+		return apiErr
+	}
+
+	return nil
+}
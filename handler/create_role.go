@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// CreateRole adds a role granting the permissions given.
+func (c *RoleController) CreateRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "create role request")
+	req := roleRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding create role request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	role, err := c.createRole(ctx, req)
+	if err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "created role", "role_id", role.ID, "permissions", role.Permissions)
+	request.RespondWithJSON(w, http.StatusCreated, role)
+}
+
+func (c *RoleController) createRole(ctx context.Context, req roleRequest) (model.Role, error) {
+	if err := requirePermission(ctx, model.PermissionRolesWrite); err != nil {
+		return model.Role{}, err
+	}
+	if err := validateRoleRequest(&req); err != nil {
+		return model.Role{}, err
+	}
+
+	return c.roles.InsertRole(ctx, model.Role{
+		Name:        req.Name,
+		Permissions: req.Permissions,
+		IsDefault:   req.IsDefault,
+	})
+}
@@ -16,6 +16,8 @@ import (
 	"github.com/slham/sandbox-api/valid"
 )
 
+const weakPasswordMessage = "password must be at least 8 characters long and contain at least one number, one special character, one upper case character, and one lower case character"
+
 type createUserRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -53,6 +55,13 @@ func (c *UserController) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the account is usable without verifying, so a failed email only means
+	// the user has to ask for another one
+	if err := c.verifier.Send(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "failed to send verification email", "user_id", user.ID, "err", err)
+	}
+
+	setETag(w, user.Version)
 	request.RespondWithJSON(w, http.StatusCreated, user)
 }
 
@@ -64,22 +73,22 @@ func (c *UserController) createUser(ctx context.Context, req createUserRequest)
 	}
 
 	var err error
-	user.Password, err = crypt.Encrypt(req.Password)
+	user.Password, err = crypt.HashPassword(req.Password)
 	if err != nil {
-		return ctx, user, fmt.Errorf("failed to encrypt password. %w", err)
+		return ctx, user, fmt.Errorf("failed to hash password. %w", err)
 	}
 
-	role, err := dao.GetRole(ctx, dao.RoleQuery{Name: "CIVILIAN"})
+	roles, err := defaultRoles(ctx, c.roles)
 	if err != nil {
-		return ctx, user, fmt.Errorf("failed to get default user role. %w", err)
+		return ctx, user, err
 	}
 
 	user.ID = newUserID()
 	user.Username = req.Username
 	user.Email = req.Email
-	user.Roles = []model.Role{role}
+	user.Roles = roles
 
-	user, err = dao.InsertUser(ctx, user)
+	user, err = c.users.InsertUser(ctx, user)
 	if err != nil {
 		if errors.Is(err, dao.ErrConflictUsername) {
 			return ctx, user, NewApiError(409, ApiErrConflict).Append("username already exists")
@@ -104,8 +113,7 @@ func validateCreateUserRequest(ctx context.Context, req createUserRequest) error
 	}
 
 	if ok := valid.IsMediumPassword(req.Password); !ok {
-		passwordErrMsg := "password must be at least 8 characters long and contain at least one number, one special character, one upper case character, and one lower case character"
-		apiErr = apiErr.Append(passwordErrMsg)
+		apiErr = apiErr.Append(weakPasswordMessage)
 	}
 
 	if err := valid.IsEmail(req.Email); err != nil {
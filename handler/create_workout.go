@@ -23,6 +23,12 @@ func handleCreateWorkoutError(ctx context.Context, w http.ResponseWriter, err er
 		return
 	}
 
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error creating workout", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
 	if errors.Is(err, ApiErrConflict) {
 		slog.WarnContext(ctx, "error creating workout", "err", err)
 		request.RespondWithError(w, http.StatusConflict, err.Error())
@@ -47,18 +53,23 @@ func (c *WorkoutController) CreateWorkout(w http.ResponseWriter, r *http.Request
 	}
 
 	workout.UserID = userID
-	workout, err := c.createWorkout(ctx, workout)
+	err := c.workouts.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		workout, err = c.createWorkout(ctx, workout)
+		return err
+	})
 	if err != nil {
 		handleCreateWorkoutError(ctx, w, err)
 		return
 	}
 
+	setETag(w, workout.Version)
 	request.RespondWithJSON(w, http.StatusCreated, workout)
 }
 
 func (c *WorkoutController) createWorkout(ctx context.Context, workout model.Workout) (model.Workout, error) {
 	slog.DebugContext(ctx, "createWorkout", "userID", workout.UserID)
-	if _, err := dao.GetUserByID(ctx, workout.UserID); err != nil {
+	if _, err := c.users.GetUserByID(ctx, workout.UserID); err != nil {
 		return workout, NewApiError(404, ApiErrNotFound).Append("user does not exist")
 	}
 
@@ -68,7 +79,7 @@ func (c *WorkoutController) createWorkout(ctx context.Context, workout model.Wor
 
 	workout.ID = newWorkoutID()
 
-	workout, err := dao.InsertWorkout(ctx, workout)
+	workout, err := c.workouts.InsertWorkout(ctx, workout)
 	if err != nil {
 		if errors.Is(err, dao.ErrConflictWorkoutName) {
 			return workout, NewApiError(http.StatusConflict, ApiErrConflict).Append("workout name already exists")
@@ -76,6 +87,10 @@ func (c *WorkoutController) createWorkout(ctx context.Context, workout model.Wor
 		return workout, fmt.Errorf("failed to insert workout. %w", err)
 	}
 
+	if err := c.recordRevision(ctx, workout); err != nil {
+		return workout, err
+	}
+
 	return workout, nil
 }
 
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+type csrfResponse struct {
+	Token string `json:"csrf_token"`
+}
+
+// CSRF hands the browser the token to send in the X-CSRF-Token header with
+// requests that change state. Only the frontend's origin can read it.
+func (c *AuthController) CSRF(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token, err := auth.CSRFToken(w, r)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get csrf token", "err", err)
+		request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusOK, csrfResponse{Token: token})
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+// DeactivateUser closes the user's own account and logs them out everywhere.
+// The account is kept, and an admin can reactivate it.
+func (c *AccountController) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "deactivate user request")
+	vars := mux.Vars(r)
+
+	n, err := c.deactivateUser(ctx, vars["user_id"])
+	if err != nil {
+		handleAccountError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, revokedSessions{Revoked: n})
+}
+
+func (c *AccountController) deactivateUser(ctx context.Context, userID string) (int, error) {
+	if err := requireSelf(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	return c.accounts.Deactivate(ctx, userID)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleDeleteOAuthClientError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, dao.ErrOAuthClientNotFound) {
+		slog.WarnContext(ctx, "error deleting oauth client", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, "client not found")
+		return
+	}
+
+	slog.ErrorContext(ctx, "error deleting oauth client", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// DeleteOAuthClient removes a third-party app. Every user's grant to it and
+// every token it holds go with it.
+func (c *AuthorizationController) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "delete oauth client request")
+	vars := mux.Vars(r)
+
+	if err := c.clients.DeleteOAuthClient(ctx, vars["client_id"]); err != nil {
+		handleDeleteOAuthClientError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "deleted oauth client", "client_id", vars["client_id"])
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
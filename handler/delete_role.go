@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// DeleteRole deletes a role and takes it away from everyone who has it.
+func (c *RoleController) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "delete role request")
+	vars := mux.Vars(r)
+
+	if err := c.deleteRole(ctx, vars["role_id"]); err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (c *RoleController) deleteRole(ctx context.Context, roleID string) error {
+	if err := requirePermission(ctx, model.PermissionRolesWrite); err != nil {
+		return err
+	}
+	id, err := parseRoleID(roleID)
+	if err != nil {
+		return err
+	}
+
+	if err := c.roles.DeleteRole(ctx, id); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "deleted role", "role_id", id)
+	return nil
+}
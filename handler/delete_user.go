@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -18,6 +17,12 @@ type deleteUserRequest struct {
 }
 
 func handleDeleteUserError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error deleting user", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
 	slog.ErrorContext(ctx, "error deleting user", "err", err)
 	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
 	return
@@ -31,9 +36,11 @@ func (c *UserController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	req := deleteUserRequest{UserID: userID}
 
-	err := c.deleteUser(ctx, req)
+	err := c.users.WithTx(ctx, func(ctx context.Context) error {
+		return c.deleteUser(ctx, req)
+	})
 	if err != nil {
-		handleDeleteWorkoutError(ctx, w, err)
+		handleDeleteUserError(ctx, w, err)
 		return
 	}
 
@@ -44,13 +51,13 @@ func (c *UserController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 func (c *UserController) deleteUser(ctx context.Context, req deleteUserRequest) error {
 	_, err := c.getUserByID(ctx, getUserRequest{ID: req.UserID})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, dao.ErrUserNotFound) {
 			return NewApiError(404, ApiErrNotFound)
 		}
 		return fmt.Errorf("failed to delete user. %w", err)
 	}
 
-	err = dao.DeleteUser(ctx, req.UserID)
+	err = c.users.DeleteUser(ctx, req.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to delete user. %w", err)
 	}
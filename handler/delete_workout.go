@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -19,6 +18,12 @@ type deleteWorkoutRequest struct {
 }
 
 func handleDeleteWorkoutError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error deleting workout", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
 	slog.ErrorContext(ctx, "error deleting workout", "err", err)
 	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
 	return
@@ -48,13 +53,13 @@ func (c *WorkoutController) DeleteWorkout(w http.ResponseWriter, r *http.Request
 func (c *WorkoutController) deleteWorkout(ctx context.Context, req deleteWorkoutRequest) error {
 	_, err := c.getWorkoutByID(ctx, getWorkoutRequest{UserID: req.UserID, WorkoutID: req.WorkoutID})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, dao.ErrWorkoutNotFound) {
 			return NewApiError(404, ApiErrNotFound)
 		}
 		return fmt.Errorf("failed to delete workout. %w", err)
 	}
 
-	err = dao.DeleteWorkout(ctx, req.UserID, req.WorkoutID)
+	err = c.workouts.DeleteWorkout(ctx, req.UserID, req.WorkoutID)
 	if err != nil {
 		return fmt.Errorf("failed to delete workout. %w", err)
 	}
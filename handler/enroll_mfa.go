@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+const mfaQRSize = 256
+
+type enrollMFAResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	// QRCode is a data URI of a PNG, sent when asked for with qr=true.
+	QRCode string `json:"qr_code,omitempty"`
+}
+
+// EnrollMFA starts TOTP enrollment. It has no effect on logins until it is
+// confirmed with a code.
+func (c *MFAController) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "enroll mfa request")
+	vars := mux.Vars(r)
+
+	resp, err := c.enrollMFA(ctx, vars["user_id"], r.URL.Query().Get("qr") == "true")
+	if err != nil {
+		handleMFAError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "started mfa enrollment", "user_id", vars["user_id"])
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (c *MFAController) enrollMFA(ctx context.Context, userID string, qr bool) (enrollMFAResponse, error) {
+	if err := requireSelf(ctx, userID); err != nil {
+		return enrollMFAResponse{}, err
+	}
+
+	user, err := c.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return enrollMFAResponse{}, NewApiError(404, ApiErrNotFound).Append("user does not exist")
+	}
+
+	enrollment, err := c.mfa.Enroll(ctx, user)
+	if err != nil {
+		return enrollMFAResponse{}, err
+	}
+
+	resp := enrollMFAResponse{Secret: enrollment.Secret, URI: enrollment.URI}
+	if qr {
+		png, err := enrollment.QR(mfaQRSize)
+		if err != nil {
+			return resp, fmt.Errorf("failed to make qr code. %w", err)
+		}
+		resp.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	}
+
+	return resp, nil
+}
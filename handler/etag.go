@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// IfMatchMode decides what happens to writes that arrive without an If-Match
+// header.
+type IfMatchMode int
+
+const (
+	// IfMatchOptional applies writes without If-Match unconditionally.
+	IfMatchOptional IfMatchMode = iota
+	// IfMatchRequired rejects writes without If-Match with 428 Precondition
+	// Required.
+	IfMatchRequired
+)
+
+var ifMatchMode = IfMatchOptional
+
+func SetIfMatchMode(mode IfMatchMode) {
+	ifMatchMode = mode
+}
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// ifMatchVersion returns the version named by the request's If-Match header,
+// or 0 when the write should be applied regardless of the stored version.
+func ifMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if ifMatchMode == IfMatchRequired {
+			return 0, NewApiError(428, ApiErrPreconditionRequired).Append("If-Match header is required")
+		}
+		return 0, nil
+	}
+
+	if header == "*" {
+		return 0, nil
+	}
+
+	tag := strings.TrimPrefix(header, "W/")
+	version, err := strconv.Atoi(strings.Trim(tag, `"`))
+	if err != nil || version <= 0 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+		return 0, NewApiError(400, ApiErrBadRequest).Append("invalid If-Match header")
+	}
+
+	return version, nil
+}
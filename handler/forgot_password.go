@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/request"
+	"github.com/slham/sandbox-api/valid"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ForgotPassword mails a reset link to the address, if it belongs to a user
+// who was not sent one too recently. The answer is the same either way, and
+// the email is sent after answering so the timing does not tell either.
+func (c *PasswordController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "forgot password request")
+	req := forgotPasswordRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding forgot password request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	if err := valid.IsEmail(req.Email); err != nil {
+		request.RespondWithError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+
+	go c.forgotPassword(context.WithoutCancel(ctx), req)
+
+	request.RespondWithJSON(w, http.StatusAccepted, nil)
+}
+
+func (c *PasswordController) forgotPassword(ctx context.Context, req forgotPasswordRequest) {
+	if err := c.passwords.Forgot(ctx, req.Email); err != nil {
+		slog.ErrorContext(ctx, "failed to send password reset", "err", err)
+	}
+}
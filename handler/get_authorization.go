@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+// GetAuthorization is where the consent screen starts. The app sends the
+// user's browser there with its authorization request in the query, and the
+// screen shows the app and the scopes it wants.
+func (c *AuthorizationController) GetAuthorization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get authorization request")
+	query := r.URL.Query()
+	req := auth.AuthorizationRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	consent, err := c.server.Authorize(ctx, request.GetRequestContext(ctx).UserID, req)
+	if err != nil {
+		handleAuthorizationError(ctx, w, req, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusOK, consent)
+}
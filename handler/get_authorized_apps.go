@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleGetAuthorizedAppsError(ctx context.Context, w http.ResponseWriter, err error) {
+	slog.ErrorContext(ctx, "error getting authorized apps", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// GetAuthorizedApps lists the apps the user has let into their account, and
+// the scopes each has.
+func (c *AuthorizationController) GetAuthorizedApps(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get authorized apps request")
+	vars := mux.Vars(r)
+
+	grants, err := c.clients.GetUserOAuthGrants(ctx, vars["user_id"])
+	if err != nil {
+		handleGetAuthorizedAppsError(ctx, w, fmt.Errorf("failed to get oauth grants. %w", err))
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, grants)
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+type mfaStatus struct {
+	Enabled       bool `json:"enabled"`
+	Pending       bool `json:"pending"`
+	RecoveryCodes int  `json:"recovery_codes"`
+}
+
+// GetMFA reports whether the user has MFA on and how many recovery codes they
+// have left.
+func (c *MFAController) GetMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get mfa request")
+	vars := mux.Vars(r)
+
+	mfa, err := c.mfa.Status(ctx, vars["user_id"])
+	if errors.Is(err, auth.ErrMFANotEnabled) {
+		request.RespondWithJSON(w, http.StatusOK, mfaStatus{})
+		return
+	}
+	if err != nil {
+		handleMFAError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, mfaStatus{
+		Enabled:       mfa.Confirmed != nil,
+		Pending:       mfa.Confirmed == nil,
+		RecoveryCodes: mfa.RecoveryCodes,
+	})
+}
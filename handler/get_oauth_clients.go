@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleGetOAuthClientsError(ctx context.Context, w http.ResponseWriter, err error) {
+	slog.ErrorContext(ctx, "error getting oauth clients", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// GetOAuthClients lists the registered third-party apps.
+func (c *AuthorizationController) GetOAuthClients(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get oauth clients request")
+
+	clients, err := c.clients.GetOAuthClients(ctx)
+	if err != nil {
+		handleGetOAuthClientsError(ctx, w, fmt.Errorf("failed to get oauth clients. %w", err))
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, clients)
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+type passwordReport struct {
+	Total  int `json:"total"`
+	Legacy int `json:"legacy"`
+	Hashed int `json:"hashed"`
+}
+
+func handleGetPasswordReportError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error getting password report", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error getting password report", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+	return
+}
+
+// GetPasswordReport reports how many accounts still have a password in the
+// legacy reversible format. They are rehashed as their owners log in.
+func (c *AdminController) GetPasswordReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get password report request")
+
+	report, err := c.getPasswordReport(ctx)
+	if err != nil {
+		handleGetPasswordReportError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, report)
+}
+
+func (c *AdminController) getPasswordReport(ctx context.Context) (passwordReport, error) {
+	if err := requirePermission(ctx, model.PermissionPasswordsReport); err != nil {
+		return passwordReport{}, err
+	}
+
+	legacy, total, err := c.users.CountLegacyPasswords(ctx)
+	if err != nil {
+		return passwordReport{}, fmt.Errorf("failed to count legacy passwords. %w", err)
+	}
+
+	return passwordReport{Total: total, Legacy: legacy, Hashed: total - legacy}, nil
+}
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+type permissionsResponse struct {
+	Permissions []string `json:"permissions"`
+}
+
+// GetPermissions lists the permissions roles can grant.
+func (c *RoleController) GetPermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := requirePermission(ctx, model.PermissionRolesRead); err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	slog.DebugContext(ctx, "get permissions request")
+	request.RespondWithJSON(w, http.StatusOK, permissionsResponse{Permissions: model.Permissions})
+}
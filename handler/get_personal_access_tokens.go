@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleGetPersonalAccessTokensError(ctx context.Context, w http.ResponseWriter, err error) {
+	slog.ErrorContext(ctx, "error getting personal access tokens", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// GetPersonalAccessTokens lists the user's tokens that have not been revoked.
+// Admins can list anyone's.
+func (c *AccessTokenController) GetPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get personal access tokens request")
+	vars := mux.Vars(r)
+
+	tokens, err := c.pats.GetUserPersonalAccessTokens(ctx, vars["user_id"])
+	if err != nil {
+		handleGetPersonalAccessTokensError(ctx, w, fmt.Errorf("failed to get personal access tokens. %w", err))
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, tokens)
+}
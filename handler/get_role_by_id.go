@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+func (c *RoleController) GetRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get role request")
+	vars := mux.Vars(r)
+
+	role, err := c.getRole(ctx, vars["role_id"])
+	if err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, role)
+}
+
+func (c *RoleController) getRole(ctx context.Context, roleID string) (model.Role, error) {
+	if err := requirePermission(ctx, model.PermissionRolesRead); err != nil {
+		return model.Role{}, err
+	}
+	id, err := parseRoleID(roleID)
+	if err != nil {
+		return model.Role{}, err
+	}
+
+	return c.roles.GetRoleByID(ctx, id)
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// GetRoles lists every role with the permissions it grants.
+func (c *RoleController) GetRoles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get roles request")
+
+	roles, err := c.getRoles(ctx)
+	if err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, roles)
+}
+
+func (c *RoleController) getRoles(ctx context.Context) ([]model.Role, error) {
+	if err := requirePermission(ctx, model.PermissionRolesRead); err != nil {
+		return nil, err
+	}
+
+	return c.roles.GetRoles(ctx, dao.RoleQuery{})
+}
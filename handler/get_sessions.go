@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleGetSessionsError(ctx context.Context, w http.ResponseWriter, err error) {
+	slog.ErrorContext(ctx, "error getting sessions", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+	return
+}
+
+type sessionResponse struct {
+	model.Session
+	Current bool `json:"current"`
+}
+
+// GetSessions lists the user's live sessions, marking the one the request was
+// made with.
+func (c *SessionController) GetSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get sessions request")
+	vars := mux.Vars(r)
+
+	sessions, err := c.sessions.GetUserSessions(ctx, vars["user_id"])
+	if err != nil {
+		handleGetSessionsError(ctx, w, fmt.Errorf("failed to get sessions. %w", err))
+		return
+	}
+
+	current := ""
+	if rc := request.GetRequestContext(ctx); rc != nil {
+		current = rc.SessionID
+	}
+
+	resp := make([]sessionResponse, len(sessions))
+	for i := range sessions {
+		resp[i] = sessionResponse{Session: sessions[i], Current: sessions[i].ID == current}
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+// GetTrash lists the user's deleted workouts. It takes the same query
+// parameters as GetWorkouts.
+func (c *WorkoutController) GetTrash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get trash request")
+	vars := mux.Vars(r)
+	userID := vars["user_id"]
+	req := getWorkoutsRequest{userID: userID, trashed: true}
+	q, err := getWorkoutsQueryParams(ctx, r.URL.Query())
+	if err != nil {
+		handleGetWorkoutsError(ctx, w, err)
+		return
+	}
+
+	req.query = q
+
+	if q.Paginate {
+		page, err := c.listWorkouts(ctx, req)
+		if err != nil {
+			handleGetWorkoutsError(ctx, w, err)
+			return
+		}
+		respondWithPage(w, r, page)
+		return
+	}
+
+	workouts, err := c.getWorkouts(ctx, req)
+	if err != nil {
+		handleGetWorkoutsError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, workouts)
+	return
+}
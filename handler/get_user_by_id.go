@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -41,14 +40,15 @@ func (c *UserController) GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, user.Version)
 	request.RespondWithJSON(w, http.StatusOK, user)
 	return
 }
 
 func (c *UserController) getUserByID(ctx context.Context, req getUserRequest) (model.User, error) {
-	user, err := dao.GetUserByID(ctx, req.ID)
+	user, err := c.users.GetUser(ctx, dao.UserQuery{ID: req.ID, HidePassword: true, WithRoles: true})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, dao.ErrUserNotFound) {
 			return user, NewApiError(404, ApiErrNotFound)
 		}
 		return user, fmt.Errorf("failed to get user by id. %w", err)
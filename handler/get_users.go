@@ -70,6 +70,16 @@ func (c *UserController) GetUsers(w http.ResponseWriter, r *http.Request) {
 
 	req.query = q
 
+	if q.Paginate {
+		page, err := c.listUsers(ctx, req)
+		if err != nil {
+			handleGetUsersError(ctx, w, err)
+			return
+		}
+		respondWithPage(w, r, page)
+		return
+	}
+
 	users, err := c.getUsers(ctx, req)
 	if err != nil {
 		handleGetUsersError(ctx, w, err)
@@ -81,25 +91,35 @@ func (c *UserController) GetUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *UserController) getUsers(ctx context.Context, req getUsersRequest) ([]model.User, error) {
-	q := dao.UserQuery{
-		ID:       req.query.ID,
-		Username: req.query.Username,
-		Email:    req.query.Email,
-		Query: dao.Query{
-			SortCol: req.query.APIQuery.SortCol,
-			Sort:    req.query.APIQuery.Sort,
-			Limit:   req.query.APIQuery.Limit,
-			Offset:  req.query.APIQuery.Offset,
-		},
-	}
-
-	users, err := dao.GetUsers(ctx, q)
+	users, err := c.users.GetUsers(ctx, req.query.toDaoQuery())
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return users, nil
 		}
-		return users, fmt.Errorf("failed to get users. %w", err)
+		return users, fmt.Errorf("failed to get users. %w", queryError(err))
 	}
 
 	return users, nil
 }
+
+func (c *UserController) listUsers(ctx context.Context, req getUsersRequest) (dao.Page[model.User], error) {
+	page, err := c.users.ListUsers(ctx, req.query.toDaoQuery())
+	if err != nil {
+		return page, fmt.Errorf("failed to list users. %w", queryError(err))
+	}
+
+	return page, nil
+}
+
+func (q getUsersQuery) toDaoQuery() dao.UserQuery {
+	return dao.UserQuery{
+		ID:            q.ID,
+		Username:      q.Username,
+		Email:         q.Email,
+		WithRoles:     true,
+		Search:        q.APIQuery.Search,
+		CreatedAfter:  q.APIQuery.CreatedAfter,
+		CreatedBefore: q.APIQuery.CreatedBefore,
+		Query:         q.APIQuery.toDaoQuery(),
+	}
+}
@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -43,14 +42,15 @@ func (c *WorkoutController) GetWorkout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setETag(w, workout.Version)
 	request.RespondWithJSON(w, http.StatusOK, workout)
 	return
 }
 
 func (c *WorkoutController) getWorkoutByID(ctx context.Context, req getWorkoutRequest) (model.Workout, error) {
-	workout, err := dao.GetWorkoutByID(ctx, req.UserID, req.WorkoutID)
+	workout, err := c.workouts.GetWorkoutByID(ctx, req.UserID, req.WorkoutID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, dao.ErrWorkoutNotFound) {
 			return workout, NewApiError(404, ApiErrNotFound)
 		}
 		return workout, fmt.Errorf("failed to get workout by id. %w", err)
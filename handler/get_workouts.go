@@ -20,8 +20,9 @@ type getWorkoutsQuery struct {
 }
 
 type getWorkoutsRequest struct {
-	userID string
-	query  getWorkoutsQuery
+	userID  string
+	trashed bool
+	query   getWorkoutsQuery
 }
 
 func getWorkoutsQueryParams(ctx context.Context, q url.Values) (getWorkoutsQuery, error) {
@@ -61,6 +62,16 @@ func (c *WorkoutController) GetWorkouts(w http.ResponseWriter, r *http.Request)
 
 	req.query = q
 
+	if q.Paginate {
+		page, err := c.listWorkouts(ctx, req)
+		if err != nil {
+			handleGetWorkoutsError(ctx, w, err)
+			return
+		}
+		respondWithPage(w, r, page)
+		return
+	}
+
 	workouts, err := c.getWorkouts(ctx, req)
 	if err != nil {
 		handleGetWorkoutsError(ctx, w, err)
@@ -72,21 +83,31 @@ func (c *WorkoutController) GetWorkouts(w http.ResponseWriter, r *http.Request)
 }
 
 func (c *WorkoutController) getWorkouts(ctx context.Context, req getWorkoutsRequest) ([]model.Workout, error) {
-	q := dao.WorkoutQuery{
-		UserID: req.userID,
-		Query: dao.Query{
-			SortCol: req.query.APIQuery.SortCol,
-			Sort:    req.query.APIQuery.Sort,
-			Limit:   req.query.APIQuery.Limit,
-			Offset:  req.query.APIQuery.Offset,
-		},
-	}
-	workouts, err := dao.GetWorkouts(ctx, q)
+	workouts, err := c.workouts.GetWorkouts(ctx, req.toDaoQuery())
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return workouts, nil
 		}
-		return workouts, fmt.Errorf("failed to get workouts. %w", err)
+		return workouts, fmt.Errorf("failed to get workouts. %w", queryError(err))
 	}
 	return workouts, nil
 }
+
+func (c *WorkoutController) listWorkouts(ctx context.Context, req getWorkoutsRequest) (dao.Page[model.Workout], error) {
+	page, err := c.workouts.ListWorkouts(ctx, req.toDaoQuery())
+	if err != nil {
+		return page, fmt.Errorf("failed to list workouts. %w", queryError(err))
+	}
+	return page, nil
+}
+
+func (req getWorkoutsRequest) toDaoQuery() dao.WorkoutQuery {
+	return dao.WorkoutQuery{
+		UserID:        req.userID,
+		Trashed:       req.trashed,
+		Search:        req.query.APIQuery.Search,
+		CreatedAfter:  req.query.APIQuery.CreatedAfter,
+		CreatedBefore: req.query.APIQuery.CreatedBefore,
+		Query:         req.query.APIQuery.toDaoQuery(),
+	}
+}
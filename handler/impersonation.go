@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+type ImpersonationController struct {
+	impersonations *auth.ImpersonationService
+}
+
+func NewImpersonationController(impersonations *auth.ImpersonationService) ImpersonationController {
+	return ImpersonationController{
+		impersonations: impersonations,
+	}
+}
+
+func handleImpersonationError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error impersonation", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	} else if errors.Is(err, dao.ErrUserNotFound) {
+		slog.WarnContext(ctx, "error impersonation", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, "user not found")
+		return
+	} else if errors.Is(err, auth.ErrImpersonationNotAllowed) {
+		slog.WarnContext(ctx, "error impersonation", "err", err)
+		request.RespondWithErrorCode(w, http.StatusForbidden, auth.CodeImpersonating, err.Error())
+		return
+	} else if errors.Is(err, auth.ErrNotImpersonating) {
+		slog.WarnContext(ctx, "error impersonation", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error impersonation", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// StartImpersonation signs the admin in as the user until they end it or it
+// expires. The session is returned so the admin knows when that is.
+func (c *ImpersonationController) StartImpersonation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "start impersonation request")
+	vars := mux.Vars(r)
+
+	if err := requirePermission(ctx, model.PermissionUsersImpersonate); err != nil {
+		handleImpersonationError(ctx, w, err)
+		return
+	}
+
+	session, err := c.impersonations.Start(w, r, vars["user_id"])
+	if err != nil {
+		handleImpersonationError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusCreated, session)
+}
+
+// EndImpersonation signs the admin back in as themselves.
+func (c *ImpersonationController) EndImpersonation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "end impersonation request")
+
+	if err := c.impersonations.End(w, r); err != nil {
+		handleImpersonationError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,101 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/middlewares"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonation(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	store := auth.NewPostgresSessionStore(repo, repo, 0)
+	c := NewAuthController(store, auth.NewTokenService(repo, repo, auth.TokenConfig{}), nil, repo, repo, repo, nil)
+	ic := NewImpersonationController(auth.NewImpersonationService(repo, repo, repo))
+	verifySession := middlewares.Verify(store)
+	start := middlewares.Chain(ic.StartImpersonation, verifySession, middlewares.RequirePermission(model.PermissionUsersImpersonate))
+	end := middlewares.Chain(ic.EndImpersonation, verifySession)
+	blocked := middlewares.Chain(func(w http.ResponseWriter, r *http.Request) {
+		request.RespondWithJSON(w, http.StatusNoContent, nil)
+	}, middlewares.BlockImpersonation, verifySession, middlewares.RequirePermission(model.PermissionUsersWrite))
+
+	users := NewUserController(repo, repo, nil)
+	user := createUser(t, users, "impersonated", "i@b.c")
+	admin := createUser(t, users, "impersonator", "r@b.c")
+	adminRole, err := repo.GetRoleByName(ctx, "ADMIN")
+	assert.NoError(t, err)
+	assert.NoError(t, repo.AddUserRole(ctx, admin.ID, adminRole.ID))
+
+	call := func(f http.HandlerFunc, target string, userID string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, nil)
+		r = r.WithContext(request.WithRequestContext(r.Context(), &request.RequestContext{}))
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		if userID != "" {
+			r = mux.SetURLVars(r, map[string]string{"user_id": userID})
+		}
+		w := httptest.NewRecorder()
+		f(w, r)
+		return w
+	}
+	login := func(username string) *http.Cookie {
+		w := serve(c.Login, "POST", "/auth/login", nil, `{"username": "`+username+`", "password": "thisIsAG00dPassword!"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return sessionCookie(w)
+	}
+
+	userCookie := login("impersonated")
+	adminCookie := login("impersonator")
+
+	w := call(start, "/admin/users/"+admin.ID+"/impersonate", admin.ID, userCookie)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = call(start, "/admin/users/user_nobody/impersonate", "user_nobody", adminCookie)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = call(start, "/admin/users/"+user.ID+"/impersonate", user.ID, adminCookie)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	session := model.Session{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	assert.Equal(t, user.ID, session.UserID)
+	assert.Equal(t, admin.ID, session.ImpersonatorID)
+	impersonation := sessionCookie(w)
+	var stash *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "sandbox-impersonator" {
+			stash = cookie
+		}
+	}
+	assert.NotNil(t, stash)
+
+	// sensitive routes are off limits while impersonating
+	w = call(blocked, "/users/"+user.ID+"/password", user.ID, impersonation)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	body := map[string]string{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, auth.CodeImpersonating, body["code"])
+	w = call(blocked, "/users/"+user.ID+"/password", user.ID, userCookie)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = call(end, "/auth/impersonation/end", "", adminCookie)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = call(end, "/auth/impersonation/end", "", impersonation, stash)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, adminCookie.Value, sessionCookie(w).Value)
+
+	w = call(blocked, "/users/"+user.ID+"/password", user.ID, impersonation)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
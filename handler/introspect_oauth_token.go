@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+// IntrospectOAuthToken tells an app whether one of its tokens is still good,
+// as RFC 7662 has it.
+func (c *AuthorizationController) IntrospectOAuthToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "introspect oauth token request")
+
+	if err := r.ParseForm(); err != nil {
+		handleOAuthTokenError(ctx, w, &auth.OAuthError{Code: auth.OAuthInvalidRequest, Description: "malformed request body"})
+		return
+	}
+
+	introspection, err := c.server.Introspect(ctx, clientCredentials(r), r.PostForm.Get("token"))
+	if err != nil {
+		handleOAuthTokenError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusOK, introspection)
+}
@@ -6,8 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/slham/sandbox-api/auth"
 	"github.com/slham/sandbox-api/crypt"
 	"github.com/slham/sandbox-api/dao"
 	"github.com/slham/sandbox-api/model"
@@ -17,8 +22,20 @@ import (
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// IP is the client's address, which failed logins are counted against.
+	IP string `json:"-"`
 }
 
+// errInvalidLogin is the answer to both unknown usernames and wrong
+// passwords, so logins cannot be used to find accounts.
+const errInvalidLogin = "invalid username or password"
+
+// dummyPasswordHash is checked against when nobody has the username, so the
+// answer takes as long as for a wrong password.
+var dummyPasswordHash = sync.OnceValues(func() (string, error) {
+	return crypt.HashPassword("not a real password")
+})
+
 func handleLoginError(ctx context.Context, w http.ResponseWriter, err error) {
 	if errors.Is(err, ApiErrBadRequest) {
 		slog.WarnContext(ctx, "error login", "err", err)
@@ -32,6 +49,23 @@ func handleLoginError(ctx context.Context, w http.ResponseWriter, err error) {
 		slog.ErrorContext(ctx, "user not found", "err", err)
 		request.RespondWithError(w, http.StatusNotFound, err.Error())
 		return
+	} else if locked := (auth.LoginLockedError{}); errors.As(err, &locked) {
+		slog.WarnContext(ctx, "locked out login attempt", "err", err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		request.RespondWithError(w, http.StatusTooManyRequests, "too many failed logins. try again later")
+		return
+	} else if errors.Is(err, auth.ErrAccountSuspended) || errors.Is(err, auth.ErrAccountDeactivated) {
+		slog.WarnContext(ctx, "login to inactive account", "err", err)
+		auth.RespondWithAccountError(w, err)
+		return
+	} else if errors.Is(err, auth.ErrMFAInvalidCode) {
+		slog.WarnContext(ctx, "wrong mfa code", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, "invalid mfa code")
+		return
+	} else if errors.Is(err, auth.ErrMFAChallengeInvalid) {
+		slog.WarnContext(ctx, "invalid mfa challenge", "err", err)
+		request.RespondWithError(w, http.StatusUnauthorized, "mfa challenge is invalid or expired. log in again")
+		return
 	}
 
 	slog.ErrorContext(ctx, "error login", "err", err)
@@ -49,45 +83,104 @@ func (c *AuthController) Login(w http.ResponseWriter, r *http.Request) {
 		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
 		return
 	}
+	loginRequest.IP = auth.ClientIP(r)
 
-	user, err := handleLogin(ctx, loginRequest)
+	user, err := c.handleLogin(ctx, loginRequest)
 	if err != nil {
 		handleLoginError(ctx, w, err)
 		return
 	}
 
-	c.hydrateSession(w, r, user)
+	challenge, err := c.mfaChallenge(ctx, user)
+	if err != nil {
+		handleLoginError(ctx, w, err)
+		return
+	}
+	if challenge != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		request.RespondWithJSON(w, http.StatusAccepted, challenge)
+		return
+	}
+
+	if err := c.sessions.StartSession(w, r, user); err != nil {
+		handleLoginError(ctx, w, err)
+		return
+	}
 
 	request.RespondWithJSON(w, http.StatusOK, user)
 }
 
-func handleLogin(ctx context.Context, req LoginRequest) (model.User, error) {
+func (c *AuthController) handleLogin(ctx context.Context, req LoginRequest) (model.User, error) {
 	if err := validateLoginRequest(ctx, req); err != nil {
 		return model.User{}, fmt.Errorf("failed to validate login request. %w", err)
 	}
 
-	user, err := dao.GetUserByUsername(ctx, req.Username)
-	if err != nil {
-		if errors.Is(err, dao.ErrUserNotFound) {
-			return user, NewApiError(404, ApiErrNotFound)
-		}
+	if err := c.guard.Check(ctx, req.Username, req.IP); err != nil {
+		return model.User{}, err
+	}
+
+	// read from the primary, so a new password or suspension counts straight
+	// away
+	user, err := c.users.GetUser(ctx, dao.UserQuery{Username: req.Username, WithRoles: true, Primary: true})
+	if err != nil && !errors.Is(err, dao.ErrUserNotFound) {
 		return user, fmt.Errorf("failed to get user. %w", err)
 	}
 
-	plainText, err := crypt.Decrypt(user.Password)
+	stored := user.Password
+	if user.ID == "" {
+		if stored, err = dummyPasswordHash(); err != nil {
+			return user, fmt.Errorf("failed to hash dummy password. %w", err)
+		}
+	}
+
+	ok, rehash, err := crypt.VerifyPassword(req.Password, stored)
 	if err != nil {
 		return user, fmt.Errorf("failed to check password. %w", err)
 	}
 
-	if plainText != req.Password {
-		slog.WarnContext(ctx, "failed login attempt for user", "user_id", user.ID)
-		return user, NewApiError(403, ApiErrForbidden)
+	if !ok || user.ID == "" {
+		slog.WarnContext(ctx, "failed login attempt", "user_id", user.ID, "ip", req.IP)
+		if err := c.guard.Failed(ctx, req.Username, user.ID, req.IP); err != nil {
+			return model.User{}, fmt.Errorf("failed to record failed login. %w", err)
+		}
+		return model.User{}, NewApiError(403, ApiErrForbidden).Append(errInvalidLogin)
+	}
+
+	if err := c.guard.Succeeded(ctx, req.Username); err != nil {
+		return user, fmt.Errorf("failed to clear failed logins. %w", err)
+	}
+
+	// only told once the password is right, so it does not give away accounts
+	if err := auth.CheckAccount(user.AccountState, time.Now()); err != nil {
+		return model.User{}, err
+	}
+
+	if rehash {
+		c.rehashPassword(ctx, user, req.Password)
 	}
 
 	user.Password = ""
 	return user, nil
 }
 
+// rehashPassword replaces a legacy or outdated password hash now that the
+// plain text password is known. Failing to do so does not fail the login; it
+// is tried again next time.
+func (c *AuthController) rehashPassword(ctx context.Context, user model.User, password string) {
+	hash, err := crypt.HashPassword(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to rehash password", "user_id", user.ID, "err", err)
+		return
+	}
+
+	if err := c.users.UpdatePassword(ctx, user.ID, hash); err != nil {
+		slog.ErrorContext(ctx, "failed to store rehashed password", "user_id", user.ID, "err", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "rehashed password", "user_id", user.ID, "legacy", crypt.IsLegacyPassword(user.Password))
+}
+
 func validateLoginRequest(ctx context.Context, req LoginRequest) error {
 	apiErr := NewApiError(400, ApiErrBadRequest)
 
@@ -105,32 +198,3 @@ func validateLoginRequest(ctx context.Context, req LoginRequest) error {
 
 	return nil
 }
-
-func (c *AuthController) hydrateSession(w http.ResponseWriter, r *http.Request, user model.User) error {
-	ctx := r.Context()
-	session, err := c.cookieStore.Get(r, "sandbox-cookie")
-	if err != nil {
-		slog.ErrorContext(ctx, "failed to establish session")
-		return fmt.Errorf("failes to establish session. %w", err)
-	}
-
-	rc := request.GetRequestContext(ctx)
-	roles := make([]string, len(user.Roles))
-	for i := range user.Roles {
-		role := user.Roles[i]
-		roles[i] = role.Name
-	}
-
-	rc.UserID = user.ID
-	rc.Roles = roles
-	ctx = request.WithRequestContext(ctx, rc)
-	r = r.WithContext(ctx)
-
-	slog.DebugContext(ctx, "HYDRATING SESSION", "user_id", user.ID, "roles", roles)
-	session.Values["authenticated"] = true
-	session.Values["user_id"] = user.ID
-	session.Values["roles"] = roles
-	session.Save(r, w)
-
-	return nil
-}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+const (
+	// mfaChallengeCookie carries the challenge of a provider login to
+	// LoginMFA, so the token is never in a url where history, logs or the
+	// Referer header could leak it.
+	mfaChallengeCookie = "sandbox-mfa"
+	mfaChallengePath   = "/auth/login/mfa"
+)
+
+// LoginMFARequest finishes a login that answered with an mfa challenge. Code
+// is a code from the authenticator or a recovery code.
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// LoginMFA starts the session of a login once its mfa challenge is met.
+// Provider logins leave their mfa_token in a cookie rather than the body.
+func (c *AuthController) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "login mfa request")
+	loginRequest := LoginMFARequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
+		slog.WarnContext(ctx, "error decoding login mfa request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+	if cookie, err := r.Cookie(mfaChallengeCookie); err == nil && loginRequest.MFAToken == "" {
+		loginRequest.MFAToken = cookie.Value
+	}
+
+	user, err := c.completeMFAChallenge(ctx, loginRequest)
+	if err != nil {
+		handleLoginError(ctx, w, err)
+		return
+	}
+
+	if err := c.sessions.StartSession(w, r, user); err != nil {
+		handleLoginError(ctx, w, err)
+		return
+	}
+	if _, err := r.Cookie(mfaChallengeCookie); err == nil {
+		setMFAChallengeCookie(w, auth.MFAChallenge{ExpiresIn: -1})
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, user)
+}
+
+// setMFAChallengeCookie hands the challenge to the browser for LoginMFA. A
+// negative ExpiresIn clears it.
+func setMFAChallengeCookie(w http.ResponseWriter, challenge auth.MFAChallenge) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaChallengeCookie,
+		Value:    challenge.MFAToken,
+		Path:     mfaChallengePath,
+		MaxAge:   challenge.ExpiresIn,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// mfaChallenge returns a challenge for the user if they have MFA on, and nil
+// if the password is enough.
+func (c *AuthController) mfaChallenge(ctx context.Context, user model.User) (*auth.MFAChallenge, error) {
+	enabled, err := c.mfa.Enabled(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check mfa. %w", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	challenge, err := c.mfa.Challenge(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start mfa challenge. %w", err)
+	}
+
+	slog.InfoContext(ctx, "login needs mfa", "user_id", user.ID)
+	return &challenge, nil
+}
+
+func (c *AuthController) completeMFAChallenge(ctx context.Context, req LoginMFARequest) (model.User, error) {
+	apiErr := NewApiError(400, ApiErrBadRequest)
+	if req.MFAToken == "" {
+		apiErr.Append("mfa_token must be present")
+	}
+	if req.Code == "" {
+		apiErr.Append("code must be present")
+	}
+	if apiErr.HasError() {
+		return model.User{}, apiErr
+	}
+	if c.mfa == nil {
+		return model.User{}, auth.ErrMFAChallengeInvalid
+	}
+
+	userID, err := c.mfa.CompleteChallenge(ctx, req.MFAToken, req.Code)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user, err := c.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return user, fmt.Errorf("failed to get user. %w", err)
+	}
+	if err := auth.CheckAccount(user.AccountState, time.Now()); err != nil {
+		return model.User{}, err
+	}
+
+	slog.InfoContext(ctx, "passed mfa", "user_id", user.ID)
+	user.Password = ""
+	return user, nil
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/request"
+)
+
+// Logout responds once the session has been ended by the terminate
+// middleware it is chained behind.
+func (c *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
+	slog.DebugContext(r.Context(), "logout request")
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// LogoutAll ends every session and refresh token of the calling user,
+// including the one the request was made with.
+func (c *SessionController) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "logout all request")
+
+	rc := request.GetRequestContext(ctx)
+	if rc == nil || rc.UserID == "" {
+		request.RespondWithError(w, http.StatusUnauthorized, "Invalid Credentials")
+		return
+	}
+
+	n, err := c.revokeAll(ctx, rc.UserID)
+	if err != nil {
+		handleRevokeSessionError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "logged out everywhere", "revoked", n)
+	request.RespondWithJSON(w, http.StatusOK, revokedSessions{Revoked: n})
+}
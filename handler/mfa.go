@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+type MFAController struct {
+	mfa   *auth.MFAService
+	users dao.UserRepository
+}
+
+func NewMFAController(mfa *auth.MFAService, users dao.UserRepository) MFAController {
+	return MFAController{
+		mfa:   mfa,
+		users: users,
+	}
+}
+
+// MFACodeRequest carries a code from the authenticator, or a recovery code
+// where one is accepted.
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func handleMFAError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error mfa", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error mfa", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	} else if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error mfa", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	} else if errors.Is(err, auth.ErrMFAInvalidCode) {
+		slog.WarnContext(ctx, "wrong mfa code", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, "invalid mfa code")
+		return
+	} else if errors.Is(err, auth.ErrMFANotEnabled) {
+		slog.WarnContext(ctx, "error mfa", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	} else if errors.Is(err, auth.ErrMFAEnabled) {
+		slog.WarnContext(ctx, "error mfa", "err", err)
+		request.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error mfa", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// requireSelf stops admins from changing another user's second factor, which
+// only its owner can hold.
+func requireSelf(ctx context.Context, userID string) error {
+	if rc := request.GetRequestContext(ctx); rc == nil || rc.UserID != userID {
+		return NewApiError(403, ApiErrForbidden).Append("only the user can do this")
+	}
+	return nil
+}
+
+func validateMFACodeRequest(req MFACodeRequest) error {
+	if req.Code == "" {
+		return NewApiError(400, ApiErrBadRequest).Append("code must be present")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginMFA(t *testing.T) {
+	repo := dao.NewMemory()
+	mfa := auth.NewMFAService(repo, "")
+	tokens := auth.NewTokenService(repo, repo, auth.TokenConfig{})
+	c := NewAuthController(auth.NewPostgresSessionStore(repo, repo, 0), tokens, mfa, repo, repo, repo, nil)
+	m := NewMFAController(mfa, repo)
+	user := createUser(t, NewUserController(repo, repo, nil), "mfa_user", "m@b.c")
+	ctx := request.WithRequestContext(context.Background(), &request.RequestContext{UserID: user.ID})
+	login := `{"username": "mfa_user", "password": "thisIsAG00dPassword!"}`
+
+	adminCtx := request.WithRequestContext(context.Background(), &request.RequestContext{UserID: "user_admin", Roles: []string{"ADMIN"}, Permissions: model.Permissions})
+	_, err := m.enrollMFA(adminCtx, user.ID, false)
+	assert.ErrorIs(t, err, ApiErrForbidden)
+
+	enrollment, err := m.enrollMFA(ctx, user.ID, true)
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.QRCode, "data:image/png;base64,")
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now().Add(-30*time.Second))
+	assert.NoError(t, err)
+	codes, err := m.confirmMFA(ctx, user.ID, MFACodeRequest{Code: code})
+	assert.NoError(t, err)
+
+	// the password alone only gets a challenge
+	w := serve(c.Login, "POST", "/auth/login", nil, login)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Nil(t, sessionCookie(w))
+	challenge := auth.MFAChallenge{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+	assert.True(t, challenge.MFARequired)
+
+	w = serve(c.LoginMFA, "POST", "/auth/login/mfa", nil, `{"mfa_token": "`+challenge.MFAToken+`", "code": "000000"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	code, err = totp.GenerateCode(enrollment.Secret, time.Now())
+	assert.NoError(t, err)
+	w = serve(c.LoginMFA, "POST", "/auth/login/mfa", nil, `{"mfa_token": "`+challenge.MFAToken+`", "code": "`+code+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotNil(t, sessionCookie(w))
+	w = serve(c.LoginMFA, "POST", "/auth/login/mfa", nil, `{"mfa_token": "`+challenge.MFAToken+`", "code": "`+codes[0]+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// token clients go through the same challenge
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "password", "username": "mfa_user", "password": "thisIsAG00dPassword!"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+	w = serve(c.Token, "POST", "/auth/token", nil, `{"grant_type": "mfa", "mfa_token": "`+challenge.MFAToken+`", "code": "`+codes[0]+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	pair := auth.TokenPair{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pair))
+	assert.NotEmpty(t, pair.AccessToken)
+
+	// users need a code to turn it off, admins do not
+	assert.ErrorIs(t, m.resetMFA(ctx, user.ID, MFACodeRequest{}), ApiErrBadRequest)
+	assert.ErrorIs(t, m.resetMFA(ctx, user.ID, MFACodeRequest{Code: codes[0]}), auth.ErrMFAInvalidCode)
+	assert.NoError(t, m.resetMFA(adminCtx, user.ID, MFACodeRequest{}))
+
+	w = serve(c.Login, "POST", "/auth/login", nil, login)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotNil(t, sessionCookie(w))
+}
@@ -0,0 +1,470 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/oidc"
+	"github.com/slham/sandbox-api/request"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultFrontendURL = "http://localhost:3000"
+	oauthStateCookie   = "oauthstate"
+	oauthStateTTL      = 20 * time.Minute
+)
+
+// OAuthConfig configures sign in with external providers.
+type OAuthConfig struct {
+	Providers *oidc.Registry
+	// FrontendURL is where the browser is sent once the callback is done.
+	// Failures add an error query parameter.
+	FrontendURL string
+}
+
+var (
+	oauthProviders *oidc.Registry
+	frontendURL    = defaultFrontendURL
+
+	oauthFlowMap = map[string]func(*AuthController, context.Context, string, oidc.UserInfo) (model.User, error){
+		"login":    (*AuthController).handleOauthLogin,
+		"register": (*AuthController).handleOauthRegister,
+	}
+)
+
+// SetOAuth replaces the sign in providers. An empty FrontendURL keeps the
+// default.
+func SetOAuth(cfg OAuthConfig) {
+	oauthProviders = cfg.Providers
+	if cfg.FrontendURL != "" {
+		frontendURL = cfg.FrontendURL
+	}
+}
+
+// oauthState is kept in a cookie between the redirect to the provider and the
+// callback. The flow and provider travel inside it so they cannot be swapped
+// on the way back. The cookie is encrypted, so the verifier and nonce cannot
+// be read from it and a state we did not make is never accepted.
+type oauthState struct {
+	Provider string    `json:"provider"`
+	Flow     string    `json:"flow"`
+	State    string    `json:"state"`
+	Verifier string    `json:"verifier"`
+	Nonce    string    `json:"nonce"`
+	Expires  time.Time `json:"expires"`
+}
+
+// handleOauthError sends the browser back to the frontend with an error code
+// it can show, since the callback is reached by redirect rather than by the
+// frontend's own request.
+func handleOauthError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	code := "server_error"
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error oauth", "err", err)
+		code = "bad_request"
+	} else if errors.Is(err, ApiErrConflict) {
+		slog.WarnContext(ctx, "user already exists", "err", err)
+		code = "conflict"
+	} else if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "no user for provider account", "err", err)
+		code = "not_found"
+	} else if accountErr := (auth.AccountError{}); errors.As(err, &accountErr) {
+		slog.WarnContext(ctx, "provider login to inactive account", "err", err)
+		code = accountErr.Code()
+	} else {
+		slog.ErrorContext(ctx, "error oauth", "err", err)
+	}
+
+	http.Redirect(w, r, frontendRedirect(code), http.StatusFound)
+}
+
+func frontendRedirect(errCode string) string {
+	if errCode == "" {
+		return frontendURL
+	}
+
+	return frontendURLWith("error", errCode)
+}
+
+// frontendURLWith returns the frontend URL with a query parameter added.
+func frontendURLWith(key, value string) string {
+	u, err := url.Parse(frontendURL)
+	if err != nil {
+		return frontendURL
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// OauthLogin sends the browser to the provider named in the path. The
+// oauth-flow query parameter picks between logging in to a linked account
+// and registering a new one, and defaults to login.
+func (c *AuthController) OauthLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	provider, ok := oauthProviders.Get(mux.Vars(r)["provider"])
+	if !ok {
+		request.RespondWithError(w, http.StatusNotFound, NewApiError(404, ApiErrNotFound).Append("unknown provider").Error())
+		return
+	}
+
+	flow := r.URL.Query().Get("oauth-flow")
+	if flow == "" {
+		flow = "login"
+	}
+	if _, ok := oauthFlowMap[flow]; !ok {
+		slog.WarnContext(ctx, "unknown oauth flow", "oauth_flow", flow)
+		request.RespondWithError(w, http.StatusBadRequest, NewApiError(400, ApiErrBadRequest).Append("unknown oauth-flow").Error())
+		return
+	}
+
+	state := oauthState{
+		Provider: provider.Name(),
+		Flow:     flow,
+		State:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    randomString(),
+		Expires:  time.Now().Add(oauthStateTTL),
+	}
+	u, err := provider.AuthCodeURL(ctx, state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to build authorization url", "provider", provider.Name(), "err", err)
+		request.RespondWithError(w, http.StatusBadGateway, "provider is unavailable")
+		return
+	}
+	if err := setOauthStateCookie(w, state); err != nil {
+		slog.ErrorContext(ctx, "failed to set oauth state", "err", err)
+		request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	http.Redirect(w, r, u, http.StatusTemporaryRedirect)
+}
+
+func (c *AuthController) OauthCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	name := mux.Vars(r)["provider"]
+	state, err := readOauthStateCookie(r)
+	clearOauthStateCookie(w, name)
+	if err != nil {
+		handleOauthError(ctx, w, r, NewApiError(400, ApiErrBadRequest).Append(err.Error()))
+		return
+	}
+
+	got := r.FormValue("state")
+	if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(state.State)) != 1 || name != state.Provider {
+		slog.ErrorContext(ctx, "invalid oauth state", "provider", name)
+		handleOauthError(ctx, w, r, NewApiError(400, ApiErrBadRequest).Append("invalid oauth state"))
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		handleOauthError(ctx, w, r, NewApiError(400, ApiErrBadRequest).Append("provider returned "+e))
+		return
+	}
+
+	provider, ok := oauthProviders.Get(name)
+	if !ok {
+		handleOauthError(ctx, w, r, NewApiError(404, ApiErrNotFound).Append("unknown provider"))
+		return
+	}
+	flow, ok := oauthFlowMap[state.Flow]
+	if !ok {
+		handleOauthError(ctx, w, r, NewApiError(400, ApiErrBadRequest).Append("unknown oauth-flow"))
+		return
+	}
+
+	userInfo, err := provider.Exchange(ctx, r.FormValue("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		handleOauthError(ctx, w, r, fmt.Errorf("failed to get user data from %s. %w", name, err))
+		return
+	}
+
+	user, err := flow(c, ctx, name, userInfo)
+	if err == nil {
+		err = auth.CheckAccount(user.AccountState, time.Now())
+	}
+	if err != nil {
+		handleOauthError(ctx, w, r, err)
+		return
+	}
+
+	challenge, err := c.mfaChallenge(ctx, user)
+	if err != nil {
+		handleOauthError(ctx, w, r, err)
+		return
+	}
+	if challenge != nil {
+		slog.InfoContext(ctx, "provider login needs mfa", "user_id", user.ID, "provider", name)
+		setMFAChallengeCookie(w, *challenge)
+		http.Redirect(w, r, frontendURLWith("mfa_required", "true"), http.StatusFound)
+		return
+	}
+
+	if err := c.sessions.StartSession(w, r, user); err != nil {
+		handleOauthError(ctx, w, r, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "logged in with provider", "user_id", user.ID, "provider", name, "oauth_flow", state.Flow)
+	http.Redirect(w, r, frontendRedirect(""), http.StatusFound)
+}
+
+// handleOauthRegister creates a user for a provider account that has no user
+// yet, linked to it.
+func (c *AuthController) handleOauthRegister(ctx context.Context, provider string, userInfo oidc.UserInfo) (model.User, error) {
+	user := model.User{}
+	err := c.users.WithTx(ctx, func(ctx context.Context) error {
+		_, err := c.identities.GetIdentity(ctx, provider, userInfo.Subject)
+		if err == nil {
+			return NewApiError(409, ApiErrConflict).Append(provider + " account already registered")
+		}
+		if !errors.Is(err, dao.ErrIdentityNotFound) {
+			return fmt.Errorf("failed to get identity. %w", err)
+		}
+
+		_, err = c.users.GetUserByEmail(ctx, userInfo.Email)
+		if err == nil {
+			return NewApiError(409, ApiErrConflict).Append("email already exists. log in to link " + provider)
+		}
+		if !errors.Is(err, dao.ErrUserNotFound) {
+			return fmt.Errorf("failed to get user. %w", err)
+		}
+
+		user, err = c.makeUser(ctx, userInfo)
+		if err != nil {
+			return fmt.Errorf("failed to create new user. %w", err)
+		}
+
+		return c.linkIdentity(ctx, provider, user, userInfo)
+	})
+
+	return user, err
+}
+
+// handleOauthLogin finds the user linked to the provider account. A user with
+// the same email who has not linked the provider yet is linked now, but only
+// when both we and the provider have verified the email. This lets a password
+// user add sign in with a provider. An unverified user may have been made by
+// someone else with the owner's email, so they must log in with the password
+// and verify the email first.
+func (c *AuthController) handleOauthLogin(ctx context.Context, provider string, userInfo oidc.UserInfo) (model.User, error) {
+	user := model.User{}
+	err := c.users.WithTx(ctx, func(ctx context.Context) error {
+		identity, err := c.identities.GetIdentity(ctx, provider, userInfo.Subject)
+		if err == nil {
+			user, err = c.users.GetUser(ctx, dao.UserQuery{ID: identity.UserID, HidePassword: true, WithRoles: true})
+			if errors.Is(err, dao.ErrUserNotFound) {
+				return NewApiError(404, ApiErrNotFound).Append(provider + " account is not linked")
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get user. %w", err)
+			}
+			return nil
+		}
+		if !errors.Is(err, dao.ErrIdentityNotFound) {
+			return fmt.Errorf("failed to get identity. %w", err)
+		}
+
+		if !userInfo.EmailVerified {
+			return NewApiError(404, ApiErrNotFound).Append(provider + " account is not linked")
+		}
+
+		user, err = c.users.GetUserByEmail(ctx, userInfo.Email)
+		if errors.Is(err, dao.ErrUserNotFound) {
+			return NewApiError(404, ApiErrNotFound).Append(provider + " account is not linked")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user. %w", err)
+		}
+		user.Password = ""
+
+		if !user.IsVerified {
+			return NewApiError(409, ApiErrConflict).Append("email is not verified. log in with your password and verify it to link " + provider)
+		}
+
+		return c.linkIdentity(ctx, provider, user, userInfo)
+	})
+
+	return user, err
+}
+
+func (c *AuthController) linkIdentity(ctx context.Context, provider string, user model.User, userInfo oidc.UserInfo) error {
+	_, err := c.identities.InsertIdentity(ctx, model.Identity{
+		Provider: provider,
+		Subject:  userInfo.Subject,
+		UserID:   user.ID,
+		Email:    userInfo.Email,
+	})
+	if errors.Is(err, dao.ErrConflictIdentity) {
+		return NewApiError(409, ApiErrConflict).Append(provider + " account already linked")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to link %s account. %w", provider, err)
+	}
+
+	slog.InfoContext(ctx, "linked provider account", "user_id", user.ID, "provider", provider)
+	return nil
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oauthStatePath keeps the state cookie to the provider's callback, the only
+// place it is read.
+func oauthStatePath(provider string) string {
+	return "/auth/" + provider + "/callback"
+}
+
+// setOauthStateCookie stores the state for the callback. It is sent with
+// SameSite Lax, since the provider brings the browser back from another site.
+func setOauthStateCookie(w http.ResponseWriter, state oauthState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal oauth state. %w", err)
+	}
+	value, err := crypt.Encrypt(string(b))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt oauth state. %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     oauthStatePath(state.Provider),
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// clearOauthStateCookie makes the state good for one callback only.
+func clearOauthStateCookie(w http.ResponseWriter, provider string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    "",
+		Path:     oauthStatePath(provider),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func readOauthStateCookie(r *http.Request) (oauthState, error) {
+	state := oauthState{}
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return state, errors.New("missing oauthstate cookie")
+	}
+	b, err := crypt.Decrypt(cookie.Value)
+	if err != nil {
+		return state, errors.New("invalid oauthstate cookie")
+	}
+	if err := json.Unmarshal([]byte(b), &state); err != nil {
+		return state, errors.New("invalid oauthstate cookie")
+	}
+	if time.Now().After(state.Expires) {
+		return state, errors.New("expired oauthstate cookie")
+	}
+
+	return state, nil
+}
+
+func generatePassword(length int) (string, error) {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()"
+
+	var password []byte
+	for i := 0; i < length; i++ {
+		randomIndex, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, charset[randomIndex.Int64()])
+	}
+	return string(password), nil
+}
+
+// newPassword hashes a random password nobody knows, so users created through
+// a provider cannot log in with a password until they set one.
+func newPassword() (string, error) {
+	password, passwordErr := generatePassword(32)
+	if passwordErr != nil {
+		return password, fmt.Errorf("failed to generate new user password. %w", passwordErr)
+	}
+	password, hashErr := crypt.HashPassword(password)
+	if hashErr != nil {
+		return password, fmt.Errorf("failed to hash password. %w", hashErr)
+	}
+
+	return password, nil
+}
+
+func (c *AuthController) makeUser(ctx context.Context, userInfo oidc.UserInfo) (model.User, error) {
+	password, passwordErr := newPassword()
+	if passwordErr != nil {
+		return model.User{}, fmt.Errorf("failed to generate new user password. %w", passwordErr)
+	}
+
+	roles, err := defaultRoles(ctx, c.roles)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	newUser := model.User{
+		ID:       fmt.Sprintf("user_%s", ksuid.New().String()),
+		Username: oauthUsername(userInfo),
+		Password: password,
+		Email:    userInfo.Email,
+		Roles:    roles,
+		// the provider has already checked the address
+		IsVerified: userInfo.EmailVerified,
+	}
+	user, err := c.users.InsertUser(ctx, newUser)
+	if err != nil {
+		if errors.Is(err, dao.ErrConflictUsername) {
+			return user, NewApiError(409, ApiErrConflict).Append("username already exists")
+		}
+		if errors.Is(err, dao.ErrConflictEmail) {
+			return user, NewApiError(409, ApiErrConflict).Append("email already exists")
+		}
+		return user, fmt.Errorf("failed to insert user. %w", err)
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// oauthUsername derives a username from the email's local part, which unlike
+// the display name is unique to the account, with a random suffix so it does
+// not collide with an existing username.
+func oauthUsername(userInfo oidc.UserInfo) string {
+	local, _, _ := strings.Cut(userInfo.Email, "@")
+	suffix := ksuid.New().String()
+	return fmt.Sprintf("%s_%s", local, strings.ToLower(suffix[len(suffix)-6:]))
+}
@@ -0,0 +1,301 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pquerna/otp/totp"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/oidc"
+	"github.com/slham/sandbox-api/oidc/oidctest"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+// oauthRoundTrip starts the flow with the stub provider, lets the issuer
+// approve it as loginHint, then comes back to the callback and returns the
+// callback's response.
+func oauthRoundTrip(t *testing.T, c AuthController, flow string, loginHint string) *httptest.ResponseRecorder {
+	t.Helper()
+	vars := map[string]string{"provider": "stub"}
+	w := serve(c.OauthLogin, "GET", "/auth/stub/login?oauth-flow="+flow, vars, "")
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected redirect to issuer. %d %s", w.Code, w.Body.String())
+	}
+	authorize, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	q := authorize.Query()
+	assert.NotEmpty(t, q.Get("nonce"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	q.Set("login_hint", loginHint)
+	authorize.RawQuery = q.Encode()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authorize.String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/auth/stub/callback?"+callback.RawQuery, nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	r = mux.SetURLVars(r, vars)
+	w = httptest.NewRecorder()
+	c.OauthCallback(w, r)
+
+	return w
+}
+
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "sandbox-cookie" && cookie.MaxAge >= 0 {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// deletedUsers loads every user as if they had just been deleted.
+type deletedUsers struct {
+	*dao.Memory
+}
+
+func (u deletedUsers) GetUser(ctx context.Context, q dao.UserQuery) (model.User, error) {
+	return model.User{}, dao.ErrUserNotFound
+}
+
+func TestOauth(t *testing.T) {
+	ctx := context.Background()
+	issuer, srv, err := oidctest.NewServer("client", "secret")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer srv.Close()
+	issuer.AddUser("new", oidctest.User{Subject: "s_new", Email: "new@gmail.com", EmailVerified: true, Name: "New"})
+	issuer.AddUser("existing", oidctest.User{Subject: "s_existing", Email: "existing@b.c", EmailVerified: true, Name: "Existing"})
+	issuer.AddUser("unverified", oidctest.User{Subject: "s_unverified", Email: "unverified@b.c", Name: "Unverified"})
+
+	providers, err := oidc.NewRegistry(oidc.Config{
+		Name:         "stub",
+		DiscoveryURL: issuer.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8000/auth/stub/callback",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	SetOAuth(OAuthConfig{Providers: providers, FrontendURL: "https://app.example.com/home"})
+	defer SetOAuth(OAuthConfig{FrontendURL: defaultFrontendURL})
+
+	repo := dao.NewMemory()
+	store := auth.NewPostgresSessionStore(repo, repo, 0)
+	c := NewAuthController(store, nil, nil, repo, repo, repo, nil)
+	existing := createUser(t, NewUserController(repo, repo, nil), "existing_user", "existing@b.c")
+
+	t.Run("unknown provider", func(t *testing.T) {
+		w := serve(c.OauthLogin, "GET", "/auth/nope/login", map[string]string{"provider": "nope"}, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("unknown flow", func(t *testing.T) {
+		w := serve(c.OauthLogin, "GET", "/auth/stub/login?oauth-flow=steal", map[string]string{"provider": "stub"}, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		assert.NoError(t, setOauthStateCookie(w, oauthState{Provider: "stub", Flow: "login", State: "real", Expires: time.Now().Add(time.Minute)}))
+		r := httptest.NewRequest("GET", "/auth/stub/callback?state=forged&code=new", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		w = httptest.NewRecorder()
+		c.OauthCallback(w, mux.SetURLVars(r, map[string]string{"provider": "stub"}))
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://app.example.com/home?error=bad_request", w.Header().Get("Location"))
+		assert.Nil(t, sessionCookie(w))
+	})
+
+	t.Run("state for another provider", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		assert.NoError(t, setOauthStateCookie(w, oauthState{Provider: "stub", Flow: "login", State: "real", Expires: time.Now().Add(time.Minute)}))
+		r := httptest.NewRequest("GET", "/auth/other/callback?state=real&code=new", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		w = httptest.NewRecorder()
+		c.OauthCallback(w, mux.SetURLVars(r, map[string]string{"provider": "other"}))
+		assert.Equal(t, "https://app.example.com/home?error=bad_request", w.Header().Get("Location"))
+	})
+
+	t.Run("state cookie", func(t *testing.T) {
+		w := serve(c.OauthLogin, "GET", "/auth/stub/login", map[string]string{"provider": "stub"}, "")
+		cookie := w.Result().Cookies()[0]
+		assert.Equal(t, "/auth/stub/callback", cookie.Path)
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		assert.NotContains(t, cookie.Value, "verifier")
+	})
+
+	tables := []struct {
+		name  string
+		value func() string
+	}{
+		{"tampered state", func() string {
+			b, _ := json.Marshal(oauthState{Provider: "stub", Flow: "login", State: "real", Expires: time.Now().Add(time.Minute)})
+			return base64.RawURLEncoding.EncodeToString(b)
+		}},
+		{"expired state", func() string {
+			w := httptest.NewRecorder()
+			assert.NoError(t, setOauthStateCookie(w, oauthState{Provider: "stub", Flow: "login", State: "real", Expires: time.Now().Add(-time.Second)}))
+			return w.Result().Cookies()[0].Value
+		}},
+	}
+	for _, tt := range tables {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/auth/stub/callback?state=real&code=new", nil)
+			r.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: tt.value()})
+			w := httptest.NewRecorder()
+			c.OauthCallback(w, mux.SetURLVars(r, map[string]string{"provider": "stub"}))
+			assert.Equal(t, "https://app.example.com/home?error=bad_request", w.Header().Get("Location"))
+			cleared := w.Result().Cookies()[0]
+			assert.Equal(t, oauthStateCookie, cleared.Name)
+			assert.Equal(t, -1, cleared.MaxAge)
+		})
+	}
+
+	t.Run("login before register", func(t *testing.T) {
+		w := oauthRoundTrip(t, c, "login", "new")
+		assert.Equal(t, "https://app.example.com/home?error=not_found", w.Header().Get("Location"))
+	})
+
+	t.Run("register", func(t *testing.T) {
+		w := oauthRoundTrip(t, c, "register", "new")
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://app.example.com/home", w.Header().Get("Location"))
+		assert.NotNil(t, sessionCookie(w))
+
+		user, err := repo.GetUserByEmail(ctx, "new@gmail.com")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"CIVILIAN"}, []string{user.Roles[0].Name})
+		identities, err := repo.GetUserIdentities(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, []model.Identity{{Provider: "stub", Subject: "s_new", UserID: user.ID, Email: "new@gmail.com", Created: identities[0].Created}}, identities)
+
+		w = oauthRoundTrip(t, c, "register", "new")
+		assert.Equal(t, "https://app.example.com/home?error=conflict", w.Header().Get("Location"))
+	})
+
+	t.Run("mfa", func(t *testing.T) {
+		mfa := auth.NewMFAService(repo, "")
+		mc := NewAuthController(store, nil, mfa, repo, repo, repo, nil)
+		user, err := repo.GetUserByEmail(ctx, "new@gmail.com")
+		assert.NoError(t, err)
+		m := NewMFAController(mfa, repo)
+		userCtx := request.WithRequestContext(ctx, &request.RequestContext{UserID: user.ID})
+		enrollment, err := m.enrollMFA(userCtx, user.ID, false)
+		assert.NoError(t, err)
+		code, err := totp.GenerateCode(enrollment.Secret, time.Now().Add(-30*time.Second))
+		assert.NoError(t, err)
+		_, err = m.confirmMFA(userCtx, user.ID, MFACodeRequest{Code: code})
+		assert.NoError(t, err)
+
+		// the token stays out of the url, where history and logs keep it
+		w := oauthRoundTrip(t, mc, "login", "new")
+		assert.Equal(t, "https://app.example.com/home?mfa_required=true", w.Header().Get("Location"))
+		assert.Nil(t, sessionCookie(w))
+		var challenge *http.Cookie
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == mfaChallengeCookie {
+				challenge = cookie
+			}
+		}
+		if !assert.NotNil(t, challenge) {
+			return
+		}
+		assert.True(t, challenge.HttpOnly)
+		assert.Equal(t, mfaChallengePath, challenge.Path)
+
+		code, err = totp.GenerateCode(enrollment.Secret, time.Now())
+		assert.NoError(t, err)
+		r := httptest.NewRequest("POST", "/auth/login/mfa", strings.NewReader(`{"code": "`+code+`"}`))
+		r.AddCookie(challenge)
+		w = httptest.NewRecorder()
+		mc.LoginMFA(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotNil(t, sessionCookie(w))
+		cleared := w.Result().Cookies()[len(w.Result().Cookies())-1]
+		assert.Equal(t, mfaChallengeCookie, cleared.Name)
+		assert.Equal(t, -1, cleared.MaxAge)
+
+		assert.NoError(t, mfa.Reset(ctx, user.ID))
+	})
+
+	t.Run("login links a password user", func(t *testing.T) {
+		w := oauthRoundTrip(t, c, "register", "existing")
+		assert.Equal(t, "https://app.example.com/home?error=conflict", w.Header().Get("Location"))
+
+		// whoever made the password user may not own the email
+		w = oauthRoundTrip(t, c, "login", "existing")
+		assert.Equal(t, "https://app.example.com/home?error=conflict", w.Header().Get("Location"))
+		assert.Nil(t, sessionCookie(w))
+		unlinked, err := repo.GetUserByID(ctx, existing.ID)
+		assert.NoError(t, err)
+		assert.False(t, unlinked.IsVerified)
+		identities, err := repo.GetUserIdentities(ctx, existing.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, identities)
+
+		assert.NoError(t, repo.VerifyEmail(ctx, existing.ID, existing.Email))
+		w = oauthRoundTrip(t, c, "login", "existing")
+		assert.Equal(t, "https://app.example.com/home", w.Header().Get("Location"))
+		cookie := sessionCookie(w)
+		if assert.NotNil(t, cookie) {
+			sessions, err := repo.GetUserSessions(ctx, existing.ID)
+			assert.NoError(t, err)
+			assert.Len(t, sessions, 1)
+		}
+
+		identities, err = repo.GetUserIdentities(ctx, existing.ID)
+		assert.NoError(t, err)
+		assert.Len(t, identities, 1)
+
+		// the password still works alongside the provider
+		_, err = c.handleLogin(ctx, LoginRequest{Username: "existing_user", Password: "thisIsAG00dPassword!"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("unverified email is not linked", func(t *testing.T) {
+		createUser(t, NewUserController(repo, repo, nil), "unverified_user", "unverified@b.c")
+		w := oauthRoundTrip(t, c, "login", "unverified")
+		assert.Equal(t, "https://app.example.com/home?error=not_found", w.Header().Get("Location"))
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		// deleted between finding the identity and loading the user
+		dc := NewAuthController(store, nil, nil, deletedUsers{repo}, repo, repo, nil)
+		w := oauthRoundTrip(t, dc, "login", "existing")
+		assert.Equal(t, "https://app.example.com/home?error=not_found", w.Header().Get("Location"))
+		assert.Nil(t, sessionCookie(w))
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		w := oauthRoundTrip(t, c, "login", "nobody")
+		assert.Equal(t, "https://app.example.com/home?error=bad_request", w.Header().Get("Location"))
+	})
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+const grantTypeAuthorizationCode = "authorization_code"
+
+// OAuthToken swaps an authorization code, or a refresh token, for tokens the
+// app can call the api with. It takes a form, as RFC 6749 has it.
+func (c *AuthorizationController) OAuthToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "oauth token request")
+
+	if err := r.ParseForm(); err != nil {
+		handleOAuthTokenError(ctx, w, &auth.OAuthError{Code: auth.OAuthInvalidRequest, Description: "malformed request body"})
+		return
+	}
+
+	creds := clientCredentials(r)
+	var pair auth.TokenPair
+	var err error
+	switch r.PostForm.Get("grant_type") {
+	case grantTypeAuthorizationCode:
+		pair, err = c.server.Exchange(ctx, creds, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	case grantTypeRefreshToken:
+		pair, err = c.server.Refresh(ctx, creds, r.PostForm.Get("refresh_token"))
+	default:
+		err = &auth.OAuthError{Code: auth.OAuthUnsupportedGrantType, Description: "grant_type must be authorization_code or refresh_token"}
+	}
+	if err != nil {
+		handleOAuthTokenError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusOK, pair)
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+const defaultMaxPageSize = 100
+
+var maxPageSize = defaultMaxPageSize
+
+// SetMaxPageSize caps the limit a caller may ask for on list endpoints.
+// Larger limits are lowered to it rather than rejected.
+func SetMaxPageSize(size int) {
+	if size <= 0 {
+		size = defaultMaxPageSize
+	}
+	maxPageSize = size
+}
+
+type pageResponse[T any] struct {
+	Data  []T    `json:"data"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Total *int   `json:"total,omitempty"`
+}
+
+// respondWithPage writes a keyset page in the list envelope. The next and
+// previous cursors are also advertised in a Link header pointing back at the
+// request's own url.
+func respondWithPage[T any](w http.ResponseWriter, r *http.Request, page dao.Page[T]) {
+	res := pageResponse[T]{Data: page.Items, Total: page.Total}
+	if res.Data == nil {
+		res.Data = []T{}
+	}
+
+	links := []string{}
+	if page.Next != nil {
+		res.Next = page.Next.Encode()
+		links = append(links, pageLink(r, res.Next, "next"))
+	}
+	if page.Prev != nil {
+		res.Prev = page.Prev.Encode()
+		links = append(links, pageLink(r, res.Prev, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, res)
+}
+
+func pageLink(r *http.Request, cursor, rel string) string {
+	u := *r.URL
+	query := u.Query()
+	query.Set("cursor", cursor)
+	u.RawQuery = query.Encode()
+
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+}
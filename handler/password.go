@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+	"github.com/slham/sandbox-api/valid"
+)
+
+type PasswordController struct {
+	passwords *auth.PasswordService
+}
+
+func NewPasswordController(passwords *auth.PasswordService) PasswordController {
+	return PasswordController{
+		passwords: passwords,
+	}
+}
+
+func handlePasswordError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error password", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error password", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	} else if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error password", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	} else if errors.Is(err, auth.ErrWrongPassword) {
+		slog.WarnContext(ctx, "error password", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	} else if locked := (auth.LoginLockedError{}); errors.As(err, &locked) {
+		slog.WarnContext(ctx, "locked out password change", "err", err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		request.RespondWithError(w, http.StatusTooManyRequests, "too many wrong passwords. try again later")
+		return
+	} else if errors.Is(err, auth.ErrInvalidPasswordReset) {
+		slog.WarnContext(ctx, "error password", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error password", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+func validateNewPassword(password string) error {
+	if !valid.IsMediumPassword(password) {
+		return NewApiError(400, ApiErrBadRequest).Append(weakPasswordMessage)
+	}
+	return nil
+}
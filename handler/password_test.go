@@ -0,0 +1,72 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordFlows(t *testing.T) {
+	repo := dao.NewMemory()
+	box := &outbox{}
+	c := NewPasswordController(auth.NewPasswordService(repo, repo, repo, repo, repo, repo, nil, box, auth.PasswordResetConfig{}))
+	a := NewAuthController(auth.NewPostgresSessionStore(repo, repo, 0), nil, nil, repo, repo, repo, nil)
+	user := createUser(t, NewUserController(repo, repo, nil), "password_user", "p@b.c")
+	ctx := request.WithRequestContext(context.Background(), &request.RequestContext{UserID: user.ID})
+	login := func(password string) int {
+		return serve(a.Login, "POST", "/auth/login", nil, `{"username": "password_user", "password": "`+password+`"}`).Code
+	}
+
+	assert.Equal(t, http.StatusOK, login("thisIsAG00dPassword!"))
+
+	tables := []struct {
+		name string
+		ctx  context.Context
+		req  changePasswordRequest
+		err  error
+	}{
+		{name: "someone else", ctx: context.Background(), req: changePasswordRequest{CurrentPassword: "thisIsAG00dPassword!", NewPassword: "Chang3d-password"}, err: ApiErrForbidden},
+		{name: "weak", ctx: ctx, req: changePasswordRequest{CurrentPassword: "thisIsAG00dPassword!", NewPassword: "weak"}, err: ApiErrBadRequest},
+		{name: "no current", ctx: ctx, req: changePasswordRequest{NewPassword: "Chang3d-password"}, err: ApiErrBadRequest},
+		{name: "wrong current", ctx: ctx, req: changePasswordRequest{CurrentPassword: "wrong", NewPassword: "Chang3d-password"}, err: auth.ErrWrongPassword},
+	}
+	for _, table := range tables {
+		_, err := c.changePassword(table.ctx, user.ID, table.req)
+		assert.ErrorIs(t, err, table.err, table.name)
+	}
+
+	n, err := c.changePassword(ctx, user.ID, changePasswordRequest{CurrentPassword: "thisIsAG00dPassword!", NewPassword: "Chang3d-password"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, http.StatusForbidden, login("thisIsAG00dPassword!"))
+	assert.Equal(t, http.StatusOK, login("Chang3d-password"))
+
+	// the answer does not say whether the email belongs to anyone
+	w := serve(c.ForgotPassword, "POST", "/auth/password/forgot", nil, `{"email": "nobody@b.c"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = serve(c.ForgotPassword, "POST", "/auth/password/forgot", nil, `{"email": "nope"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	c.forgotPassword(context.Background(), forgotPasswordRequest{Email: "p@b.c"})
+	assert.Len(t, box.sent, 1)
+	token := box.token(t)
+
+	w = serve(c.ResetPassword, "POST", "/auth/password/reset", nil, `{"token": "`+token+`", "new_password": "weak"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(c.ResetPassword, "POST", "/auth/password/reset", nil, `{"token": "`+token+`", "new_password": "Res3t-password"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"revoked": 1}`, w.Body.String())
+	w = serve(c.ResetPassword, "POST", "/auth/password/reset", nil, `{"token": "`+token+`", "new_password": "Res3t-password"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, http.StatusForbidden, login("Chang3d-password"))
+	assert.Equal(t, http.StatusOK, login("Res3t-password"))
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// ReactivateUser lifts a user's suspension, or reopens an account they
+// deactivated.
+func (c *AccountController) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "reactivate user request")
+	vars := mux.Vars(r)
+
+	if err := c.reactivateUser(ctx, vars["user_id"]); err != nil {
+		handleAccountError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (c *AccountController) reactivateUser(ctx context.Context, userID string) error {
+	if err := requirePermission(ctx, model.PermissionUsersManage); err != nil {
+		return err
+	}
+
+	return c.accounts.Reactivate(ctx, userID, request.GetRequestContext(ctx).UserID)
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. It takes a code
+// so a stolen session alone cannot read new ones.
+func (c *MFAController) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "regenerate recovery codes request")
+	vars := mux.Vars(r)
+	req := MFACodeRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding regenerate recovery codes request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	codes, err := c.regenerateRecoveryCodes(ctx, vars["user_id"], req)
+	if err != nil {
+		handleMFAError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "regenerated recovery codes", "user_id", vars["user_id"])
+	w.Header().Set("Cache-Control", "no-store")
+	request.RespondWithJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (c *MFAController) regenerateRecoveryCodes(ctx context.Context, userID string, req MFACodeRequest) ([]string, error) {
+	if err := requireSelf(ctx, userID); err != nil {
+		return nil, err
+	}
+	if err := validateMFACodeRequest(req); err != nil {
+		return nil, err
+	}
+
+	return c.mfa.RegenerateRecoveryCodes(ctx, userID, req.Code)
+}
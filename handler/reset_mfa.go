@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/request"
+)
+
+// ResetMFA turns MFA off. Users turning off their own need a code. Admins can
+// reset anyone's without one, for users who lost their authenticator and
+// recovery codes.
+func (c *MFAController) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "reset mfa request")
+	vars := mux.Vars(r)
+	req := MFACodeRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		slog.WarnContext(ctx, "error decoding reset mfa request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	if err := c.resetMFA(ctx, vars["user_id"], req); err != nil {
+		handleMFAError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (c *MFAController) resetMFA(ctx context.Context, userID string, req MFACodeRequest) error {
+	rc := request.GetRequestContext(ctx)
+	if rc == nil || rc.UserID == "" {
+		return NewApiError(403, ApiErrForbidden).Append("forbidden")
+	}
+
+	if rc.UserID == userID {
+		if err := validateMFACodeRequest(req); err != nil {
+			return err
+		}
+		if err := c.mfa.Verify(ctx, userID, req.Code); err != nil {
+			return err
+		}
+	}
+
+	if err := c.mfa.Reset(ctx, userID); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "reset mfa", "user_id", userID, "by", rc.UserID)
+	return nil
+}
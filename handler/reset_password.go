@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/request"
+)
+
+type resetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ResetPassword sets a new password with the token from a forgot password
+// email, and logs the user out everywhere.
+func (c *PasswordController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "reset password request")
+	req := resetPasswordRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding reset password request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	n, err := c.resetPassword(ctx, req)
+	if err != nil {
+		handlePasswordError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, revokedSessions{Revoked: n})
+}
+
+func (c *PasswordController) resetPassword(ctx context.Context, req resetPasswordRequest) (int, error) {
+	if req.Token == "" {
+		return 0, NewApiError(400, ApiErrBadRequest).Append("token must be present")
+	}
+	if err := validateNewPassword(req.NewPassword); err != nil {
+		return 0, err
+	}
+
+	userID, n, err := c.passwords.Reset(ctx, req.Token, req.NewPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	slog.InfoContext(ctx, "reset password", "user_id", userID)
+	return n, nil
+}
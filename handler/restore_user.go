@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+type restoreUserRequest struct {
+	UserID string
+}
+
+func handleRestoreUserError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error restoring user", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrConflict) {
+		slog.WarnContext(ctx, "error restoring user", "err", err)
+		request.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error restoring user", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+	return
+}
+
+func (c *UserController) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "restore user request")
+	vars := mux.Vars(r)
+	req := restoreUserRequest{UserID: vars["user_id"]}
+
+	user, err := c.restoreUser(ctx, req)
+	if err != nil {
+		handleRestoreUserError(ctx, w, err)
+		return
+	}
+
+	setETag(w, user.Version)
+	request.RespondWithJSON(w, http.StatusOK, user)
+	return
+}
+
+func (c *UserController) restoreUser(ctx context.Context, req restoreUserRequest) (model.User, error) {
+	user, err := c.users.RestoreUser(ctx, req.UserID)
+	if err != nil {
+		if errors.Is(err, dao.ErrUserNotFound) {
+			return user, NewApiError(404, ApiErrNotFound).Append("user is not in the trash")
+		}
+		if errors.Is(err, dao.ErrConflictUsername) {
+			return user, NewApiError(409, ApiErrConflict).Append("username already exists")
+		}
+		if errors.Is(err, dao.ErrConflictEmail) {
+			return user, NewApiError(409, ApiErrConflict).Append("email already exists")
+		}
+		if errors.Is(err, dao.ErrConflictWorkoutName) {
+			return user, NewApiError(409, ApiErrConflict).Append("workout name already exists")
+		}
+		return user, fmt.Errorf("failed to restore user. %w", err)
+	}
+
+	return user, nil
+}
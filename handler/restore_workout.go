@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+type restoreWorkoutRequest struct {
+	UserID    string
+	WorkoutID string
+}
+
+func handleRestoreWorkoutError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error restoring workout", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrConflict) {
+		slog.WarnContext(ctx, "error restoring workout", "err", err)
+		request.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error restoring workout", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+	return
+}
+
+func (c *WorkoutController) RestoreWorkout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "restore workout request")
+	vars := mux.Vars(r)
+	req := restoreWorkoutRequest{
+		UserID:    vars["user_id"],
+		WorkoutID: vars["workout_id"],
+	}
+
+	workout, err := c.restoreWorkout(ctx, req)
+	if err != nil {
+		handleRestoreWorkoutError(ctx, w, err)
+		return
+	}
+
+	setETag(w, workout.Version)
+	request.RespondWithJSON(w, http.StatusOK, workout)
+	return
+}
+
+func (c *WorkoutController) restoreWorkout(ctx context.Context, req restoreWorkoutRequest) (model.Workout, error) {
+	workout, err := c.workouts.RestoreWorkout(ctx, req.UserID, req.WorkoutID)
+	if err != nil {
+		if errors.Is(err, dao.ErrWorkoutNotFound) {
+			return workout, NewApiError(404, ApiErrNotFound).Append("workout is not in the trash")
+		}
+		if errors.Is(err, dao.ErrConflictWorkoutName) {
+			return workout, NewApiError(409, ApiErrConflict).Append("workout name already exists")
+		}
+		return workout, fmt.Errorf("failed to restore workout. %w", err)
+	}
+
+	return workout, nil
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleRevokeAuthorizedAppError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, dao.ErrOAuthGrantNotFound) {
+		slog.WarnContext(ctx, "error revoking authorized app", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, "app not found")
+		return
+	}
+
+	slog.ErrorContext(ctx, "error revoking authorized app", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// RevokeAuthorizedApp cuts an app off from the user's account. Its tokens
+// stop working straight away.
+func (c *AuthorizationController) RevokeAuthorizedApp(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "revoke authorized app request")
+	vars := mux.Vars(r)
+
+	n, err := c.server.RevokeApp(ctx, vars["user_id"], vars["client_id"])
+	if err != nil {
+		handleRevokeAuthorizedAppError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, revokedSessions{Revoked: n})
+}
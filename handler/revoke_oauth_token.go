@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+)
+
+// RevokeOAuthToken lets an app end a login with its refresh token, as RFC
+// 7009 has it. Unknown tokens are not an error.
+func (c *AuthorizationController) RevokeOAuthToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "revoke oauth token request")
+
+	if err := r.ParseForm(); err != nil {
+		handleOAuthTokenError(ctx, w, &auth.OAuthError{Code: auth.OAuthInvalidRequest, Description: "malformed request body"})
+		return
+	}
+
+	if err := c.server.Revoke(ctx, clientCredentials(r), r.PostForm.Get("token")); err != nil {
+		handleOAuthTokenError(ctx, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleRevokePersonalAccessTokenError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error revoking personal access token", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error revoking personal access token", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// RevokePersonalAccessToken revokes one of the user's tokens. Admins can
+// revoke anyone's.
+func (c *AccessTokenController) RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "revoke personal access token request")
+	vars := mux.Vars(r)
+
+	err := c.pats.RevokePersonalAccessToken(ctx, vars["user_id"], vars["token_id"])
+	if err != nil {
+		if errors.Is(err, dao.ErrPersonalAccessTokenNotFound) {
+			err = NewApiError(404, ApiErrNotFound).Append("token not found")
+		}
+		handleRevokePersonalAccessTokenError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "revoked personal access token", "user_id", vars["user_id"], "token_id", vars["token_id"])
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
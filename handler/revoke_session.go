@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+type revokedSessions struct {
+	Revoked int `json:"revoked"`
+}
+
+func handleRevokeSessionError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error revoking session", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error revoking session", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+	return
+}
+
+// RevokeSession ends one of the user's sessions. Admins can end anyone's.
+func (c *SessionController) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "revoke session request")
+	vars := mux.Vars(r)
+
+	err := c.sessions.RevokeSession(ctx, vars["user_id"], vars["session_id"])
+	if err != nil {
+		if errors.Is(err, dao.ErrSessionNotFound) {
+			err = NewApiError(404, ApiErrNotFound).Append("session not found")
+		}
+		handleRevokeSessionError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "revoked session", "user_id", vars["user_id"], "session_id", vars["session_id"])
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// RevokeSessions ends every session and refresh token the user has, including
+// this one. Admins may revoke another user's sessions.
+func (c *SessionController) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "revoke sessions request")
+	vars := mux.Vars(r)
+
+	n, err := c.revokeAll(ctx, vars["user_id"])
+	if err != nil {
+		handleRevokeSessionError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "revoked sessions", "user_id", vars["user_id"], "revoked", n)
+	request.RespondWithJSON(w, http.StatusOK, revokedSessions{Revoked: n})
+}
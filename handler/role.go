@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+const maxRoleName = 50
+
+type RoleController struct {
+	roles dao.RoleRepository
+}
+
+func NewRoleController(roles dao.RoleRepository) RoleController {
+	return RoleController{
+		roles: roles,
+	}
+}
+
+// roleRequest creates a role or replaces one.
+type roleRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	IsDefault   bool     `json:"isDefault"`
+}
+
+func handleRoleError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error role", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error role", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	} else if errors.Is(err, dao.ErrRoleNotFound) {
+		slog.WarnContext(ctx, "error role", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, "role not found")
+		return
+	} else if errors.Is(err, dao.ErrUserNotFound) {
+		slog.WarnContext(ctx, "error role", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, "user not found")
+		return
+	} else if errors.Is(err, dao.ErrConflictRoleName) {
+		slog.WarnContext(ctx, "error role", "err", err)
+		request.RespondWithError(w, http.StatusConflict, "role name already exists")
+		return
+	}
+
+	slog.ErrorContext(ctx, "error role", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+func parseRoleID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, NewApiError(400, ApiErrBadRequest).Append("invalid role_id")
+	}
+	return id, nil
+}
+
+func validateRoleRequest(req *roleRequest) error {
+	apiErr := NewApiError(400, ApiErrBadRequest)
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		apiErr.Append("name is required")
+	} else if len(req.Name) > maxRoleName {
+		apiErr.Append(fmt.Sprintf("name must be at most %d characters", maxRoleName))
+	}
+
+	for _, permission := range req.Permissions {
+		if !model.ValidPermission(permission) {
+			apiErr.Append(fmt.Sprintf("unknown permission %q, must be one of %s", permission, strings.Join(model.Permissions, ", ")))
+		}
+	}
+	if req.Permissions == nil {
+		req.Permissions = []string{}
+	}
+	slices.Sort(req.Permissions)
+	req.Permissions = slices.Compact(req.Permissions)
+
+	if apiErr.HasError() {
+		return apiErr
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+	repo := dao.NewMemory()
+	c := NewRoleController(repo)
+	user := createUser(t, NewUserController(repo, repo, nil), "role_user", "role@b.c")
+	userCtx := request.WithRequestContext(ctx, &request.RequestContext{UserID: user.ID, Permissions: []string{model.PermissionUsersRead}})
+	adminCtx := request.WithRequestContext(ctx, &request.RequestContext{UserID: "user_admin", Roles: []string{"ADMIN"}, Permissions: model.Permissions})
+
+	// new users get the default roles
+	roles, err := repo.GetUserRoles(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+	assert.True(t, roles[0].IsDefault)
+
+	_, err = c.getRoles(userCtx)
+	assert.ErrorIs(t, err, ApiErrForbidden)
+	_, err = c.createRole(userCtx, roleRequest{Name: "COACH"})
+	assert.ErrorIs(t, err, ApiErrForbidden)
+
+	_, err = c.createRole(adminCtx, roleRequest{Name: " ", Permissions: []string{"workouts:fly"}})
+	assert.ErrorIs(t, err, ApiErrBadRequest)
+	_, err = c.createRole(adminCtx, roleRequest{Name: "ADMIN"})
+	assert.ErrorIs(t, err, dao.ErrConflictRoleName)
+	coach, err := c.createRole(adminCtx, roleRequest{Name: "COACH", Permissions: []string{model.PermissionWorkoutsReadAny, model.PermissionWorkoutsReadAny}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{model.PermissionWorkoutsReadAny}, coach.Permissions)
+
+	roles, err = c.getRoles(adminCtx)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 3)
+
+	coach, err = c.updateRole(adminCtx, "3", roleRequest{Name: "COACH", Permissions: []string{model.PermissionUsersList}})
+	assert.NoError(t, err)
+	got, err := c.getRole(adminCtx, "3")
+	assert.NoError(t, err)
+	assert.Equal(t, coach.Permissions, got.Permissions)
+	_, err = c.updateRole(adminCtx, "99", roleRequest{Name: "NOBODY"})
+	assert.ErrorIs(t, err, dao.ErrRoleNotFound)
+	_, err = c.getRole(adminCtx, "x")
+	assert.ErrorIs(t, err, ApiErrBadRequest)
+
+	// assignments change the permissions a user's sessions see
+	assert.ErrorIs(t, c.changeUserRole(adminCtx, "user_admin", "3", true), ApiErrBadRequest)
+	assert.ErrorIs(t, c.changeUserRole(adminCtx, "user_nobody", "3", true), dao.ErrUserNotFound)
+	assert.ErrorIs(t, c.changeUserRole(adminCtx, user.ID, "99", true), dao.ErrRoleNotFound)
+	assert.NoError(t, c.changeUserRole(adminCtx, user.ID, "3", true))
+	assert.NoError(t, c.changeUserRole(adminCtx, user.ID, "3", true))
+	permissions, err := repo.GetUserPermissions(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Contains(t, permissions, model.PermissionUsersList)
+	assert.NoError(t, c.changeUserRole(adminCtx, user.ID, "3", false))
+	permissions, err = repo.GetUserPermissions(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.NotContains(t, permissions, model.PermissionUsersList)
+
+	assert.NoError(t, c.changeUserRole(adminCtx, user.ID, "3", true))
+	assert.NoError(t, c.deleteRole(adminCtx, "3"))
+	assert.ErrorIs(t, c.deleteRole(adminCtx, "3"), dao.ErrRoleNotFound)
+	permissions, err = repo.GetUserPermissions(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.NotContains(t, permissions, model.PermissionUsersList)
+}
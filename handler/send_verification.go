@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/request"
+)
+
+func (c *UserController) handleSendVerificationError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error sending verification email", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, ApiErrConflict) {
+		slog.WarnContext(ctx, "error sending verification email", "err", err)
+		request.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, dao.ErrVerificationRateLimited) {
+		slog.WarnContext(ctx, "error sending verification email", "err", err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(c.verifier.ResendInterval().Seconds())))
+		request.RespondWithError(w, http.StatusTooManyRequests, "verification email was sent recently, try again later")
+		return
+	}
+
+	slog.ErrorContext(ctx, "error sending verification email", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// SendVerification mails the user another verification link.
+func (c *UserController) SendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "send verification request")
+	vars := mux.Vars(r)
+
+	if err := c.sendVerification(ctx, vars["user_id"]); err != nil {
+		c.handleSendVerificationError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusAccepted, nil)
+}
+
+func (c *UserController) sendVerification(ctx context.Context, userID string) error {
+	if c.verifier == nil {
+		return NewApiError(404, ApiErrNotFound).Append("email verification is not enabled")
+	}
+
+	user, err := c.users.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, dao.ErrUserNotFound) {
+			return NewApiError(404, ApiErrNotFound).Append("user not found")
+		}
+		return fmt.Errorf("failed to get user. %w", err)
+	}
+
+	err = c.verifier.Send(ctx, user)
+	if errors.Is(err, auth.ErrAlreadyVerified) {
+		return NewApiError(409, ApiErrConflict).Append("email is already verified")
+	}
+
+	return err
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/slham/sandbox-api/dao"
+)
+
+type SessionController struct {
+	sessions      dao.SessionRepository
+	refreshTokens dao.RefreshTokenRepository
+}
+
+func NewSessionController(sessions dao.SessionRepository, refreshTokens dao.RefreshTokenRepository) SessionController {
+	return SessionController{
+		sessions:      sessions,
+		refreshTokens: refreshTokens,
+	}
+}
+
+// revokeAll ends the user's sessions and the logins behind their refresh
+// tokens, and returns how many were ended.
+func (c *SessionController) revokeAll(ctx context.Context, userID string) (int, error) {
+	n, err := c.sessions.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to revoke sessions. %w", err)
+	}
+
+	tokens, err := c.refreshTokens.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return n, fmt.Errorf("failed to revoke refresh tokens. %w", err)
+	}
+
+	return n + tokens, nil
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+type suspendUserRequest struct {
+	Reason string `json:"reason"`
+	// Until ends the suspension on its own. Without it the suspension lasts
+	// until the user is reactivated.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// SuspendUser shuts a user out of the api. Their sessions and tokens are
+// refused from the next request on, with the reason given.
+func (c *AccountController) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "suspend user request")
+	vars := mux.Vars(r)
+	req := suspendUserRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding suspend user request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	if err := c.suspendUser(ctx, vars["user_id"], req); err != nil {
+		handleAccountError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (c *AccountController) suspendUser(ctx context.Context, userID string, req suspendUserRequest) error {
+	if err := requirePermission(ctx, model.PermissionUsersManage); err != nil {
+		return err
+	}
+	actorID := request.GetRequestContext(ctx).UserID
+	if userID == actorID {
+		return NewApiError(400, ApiErrBadRequest).Append("admins cannot suspend themselves")
+	}
+	if req.Reason == "" {
+		return NewApiError(400, ApiErrBadRequest).Append("reason must be present")
+	}
+
+	return c.accounts.Suspend(ctx, userID, actorID, req.Reason, req.Until)
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+const (
+	grantTypePassword     = "password"
+	grantTypeRefreshToken = "refresh_token"
+	grantTypeMFA          = "mfa"
+)
+
+// TokenRequest logs in with a username and password, finishes such a login
+// with an mfa code, or swaps a refresh token for a new pair.
+type TokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"`
+	Code         string `json:"code,omitempty"`
+	// IP is the client's address, which failed logins are counted against.
+	IP string `json:"-"`
+}
+
+type RevokeTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func handleTokenError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrRefreshTokenReused) {
+		slog.WarnContext(ctx, "error token", "err", err)
+		request.RespondWithError(w, http.StatusUnauthorized, "refresh token reuse detected. log in again")
+		return
+	} else if errors.Is(err, auth.ErrInvalidToken) {
+		slog.WarnContext(ctx, "error token", "err", err)
+		request.RespondWithError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	handleLoginError(ctx, w, err)
+}
+
+// Token issues an access token and a refresh token for clients that cannot
+// hold the session cookie.
+func (c *AuthController) Token(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "token request")
+	tokenRequest := TokenRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&tokenRequest); err != nil {
+		slog.WarnContext(ctx, "error decoding token request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	var pair auth.TokenPair
+	var challenge *auth.MFAChallenge
+	var err error
+	switch tokenRequest.GrantType {
+	case grantTypePassword:
+		tokenRequest.IP = auth.ClientIP(r)
+		pair, challenge, err = c.handlePasswordGrant(ctx, tokenRequest)
+	case grantTypeMFA:
+		pair, err = c.handleMFAGrant(ctx, tokenRequest)
+	case grantTypeRefreshToken:
+		if tokenRequest.RefreshToken == "" {
+			err = NewApiError(400, ApiErrBadRequest).Append("refresh_token must be present")
+			break
+		}
+		pair, err = c.tokens.Refresh(ctx, tokenRequest.RefreshToken)
+	default:
+		err = NewApiError(400, ApiErrBadRequest).Append("grant_type must be password, mfa or refresh_token")
+	}
+	if err != nil {
+		handleTokenError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if challenge != nil {
+		request.RespondWithJSON(w, http.StatusAccepted, challenge)
+		return
+	}
+	request.RespondWithJSON(w, http.StatusOK, pair)
+}
+
+// handlePasswordGrant issues tokens, or a challenge when the user has MFA on.
+func (c *AuthController) handlePasswordGrant(ctx context.Context, req TokenRequest) (auth.TokenPair, *auth.MFAChallenge, error) {
+	user, err := c.handleLogin(ctx, LoginRequest{Username: req.Username, Password: req.Password, IP: req.IP})
+	if err != nil {
+		return auth.TokenPair{}, nil, err
+	}
+
+	challenge, err := c.mfaChallenge(ctx, user)
+	if err != nil || challenge != nil {
+		return auth.TokenPair{}, challenge, err
+	}
+
+	pair, err := c.issueTokens(ctx, user)
+	return pair, nil, err
+}
+
+func (c *AuthController) handleMFAGrant(ctx context.Context, req TokenRequest) (auth.TokenPair, error) {
+	user, err := c.completeMFAChallenge(ctx, LoginMFARequest{MFAToken: req.MFAToken, Code: req.Code})
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	return c.issueTokens(ctx, user)
+}
+
+func (c *AuthController) issueTokens(ctx context.Context, user model.User) (auth.TokenPair, error) {
+	pair, err := c.tokens.Issue(ctx, user)
+	if err != nil {
+		return pair, fmt.Errorf("failed to issue tokens. %w", err)
+	}
+
+	slog.InfoContext(ctx, "issued tokens", "user_id", user.ID)
+	return pair, nil
+}
+
+// RevokeToken ends the login the refresh token belongs to. Its access tokens
+// stay valid until they expire.
+func (c *AuthController) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "revoke token request")
+	revokeRequest := RevokeTokenRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&revokeRequest); err != nil || revokeRequest.RefreshToken == "" {
+		slog.WarnContext(ctx, "error decoding revoke token request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	if err := c.tokens.Revoke(ctx, revokeRequest.RefreshToken); err != nil {
+		handleTokenError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// JWKS publishes the keys access tokens are signed with.
+func (c *AuthController) JWKS(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	keys, err := c.tokens.JWKS(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get jwks", "err", err)
+		request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	request.RespondWithJSON(w, http.StatusOK, keys)
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+func handleUnlockUserError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrForbidden) {
+		slog.WarnContext(ctx, "error unlocking user", "err", err)
+		request.RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	} else if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error unlocking user", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error unlocking user", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+}
+
+// UnlockUser lets a user locked out by failed logins try again straight away.
+func (c *AdminController) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "unlock user request")
+	vars := mux.Vars(r)
+
+	if err := c.unlockUser(ctx, vars["user_id"]); err != nil {
+		handleUnlockUserError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (c *AdminController) unlockUser(ctx context.Context, userID string) error {
+	if err := requirePermission(ctx, model.PermissionUsersManage); err != nil {
+		return err
+	}
+	if c.guard == nil {
+		return NewApiError(404, ApiErrNotFound).Append("login lockout is not enabled")
+	}
+
+	err := c.guard.Unlock(ctx, userID, request.GetRequestContext(ctx).UserID)
+	if errors.Is(err, dao.ErrUserNotFound) {
+		return NewApiError(404, ApiErrNotFound).Append("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unlock user. %w", err)
+	}
+
+	return nil
+}
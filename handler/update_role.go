@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// UpdateRole replaces a role's name and permissions. Users with the role get
+// the new permissions on their next request.
+func (c *RoleController) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "update role request")
+	vars := mux.Vars(r)
+	req := roleRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(ctx, "error decoding update role request", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, "malformed request body")
+		return
+	}
+
+	role, err := c.updateRole(ctx, vars["role_id"], req)
+	if err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "updated role", "role_id", role.ID, "permissions", role.Permissions)
+	request.RespondWithJSON(w, http.StatusOK, role)
+}
+
+func (c *RoleController) updateRole(ctx context.Context, roleID string, req roleRequest) (model.Role, error) {
+	if err := requirePermission(ctx, model.PermissionRolesWrite); err != nil {
+		return model.Role{}, err
+	}
+	id, err := parseRoleID(roleID)
+	if err != nil {
+		return model.Role{}, err
+	}
+	if err := validateRoleRequest(&req); err != nil {
+		return model.Role{}, err
+	}
+
+	return c.roles.UpdateRole(ctx, model.Role{
+		ID:          id,
+		Name:        req.Name,
+		Permissions: req.Permissions,
+		IsDefault:   req.IsDefault,
+	})
+}
@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +17,7 @@ import (
 
 type updateUserRequest struct {
 	UserID   string
+	Version  int
 	Username string `json:"username"`
 	Email    string `json:"email"`
 }
@@ -29,12 +29,30 @@ func handleUpdateUserError(ctx context.Context, w http.ResponseWriter, err error
 		return
 	}
 
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error updating user", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
 	if errors.Is(err, ApiErrConflict) {
 		slog.WarnContext(ctx, "error updating user", "err", err)
 		request.RespondWithError(w, http.StatusConflict, err.Error())
 		return
 	}
 
+	if errors.Is(err, ApiErrPreconditionFailed) {
+		slog.WarnContext(ctx, "error updating user", "err", err)
+		request.RespondWithError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrPreconditionRequired) {
+		slog.WarnContext(ctx, "error updating user", "err", err)
+		request.RespondWithError(w, http.StatusPreconditionRequired, err.Error())
+		return
+	}
+
 	slog.ErrorContext(ctx, "error updating user", "err", err)
 	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
 	return
@@ -55,51 +73,93 @@ func (c *UserController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 
 	req.UserID = userID
 
-	user, err := c.updateUser(ctx, req)
+	version, err := ifMatchVersion(r)
 	if err != nil {
 		handleUpdateUserError(ctx, w, err)
 		return
 	}
+	req.Version = version
+
+	var user model.User
+	var emailChanged bool
+	err = c.users.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		user, emailChanged, err = c.updateUser(ctx, req)
+		return err
+	})
+	if err != nil {
+		handleUpdateUserError(ctx, w, err)
+		return
+	}
+
+	// the new address has to be verified. as on sign up, a failed email only
+	// means the user has to ask for another one
+	if emailChanged {
+		if err := c.verifier.Send(ctx, user); err != nil {
+			slog.ErrorContext(ctx, "failed to send verification email", "user_id", user.ID, "err", err)
+		}
+	}
 
+	setETag(w, user.Version)
 	request.RespondWithJSON(w, http.StatusOK, user)
 	return
 }
 
-func (c *UserController) updateUser(ctx context.Context, req updateUserRequest) (model.User, error) {
+// updateUser returns the user as stored after the update, and whether their
+// email changed.
+func (c *UserController) updateUser(ctx context.Context, req updateUserRequest) (model.User, bool, error) {
 	user, err := c.getUserByID(ctx, getUserRequest{ID: req.UserID})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return user, NewApiError(404, ApiErrNotFound)
+		if errors.Is(err, dao.ErrUserNotFound) {
+			return user, false, NewApiError(404, ApiErrNotFound)
 		}
-		return user, fmt.Errorf("failed to update user. %w", err)
+		return user, false, fmt.Errorf("failed to update user. %w", err)
+	}
+
+	if req.Version != 0 && req.Version != user.Version {
+		return user, false, NewApiError(412, ApiErrPreconditionFailed).Append("user has been modified")
 	}
 
 	if err := validateUpdateUserRequest(ctx, req); err != nil {
-		return user, fmt.Errorf("failed to validate update user request. %w", err)
+		return user, false, fmt.Errorf("failed to validate update user request. %w", err)
 	}
 
 	if req.Username != "" {
 		user.Username = req.Username
 	}
 
+	emailChanged := req.Email != "" && req.Email != user.Email
 	if req.Email != "" {
 		user.Email = req.Email
 	}
 
 	user.Password = ""
 
-	err = dao.UpdateUser(ctx, user)
+	// roles are managed separately, leave them untouched
+	update := user
+	update.Roles = nil
+	update.Version = req.Version
+
+	updated, err := c.users.UpdateUser(ctx, update)
 	if err != nil {
+		if errors.Is(err, dao.ErrVersionConflict) {
+			return user, false, NewApiError(412, ApiErrPreconditionFailed).Append("user has been modified")
+		}
 		if errors.Is(err, dao.ErrConflictUsername) {
-			return user, NewApiError(409, ApiErrConflict).Append("username already exists")
+			return user, false, NewApiError(409, ApiErrConflict).Append("username already exists")
 		}
 		if errors.Is(err, dao.ErrConflictEmail) {
-			return user, NewApiError(409, ApiErrConflict).Append("email already exists")
+			return user, false, NewApiError(409, ApiErrConflict).Append("email already exists")
 		}
-		return user, fmt.Errorf("failed to update user. %w", err)
+		return user, false, fmt.Errorf("failed to update user. %w", err)
 	}
 
-	return user, nil
+	user.Updated = updated.Updated
+	user.Version = updated.Version
+	user.IsVerified = updated.IsVerified
+	user.AccountState = updated.AccountState
+
+	return user, emailChanged, nil
 }
 
 func validateUpdateUserRequest(ctx context.Context, req updateUserRequest) error {
@@ -18,6 +18,7 @@ import (
 type updateWorkoutRequest struct {
 	UserID    string
 	WorkoutID string
+	Version   int
 	Name      string          `json:"name"`
 	Exercises model.Exercises `json:"exercises"`
 }
@@ -29,12 +30,30 @@ func handleUpdateWorkoutError(ctx context.Context, w http.ResponseWriter, err er
 		return
 	}
 
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error creating workout", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
 	if errors.Is(err, ApiErrConflict) {
 		slog.WarnContext(ctx, "error creating workout", "err", err)
 		request.RespondWithError(w, http.StatusConflict, err.Error())
 		return
 	}
 
+	if errors.Is(err, ApiErrPreconditionFailed) {
+		slog.WarnContext(ctx, "error updating workout", "err", err)
+		request.RespondWithError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrPreconditionRequired) {
+		slog.WarnContext(ctx, "error updating workout", "err", err)
+		request.RespondWithError(w, http.StatusPreconditionRequired, err.Error())
+		return
+	}
+
 	slog.ErrorContext(ctx, "error creating workout", "err", err)
 	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
 	return
@@ -62,43 +81,69 @@ func (c *WorkoutController) UpdateWorkout(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	workout, err := c.updateWorkout(ctx, req)
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		handleUpdateWorkoutError(ctx, w, err)
+		return
+	}
+	req.Version = version
+
+	var workout model.Workout
+	err = c.workouts.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		workout, err = c.updateWorkout(ctx, req)
+		return err
+	})
 	if err != nil {
 		handleUpdateWorkoutError(ctx, w, err)
 		return
 	}
 
+	setETag(w, workout.Version)
 	request.RespondWithJSON(w, http.StatusOK, workout)
 	return
 }
 
 func (c *WorkoutController) updateWorkout(ctx context.Context, req updateWorkoutRequest) (model.Workout, error) {
 	workout := model.Workout{}
-	if _, err := dao.GetUserByID(ctx, req.UserID); err != nil {
+	if _, err := c.users.GetUserByID(ctx, req.UserID); err != nil {
 		slog.Warn("failed to find user", "err", err)
 		return workout, NewApiError(404, ApiErrNotFound).Append("user does not exist")
 	}
 
-	workout, err := dao.GetWorkoutByID(ctx, req.UserID, req.WorkoutID)
+	workout, err := c.workouts.GetWorkoutByID(ctx, req.UserID, req.WorkoutID)
 	if err != nil {
 		slog.Warn("failed to find workout", "err", err)
 		return workout, NewApiError(404, ApiErrNotFound).Append("workout does not exist")
 	}
 
+	if req.Version != 0 && req.Version != workout.Version {
+		return workout, NewApiError(412, ApiErrPreconditionFailed).Append("workout has been modified")
+	}
+
 	if err := validateUpdateWorkoutRequest(ctx, req); err != nil {
 		return workout, fmt.Errorf("failed to validate update workout request. %w", err)
 	}
 
 	workout.Name = req.Name
 	workout.Exercises = req.Exercises
+	workout.Version = req.Version
 
-	if err := dao.UpdateWorkout(ctx, workout); err != nil {
+	workout, err = c.workouts.UpdateWorkout(ctx, workout)
+	if err != nil {
+		if errors.Is(err, dao.ErrVersionConflict) {
+			return workout, NewApiError(412, ApiErrPreconditionFailed).Append("workout has been modified")
+		}
 		if errors.Is(err, dao.ErrConflictWorkoutName) {
 			return workout, NewApiError(409, ApiErrConflict).Append("workout name already exists")
 		}
 		return workout, fmt.Errorf("failed to update workout. %w", err)
 	}
 
+	if err := c.recordRevision(ctx, workout); err != nil {
+		return workout, err
+	}
+
 	return workout, nil
 }
 
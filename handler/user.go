@@ -1,8 +1,34 @@
 package handler
 
+import (
+	"context"
+	"fmt"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+)
+
 type UserController struct {
+	users    dao.UserRepository
+	roles    dao.RoleRepository
+	verifier *auth.EmailVerifier
 }
 
-func NewUserController() UserController {
-	return UserController{}
+func NewUserController(users dao.UserRepository, roles dao.RoleRepository, verifier *auth.EmailVerifier) UserController {
+	return UserController{
+		users:    users,
+		roles:    roles,
+		verifier: verifier,
+	}
+}
+
+// defaultRoles returns the roles every new user is given.
+func defaultRoles(ctx context.Context, roles dao.RoleRepository) ([]model.Role, error) {
+	defaults, err := roles.GetRoles(ctx, dao.RoleQuery{Default: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default user roles. %w", err)
+	}
+
+	return defaults, nil
 }
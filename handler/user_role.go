@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// AddUserRole gives a user a role. It reaches their live sessions on their
+// next request.
+func (c *RoleController) AddUserRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "add user role request")
+	vars := mux.Vars(r)
+
+	if err := c.changeUserRole(ctx, vars["user_id"], vars["role_id"], true); err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// RemoveUserRole takes a role away from a user. It reaches their live
+// sessions on their next request.
+func (c *RoleController) RemoveUserRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "remove user role request")
+	vars := mux.Vars(r)
+
+	if err := c.changeUserRole(ctx, vars["user_id"], vars["role_id"], false); err != nil {
+		handleRoleError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// changeUserRole adds or removes the role. Users cannot change their own
+// roles, so an admin cannot lock everyone out by accident.
+func (c *RoleController) changeUserRole(ctx context.Context, userID string, roleID string, add bool) error {
+	if err := requirePermission(ctx, model.PermissionRolesWrite); err != nil {
+		return err
+	}
+	if userID == request.GetRequestContext(ctx).UserID {
+		return NewApiError(400, ApiErrBadRequest).Append("you cannot change your own roles")
+	}
+	id, err := parseRoleID(roleID)
+	if err != nil {
+		return err
+	}
+
+	if add {
+		err = c.roles.AddUserRole(ctx, userID, id)
+	} else {
+		err = c.roles.RemoveUserRole(ctx, userID, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "changed user roles", "user_id", userID, "role_id", id, "added", add)
+	return nil
+}
@@ -0,0 +1,144 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/crypt"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	crypt.Initialize("qwertyuiopasdfghjklzxcvbnm098765")
+	crypt.SetArgon2Params(crypt.Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32})
+}
+
+func serve(f http.HandlerFunc, method string, target string, vars map[string]string, body string) *httptest.ResponseRecorder {
+	return serveWithHeader(f, method, target, vars, body, nil)
+}
+
+func serveWithHeader(f http.HandlerFunc, method string, target string, vars map[string]string, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	w := httptest.NewRecorder()
+	f(w, r)
+	return w
+}
+
+func createUser(t *testing.T, c UserController, username, email string) model.User {
+	t.Helper()
+	body := `{"username": "` + username + `", "password": "thisIsAG00dPassword!", "email": "` + email + `"}`
+	w := serve(c.CreateUser, "POST", "/users", nil, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to create user. %d %s", w.Code, w.Body.String())
+	}
+
+	user := model.User{}
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatal(err.Error())
+	}
+	return user
+}
+
+func TestCreateUser(t *testing.T) {
+	repo := dao.NewMemory()
+	c := NewUserController(repo, repo, nil)
+
+	tables := []struct {
+		name string
+		req  string
+		code int
+		resp string
+	}{
+		{
+			name: "create fail validations",
+			req:  `{"username": "bad", "password": "bad", "email": "bad"}`,
+			code: http.StatusBadRequest,
+			resp: `{"errors":"failed to validate create user request. username must be at leat four characters long. password must be at least 8 characters long and contain at least one number, one special character, one upper case character, and one lower case character. invalid email"}`,
+		},
+		{
+			name: "create happy path",
+			req:  `{"username": "test_user_1", "password": "thisIsAG00dPassword!", "email": "a@b.c"}`,
+			code: http.StatusCreated,
+		},
+		{
+			name: "create fail username conflict",
+			req:  `{"username": "test_user_1", "password": "thisIsAG00dPassword!", "email": "c@d.e"}`,
+			code: http.StatusConflict,
+			resp: `{"errors":"username already exists"}`,
+		},
+		{
+			name: "create fail email conflict",
+			req:  `{"username": "test_user_2", "password": "thisIsAG00dPassword!", "email": "a@b.c"}`,
+			code: http.StatusConflict,
+			resp: `{"errors":"email already exists"}`,
+		},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			w := serve(c.CreateUser, "POST", "/users", nil, table.req)
+			assert.Equal(t, table.code, w.Code)
+			if table.resp != "" {
+				assert.JSONEq(t, table.resp, w.Body.String())
+			}
+		})
+	}
+
+	user, err := repo.GetUserByUsername(context.Background(), "test_user_1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "thisIsAG00dPassword!", user.Password, "password must not be stored in plain text")
+	assert.Len(t, user.Roles, 1)
+	assert.Equal(t, "CIVILIAN", user.Roles[0].Name)
+}
+
+func TestGetUpdateDeleteUser(t *testing.T) {
+	repo := dao.NewMemory()
+	c := NewUserController(repo, repo, nil)
+	user := createUser(t, c, "test_user_1", "a@b.c")
+	createUser(t, c, "test_user_2", "c@d.e")
+
+	w := serve(c.GetUser, "GET", "/users/"+user.ID, map[string]string{"user_id": user.ID}, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(c.GetUser, "GET", "/users/user_nope", map[string]string{"user_id": "user_nope"}, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(c.UpdateUser, "PATCH", "/users/"+user.ID, map[string]string{"user_id": user.ID}, `{"username": "test_user_2", "email": "x@y.z"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors":"username already exists"}`, w.Body.String())
+
+	w = serve(c.UpdateUser, "PATCH", "/users/"+user.ID, map[string]string{"user_id": user.ID}, `{"username": "test_user_3", "email": "x@y.z"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(c.GetUsers, "GET", "/users?search=USER_3", nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	users := []model.User{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &users))
+	assert.Len(t, users, 1)
+	assert.Equal(t, "test_user_3", users[0].Username)
+
+	w = serve(c.GetUsers, "GET", "/users?sort_column=password", nil, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(c.DeleteUser, "DELETE", "/users/"+user.ID, map[string]string{"user_id": user.ID}, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve(c.DeleteUser, "DELETE", "/users/"+user.ID, map[string]string{"user_id": user.ID}, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,108 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/mail"
+	"github.com/slham/sandbox-api/middlewares"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+	"github.com/stretchr/testify/assert"
+)
+
+type outbox struct {
+	sent []mail.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg mail.Message) error {
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+func (o *outbox) token(t *testing.T) string {
+	t.Helper()
+	link, err := url.Parse(regexp.MustCompile(`https?://\S+`).FindString(o.sent[len(o.sent)-1].Body))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return link.Query().Get("token")
+}
+
+func serveRequest(f http.HandlerFunc, r *http.Request, vars map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	f(w, mux.SetURLVars(r, vars))
+	return w
+}
+
+func TestEmailVerification(t *testing.T) {
+	repo := dao.NewMemory()
+	box := &outbox{}
+	c := NewUserController(repo, repo, auth.NewEmailVerifier(repo, box, auth.VerificationConfig{}))
+	user := createUser(t, c, "verify_user", "v@b.c")
+	assert.False(t, user.IsVerified)
+	assert.Len(t, box.sent, 1)
+	assert.Equal(t, "v@b.c", box.sent[0].To)
+
+	ctx := request.WithRequestContext(context.Background(), &request.RequestContext{UserID: user.ID})
+	vars := map[string]string{"user_id": user.ID}
+	resend := func() int {
+		r, _ := http.NewRequestWithContext(ctx, "POST", "/users/"+user.ID+"/verification", nil)
+		return serveRequest(c.SendVerification, r, vars).Code
+	}
+
+	// verified only actions are refused until the link is followed
+	gate := middlewares.NewVerifiedGate(repo, []string{middlewares.ActionWorkoutsWrite})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	gated := middlewares.Chain(ok, gate.Require(middlewares.ActionWorkoutsWrite))
+	r, _ := http.NewRequestWithContext(ctx, "POST", "/users/"+user.ID+"/workouts", nil)
+	assert.Equal(t, http.StatusForbidden, serveRequest(gated, r, vars).Code)
+	ungated := middlewares.Chain(ok, gate.Require(middlewares.ActionTokensCreate))
+	assert.Equal(t, http.StatusNoContent, serveRequest(ungated, r, vars).Code)
+
+	assert.Equal(t, http.StatusTooManyRequests, resend())
+
+	w := serve(c.VerifyEmail, "GET", "/auth/verify?token=nope", nil, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(c.VerifyEmail, "GET", "/auth/verify?token="+url.QueryEscape(box.token(t)), nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"verified": true}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNoContent, serveRequest(gated, r, vars).Code)
+	assert.Equal(t, http.StatusConflict, resend())
+
+	// a new address is unverified and gets its own link straight away
+	oldLink := box.token(t)
+	w = serve(c.UpdateUser, "PATCH", "/users/"+user.ID, vars, `{"username": "verify_user", "email": "new@b.c"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	updated := model.User{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, "new@b.c", updated.Email)
+	assert.False(t, updated.IsVerified)
+	assert.Len(t, box.sent, 2)
+	assert.Equal(t, "new@b.c", box.sent[1].To)
+	assert.Equal(t, http.StatusForbidden, serveRequest(gated, r, vars).Code)
+
+	w = serve(c.VerifyEmail, "GET", "/auth/verify?token="+url.QueryEscape(oldLink), nil, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(c.VerifyEmail, "GET", "/auth/verify?token="+url.QueryEscape(box.token(t)), nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// keeping the address keeps it verified, and sends nothing
+	w = serve(c.UpdateUser, "PATCH", "/users/"+user.ID, vars, `{"username": "verify_user_2", "email": "new@b.c"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.True(t, updated.IsVerified)
+	assert.Len(t, box.sent, 2)
+}
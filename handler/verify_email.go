@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+type verifyEmailResponse struct {
+	Verified bool `json:"verified"`
+}
+
+// VerifyEmail follows the link mailed by SendVerification. It needs no
+// session since the signed token says whose address it is.
+func (c *UserController) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "verify email request")
+
+	token := r.URL.Query().Get("token")
+	if token == "" || c.verifier == nil {
+		request.RespondWithError(w, http.StatusBadRequest, auth.ErrInvalidVerificationToken.Error())
+		return
+	}
+
+	userID, err := c.verifier.Verify(ctx, token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidVerificationToken) {
+			slog.WarnContext(ctx, "error verifying email", "err", err)
+			request.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		slog.ErrorContext(ctx, "error verifying email", "err", err)
+		request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	slog.InfoContext(ctx, "verified email", "user_id", userID)
+	request.RespondWithJSON(w, http.StatusOK, verifyEmailResponse{Verified: true})
+}
@@ -1,8 +1,15 @@
 package handler
 
+import "github.com/slham/sandbox-api/dao"
+
 type WorkoutController struct {
+	workouts dao.WorkoutRepository
+	users    dao.UserRepository
 }
 
-func NewWorkoutController() WorkoutController {
-	return WorkoutController{}
+func NewWorkoutController(workouts dao.WorkoutRepository, users dao.UserRepository) WorkoutController {
+	return WorkoutController{
+		workouts: workouts,
+		users:    users,
+	}
 }
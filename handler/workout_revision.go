@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+type workoutRevisionRequest struct {
+	UserID    string
+	WorkoutID string
+	Revision  int
+	From      int
+	To        int
+	Version   int
+}
+
+func handleWorkoutRevisionError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(err, ApiErrBadRequest) {
+		slog.WarnContext(ctx, "error handling workout revision", "err", err)
+		request.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrNotFound) {
+		slog.WarnContext(ctx, "error handling workout revision", "err", err)
+		request.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrConflict) {
+		slog.WarnContext(ctx, "error handling workout revision", "err", err)
+		request.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrPreconditionFailed) {
+		slog.WarnContext(ctx, "error handling workout revision", "err", err)
+		request.RespondWithError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+
+	if errors.Is(err, ApiErrPreconditionRequired) {
+		slog.WarnContext(ctx, "error handling workout revision", "err", err)
+		request.RespondWithError(w, http.StatusPreconditionRequired, err.Error())
+		return
+	}
+
+	slog.ErrorContext(ctx, "error handling workout revision", "err", err)
+	request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+	return
+}
+
+// recordRevision stores the workout's current name and exercises as its next
+// revision, authored by the session user.
+func (c *WorkoutController) recordRevision(ctx context.Context, workout model.Workout) error {
+	author := workout.UserID
+	if rc := request.GetRequestContext(ctx); rc != nil && rc.UserID != "" {
+		author = rc.UserID
+	}
+
+	_, err := c.workouts.InsertWorkoutRevision(ctx, model.WorkoutRevision{
+		WorkoutID: workout.ID,
+		Author:    author,
+		Name:      workout.Name,
+		Exercises: workout.Exercises,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record workout revision. %w", err)
+	}
+
+	return nil
+}
+
+func (c *WorkoutController) GetWorkoutRevisions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get workout revisions request")
+	vars := mux.Vars(r)
+	req := workoutRevisionRequest{UserID: vars["user_id"], WorkoutID: vars["workout_id"]}
+
+	revs, err := c.getWorkoutRevisions(ctx, req)
+	if err != nil {
+		handleWorkoutRevisionError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, revs)
+	return
+}
+
+func (c *WorkoutController) getWorkoutRevisions(ctx context.Context, req workoutRevisionRequest) ([]model.WorkoutRevision, error) {
+	if _, err := c.getWorkoutByID(ctx, getWorkoutRequest{UserID: req.UserID, WorkoutID: req.WorkoutID}); err != nil {
+		return nil, err
+	}
+
+	revs, err := c.workouts.GetWorkoutRevisions(ctx, req.WorkoutID)
+	if err != nil {
+		return revs, fmt.Errorf("failed to get workout revisions. %w", err)
+	}
+
+	return revs, nil
+}
+
+// GetWorkoutRevisionDiff compares the revisions named by the from and to query
+// parameters. to defaults to the latest revision and from to the one before
+// it.
+func (c *WorkoutController) GetWorkoutRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "get workout revision diff request")
+	vars := mux.Vars(r)
+	req := workoutRevisionRequest{UserID: vars["user_id"], WorkoutID: vars["workout_id"]}
+
+	query := r.URL.Query()
+	params := []struct {
+		name string
+		dest *int
+	}{{"from", &req.From}, {"to", &req.To}}
+	for _, param := range params {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			handleWorkoutRevisionError(ctx, w, NewApiError(400, ApiErrBadRequest).Append(fmt.Sprintf("invalid %s", param.name)))
+			return
+		}
+		*param.dest = n
+	}
+
+	diff, err := c.getWorkoutRevisionDiff(ctx, req)
+	if err != nil {
+		handleWorkoutRevisionError(ctx, w, err)
+		return
+	}
+
+	request.RespondWithJSON(w, http.StatusOK, diff)
+	return
+}
+
+func (c *WorkoutController) getWorkoutRevisionDiff(ctx context.Context, req workoutRevisionRequest) (model.WorkoutDiff, error) {
+	diff := model.WorkoutDiff{}
+	revs, err := c.getWorkoutRevisions(ctx, req)
+	if err != nil {
+		return diff, err
+	}
+
+	// revisions are numbered from one without gaps, newest first
+	latest := len(revs)
+	if req.To == 0 {
+		req.To = latest
+	}
+	if req.From == 0 {
+		req.From = max(req.To-1, 1)
+	}
+	if req.From > latest || req.To > latest {
+		return diff, NewApiError(404, ApiErrNotFound).Append("revision does not exist")
+	}
+
+	return model.DiffRevisions(revs[latest-req.From], revs[latest-req.To]), nil
+}
+
+// RevertWorkout sets the workout's name and exercises back to those of an
+// earlier revision. The revert is itself recorded as a new revision, and
+// If-Match is honoured as it is for updates.
+func (c *WorkoutController) RevertWorkout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.DebugContext(ctx, "revert workout request")
+	vars := mux.Vars(r)
+	req := workoutRevisionRequest{UserID: vars["user_id"], WorkoutID: vars["workout_id"]}
+
+	revision, err := strconv.Atoi(vars["revision"])
+	if err != nil {
+		handleWorkoutRevisionError(ctx, w, NewApiError(400, ApiErrBadRequest).Append("invalid revision"))
+		return
+	}
+	req.Revision = revision
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		handleWorkoutRevisionError(ctx, w, err)
+		return
+	}
+	req.Version = version
+
+	var workout model.Workout
+	err = c.workouts.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		workout, err = c.revertWorkout(ctx, req)
+		return err
+	})
+	if err != nil {
+		handleWorkoutRevisionError(ctx, w, err)
+		return
+	}
+
+	setETag(w, workout.Version)
+	request.RespondWithJSON(w, http.StatusOK, workout)
+	return
+}
+
+func (c *WorkoutController) revertWorkout(ctx context.Context, req workoutRevisionRequest) (model.Workout, error) {
+	workout, err := c.getWorkoutByID(ctx, getWorkoutRequest{UserID: req.UserID, WorkoutID: req.WorkoutID})
+	if err != nil {
+		return workout, err
+	}
+
+	if req.Version != 0 && req.Version != workout.Version {
+		return workout, NewApiError(412, ApiErrPreconditionFailed).Append("workout has been modified")
+	}
+
+	rev, err := c.workouts.GetWorkoutRevision(ctx, req.WorkoutID, req.Revision)
+	if err != nil {
+		if errors.Is(err, dao.ErrRevisionNotFound) {
+			return workout, NewApiError(404, ApiErrNotFound).Append("revision does not exist")
+		}
+		return workout, fmt.Errorf("failed to get workout revision. %w", err)
+	}
+
+	workout.Name = rev.Name
+	workout.Exercises = rev.Exercises
+	workout.Version = req.Version
+
+	workout, err = c.workouts.UpdateWorkout(ctx, workout)
+	if err != nil {
+		if errors.Is(err, dao.ErrVersionConflict) {
+			return workout, NewApiError(412, ApiErrPreconditionFailed).Append("workout has been modified")
+		}
+		if errors.Is(err, dao.ErrConflictWorkoutName) {
+			return workout, NewApiError(409, ApiErrConflict).Append("workout name already exists")
+		}
+		return workout, fmt.Errorf("failed to revert workout. %w", err)
+	}
+
+	if err := c.recordRevision(ctx, workout); err != nil {
+		return workout, err
+	}
+
+	return workout, nil
+}
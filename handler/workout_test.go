@@ -0,0 +1,274 @@
+//go:build unit
+// +build unit
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkoutCRUD(t *testing.T) {
+	repo := dao.NewMemory()
+	users := NewUserController(repo, repo, nil)
+	c := NewWorkoutController(repo, repo)
+	user := createUser(t, users, "test_user_1", "a@b.c")
+	vars := map[string]string{"user_id": user.ID}
+	url := "/users/" + user.ID + "/workouts"
+
+	light := `{"name":"Arms Light","exercises":[{"name":"Curl","muscles":[{"name":"Bicep","muscleGroup":"arms"}],"sets":[{"weight":25,"reps":10}]}]}`
+	heavy := `{"name":"Arms Heavy","exercises":[{"name":"Curl","muscles":[{"name":"Bicep","muscleGroup":"arms"}],"sets":[{"weight":45,"reps":10}]}]}`
+
+	w := serve(c.CreateWorkout, "POST", url, vars, `{"name":"","exercises":[{"name":"","muscles":[{"name":"","muscleGroup":"Arms"}]}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(c.CreateWorkout, "POST", url, vars, light)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(c.CreateWorkout, "POST", url, vars, heavy)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(c.CreateWorkout, "POST", url, vars, heavy)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"errors":"workout name already exists"}`, w.Body.String())
+
+	w = serve(c.CreateWorkout, "POST", "/users/user_nope/workouts", map[string]string{"user_id": "user_nope"}, light)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	workouts, err := repo.GetWorkouts(context.Background(), dao.WorkoutQuery{UserID: user.ID, Query: dao.Query{SortCol: "name"}})
+	assert.NoError(t, err)
+	assert.Len(t, workouts, 2)
+	assert.Equal(t, "Arms Heavy", workouts[0].Name)
+
+	workoutVars := map[string]string{"user_id": user.ID, "workout_id": workouts[1].ID}
+	w = serve(c.UpdateWorkout, "PATCH", url+"/"+workouts[1].ID, workoutVars, heavy)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(c.UpdateWorkout, "PATCH", url+"/"+workouts[1].ID, workoutVars, `{"name":"Popeye","exercises":[]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(c.GetWorkout, "GET", url+"/"+workouts[1].ID, workoutVars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(c.DeleteWorkout, "DELETE", url+"/"+workouts[1].ID, workoutVars, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve(c.GetWorkout, "GET", url+"/"+workouts[1].ID, workoutVars, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(users.DeleteUser, "DELETE", "/users/"+user.ID, vars, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	workouts, err = repo.GetWorkouts(context.Background(), dao.WorkoutQuery{UserID: user.ID})
+	assert.NoError(t, err)
+	assert.Empty(t, workouts, "deleting a user removes their workouts")
+}
+
+func TestGetWorkoutsPaged(t *testing.T) {
+	repo := dao.NewMemory()
+	users := NewUserController(repo, repo, nil)
+	c := NewWorkoutController(repo, repo)
+	user := createUser(t, users, "test_user_1", "a@b.c")
+	vars := map[string]string{"user_id": user.ID}
+	url := "/users/" + user.ID + "/workouts"
+
+	for _, name := range []string{"Arms", "Back", "Chest"} {
+		w := serve(c.CreateWorkout, "POST", url, vars, `{"name":"`+name+`","exercises":[]}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	w := serve(c.GetWorkouts, "GET", url+"?cursor=&sort_column=name&limit=2&total=true", vars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	res := struct {
+		Data  []model.Workout `json:"data"`
+		Next  string          `json:"next"`
+		Prev  string          `json:"prev"`
+		Total int             `json:"total"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Len(t, res.Data, 2)
+	assert.Equal(t, 3, res.Total)
+	assert.Empty(t, res.Prev)
+	assert.NotEmpty(t, res.Next)
+	assert.Contains(t, w.Header().Get("Link"), `rel="next"`)
+
+	w = serve(c.GetWorkouts, "GET", url+"?cursor="+res.Next+"&limit=2", vars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	res.Next, res.Prev = "", ""
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Len(t, res.Data, 1)
+	assert.Equal(t, "Chest", res.Data[0].Name)
+	assert.Empty(t, res.Next)
+	assert.NotEmpty(t, res.Prev)
+
+	w = serve(c.GetWorkouts, "GET", url+"?cursor=bogus", vars, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(c.GetWorkouts, "GET", url+"?cursor=&offset=2", vars, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	SetMaxPageSize(1)
+	defer SetMaxPageSize(defaultMaxPageSize)
+	w = serve(c.GetWorkouts, "GET", url+"?limit=50", vars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	workouts := []model.Workout{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &workouts))
+	assert.Len(t, workouts, 1)
+}
+
+func TestUpdateWorkoutIfMatch(t *testing.T) {
+	repo := dao.NewMemory()
+	users := NewUserController(repo, repo, nil)
+	c := NewWorkoutController(repo, repo)
+	user := createUser(t, users, "test_user_1", "a@b.c")
+	vars := map[string]string{"user_id": user.ID}
+	url := "/users/" + user.ID + "/workouts"
+
+	w := serve(c.CreateWorkout, "POST", url, vars, `{"name":"Arms","exercises":[]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	workout := model.Workout{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &workout))
+
+	workoutVars := map[string]string{"user_id": user.ID, "workout_id": workout.ID}
+	workoutURL := url + "/" + workout.ID
+	ifMatch := func(etag string) http.Header {
+		return http.Header{"If-Match": []string{etag}}
+	}
+
+	w = serveWithHeader(c.UpdateWorkout, "PATCH", workoutURL, workoutVars, `{"name":"Legs","exercises":[]}`, ifMatch(`"1"`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	// a second device still holding the first version
+	w = serveWithHeader(c.UpdateWorkout, "PATCH", workoutURL, workoutVars, `{"name":"Back","exercises":[]}`, ifMatch(`"1"`))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serveWithHeader(c.UpdateWorkout, "PATCH", workoutURL, workoutVars, `{"name":"Back","exercises":[]}`, ifMatch("nope"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(c.GetWorkout, "GET", workoutURL, workoutVars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), "Legs")
+
+	SetIfMatchMode(IfMatchRequired)
+	defer SetIfMatchMode(IfMatchOptional)
+
+	w = serve(c.UpdateWorkout, "PATCH", workoutURL, workoutVars, `{"name":"Back","exercises":[]}`)
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+
+	w = serveWithHeader(c.UpdateWorkout, "PATCH", workoutURL, workoutVars, `{"name":"Back","exercises":[]}`, ifMatch("*"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+}
+
+func TestWorkoutTrash(t *testing.T) {
+	repo := dao.NewMemory()
+	users := NewUserController(repo, repo, nil)
+	c := NewWorkoutController(repo, repo)
+	user := createUser(t, users, "test_user_1", "a@b.c")
+	vars := map[string]string{"user_id": user.ID}
+	url := "/users/" + user.ID + "/workouts"
+
+	w := serve(c.CreateWorkout, "POST", url, vars, `{"name":"Arms","exercises":[]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	workout := model.Workout{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &workout))
+	workoutVars := map[string]string{"user_id": user.ID, "workout_id": workout.ID}
+
+	w = serve(c.DeleteWorkout, "DELETE", url+"/"+workout.ID, workoutVars, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve(c.GetWorkouts, "GET", url, vars, "")
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	w = serve(c.GetTrash, "GET", "/users/"+user.ID+"/trash", vars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	trash := []model.Workout{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &trash))
+	assert.Len(t, trash, 1)
+	assert.NotNil(t, trash[0].Deleted)
+
+	w = serve(c.CreateWorkout, "POST", url, vars, `{"name":"Arms","exercises":[]}`)
+	assert.Equal(t, http.StatusCreated, w.Code, "trashed names can be reused")
+
+	w = serve(c.RestoreWorkout, "POST", url+"/"+workout.ID+"/restore", workoutVars, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(c.CreateWorkout, "POST", url, vars, `{"name":"Legs","exercises":[]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	other := model.Workout{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &other))
+	w = serve(c.DeleteWorkout, "DELETE", url+"/"+other.ID, map[string]string{"user_id": user.ID, "workout_id": other.ID}, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve(c.RestoreWorkout, "POST", url+"/"+other.ID+"/restore", map[string]string{"user_id": user.ID, "workout_id": other.ID}, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(c.RestoreWorkout, "POST", url+"/"+other.ID+"/restore", map[string]string{"user_id": user.ID, "workout_id": other.ID}, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWorkoutRevisions(t *testing.T) {
+	repo := dao.NewMemory()
+	users := NewUserController(repo, repo, nil)
+	c := NewWorkoutController(repo, repo)
+	user := createUser(t, users, "test_user_1", "a@b.c")
+	vars := map[string]string{"user_id": user.ID}
+	url := "/users/" + user.ID + "/workouts"
+
+	w := serve(c.CreateWorkout, "POST", url, vars, `{"name":"Arms","exercises":[{"name":"Curl","sets":[{"weight":25,"reps":10}]}]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	workout := model.Workout{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &workout))
+	workoutVars := map[string]string{"user_id": user.ID, "workout_id": workout.ID}
+	workoutURL := url + "/" + workout.ID
+
+	w = serve(c.UpdateWorkout, "PATCH", workoutURL, workoutVars, `{"name":"Arms","exercises":[{"name":"Curl","sets":[{"weight":30,"reps":10}]},{"name":"Dip"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(c.GetWorkoutRevisions, "GET", workoutURL+"/revisions", workoutVars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	revs := []model.WorkoutRevision{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &revs))
+	assert.Len(t, revs, 2)
+	assert.Equal(t, 2, revs[0].Revision)
+	assert.Equal(t, user.ID, revs[0].Author)
+
+	w = serve(c.GetWorkoutRevisionDiff, "GET", workoutURL+"/revisions/diff", workoutVars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	diff := model.WorkoutDiff{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.Equal(t, 1, diff.From)
+	assert.Equal(t, 2, diff.To)
+	assert.Len(t, diff.Exercises, 2)
+
+	w = serve(c.GetWorkoutRevisionDiff, "GET", workoutURL+"/revisions/diff?from=1&to=9", workoutVars, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	revertVars := map[string]string{"user_id": user.ID, "workout_id": workout.ID, "revision": "1"}
+	w = serveWithHeader(c.RevertWorkout, "POST", workoutURL+"/revisions/1/revert", revertVars, "", http.Header{"If-Match": []string{`"1"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serve(c.RevertWorkout, "POST", workoutURL+"/revisions/1/revert", revertVars, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &workout))
+	assert.Len(t, workout.Exercises, 1)
+	assert.Equal(t, float32(25), workout.Exercises[0].Sets[0].Weight)
+
+	w = serve(c.GetWorkoutRevisions, "GET", workoutURL+"/revisions", workoutVars, "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &revs))
+	assert.Len(t, revs, 3, "a revert is recorded as a new revision")
+
+	revertVars["revision"] = "7"
+	w = serve(c.RevertWorkout, "POST", workoutURL+"/revisions/7/revert", revertVars, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
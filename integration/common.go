@@ -102,7 +102,23 @@ func cleanUpTestUsers(suffix string) error {
 	return nil
 }
 
+// sendJSONHttpRequest sends the request with testCookie, along with a CSRF
+// token for its session when the method changes state.
 func sendJSONHttpRequest(method, url, body string, testCookie *http.Cookie) (*http.Response, error) {
+	var csrfCookie *http.Cookie
+	if testCookie != nil && !safeMethod(method) {
+		var err error
+		if csrfCookie, err = getCSRFToken(testCookie); err != nil {
+			return nil, fmt.Errorf("failed to get csrf token. %w", err)
+		}
+	}
+
+	return sendJSONHttpRequestWithCSRF(method, url, body, testCookie, csrfCookie)
+}
+
+// sendJSONHttpRequestWithCSRF sends the request with testCookie and, when it
+// is not nil, csrfCookie and its token in the X-CSRF-Token header.
+func sendJSONHttpRequestWithCSRF(method, url, body string, testCookie *http.Cookie, csrfCookie *http.Cookie) (*http.Response, error) {
 	bodyReader := bytes.NewReader([]byte(body))
 	req, err := http.NewRequest(method, url, bodyReader)
 	if err != nil {
@@ -112,6 +128,10 @@ func sendJSONHttpRequest(method, url, body string, testCookie *http.Cookie) (*ht
 	if testCookie != nil {
 		req.AddCookie(testCookie)
 	}
+	if csrfCookie != nil {
+		req.AddCookie(csrfCookie)
+		req.Header.Set("X-CSRF-Token", csrfCookie.Value)
+	}
 	client := http.Client{
 		Timeout: 10 * time.Second,
 	}
@@ -123,6 +143,36 @@ func sendJSONHttpRequest(method, url, body string, testCookie *http.Cookie) (*ht
 	return resp, nil
 }
 
+// getCSRFToken fetches the CSRF cookie for the session in testCookie. Its
+// value is the token to send in the header.
+func getCSRFToken(testCookie *http.Cookie) (*http.Cookie, error) {
+	resp, err := sendJSONHttpRequestWithCSRF("GET", "http://localhost:8080/auth/csrf", "", testCookie, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request. %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get csrf token. status:%d", resp.StatusCode)
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "__Host-sandbox-csrf" {
+			return cookie, nil
+		}
+	}
+
+	return nil, fmt.Errorf("got no csrf cookie")
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
 func randomEmail() string {
 	return randomail.GenerateRandomEmail()
 }
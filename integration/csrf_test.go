@@ -0,0 +1,56 @@
+package integration
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	matcher "github.com/panta/go-json-matcher"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestCSRF(t *testing.T) {
+	suffix := "_test_csrf"
+	email := randomEmail()
+	username := randomUsername(suffix)
+	userID, err := createTestUser(username, email)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	testCookie, err := loginTestUser(username)
+	if err != nil {
+		t.Log("err", err)
+		t.Fail()
+	}
+
+	t.Run("cookie without token", func(t *testing.T) {
+		url := fmt.Sprintf("http://localhost:8080/users/%s", userID)
+		resp, err := sendJSONHttpRequestWithCSRF("PATCH", url, `{"username": "csrf_user"}`, testCookie, nil)
+		if err != nil {
+			t.Log("err", err)
+			t.Fail()
+		}
+
+		assert.Equal(t, resp.StatusCode, http.StatusForbidden)
+
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+
+		respString := string(bodyBytes)
+		want := `{"errors": "missing or invalid csrf token", "code": "csrf"}`
+		matches, err := matcher.JSONStringMatches(respString, want)
+		if !matches || err != nil {
+			t.Log("err", err)
+			t.Log("got", respString)
+			t.Log("wanted", want)
+			t.Fail()
+		}
+	})
+	cleanUpTestUsers(suffix)
+}
@@ -0,0 +1,109 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public key in the JSON Web Key format of RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes the public key for publishing in a key set.
+func NewJWK(kid string, alg string, pub crypto.PublicKey) (JWK, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   encode(k.N.Bytes()),
+			E:   encode(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return JWK{}, fmt.Errorf("unsupported curve. %w", ErrUnsupportedAlg)
+		}
+		x, y := make([]byte, 32), make([]byte, 32)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: "P-256",
+			X:   encode(x),
+			Y:   encode(y),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("unsupported key type %T. %w", pub, ErrUnsupportedAlg)
+	}
+}
+
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q. %w", k.Crv, ErrUnsupportedAlg)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("point is not on curve. %w", ErrMalformed)
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q. %w", k.Kty, ErrUnsupportedAlg)
+	}
+}
+
+// Key returns the public key with the kid. Keys that are for encryption
+// rather than signatures are skipped.
+func (s JWKS) Key(kid string) (crypto.PublicKey, error) {
+	for _, k := range s.Keys {
+		if k.Kid != kid || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		return k.PublicKey()
+	}
+
+	return nil, fmt.Errorf("%w. %q", ErrUnknownKey, kid)
+}
@@ -0,0 +1,234 @@
+// Package jwt signs and verifies JSON Web Tokens with RS256 or ES256. It is
+// just enough of RFC 7519 for ID tokens from OpenID providers and the
+// server's own access tokens.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("token is expired")
+	ErrNotYetValid      = errors.New("token is not valid yet")
+	ErrInvalidIssuer    = errors.New("invalid issuer")
+	ErrInvalidAudience  = errors.New("invalid audience")
+)
+
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// Leeway is how much clock skew is tolerated when checking exp and nbf.
+const Leeway = time.Minute
+
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Audience is the aud claim, which may be a single string or a list.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("failed to unmarshal aud. %w", err)
+	}
+	*a = list
+
+	return nil
+}
+
+func (a Audience) Contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered claims. Embed it in a struct to add others.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Validate checks the time based claims against now, and the issuer and
+// audience when they are not empty.
+func (c Claims) Validate(now time.Time, issuer string, audience string) error {
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(Leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("%w. %s", ErrInvalidIssuer, c.Issuer)
+	}
+	if audience != "" && !c.Audience.Contains(audience) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+// Signer signs tokens with a private key. Kid names the key so verifiers can
+// find its public half in a key set.
+type Signer struct {
+	Kid string
+	Alg string
+	Key crypto.Signer
+}
+
+func (s Signer) Sign(claims any) (string, error) {
+	header := Header{Alg: s.Alg, Kid: s.Kid, Typ: "JWT"}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal header. %w", err)
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims. %w", err)
+	}
+
+	signingInput := encode(h) + "." + encode(p)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	switch s.Alg {
+	case RS256:
+		sig, err = s.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case ES256:
+		key, ok := s.Key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrUnsupportedAlg
+		}
+		var r, ss *big.Int
+		r, ss, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			ss.FillBytes(sig[32:])
+		}
+	default:
+		return "", ErrUnsupportedAlg
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sign. %w", err)
+	}
+
+	return signingInput + "." + encode(sig), nil
+}
+
+// KeyFunc finds the public key for a token's header.
+type KeyFunc func(header Header) (crypto.PublicKey, error)
+
+// Parse verifies the token's signature with the key returned by keyFunc and
+// unmarshals its payload into claims. It does not validate the claims.
+func Parse(token string, claims any, keyFunc KeyFunc) (Header, error) {
+	header := Header{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, ErrMalformed
+	}
+
+	h, err := decode(parts[0])
+	if err != nil {
+		return header, err
+	}
+	if err := json.Unmarshal(h, &header); err != nil {
+		return header, fmt.Errorf("failed to unmarshal header. %w", ErrMalformed)
+	}
+
+	sig, err := decode(parts[2])
+	if err != nil {
+		return header, err
+	}
+
+	key, err := keyFunc(header)
+	if err != nil {
+		return header, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return header, ErrUnsupportedAlg
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return header, ErrInvalidSignature
+		}
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return header, ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return header, ErrInvalidSignature
+		}
+	default:
+		return header, fmt.Errorf("%w. %q", ErrUnsupportedAlg, header.Alg)
+	}
+
+	p, err := decode(parts[1])
+	if err != nil {
+		return header, err
+	}
+	if err := json.Unmarshal(p, claims); err != nil {
+		return header, fmt.Errorf("failed to unmarshal claims. %w", ErrMalformed)
+	}
+
+	return header, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode. %w", ErrMalformed)
+	}
+	return b, nil
+}
@@ -0,0 +1,79 @@
+//go:build unit
+// +build unit
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClaims struct {
+	Claims
+	Nonce string `json:"nonce"`
+}
+
+func TestSignParse(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	claims := testClaims{
+		Claims: Claims{Issuer: "https://issuer", Subject: "alice", Audience: Audience{"client"}, ExpiresAt: now.Add(time.Minute).Unix(), IssuedAt: now.Unix()},
+		Nonce:  "n0nce",
+	}
+
+	for _, signer := range []Signer{
+		{Kid: "rsa", Alg: RS256, Key: rsaKey},
+		{Kid: "ec", Alg: ES256, Key: ecKey},
+	} {
+		t.Run(signer.Alg, func(t *testing.T) {
+			jwk, err := NewJWK(signer.Kid, signer.Alg, signer.Key.Public())
+			assert.NoError(t, err)
+
+			// the key set survives a round trip through json
+			b, err := json.Marshal(JWKS{Keys: []JWK{jwk}})
+			assert.NoError(t, err)
+			keys := JWKS{}
+			assert.NoError(t, json.Unmarshal(b, &keys))
+			keyFunc := func(h Header) (crypto.PublicKey, error) { return keys.Key(h.Kid) }
+
+			token, err := signer.Sign(claims)
+			assert.NoError(t, err)
+
+			got := testClaims{}
+			header, err := Parse(token, &got, keyFunc)
+			assert.NoError(t, err)
+			assert.Equal(t, signer.Kid, header.Kid)
+			assert.Equal(t, claims, got)
+			assert.NoError(t, got.Validate(now, "https://issuer", "client"))
+
+			parts := strings.Split(token, ".")
+			tampered := parts[0] + "." + encode([]byte(`{"sub":"mallory","exp":9999999999}`)) + "." + parts[2]
+			_, err = Parse(tampered, &got, keyFunc)
+			assert.ErrorIs(t, err, ErrInvalidSignature)
+
+			none := encode([]byte(`{"alg":"none","kid":"`+signer.Kid+`"}`)) + "." + parts[1] + "."
+			_, err = Parse(none, &got, keyFunc)
+			assert.ErrorIs(t, err, ErrUnsupportedAlg)
+
+			_, err = Parse(token, &got, func(h Header) (crypto.PublicKey, error) { return keys.Key("other") })
+			assert.ErrorIs(t, err, ErrUnknownKey)
+		})
+	}
+
+	assert.ErrorIs(t, claims.Validate(now.Add(2*time.Minute+Leeway), "", ""), ErrExpired)
+	assert.ErrorIs(t, claims.Validate(now, "https://other", ""), ErrInvalidIssuer)
+	assert.ErrorIs(t, claims.Validate(now, "", "other"), ErrInvalidAudience)
+}
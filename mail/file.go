@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/segmentio/ksuid"
+)
+
+// FileMailer writes each message to its own .eml file in a directory, where
+// tests and local tools can pick them up.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) FileMailer {
+	return FileMailer{dir: dir, from: from}
+}
+
+func (m FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create mail directory. %w", err)
+	}
+
+	path := filepath.Join(m.dir, ksuid.New().String()+".eml")
+	if err := os.WriteFile(path, format(m.from, msg), 0o600); err != nil {
+		return fmt.Errorf("failed to write mail. %w", err)
+	}
+
+	return nil
+}
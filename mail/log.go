@@ -0,0 +1,19 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogMailer writes messages to the log instead of sending them. It is meant
+// for local development.
+type LogMailer struct{}
+
+func NewLogMailer() LogMailer {
+	return LogMailer{}
+}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
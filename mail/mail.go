@@ -0,0 +1,68 @@
+// Package mail sends the emails the api needs, such as address verification,
+// through a configurable sink.
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidConfig = errors.New("invalid mailer config")
+
+// Sinks a Mailer can be built for.
+const (
+	DriverLog  = "log"
+	DriverFile = "file"
+	DriverSMTP = "smtp"
+)
+
+const defaultFrom = "sandbox@localhost"
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config picks and configures a sink. An empty Driver means log.
+type Config struct {
+	Driver string
+	From   string
+	// Dir is where the file sink writes messages.
+	Dir string
+	// SMTPAddr is the host:port of the smtp server. Without SMTPUsername
+	// the server is used without authentication.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// New returns the mailer cfg asks for.
+func New(cfg Config) (Mailer, error) {
+	if cfg.From == "" {
+		cfg.From = defaultFrom
+	}
+
+	switch cfg.Driver {
+	case "", DriverLog:
+		return NewLogMailer(), nil
+	case DriverFile:
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("%w. file mailer needs a directory", ErrInvalidConfig)
+		}
+		return NewFileMailer(cfg.Dir, cfg.From), nil
+	case DriverSMTP:
+		if cfg.SMTPAddr == "" {
+			return nil, fmt.Errorf("%w. smtp mailer needs an address", ErrInvalidConfig)
+		}
+		return NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	default:
+		return nil, fmt.Errorf("%w. unknown driver %q", ErrInvalidConfig, cfg.Driver)
+	}
+}
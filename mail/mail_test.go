@@ -0,0 +1,54 @@
+//go:build unit
+// +build unit
+
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	tables := []struct {
+		name string
+		cfg  Config
+		err  error
+	}{
+		{name: "log by default", cfg: Config{}},
+		{name: "file", cfg: Config{Driver: DriverFile, Dir: t.TempDir()}},
+		{name: "file without dir", cfg: Config{Driver: DriverFile}, err: ErrInvalidConfig},
+		{name: "smtp", cfg: Config{Driver: DriverSMTP, SMTPAddr: "localhost:25"}},
+		{name: "smtp without addr", cfg: Config{Driver: DriverSMTP}, err: ErrInvalidConfig},
+		{name: "unknown", cfg: Config{Driver: "pigeon"}, err: ErrInvalidConfig},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			_, err := New(table.cfg)
+			assert.ErrorIs(t, err, table.err)
+		})
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewFileMailer(dir, "sandbox@example.com")
+
+	err := m.Send(context.Background(), Message{To: "a@b.c", Subject: "Hello", Body: "Hi there\n"})
+	assert.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	b, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "From: sandbox@example.com\r\n")
+	assert.Contains(t, string(b), "To: a@b.c\r\n")
+	assert.Contains(t, string(b), "Subject: Hello\r\n")
+	assert.Contains(t, string(b), "Hi there")
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,7 +21,11 @@ import (
 	"github.com/slham/sandbox-api/crypt"
 	"github.com/slham/sandbox-api/dao"
 	"github.com/slham/sandbox-api/handler"
+	"github.com/slham/sandbox-api/mail"
 	"github.com/slham/sandbox-api/middlewares"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/oidc"
+	"github.com/slham/sandbox-api/request"
 )
 
 const (
@@ -26,39 +34,127 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	env := os.Getenv("SANDBOX_ENVIRONMENT")
+	var db dao.Dao
 	switch env {
 	case "LOCAL":
 		if ok := middlewares.Initialize(middlewares.DEBUG); !ok {
 			log.Fatalf("failed to initialize logging")
 		}
-		_, err := dao.Connect()
+		var err error
+		db, err = dao.Connect()
 		if err != nil {
 			log.Fatalf("failed to connect to database. %s", err)
 		}
 		crypt.Initialize(os.Getenv("SANDBOX_AUTH_KEY"))
+		crypt.SetArgon2Params(argon2Params())
 		slog.Info("running on local")
 	default:
 		slog.Info("invalid environment", "env", env)
 		os.Exit(1)
 	}
 
+	if size := os.Getenv("SANDBOX_MAX_PAGE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			log.Fatalf("invalid SANDBOX_MAX_PAGE_SIZE. %s", err)
+		}
+		handler.SetMaxPageSize(n)
+	}
+
+	providers, err := oauthProviders()
+	if err != nil {
+		log.Fatalf("failed to configure sign in providers. %s", err)
+	}
+	slog.Info("sign in providers", "providers", providers.Names())
+	handler.SetOAuth(handler.OAuthConfig{
+		Providers:   providers,
+		FrontendURL: os.Getenv("SANDBOX_FRONTEND_URL"),
+	})
+
+	if os.Getenv("SANDBOX_REQUIRE_IF_MATCH") == "true" {
+		handler.SetIfMatchMode(handler.IfMatchRequired)
+	}
+
 	r := mux.NewRouter()
 
+	// Repositories
+	repo := dao.NewPostgres(db)
+	window := 5 * time.Second
+	if v := os.Getenv("SBDB_READ_YOUR_WRITES"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid SBDB_READ_YOUR_WRITES. %s", err)
+		}
+	}
+	repo.ReadYourWrites(window, requestUserID)
+
+	retention := 30 * 24 * time.Hour
+	if v := os.Getenv("SANDBOX_TRASH_RETENTION"); v != "" {
+		var err error
+		if retention, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid SANDBOX_TRASH_RETENTION. %s", err)
+		}
+	}
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go dao.RunPurge(background, repo, retention, time.Hour)
+	go repo.MonitorReplicas(background, 10*time.Second)
+
 	// Middlewares
-	standardSessionStore := auth.NewStandardSessionStore()
-	//establishSession := middlewares.Establish(standardSessionStore)
-	verifySession := middlewares.Verify(standardSessionStore)
-	//terminateSession := middlewares.Terminate(standardSessionStore)
+	tokens := auth.NewTokenService(repo, repo, tokenConfig())
+	mfa := auth.NewMFAService(repo, os.Getenv("SANDBOX_MFA_ISSUER"))
+	guard := auth.NewLoginGuard(repo, repo, lockoutConfig())
+	sessionStore := auth.NewBearerSessionStore(newSessionStore(repo, repo), tokens, repo, repo, repo, repo)
+	verifySession := middlewares.Verify(sessionStore)
+	terminateSession := middlewares.Terminate(sessionStore)
 	rateLimiter := middlewares.RateLimit(env)
+	readWorkouts := middlewares.RequireScope(model.ScopeWorkoutsRead)
+	writeWorkouts := middlewares.RequireScope(model.ScopeWorkoutsWrite)
+	readProfile := middlewares.RequireScope(model.ScopeProfileRead)
+	canListUsers := middlewares.RequirePermission(model.PermissionUsersList)
+	canReadUsers := middlewares.RequirePermission(model.PermissionUsersRead)
+	canWriteUsers := middlewares.RequirePermission(model.PermissionUsersWrite)
+	canManageUsers := middlewares.RequirePermission(model.PermissionUsersManage)
+	canReadWorkouts := middlewares.RequirePermission(model.PermissionWorkoutsRead)
+	canWriteWorkouts := middlewares.RequirePermission(model.PermissionWorkoutsWrite)
+	canReportPasswords := middlewares.RequirePermission(model.PermissionPasswordsReport)
+	canReadRoles := middlewares.RequirePermission(model.PermissionRolesRead)
+	canWriteRoles := middlewares.RequirePermission(model.PermissionRolesWrite)
+	canImpersonate := middlewares.RequirePermission(model.PermissionUsersImpersonate)
+	canReadClients := middlewares.RequirePermission(model.PermissionClientsRead)
+	canWriteClients := middlewares.RequirePermission(model.PermissionClientsWrite)
+	notImpersonating := middlewares.BlockImpersonation
+	mailer := newMailer()
+	verifier := emailVerifier(repo, mailer)
+	passwords := auth.NewPasswordService(repo, repo, repo, repo, repo, repo, guard, mailer, passwordResetConfig())
+	verified := verifiedGate(repo)
+	accounts := auth.NewAccountService(repo, repo, repo, repo)
+	impersonations := auth.NewImpersonationService(repo, repo, repo)
+	authorizations := auth.NewAuthorizationServer(repo, repo, tokens)
 
 	r.Use(middlewares.LoggingInbound)
 	r.Use(rateLimiter)
+	r.Use(middlewares.CSRF)
 
 	// Controllers
-	authController := handler.NewAuthController(standardSessionStore)
-	userController := handler.NewUserController()
-	workoutController := handler.NewWorkoutController()
+	authController := handler.NewAuthController(sessionStore, tokens, mfa, repo, repo, repo, guard)
+	userController := handler.NewUserController(repo, repo, verifier)
+	workoutController := handler.NewWorkoutController(repo, repo)
+	adminController := handler.NewAdminController(repo, guard)
+	sessionController := handler.NewSessionController(repo, repo)
+	accessTokenController := handler.NewAccessTokenController(repo)
+	mfaController := handler.NewMFAController(mfa, repo)
+	passwordController := handler.NewPasswordController(passwords)
+	accountController := handler.NewAccountController(accounts)
+	roleController := handler.NewRoleController(repo)
+	impersonationController := handler.NewImpersonationController(impersonations)
+	authorizationController := handler.NewAuthorizationController(authorizations, repo)
 
 	// Health APIs
 	r.Methods("GET").Path("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -66,24 +162,81 @@ func main() {
 	})
 
 	// Auth APIs
-	r.Methods("GET").Path("/auth/google/login").HandlerFunc(authController.OauthGoogleLogin)
-	r.Methods("GET").Path("/auth/google/callback").HandlerFunc(middlewares.Chain(authController.OauthGoogleCallback))
+	r.Methods("GET").Path("/auth/{provider}/login").HandlerFunc(authController.OauthLogin)
+	r.Methods("GET").Path("/auth/{provider}/callback").HandlerFunc(middlewares.Chain(authController.OauthCallback))
 	r.Methods("POST").Path("/auth/login").HandlerFunc(middlewares.Chain(authController.Login))
-	//r.Methods("POST").Path("/auth/logout").HandlerFunc(middlewares.Chain(authController.Logout, terminateSession))
+	r.Methods("POST").Path("/auth/login/mfa").HandlerFunc(middlewares.Chain(authController.LoginMFA))
+	r.Methods("POST").Path("/auth/logout").HandlerFunc(middlewares.Chain(authController.Logout, terminateSession))
+	r.Methods("POST").Path("/auth/token").HandlerFunc(middlewares.Chain(authController.Token))
+	r.Methods("POST").Path("/auth/token/revoke").HandlerFunc(middlewares.Chain(authController.RevokeToken))
+	r.Methods("GET").Path("/.well-known/jwks.json").HandlerFunc(authController.JWKS)
+	r.Methods("GET").Path("/auth/csrf").HandlerFunc(authController.CSRF)
+	r.Methods("POST").Path("/auth/password/forgot").HandlerFunc(middlewares.Chain(passwordController.ForgotPassword))
+	r.Methods("POST").Path("/auth/password/reset").HandlerFunc(middlewares.Chain(passwordController.ResetPassword))
+	r.Methods("GET").Path("/auth/verify").HandlerFunc(middlewares.Chain(userController.VerifyEmail))
+	r.Methods("POST").Path("/auth/impersonation/end").HandlerFunc(middlewares.Chain(impersonationController.EndImpersonation, verifySession))
+	r.Methods("POST").Path("/auth/logout/all").HandlerFunc(middlewares.Chain(sessionController.LogoutAll, notImpersonating, verifySession))
+
+	// OAuth APIs
+	r.Methods("GET").Path("/oauth/authorize").HandlerFunc(middlewares.Chain(authorizationController.GetAuthorization, verifySession))
+	r.Methods("POST").Path("/oauth/authorize").HandlerFunc(middlewares.Chain(authorizationController.Authorize, notImpersonating, verifySession))
+	r.Methods("POST").Path("/oauth/token").HandlerFunc(middlewares.Chain(authorizationController.OAuthToken))
+	r.Methods("POST").Path("/oauth/introspect").HandlerFunc(middlewares.Chain(authorizationController.IntrospectOAuthToken))
+	r.Methods("POST").Path("/oauth/revoke").HandlerFunc(middlewares.Chain(authorizationController.RevokeOAuthToken))
 
 	// User APIs
 	r.Methods("POST").Path("/users").HandlerFunc(middlewares.Chain(userController.CreateUser))
-	r.Methods("GET").Path("/users").HandlerFunc(middlewares.Chain(userController.GetUsers, verifySession))
-	r.Methods("GET").Path("/users/{user_id}").HandlerFunc(middlewares.Chain(userController.GetUser, verifySession))
-	r.Methods("PATCH").Path("/users/{user_id}").HandlerFunc(middlewares.Chain(userController.UpdateUser, verifySession))
-	r.Methods("DELETE").Path("/users/{user_id}").HandlerFunc(middlewares.Chain(userController.DeleteUser, verifySession))
+	r.Methods("GET").Path("/users").HandlerFunc(middlewares.Chain(userController.GetUsers, verifySession, canListUsers))
+	r.Methods("GET").Path("/users/{user_id}").HandlerFunc(middlewares.Chain(userController.GetUser, verifySession, canReadUsers, readProfile))
+	r.Methods("PATCH").Path("/users/{user_id}").HandlerFunc(middlewares.Chain(userController.UpdateUser, verifySession, canWriteUsers))
+	r.Methods("DELETE").Path("/users/{user_id}").HandlerFunc(middlewares.Chain(userController.DeleteUser, notImpersonating, verifySession, canWriteUsers))
+	r.Methods("PUT").Path("/users/{user_id}/password").HandlerFunc(middlewares.Chain(passwordController.ChangePassword, notImpersonating, verifySession, canWriteUsers))
+	r.Methods("POST").Path("/users/{user_id}/verification").HandlerFunc(middlewares.Chain(userController.SendVerification, verifySession, canWriteUsers))
+	r.Methods("POST").Path("/users/{user_id}/deactivate").HandlerFunc(middlewares.Chain(accountController.DeactivateUser, notImpersonating, verifySession, canWriteUsers))
+	r.Methods("POST").Path("/users/{user_id}/restore").HandlerFunc(middlewares.Chain(userController.RestoreUser, verifySession, canWriteUsers))
+	r.Methods("GET").Path("/users/{user_id}/sessions").HandlerFunc(middlewares.Chain(sessionController.GetSessions, verifySession, canReadUsers))
+	r.Methods("DELETE").Path("/users/{user_id}/sessions").HandlerFunc(middlewares.Chain(sessionController.RevokeSessions, verifySession, canWriteUsers))
+	r.Methods("DELETE").Path("/users/{user_id}/sessions/{session_id}").HandlerFunc(middlewares.Chain(sessionController.RevokeSession, verifySession, canWriteUsers))
+	r.Methods("POST").Path("/users/{user_id}/tokens").HandlerFunc(middlewares.Chain(accessTokenController.CreatePersonalAccessToken, notImpersonating, verified.Require(middlewares.ActionTokensCreate), verifySession, canWriteUsers))
+	r.Methods("GET").Path("/users/{user_id}/tokens").HandlerFunc(middlewares.Chain(accessTokenController.GetPersonalAccessTokens, verifySession, canReadUsers))
+	r.Methods("DELETE").Path("/users/{user_id}/tokens/{token_id}").HandlerFunc(middlewares.Chain(accessTokenController.RevokePersonalAccessToken, notImpersonating, verifySession, canWriteUsers))
+	r.Methods("GET").Path("/users/{user_id}/mfa").HandlerFunc(middlewares.Chain(mfaController.GetMFA, verifySession, canReadUsers))
+	r.Methods("DELETE").Path("/users/{user_id}/mfa").HandlerFunc(middlewares.Chain(mfaController.ResetMFA, notImpersonating, verifySession, canWriteUsers))
+	r.Methods("POST").Path("/users/{user_id}/mfa/totp").HandlerFunc(middlewares.Chain(mfaController.EnrollMFA, notImpersonating, verified.Require(middlewares.ActionMFAEnroll), verifySession, canWriteUsers))
+	r.Methods("POST").Path("/users/{user_id}/mfa/totp/confirm").HandlerFunc(middlewares.Chain(mfaController.ConfirmMFA, notImpersonating, verifySession, canWriteUsers))
+	r.Methods("POST").Path("/users/{user_id}/mfa/recovery-codes").HandlerFunc(middlewares.Chain(mfaController.RegenerateRecoveryCodes, notImpersonating, verifySession, canWriteUsers))
+	r.Methods("GET").Path("/users/{user_id}/apps").HandlerFunc(middlewares.Chain(authorizationController.GetAuthorizedApps, verifySession, canReadUsers))
+	r.Methods("DELETE").Path("/users/{user_id}/apps/{client_id}").HandlerFunc(middlewares.Chain(authorizationController.RevokeAuthorizedApp, verifySession, canWriteUsers))
+	r.Methods("GET").Path("/users/{user_id}/trash").HandlerFunc(middlewares.Chain(workoutController.GetTrash, verifySession, canReadWorkouts, readWorkouts))
 
 	// Workouts APIs
-	r.Methods("POST").Path("/users/{user_id}/workouts").HandlerFunc(middlewares.Chain(workoutController.CreateWorkout, verifySession))
-	r.Methods("GET").Path("/users/{user_id}/workouts").HandlerFunc(middlewares.Chain(workoutController.GetWorkouts, verifySession))
-	r.Methods("GET").Path("/users/{user_id}/workouts/{workout_id}").HandlerFunc(middlewares.Chain(workoutController.GetWorkout, verifySession))
-	r.Methods("PATCH").Path("/users/{user_id}/workouts/{workout_id}").HandlerFunc(middlewares.Chain(workoutController.UpdateWorkout, verifySession))
-	r.Methods("DELETE").Path("/users/{user_id}/workouts/{workout_id}").HandlerFunc(middlewares.Chain(workoutController.DeleteWorkout, verifySession))
+	r.Methods("POST").Path("/users/{user_id}/workouts").HandlerFunc(middlewares.Chain(workoutController.CreateWorkout, verified.Require(middlewares.ActionWorkoutsWrite), verifySession, canWriteWorkouts, writeWorkouts))
+	r.Methods("GET").Path("/users/{user_id}/workouts").HandlerFunc(middlewares.Chain(workoutController.GetWorkouts, verifySession, canReadWorkouts, readWorkouts))
+	r.Methods("GET").Path("/users/{user_id}/workouts/{workout_id}").HandlerFunc(middlewares.Chain(workoutController.GetWorkout, verifySession, canReadWorkouts, readWorkouts))
+	r.Methods("PATCH").Path("/users/{user_id}/workouts/{workout_id}").HandlerFunc(middlewares.Chain(workoutController.UpdateWorkout, verified.Require(middlewares.ActionWorkoutsWrite), verifySession, canWriteWorkouts, writeWorkouts))
+	r.Methods("DELETE").Path("/users/{user_id}/workouts/{workout_id}").HandlerFunc(middlewares.Chain(workoutController.DeleteWorkout, verified.Require(middlewares.ActionWorkoutsWrite), verifySession, canWriteWorkouts, writeWorkouts))
+	r.Methods("POST").Path("/users/{user_id}/workouts/{workout_id}/restore").HandlerFunc(middlewares.Chain(workoutController.RestoreWorkout, verified.Require(middlewares.ActionWorkoutsWrite), verifySession, canWriteWorkouts, writeWorkouts))
+	r.Methods("GET").Path("/users/{user_id}/workouts/{workout_id}/revisions").HandlerFunc(middlewares.Chain(workoutController.GetWorkoutRevisions, verifySession, canReadWorkouts, readWorkouts))
+	r.Methods("GET").Path("/users/{user_id}/workouts/{workout_id}/revisions/diff").HandlerFunc(middlewares.Chain(workoutController.GetWorkoutRevisionDiff, verifySession, canReadWorkouts, readWorkouts))
+	r.Methods("POST").Path("/users/{user_id}/workouts/{workout_id}/revisions/{revision:[0-9]+}/revert").HandlerFunc(middlewares.Chain(workoutController.RevertWorkout, verified.Require(middlewares.ActionWorkoutsWrite), verifySession, canWriteWorkouts, writeWorkouts))
+
+	// Admin APIs
+	r.Methods("GET").Path("/admin/passwords").HandlerFunc(middlewares.Chain(adminController.GetPasswordReport, verifySession, canReportPasswords))
+	r.Methods("POST").Path("/admin/users/{user_id}/unlock").HandlerFunc(middlewares.Chain(adminController.UnlockUser, verifySession, canManageUsers))
+	r.Methods("POST").Path("/admin/users/{user_id}/suspend").HandlerFunc(middlewares.Chain(accountController.SuspendUser, verifySession, canManageUsers))
+	r.Methods("POST").Path("/admin/users/{user_id}/impersonate").HandlerFunc(middlewares.Chain(impersonationController.StartImpersonation, verifySession, canImpersonate))
+	r.Methods("POST").Path("/admin/users/{user_id}/reactivate").HandlerFunc(middlewares.Chain(accountController.ReactivateUser, verifySession, canManageUsers))
+	r.Methods("GET").Path("/admin/permissions").HandlerFunc(middlewares.Chain(roleController.GetPermissions, verifySession, canReadRoles))
+	r.Methods("GET").Path("/admin/roles").HandlerFunc(middlewares.Chain(roleController.GetRoles, verifySession, canReadRoles))
+	r.Methods("POST").Path("/admin/roles").HandlerFunc(middlewares.Chain(roleController.CreateRole, verifySession, canWriteRoles))
+	r.Methods("GET").Path("/admin/roles/{role_id:[0-9]+}").HandlerFunc(middlewares.Chain(roleController.GetRole, verifySession, canReadRoles))
+	r.Methods("PUT").Path("/admin/roles/{role_id:[0-9]+}").HandlerFunc(middlewares.Chain(roleController.UpdateRole, verifySession, canWriteRoles))
+	r.Methods("DELETE").Path("/admin/roles/{role_id:[0-9]+}").HandlerFunc(middlewares.Chain(roleController.DeleteRole, verifySession, canWriteRoles))
+	r.Methods("PUT").Path("/admin/users/{user_id}/roles/{role_id:[0-9]+}").HandlerFunc(middlewares.Chain(roleController.AddUserRole, verifySession, canWriteRoles))
+	r.Methods("DELETE").Path("/admin/users/{user_id}/roles/{role_id:[0-9]+}").HandlerFunc(middlewares.Chain(roleController.RemoveUserRole, verifySession, canWriteRoles))
+	r.Methods("GET").Path("/admin/oauth/clients").HandlerFunc(middlewares.Chain(authorizationController.GetOAuthClients, verifySession, canReadClients))
+	r.Methods("POST").Path("/admin/oauth/clients").HandlerFunc(middlewares.Chain(authorizationController.CreateOAuthClient, verifySession, canWriteClients))
+	r.Methods("DELETE").Path("/admin/oauth/clients/{client_id}").HandlerFunc(middlewares.Chain(authorizationController.DeleteOAuthClient, verifySession, canWriteClients))
 
 	headersOk := handlers.AllowedHeaders([]string{
 		"Access-Control-Allow-Origin",
@@ -95,11 +248,14 @@ func main() {
 		"Access-Control-Request-Method",
 		"Access-Control-Request-Headers",
 		"Accept-Encoding",
+		"Authorization",
 		"Connection",
 		"Content-Language",
 		"Content-Type",
+		"If-Match",
 		"Origin",
 		"X-Requested-With",
+		auth.CSRFHeader,
 	})
 	originsOk := handlers.AllowedOrigins([]string{"http://localhost:3000"})
 	methodsOk := handlers.AllowedMethods([]string{
@@ -112,12 +268,14 @@ func main() {
 		http.MethodHead,
 	})
 	allowCredentials := handlers.AllowCredentials()
+	exposedHeaders := handlers.ExposedHeaders([]string{"ETag", "Link"})
 
 	cors := handlers.CORS(
 		originsOk,
 		headersOk,
 		methodsOk,
 		allowCredentials,
+		exposedHeaders,
 	)
 	handler := cors(r)
 
@@ -163,3 +321,224 @@ func main() {
 	}
 	slog.Info("server gracefully stopped")
 }
+
+// newSessionStore keeps sessions in postgres unless SANDBOX_SESSION_STORE is
+// cookie, which keeps the older signed cookie sessions that cannot be revoked.
+func newSessionStore(sessions dao.SessionRepository, events dao.SecurityRepository) auth.SessionStore {
+	if os.Getenv("SANDBOX_SESSION_STORE") == "cookie" {
+		return auth.NewStandardSessionStore()
+	}
+
+	ttl := time.Hour
+	if v := os.Getenv("SANDBOX_SESSION_TTL"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("invalid SANDBOX_SESSION_TTL. %s", err)
+		}
+	}
+
+	return auth.NewPostgresSessionStore(sessions, events, ttl)
+}
+
+// tokenConfig reads the access and refresh token settings from the
+// environment, keeping the defaults for anything unset.
+func tokenConfig() auth.TokenConfig {
+	cfg := auth.TokenConfig{Issuer: os.Getenv("SANDBOX_TOKEN_ISSUER")}
+	for name, dest := range map[string]*time.Duration{
+		"SANDBOX_ACCESS_TOKEN_TTL":     &cfg.AccessTTL,
+		"SANDBOX_REFRESH_TOKEN_TTL":    &cfg.RefreshTTL,
+		"SANDBOX_SIGNING_KEY_ROTATION": &cfg.KeyRotation,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s. %s", name, v)
+			}
+			*dest = d
+		}
+	}
+
+	return cfg
+}
+
+// newMailer sends emails through the SANDBOX_MAILER driver, which logs them
+// when unset.
+func newMailer() mail.Mailer {
+	mailer, err := mail.New(mail.Config{
+		Driver:       os.Getenv("SANDBOX_MAILER"),
+		From:         os.Getenv("SANDBOX_MAIL_FROM"),
+		Dir:          os.Getenv("SANDBOX_MAIL_DIR"),
+		SMTPAddr:     os.Getenv("SANDBOX_SMTP_ADDR"),
+		SMTPUsername: os.Getenv("SANDBOX_SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SANDBOX_SMTP_PASSWORD"),
+	})
+	if err != nil {
+		log.Fatalf("failed to configure mailer. %s", err)
+	}
+
+	return mailer
+}
+
+// emailVerifier reads the verification link settings from the environment,
+// keeping the defaults for anything unset.
+func emailVerifier(users dao.UserRepository, mailer mail.Mailer) *auth.EmailVerifier {
+	cfg := auth.VerificationConfig{URL: os.Getenv("SANDBOX_VERIFY_URL")}
+	for name, dest := range map[string]*time.Duration{
+		"SANDBOX_VERIFICATION_TTL":             &cfg.TTL,
+		"SANDBOX_VERIFICATION_RESEND_INTERVAL": &cfg.ResendInterval,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s. %s", name, v)
+			}
+			*dest = d
+		}
+	}
+
+	return auth.NewEmailVerifier(users, mailer, cfg)
+}
+
+// passwordResetConfig reads the forgotten password link settings from the
+// environment, keeping the defaults for anything unset.
+func passwordResetConfig() auth.PasswordResetConfig {
+	cfg := auth.PasswordResetConfig{URL: os.Getenv("SANDBOX_PASSWORD_RESET_URL")}
+	for name, dest := range map[string]*time.Duration{
+		"SANDBOX_PASSWORD_RESET_TTL":             &cfg.TTL,
+		"SANDBOX_PASSWORD_RESET_RESEND_INTERVAL": &cfg.ResendInterval,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s. %s", name, v)
+			}
+			*dest = d
+		}
+	}
+
+	return cfg
+}
+
+// verifiedGate holds back the comma separated actions in
+// SANDBOX_REQUIRE_VERIFIED until the user has verified their email address.
+func verifiedGate(users dao.UserRepository) middlewares.VerifiedGate {
+	actions := []string{}
+	for _, action := range strings.Split(os.Getenv("SANDBOX_REQUIRE_VERIFIED"), ",") {
+		action = strings.TrimSpace(action)
+		if action == "" {
+			continue
+		}
+		if !slices.Contains(middlewares.VerifiedActions, action) {
+			log.Fatalf("invalid SANDBOX_REQUIRE_VERIFIED action. %s", action)
+		}
+		actions = append(actions, action)
+	}
+
+	return middlewares.NewVerifiedGate(users, actions)
+}
+
+// lockoutConfig reads the failed login limits from the environment, keeping
+// the defaults for anything unset.
+func lockoutConfig() auth.LockoutConfig {
+	cfg := auth.LockoutConfig{}
+	for name, dest := range map[string]*int{
+		"SANDBOX_LOGIN_MAX_FAILURES":    &cfg.MaxAccountFailures,
+		"SANDBOX_LOGIN_MAX_IP_FAILURES": &cfg.MaxIPFailures,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("invalid %s. %s", name, v)
+			}
+			*dest = n
+		}
+	}
+	for name, dest := range map[string]*time.Duration{
+		"SANDBOX_LOGIN_LOCKOUT":        &cfg.Lockout,
+		"SANDBOX_LOGIN_FAILURE_WINDOW": &cfg.Window,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("invalid %s. %s", name, v)
+			}
+			*dest = d
+		}
+	}
+
+	return cfg
+}
+
+// argon2Params reads the password hashing cost from the environment, keeping
+// the defaults for anything unset.
+func argon2Params() crypt.Argon2Params {
+	p := crypt.DefaultArgon2Params
+	for name, dest := range map[string]*uint32{
+		"SANDBOX_ARGON2_MEMORY_KIB": &p.Memory,
+		"SANDBOX_ARGON2_TIME":       &p.Time,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || n == 0 {
+				log.Fatalf("invalid %s. %s", name, v)
+			}
+			*dest = uint32(n)
+		}
+	}
+	if v := os.Getenv("SANDBOX_ARGON2_THREADS"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || n == 0 {
+			log.Fatalf("invalid SANDBOX_ARGON2_THREADS. %s", v)
+		}
+		p.Threads = uint8(n)
+	}
+
+	return p
+}
+
+// requestUserID keys read-your-writes on the user making the request.
+func requestUserID(ctx context.Context) string {
+	if rc := request.GetRequestContext(ctx); rc != nil {
+		return rc.UserID
+	}
+	return ""
+}
+
+// oauthProviders builds the sign in providers from SANDBOX_AUTH_PROVIDERS_FILE.
+// Google can still be configured with the GOOGLE_OAUTH_* variables alone.
+func oauthProviders() (*oidc.Registry, error) {
+	cfgs := []oidc.Config{}
+	if path := os.Getenv("SANDBOX_AUTH_PROVIDERS_FILE"); path != "" {
+		var err error
+		if cfgs, err = oidc.LoadConfigs(path); err != nil {
+			return nil, err
+		}
+	}
+
+	registry, err := oidc.NewRegistry(cfgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	if id := os.Getenv("GOOGLE_OAUTH_CLIENT_ID"); id != "" {
+		if _, ok := registry.Get("google"); ok {
+			return nil, errors.New("google is configured in both SANDBOX_AUTH_PROVIDERS_FILE and GOOGLE_OAUTH_CLIENT_ID")
+		}
+		redirect := os.Getenv("GOOGLE_OAUTH_REDIRECT_URL")
+		if redirect == "" {
+			redirect = "http://localhost:8000/auth/google/callback"
+		}
+		err := registry.Add(oidc.Config{
+			Name:         "google",
+			DiscoveryURL: "https://accounts.google.com",
+			ClientID:     id,
+			ClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
+			RedirectURL:  redirect,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
@@ -0,0 +1,27 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+// CSRF refuses state-changing requests made with a session cookie unless they
+// carry the token from /auth/csrf. SameSite cookies alone do not stop other
+// sites on the same site, or browsers that ignore the attribute.
+func CSRF(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if auth.NeedsCSRF(r) {
+			if err := auth.CheckCSRF(r); err != nil {
+				slog.WarnContext(ctx, "csrf check failed", "method", r.Method, "path", r.URL.Path)
+				request.RespondWithErrorCode(w, http.StatusForbidden, auth.CodeCSRF, err.Error())
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
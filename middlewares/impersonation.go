@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/slham/sandbox-api/request"
+)
+
+// BlockImpersonation keeps admins who are impersonating a user away from the
+// user's credentials and account. It needs the session, so it is listed
+// before Verify in Chain.
+func BlockImpersonation(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if request.IsImpersonating(ctx) {
+			slog.WarnContext(ctx, "impersonator blocked", "path", r.URL.Path)
+			request.RespondWithErrorCode(w, http.StatusForbidden, auth.CodeImpersonating, "not allowed while impersonating")
+			return
+		}
+
+		f(w, r)
+	}
+}
@@ -36,13 +36,17 @@ type ContextHandler struct {
 }
 
 // Handle adds contextual attributes to the Record before calling the underlying
-// handler
+// handler. Once the session is verified that includes who the request acts as
+// and who is really making it, which differ during an impersonation.
 func (h ContextHandler) Handle(ctx context.Context, r slog.Record) error {
 	if attrs, ok := ctx.Value(slogFields).([]slog.Attr); ok {
 		for _, v := range attrs {
 			r.AddAttrs(v)
 		}
 	}
+	if rc := request.GetRequestContext(ctx); rc != nil && rc.UserID != "" {
+		r.AddAttrs(slog.String("effective_user_id", rc.UserID), slog.String("real_user_id", rc.RealUserID))
+	}
 
 	return h.Handler.Handle(ctx, r)
 }
@@ -86,7 +90,7 @@ func LoggingInbound(h http.Handler) http.Handler {
 		ctx := initContext(r)
 		r = r.WithContext(ctx)
 
-		slog.DebugContext(ctx, "inbound", "headers", fmt.Sprintf("%v", r.Header))
+		slog.DebugContext(ctx, "inbound", "headers", fmt.Sprintf("%v", request.RedactHeaders(r.Header)))
 		slog.DebugContext(ctx, "inbound", "remote", r.RemoteAddr, "method", r.Method, "url", r.URL.Path)
 		h.ServeHTTP(w, r)
 	})
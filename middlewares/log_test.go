@@ -0,0 +1,32 @@
+//go:build unit
+// +build unit
+
+package middlewares
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/slham/sandbox-api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggingInboundRedactsCredentials(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(ContextHandler{slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})}))
+
+	pat := auth.PersonalAccessTokenPrefix + "s3cr3t"
+	r := httptest.NewRequest("GET", "/users/user_1/workouts", nil)
+	r.Header.Set("Authorization", "Bearer "+pat)
+	r.AddCookie(&http.Cookie{Name: "sandbox-cookie", Value: "c00kie"})
+
+	LoggingInbound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Contains(t, logs.String(), "[REDACTED]")
+	assert.NotContains(t, logs.String(), pat)
+	assert.NotContains(t, logs.String(), "c00kie")
+}
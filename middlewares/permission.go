@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/slham/sandbox-api/request"
+)
+
+// RequirePermission lets users whose roles grant permission use the route. On
+// another user's resources they need its :any form. Like RequireScope it is
+// listed after Verify in Chain.
+func RequirePermission(permission string) Middleware {
+	return func(f http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			rc := request.GetRequestContext(ctx)
+			if rc == nil {
+				rc = &request.RequestContext{}
+				r = r.WithContext(request.WithRequestContext(ctx, rc))
+			}
+			rc.RequiredPermission = permission
+			f(w, r)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/slham/sandbox-api/request"
+)
+
+// RequireScope lets personal access tokens with scope use the route. It has to
+// run before the session is verified, so it is listed after Verify in Chain.
+func RequireScope(scope string) Middleware {
+	return func(f http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			rc := request.GetRequestContext(ctx)
+			if rc == nil {
+				rc = &request.RequestContext{}
+				r = r.WithContext(request.WithRequestContext(ctx, rc))
+			}
+			rc.RequiredScope = scope
+			f(w, r)
+		}
+	}
+}
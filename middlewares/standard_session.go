@@ -7,7 +7,7 @@ import (
 	"github.com/slham/sandbox-api/request"
 )
 
-func Establish(store *auth.StandardSessionStore) Middleware {
+func Establish(store auth.SessionStore) Middleware {
 	return func(f http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			store.EstablishSession(w, r)
@@ -19,7 +19,7 @@ func Establish(store *auth.StandardSessionStore) Middleware {
 	}
 }
 
-func Verify(store *auth.StandardSessionStore) Middleware {
+func Verify(store auth.SessionStore) Middleware {
 	return func(f http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			store.VerifySession(w, r)
@@ -31,7 +31,7 @@ func Verify(store *auth.StandardSessionStore) Middleware {
 	}
 }
 
-func Terminate(store *auth.StandardSessionStore) Middleware {
+func Terminate(store auth.SessionStore) Middleware {
 	return func(f http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			store.TerminateSession(w, r)
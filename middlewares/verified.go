@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/model"
+	"github.com/slham/sandbox-api/request"
+)
+
+// Actions SANDBOX_REQUIRE_VERIFIED can hold back until a user has verified
+// their email address.
+const (
+	ActionWorkoutsWrite = "workouts:write"
+	ActionTokensCreate  = "tokens:create"
+	ActionMFAEnroll     = "mfa:enroll"
+)
+
+var VerifiedActions = []string{ActionWorkoutsWrite, ActionTokensCreate, ActionMFAEnroll}
+
+// VerifiedGate keeps users who have not verified their email address from
+// the actions it is configured with.
+type VerifiedGate struct {
+	users   dao.UserRepository
+	actions map[string]bool
+}
+
+func NewVerifiedGate(users dao.UserRepository, actions []string) VerifiedGate {
+	g := VerifiedGate{users: users, actions: map[string]bool{}}
+	for _, action := range actions {
+		if action != "" {
+			g.actions[action] = true
+		}
+	}
+
+	return g
+}
+
+// Require stops unverified users from taking action, if the gate covers it.
+// Callers who can change any user are let through. It needs the session, so it is listed before Verify
+// in Chain.
+func (g VerifiedGate) Require(action string) Middleware {
+	return func(f http.HandlerFunc) http.HandlerFunc {
+		if !g.actions[action] {
+			return f
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			rc := request.GetRequestContext(ctx)
+			if rc == nil || rc.UserID == "" {
+				request.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if slices.Contains(rc.Permissions, model.PermissionUsersWriteAny) {
+				f(w, r)
+				return
+			}
+
+			user, err := g.users.GetUserByID(ctx, rc.UserID)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to get user for verification check", "err", err)
+				request.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			if !user.IsVerified {
+				slog.WarnContext(ctx, "unverified user blocked", "action", action)
+				request.RespondWithError(w, http.StatusForbidden, "verify your email address to do this")
+				return
+			}
+
+			f(w, r)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/slham/sandbox-api/dao"
+	"github.com/slham/sandbox-api/middlewares"
+)
+
+const migrateUsage = "usage: sandbox-api migrate up|down [steps]|status|to <version>"
+
+func migrate(args []string) {
+	if ok := middlewares.Initialize(middlewares.INFO); !ok {
+		log.Fatalf("failed to initialize logging")
+	}
+
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	d, err := dao.Open()
+	if err != nil {
+		log.Fatalf("failed to connect to database. %s", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		err = d.MigrateUp(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+		err = d.MigrateDown(ctx, steps)
+	case "to":
+		if len(args) < 2 {
+			log.Fatal(migrateUsage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			log.Fatal(migrateUsage)
+		}
+		err = d.MigrateTo(ctx, version)
+	case "status":
+		var status []dao.MigrationStatus
+		status, err = d.MigrationStatus(ctx)
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied != nil {
+				applied = s.Applied.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(os.Stdout, "%04d %-32s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+
+	if err != nil {
+		log.Fatalf("failed to migrate. %s", err)
+	}
+}
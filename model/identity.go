@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Identity links a user to an account at an external identity provider.
+// Subject is the provider's stable id for that account.
+type Identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	UserID   string    `json:"user_id"`
+	Email    string    `json:"email,omitempty"`
+	Created  time.Time `json:"created"`
+}
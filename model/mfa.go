@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// MFA is a user's TOTP enrollment. It only guards logins once it has been
+// confirmed with a first code.
+type MFA struct {
+	UserID    string     `json:"user_id"`
+	Secret    string     `json:"-"`
+	Created   time.Time  `json:"created"`
+	Confirmed *time.Time `json:"confirmed,omitempty"`
+	// LastCounter is the TOTP time step of the last accepted code, so a code
+	// cannot be replayed.
+	LastCounter int64 `json:"-"`
+	// RecoveryCodes is the number of unused recovery codes.
+	RecoveryCodes int `json:"recovery_codes"`
+}
+
+type MFARecoveryCode struct {
+	ID       string
+	UserID   string
+	CodeHash string
+	Used     *time.Time
+}
+
+// MFAChallenge is handed out by a password login of a user with MFA on, and
+// exchanged for a session once a code has been given.
+type MFAChallenge struct {
+	ID        string
+	TokenHash string
+	UserID    string
+	Created   time.Time
+	Expires   time.Time
+	Attempts  int
+}
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// OAuthClient is a third-party app registered to ask users for access to
+// their account. Public clients, such as mobile apps, cannot keep a secret
+// and rely on PKCE alone.
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	SecretHash   string    `json:"-"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Created      time.Time `json:"created"`
+}
+
+// OAuthCode is an authorization code, handed to the client through the
+// user's browser and swapped for tokens once. Only its hash is stored.
+type OAuthCode struct {
+	ID            string
+	CodeHash      string
+	ClientID      string
+	UserID        string
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Created       time.Time
+	Expires       time.Time
+	Used          *time.Time
+}
+
+// OAuthGrant records the scopes a user has let a client have. Revoking it
+// cuts the client off.
+type OAuthGrant struct {
+	UserID     string    `json:"user_id"`
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
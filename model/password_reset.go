@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// PasswordReset is a single use token mailed to a user who forgot their
+// password.
+type PasswordReset struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	Created   time.Time
+	Expires   time.Time
+	Used      *time.Time
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"slices"
+	"strings"
+)
+
+// Permissions a role can grant. Those on a user's own resources have an :any
+// form that reaches every user's.
+const (
+	PermissionUsersList        = "users:list"
+	PermissionUsersRead        = "users:read"
+	PermissionUsersReadAny     = "users:read:any"
+	PermissionUsersWrite       = "users:write"
+	PermissionUsersWriteAny    = "users:write:any"
+	PermissionUsersManage      = "users:manage"
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionWorkoutsRead     = "workouts:read"
+	PermissionWorkoutsReadAny  = "workouts:read:any"
+	PermissionWorkoutsWrite    = "workouts:write"
+	PermissionWorkoutsWriteAny = "workouts:write:any"
+	PermissionPasswordsReport  = "passwords:report"
+	PermissionRolesRead        = "roles:read"
+	PermissionRolesWrite       = "roles:write"
+	PermissionClientsRead      = "clients:read"
+	PermissionClientsWrite     = "clients:write"
+)
+
+var Permissions = []string{
+	PermissionUsersList,
+	PermissionUsersRead,
+	PermissionUsersReadAny,
+	PermissionUsersWrite,
+	PermissionUsersWriteAny,
+	PermissionUsersManage,
+	PermissionUsersImpersonate,
+	PermissionWorkoutsRead,
+	PermissionWorkoutsReadAny,
+	PermissionWorkoutsWrite,
+	PermissionWorkoutsWriteAny,
+	PermissionPasswordsReport,
+	PermissionRolesRead,
+	PermissionRolesWrite,
+	PermissionClientsRead,
+	PermissionClientsWrite,
+}
+
+const anyPermissionSuffix = ":any"
+
+func ValidPermission(permission string) bool {
+	return slices.Contains(Permissions, permission)
+}
+
+// AnyPermission returns the form of permission that reaches every user's
+// resources, or permission itself when it has no such form.
+func AnyPermission(permission string) string {
+	if strings.HasSuffix(permission, anyPermissionSuffix) || !ValidPermission(permission+anyPermissionSuffix) {
+		return permission
+	}
+	return permission + anyPermissionSuffix
+}
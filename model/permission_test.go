@@ -0,0 +1,18 @@
+//go:build unit
+// +build unit
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnyPermission(t *testing.T) {
+	assert.Equal(t, PermissionWorkoutsWriteAny, AnyPermission(PermissionWorkoutsWrite))
+	assert.Equal(t, PermissionWorkoutsWriteAny, AnyPermission(PermissionWorkoutsWriteAny))
+	assert.Equal(t, PermissionUsersManage, AnyPermission(PermissionUsersManage))
+	assert.True(t, ValidPermission(PermissionRolesWrite))
+	assert.False(t, ValidPermission("roles:write:any"))
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// Scopes a personal access token can be limited to.
+const (
+	ScopeWorkoutsRead  = "workouts:read"
+	ScopeWorkoutsWrite = "workouts:write"
+	ScopeProfileRead   = "profile:read"
+)
+
+var Scopes = []string{ScopeWorkoutsRead, ScopeWorkoutsWrite, ScopeProfileRead}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// PersonalAccessToken is a long lived token a user mints for their own
+// automation. Like sessions, only the token's hash is stored.
+type PersonalAccessToken struct {
+	ID        string   `json:"id"`
+	UserID    string   `json:"user_id"`
+	Name      string   `json:"name"`
+	TokenHash string   `json:"-"`
+	Roles     []string `json:"-"`
+	// Permissions are those the user's roles grant, loaded with the token.
+	Permissions []string `json:"-"`
+	// Account is the state of the user's account, loaded with the token.
+	Account  AccountState `json:"-"`
+	Scopes   []string     `json:"scopes"`
+	Created  time.Time    `json:"created"`
+	Expires  *time.Time   `json:"expires,omitempty"`
+	LastUsed *time.Time   `json:"last_used,omitempty"`
+	Revoked  *time.Time   `json:"revoked,omitempty"`
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// WorkoutRevision is a snapshot of a workout's name and exercises as they
+// were after one change.
+type WorkoutRevision struct {
+	WorkoutID string    `json:"workout_id"`
+	Revision  int       `json:"revision"`
+	Author    string    `json:"author"`
+	Name      string    `json:"name"`
+	Exercises Exercises `json:"exercises,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+type Change[T any] struct {
+	From T `json:"from"`
+	To   T `json:"to"`
+}
+
+type WorkoutDiff struct {
+	From      int             `json:"from"`
+	To        int             `json:"to"`
+	Name      *Change[string] `json:"name,omitempty"`
+	Exercises []ExerciseDiff  `json:"exercises"`
+}
+
+// ExerciseDiff describes one exercise that differs between two revisions.
+// Added and removed exercises carry the whole exercise, changed ones only the
+// parts that changed.
+type ExerciseDiff struct {
+	Name      string            `json:"name"`
+	Change    string            `json:"change"`
+	Exercise  *Exercise         `json:"exercise,omitempty"`
+	Muscles   *Change[[]Muscle] `json:"muscles,omitempty"`
+	SuperSets *Change[[]string] `json:"superSets,omitempty"`
+	Sets      []SetDiff         `json:"sets,omitempty"`
+}
+
+// SetDiff describes a set that differs at the same position in an exercise.
+type SetDiff struct {
+	Index  int    `json:"index"`
+	Change string `json:"change"`
+	From   *Set   `json:"from,omitempty"`
+	To     *Set   `json:"to,omitempty"`
+}
+
+// DiffRevisions compares two revisions of a workout. Exercises are matched by
+// name, in order when a name repeats, and sets are compared by position.
+func DiffRevisions(from, to WorkoutRevision) WorkoutDiff {
+	diff := WorkoutDiff{From: from.Revision, To: to.Revision, Exercises: []ExerciseDiff{}}
+	if from.Name != to.Name {
+		diff.Name = &Change[string]{From: from.Name, To: to.Name}
+	}
+
+	matched := make([]bool, len(from.Exercises))
+	for _, e := range to.Exercises {
+		i := firstUnmatched(from.Exercises, matched, e.Name)
+		if i < 0 {
+			added := e
+			diff.Exercises = append(diff.Exercises, ExerciseDiff{Name: e.Name, Change: ChangeAdded, Exercise: &added})
+			continue
+		}
+
+		matched[i] = true
+		if d, ok := diffExercise(from.Exercises[i], e); ok {
+			diff.Exercises = append(diff.Exercises, d)
+		}
+	}
+
+	for i, e := range from.Exercises {
+		if !matched[i] {
+			removed := e
+			diff.Exercises = append(diff.Exercises, ExerciseDiff{Name: e.Name, Change: ChangeRemoved, Exercise: &removed})
+		}
+	}
+
+	return diff
+}
+
+func firstUnmatched(exercises []Exercise, matched []bool, name string) int {
+	for i, e := range exercises {
+		if !matched[i] && e.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func diffExercise(from, to Exercise) (ExerciseDiff, bool) {
+	d := ExerciseDiff{Name: to.Name, Change: ChangeChanged}
+	if !slices.Equal(from.Muscles, to.Muscles) {
+		d.Muscles = &Change[[]Muscle]{From: from.Muscles, To: to.Muscles}
+	}
+	if !slices.Equal(from.SuperSets, to.SuperSets) {
+		d.SuperSets = &Change[[]string]{From: from.SuperSets, To: to.SuperSets}
+	}
+
+	for i := range max(len(from.Sets), len(to.Sets)) {
+		switch {
+		case i >= len(from.Sets):
+			d.Sets = append(d.Sets, SetDiff{Index: i, Change: ChangeAdded, To: &to.Sets[i]})
+		case i >= len(to.Sets):
+			d.Sets = append(d.Sets, SetDiff{Index: i, Change: ChangeRemoved, From: &from.Sets[i]})
+		case from.Sets[i] != to.Sets[i]:
+			d.Sets = append(d.Sets, SetDiff{Index: i, Change: ChangeChanged, From: &from.Sets[i], To: &to.Sets[i]})
+		}
+	}
+
+	changed := d.Muscles != nil || d.SuperSets != nil || len(d.Sets) > 0
+	return d, changed
+}
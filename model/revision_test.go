@@ -0,0 +1,44 @@
+//go:build unit
+// +build unit
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffRevisions(t *testing.T) {
+	curl := Exercise{Name: "Curl", Muscles: []Muscle{{Name: "Bicep", MuscleGroup: Arms}}, Sets: []Set{{Weight: 25, Reps: 10}, {Weight: 25, Reps: 8}}}
+	dip := Exercise{Name: "Dip", Sets: []Set{{Reps: 12}}}
+	squat := Exercise{Name: "Squat", Sets: []Set{{Weight: 135, Reps: 5}}}
+
+	heavierCurl := curl
+	heavierCurl.Sets = []Set{{Weight: 30, Reps: 10}, {Weight: 25, Reps: 8}, {Weight: 20, Reps: 12}}
+
+	from := WorkoutRevision{Revision: 1, Name: "Arms", Exercises: Exercises{curl, dip}}
+	to := WorkoutRevision{Revision: 2, Name: "Arms and Legs", Exercises: Exercises{heavierCurl, squat}}
+
+	diff := DiffRevisions(from, to)
+	assert.Equal(t, 1, diff.From)
+	assert.Equal(t, 2, diff.To)
+	assert.Equal(t, &Change[string]{From: "Arms", To: "Arms and Legs"}, diff.Name)
+	assert.Len(t, diff.Exercises, 3)
+
+	changed := diff.Exercises[0]
+	assert.Equal(t, "Curl", changed.Name)
+	assert.Equal(t, ChangeChanged, changed.Change)
+	assert.Nil(t, changed.Muscles)
+	assert.Equal(t, []SetDiff{
+		{Index: 0, Change: ChangeChanged, From: &Set{Weight: 25, Reps: 10}, To: &Set{Weight: 30, Reps: 10}},
+		{Index: 2, Change: ChangeAdded, To: &Set{Weight: 20, Reps: 12}},
+	}, changed.Sets)
+
+	assert.Equal(t, ExerciseDiff{Name: "Squat", Change: ChangeAdded, Exercise: &squat}, diff.Exercises[1])
+	assert.Equal(t, ExerciseDiff{Name: "Dip", Change: ChangeRemoved, Exercise: &dip}, diff.Exercises[2])
+
+	same := DiffRevisions(from, from)
+	assert.Nil(t, same.Name)
+	assert.Empty(t, same.Exercises)
+}
@@ -3,10 +3,13 @@ package model
 import "time"
 
 type Role struct {
-	ID      int       `json:"id"`
-	Name    string    `json:"name"`
-	Created time.Time `json:"created"`
-	Updated time.Time `json:"updated"`
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	// IsDefault roles are given to every new user.
+	IsDefault bool      `json:"isDefault,omitempty"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
 }
 
 type UserRole struct {
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// Kinds of SecurityEvent.
+const (
+	SecurityEventAccountLocked      = "account_locked"
+	SecurityEventIPLocked           = "ip_locked"
+	SecurityEventAccountUnlocked    = "account_unlocked"
+	SecurityEventAccountSuspended   = "account_suspended"
+	SecurityEventAccountReactivated = "account_reactivated"
+	SecurityEventAccountDeactivated = "account_deactivated"
+	SecurityEventImpersonationStart = "impersonation_started"
+	SecurityEventImpersonationEnd   = "impersonation_ended"
+)
+
+// LoginFailure counts recent failed logins against a username or a client IP.
+type LoginFailure struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil *time.Time
+}
+
+// SecurityEvent is a row of the security log.
+type SecurityEvent struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// UserID is empty when the event is not about a known user, such as a
+	// lockout of a username nobody has.
+	UserID  string    `json:"user_id,omitempty"`
+	Subject string    `json:"subject,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	ActorID string    `json:"actor_id,omitempty"`
+	Created time.Time `json:"created"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Session is a login held on the server. The token that identifies it to the
+// client is never stored, only its hash.
+type Session struct {
+	ID        string `json:"id"`
+	TokenHash string `json:"-"`
+	UserID    string `json:"user_id"`
+	// ImpersonatorID is the admin acting as the user in an impersonation
+	// session.
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+	// ImpersonatorPermissions and ImpersonatorAccount are the admin's, loaded
+	// with an impersonation session so it ends once they lose either.
+	ImpersonatorPermissions []string     `json:"-"`
+	ImpersonatorAccount     AccountState `json:"-"`
+	Roles                   []string     `json:"-"`
+	// Permissions are those the user's roles grant, loaded with the session.
+	Permissions []string `json:"-"`
+	// Account is the state of the user's account, loaded with the session.
+	Account   AccountState `json:"-"`
+	UserAgent string       `json:"user_agent,omitempty"`
+	IP        string       `json:"ip,omitempty"`
+	Created   time.Time    `json:"created"`
+	LastSeen  time.Time    `json:"last_seen"`
+	Expires   time.Time    `json:"expires"`
+	Revoked   *time.Time   `json:"revoked,omitempty"`
+}
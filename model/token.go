@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// RefreshToken is one link in a chain of refresh tokens. Each use swaps it for
+// a new token in the same family, so a token that comes back after it was
+// used means it was stolen, and the whole family is revoked.
+type RefreshToken struct {
+	ID        string
+	TokenHash string
+	FamilyID  string
+	UserID    string
+	Roles     []string
+	// ClientID is the OAuth client the token was issued to, limited to
+	// Scopes. Tokens from logging in have neither.
+	ClientID string
+	Scopes   []string
+	// Account is the state of the user's account, loaded with the token.
+	Account AccountState
+	Created time.Time
+	Expires time.Time
+	Used    *time.Time
+	Revoked *time.Time
+}
+
+// SigningKey signs access tokens. PrivateKey is encrypted with the server's
+// key and never leaves the server; only its public half is published.
+type SigningKey struct {
+	Kid        string
+	Alg        string
+	PrivateKey string
+	Created    time.Time
+}
@@ -2,15 +2,47 @@ package model
 
 import "time"
 
+// Statuses an account can be in, as reported by AccountState.Status.
+const (
+	AccountActive      = "active"
+	AccountSuspended   = "suspended"
+	AccountDeactivated = "deactivated"
+)
+
+// AccountState decides whether a user can log in and keep using their
+// sessions and tokens.
+type AccountState struct {
+	// IsActive is false once the user deactivates their own account.
+	IsActive bool `json:"isActive,omitempty"`
+	// IsSuspended is set by an admin. A suspension with SuspendedUntil ends
+	// on its own at that time.
+	IsSuspended     bool       `json:"isSuspended,omitempty"`
+	SuspendedReason string     `json:"suspendedReason,omitempty"`
+	SuspendedUntil  *time.Time `json:"suspendedUntil,omitempty"`
+}
+
+// Status is AccountActive, AccountSuspended or AccountDeactivated as of now.
+// A suspension that has run out no longer counts.
+func (a AccountState) Status(now time.Time) string {
+	if a.IsSuspended && (a.SuspendedUntil == nil || a.SuspendedUntil.After(now)) {
+		return AccountSuspended
+	}
+	if !a.IsActive {
+		return AccountDeactivated
+	}
+	return AccountActive
+}
+
 type User struct {
-	ID          string    `json:"id"`
-	Username    string    `json:"username"`
-	Password    string    `json:"password,omitempty"`
-	Email       string    `json:"email"`
-	Created     time.Time `json:"created"`
-	Updated     time.Time `json:"updated"`
-	IsActive    bool      `json:"isActive,omitempty"`
-	IsSuspended bool      `json:"isSuspended,omitempty"`
-	IsVerified  bool      `json:"isVerified,omitempty"`
-	Roles       []Role    `json:"roles,omitempty"`
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	Password   string     `json:"password,omitempty"`
+	Email      string     `json:"email"`
+	Created    time.Time  `json:"created"`
+	Updated    time.Time  `json:"updated"`
+	Version    int        `json:"version"`
+	Deleted    *time.Time `json:"deleted,omitempty"`
+	IsVerified bool       `json:"isVerified,omitempty"`
+	AccountState
+	Roles []Role `json:"roles,omitempty"`
 }
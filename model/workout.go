@@ -8,13 +8,15 @@ import (
 )
 
 type Workout struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	UserID       string    `json:"user_id"`
-	CalendarName string    `json:"calendarName,omitempty"`
-	Created      time.Time `json:"created"`
-	Updated      time.Time `json:"updated"`
-	Exercises    Exercises `json:"exercises,omitempty"`
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	UserID       string     `json:"user_id"`
+	CalendarName string     `json:"calendarName,omitempty"`
+	Created      time.Time  `json:"created"`
+	Updated      time.Time  `json:"updated"`
+	Version      int        `json:"version"`
+	Deleted      *time.Time `json:"deleted,omitempty"`
+	Exercises    Exercises  `json:"exercises,omitempty"`
 }
 
 type Exercise struct {
@@ -0,0 +1,292 @@
+// Package oidctest is a stub OpenID Connect issuer for tests and for trying
+// sign in locally without a real provider. It approves every authorization
+// request at once, as the user named by the login_hint parameter.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"github.com/slham/sandbox-api/jwt"
+)
+
+// DefaultUser is who signs in when the authorization request has no
+// login_hint.
+const DefaultUser = "default"
+
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type grant struct {
+	user        User
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	expires     time.Time
+}
+
+type Issuer struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	mu     sync.Mutex
+	signer jwt.Signer
+	keys   []jwt.JWK
+	users  map[string]User
+	codes  map[string]grant
+	tokens map[string]User
+	mux    *http.ServeMux
+}
+
+// NewIssuer creates an issuer that is served at issuerURL.
+func NewIssuer(issuerURL string, clientID string, clientSecret string) (*Issuer, error) {
+	i := &Issuer{
+		URL:          issuerURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		users:        map[string]User{},
+		codes:        map[string]grant{},
+		tokens:       map[string]User{},
+		mux:          http.NewServeMux(),
+	}
+	if err := i.Rotate(); err != nil {
+		return nil, err
+	}
+
+	i.mux.HandleFunc("GET /.well-known/openid-configuration", i.discovery)
+	i.mux.HandleFunc("GET /authorize", i.authorize)
+	i.mux.HandleFunc("POST /token", i.token)
+	i.mux.HandleFunc("GET /jwks", i.jwks)
+	i.mux.HandleFunc("GET /userinfo", i.userinfo)
+
+	return i, nil
+}
+
+// NewServer starts an issuer on a local port. Close the server when done.
+func NewServer(clientID string, clientSecret string) (*Issuer, *httptest.Server, error) {
+	srv := httptest.NewUnstartedServer(nil)
+	i, err := NewIssuer("", clientID, clientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv.Config.Handler = i
+	srv.Start()
+	i.URL = srv.URL
+
+	return i, srv, nil
+}
+
+func (i *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i.mux.ServeHTTP(w, r)
+}
+
+// AddUser registers who signs in for the login_hint.
+func (i *Issuer) AddUser(loginHint string, user User) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.users[loginHint] = user
+}
+
+// Rotate signs with a new key from now on. The old keys stay published.
+func (i *Issuer) Rotate() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	signer := jwt.Signer{Kid: ksuid.New().String(), Alg: jwt.RS256, Key: key}
+	jwk, err := jwt.NewJWK(signer.Kid, signer.Alg, key.Public())
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.signer = signer
+	i.keys = append(i.keys, jwk)
+
+	return nil
+}
+
+// Sign signs claims with the current key, for tests that need to craft their
+// own ID tokens.
+func (i *Issuer) Sign(claims any) (string, error) {
+	i.mu.Lock()
+	signer := i.signer
+	i.mu.Unlock()
+	return signer.Sign(claims)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"userinfo_endpoint":                     i.URL + "/userinfo",
+		"jwks_uri":                              i.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.RS256},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("client_id") != i.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid client_id or response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "pkce with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	hint := q.Get("login_hint")
+	if hint == "" {
+		hint = DefaultUser
+	}
+
+	i.mu.Lock()
+	user, ok := i.users[hint]
+	code := ksuid.New().String()
+	if ok {
+		i.codes[code] = grant{
+			user:        user,
+			clientID:    q.Get("client_id"),
+			redirectURI: q.Get("redirect_uri"),
+			challenge:   q.Get("code_challenge"),
+			nonce:       q.Get("nonce"),
+			expires:     time.Now().Add(time.Minute),
+		}
+	}
+	i.mu.Unlock()
+
+	back := redirectURI.Query()
+	back.Set("state", q.Get("state"))
+	if ok {
+		back.Set("code", code)
+	} else {
+		back.Set("error", "access_denied")
+	}
+	redirectURI.RawQuery = back.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != i.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(i.ClientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	i.mu.Lock()
+	code := r.PostFormValue("code")
+	g, ok := i.codes[code]
+	delete(i.codes, code)
+	i.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if !ok || time.Now().After(g.expires) || g.clientID != clientID || g.redirectURI != r.PostFormValue("redirect_uri") || challenge != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := i.Sign(map[string]any{
+		"iss":            i.URL,
+		"sub":            g.user.Subject,
+		"aud":            clientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken := ksuid.New().String()
+	i.mu.Lock()
+	i.tokens[accessToken] = g.user
+	i.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	keys := jwt.JWKS{Keys: append([]jwt.JWK{}, i.keys...)}
+	i.mu.Unlock()
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (i *Issuer) userinfo(w http.ResponseWriter, r *http.Request) {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || auth[:len(prefix)] != prefix {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	i.mu.Lock()
+	user, ok := i.tokens[auth[len(prefix):]]
+	i.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            user.Subject,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"name":           user.Name,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
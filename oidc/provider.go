@@ -0,0 +1,406 @@
+// Package oidc signs users in with external OpenID Connect providers, and with
+// plain OAuth2 providers such as GitHub that only offer a userinfo endpoint.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slham/sandbox-api/jwt"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrInvalidConfig  = errors.New("invalid provider config")
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrInvalidNonce   = errors.New("invalid nonce")
+	ErrUserInfo       = errors.New("invalid user info")
+)
+
+const (
+	TypeOIDC   = "oidc"
+	TypeOAuth2 = "oauth2"
+)
+
+// jwksRefreshInterval limits how often an unknown kid refetches the key set,
+// so a flood of forged tokens cannot hammer the provider.
+const jwksRefreshInterval = time.Minute
+
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Config describes one provider. OIDC providers only need DiscoveryURL, which
+// may be the issuer or its /.well-known/openid-configuration document. OAuth2
+// providers have no discovery and need AuthURL, TokenURL and UserInfoURL.
+type Config struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	DiscoveryURL string   `json:"discovery_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+	AuthURL      string   `json:"auth_url"`
+	TokenURL     string   `json:"token_url"`
+	UserInfoURL  string   `json:"user_info_url"`
+}
+
+func (c Config) validate() error {
+	if !providerName.MatchString(c.Name) {
+		return fmt.Errorf("%w. name %q must be lower case letters, digits, - or _", ErrInvalidConfig, c.Name)
+	}
+	if c.ClientID == "" {
+		return fmt.Errorf("%w. %s is missing client_id", ErrInvalidConfig, c.Name)
+	}
+	switch c.Type {
+	case TypeOIDC:
+		if c.DiscoveryURL == "" {
+			return fmt.Errorf("%w. %s is missing discovery_url", ErrInvalidConfig, c.Name)
+		}
+	case TypeOAuth2:
+		if c.AuthURL == "" || c.TokenURL == "" || c.UserInfoURL == "" {
+			return fmt.Errorf("%w. %s needs auth_url, token_url and user_info_url", ErrInvalidConfig, c.Name)
+		}
+	default:
+		return fmt.Errorf("%w. %s has unknown type %q", ErrInvalidConfig, c.Name, c.Type)
+	}
+
+	return nil
+}
+
+// Discovery is the part of the provider's metadata that is used.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// UserInfo is who signed in. EmailVerified is only ever true when the
+// provider vouches for the address.
+type UserInfo struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IDTokenClaims are the claims read from an ID token.
+type IDTokenClaims struct {
+	jwt.Claims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	Email           string `json:"email,omitempty"`
+	EmailVerified   any    `json:"email_verified,omitempty"`
+	Name            string `json:"name,omitempty"`
+}
+
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu          sync.Mutex
+	discovery   *Discovery
+	keys        jwt.JWKS
+	keysFetched time.Time
+}
+
+// NewProvider checks the config. OIDC discovery is fetched on first use so the
+// server starts even when a provider is unreachable.
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.Type == "" {
+		cfg.Type = TypeOIDC
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Scopes) == 0 && cfg.Type == TypeOIDC {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	cfg.DiscoveryURL = strings.TrimSuffix(cfg.DiscoveryURL, "/")
+	if cfg.Type == TypeOIDC && !strings.HasSuffix(cfg.DiscoveryURL, "/.well-known/openid-configuration") {
+		cfg.DiscoveryURL += "/.well-known/openid-configuration"
+	}
+
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// AuthCodeURL is where to send the browser. The verifier's challenge and the
+// nonce are bound to the request so the callback can check them.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	config, err := p.oauth2Config(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if p.cfg.Type == TypeOIDC {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+
+	return config.AuthCodeURL(state, opts...), nil
+}
+
+// Exchange trades the code for tokens and returns the user. For OIDC the ID
+// token must be signed by one of the issuer's keys, be meant for this client
+// and carry the nonce. The userinfo endpoint fills in an email the token
+// lacks.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (UserInfo, error) {
+	config, err := p.oauth2Config(ctx)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("failed to exchange code. %w", err)
+	}
+
+	if p.cfg.Type == TypeOAuth2 {
+		info, err := p.userInfo(ctx, config, token, p.cfg.UserInfoURL)
+		if err != nil {
+			return info, err
+		}
+		// plain oauth2 has no standard way to say the email was verified
+		info.EmailVerified = false
+		return info, nil
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return UserInfo{}, fmt.Errorf("%w. token response has no id_token", ErrInvalidIDToken)
+	}
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	info := UserInfo{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		Name:          claims.Name,
+	}
+	if info.Email != "" {
+		return info, nil
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil || d.UserInfoEndpoint == "" {
+		return info, fmt.Errorf("%w. id token has no email and there is no userinfo endpoint", ErrUserInfo)
+	}
+	fromEndpoint, err := p.userInfo(ctx, config, token, d.UserInfoEndpoint)
+	if err != nil {
+		return info, err
+	}
+	if fromEndpoint.Subject != info.Subject {
+		return info, fmt.Errorf("%w. userinfo is for another subject", ErrUserInfo)
+	}
+
+	return fromEndpoint, nil
+}
+
+// VerifyIDToken checks the ID token's signature against the issuer's JWKS
+// and its iss, aud, azp, exp and nonce claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (IDTokenClaims, error) {
+	claims := IDTokenClaims{}
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return claims, err
+	}
+
+	_, err = jwt.Parse(rawIDToken, &claims, func(h jwt.Header) (crypto.PublicKey, error) {
+		return p.key(ctx, h.Kid)
+	})
+	if err != nil {
+		return claims, fmt.Errorf("%w. %w", ErrInvalidIDToken, err)
+	}
+	if err := claims.Validate(p.now(), d.Issuer, p.cfg.ClientID); err != nil {
+		return claims, fmt.Errorf("%w. %w", ErrInvalidIDToken, err)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return claims, fmt.Errorf("%w. azp is not this client", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return claims, fmt.Errorf("%w. missing sub", ErrInvalidIDToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return claims, ErrInvalidNonce
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) oauth2Config(ctx context.Context) (*oauth2.Config, error) {
+	config := &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+		Endpoint:     oauth2.Endpoint{AuthURL: p.cfg.AuthURL, TokenURL: p.cfg.TokenURL},
+	}
+	if p.cfg.Type == TypeOAuth2 {
+		return config, nil
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config.Endpoint = oauth2.Endpoint{AuthURL: d.AuthorizationEndpoint, TokenURL: d.TokenEndpoint}
+
+	return config, nil
+}
+
+func (p *Provider) getDiscovery(ctx context.Context) (Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	d := Discovery{}
+	if err := p.getJSON(ctx, p.cfg.DiscoveryURL, &d); err != nil {
+		return d, fmt.Errorf("failed to get discovery document. %w", err)
+	}
+	if d.Issuer == "" || d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return d, fmt.Errorf("%w. discovery document for %s is incomplete", ErrInvalidConfig, p.cfg.Name)
+	}
+	p.discovery = &d
+
+	return d, nil
+}
+
+// key finds the signing key, refetching the key set once when the kid is
+// unknown since that is how providers roll their keys.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, err := p.keys.Key(kid)
+	if err == nil || p.now().Sub(p.keysFetched) < jwksRefreshInterval {
+		return key, err
+	}
+
+	keys := jwt.JWKS{}
+	if err := p.getJSON(ctx, d.JWKSURI, &keys); err != nil {
+		return nil, fmt.Errorf("failed to get jwks. %w", err)
+	}
+	p.keys = keys
+	p.keysFetched = p.now()
+
+	return p.keys.Key(kid)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request. %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get %s. %w", url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s. status %d", url, res.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s. %w", url, err)
+	}
+
+	return nil
+}
+
+// userInfo reads the userinfo endpoint. OIDC providers answer with sub, while
+// GitHub and friends use a numeric id.
+func (p *Provider) userInfo(ctx context.Context, config *oauth2.Config, token *oauth2.Token, url string) (UserInfo, error) {
+	info := UserInfo{}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return info, fmt.Errorf("failed to create request. %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := config.Client(ctx, token).Do(req)
+	if err != nil {
+		return info, fmt.Errorf("failed to get user info. %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("failed to get user info. status %d", res.StatusCode)
+	}
+
+	raw := map[string]any{}
+	decoder := json.NewDecoder(io.LimitReader(res.Body, 1<<20))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return info, fmt.Errorf("failed to decode user info. %w", err)
+	}
+
+	info.Subject = stringClaim(raw["sub"])
+	if info.Subject == "" {
+		info.Subject = stringClaim(raw["id"])
+	}
+	info.Email = stringClaim(raw["email"])
+	info.EmailVerified = isTrue(raw["email_verified"])
+	info.Name = stringClaim(raw["name"])
+	if info.Subject == "" || info.Email == "" {
+		return info, fmt.Errorf("%w. missing subject or email", ErrUserInfo)
+	}
+
+	return info, nil
+}
+
+func stringClaim(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// isTrue reads email_verified, which some providers send as a string.
+func isTrue(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+//go:build unit
+// +build unit
+
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/slham/sandbox-api/jwt"
+	"github.com/slham/sandbox-api/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// authorize follows the provider's authorization URL to the stub issuer and
+// returns the code it hands back.
+func authorize(t *testing.T, p *Provider, loginHint string, nonce string, verifier string) string {
+	t.Helper()
+	u, err := p.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	assert.NoError(t, err)
+	u += "&login_hint=" + url.QueryEscape(loginHint)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect from issuer. %d", res.StatusCode)
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "state", location.Query().Get("state"))
+
+	return location.Query().Get("code")
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	issuer, srv, err := oidctest.NewServer("client", "secret")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer srv.Close()
+	issuer.AddUser("alice", oidctest.User{Subject: "sub_alice", Email: "alice@b.c", EmailVerified: true, Name: "Alice"})
+
+	registry, err := NewRegistry(
+		Config{Name: "stub", DiscoveryURL: issuer.URL, ClientID: "client", ClientSecret: "secret", RedirectURL: "http://localhost/auth/stub/callback"},
+		Config{Name: "plain", Type: TypeOAuth2, ClientID: "client", ClientSecret: "secret", RedirectURL: "http://localhost/auth/plain/callback",
+			AuthURL: issuer.URL + "/authorize", TokenURL: issuer.URL + "/token", UserInfoURL: issuer.URL + "/userinfo"},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	p, _ := registry.Get("stub")
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	t.Run("config", func(t *testing.T) {
+		_, err := NewRegistry(Config{Name: "Bad Name", DiscoveryURL: issuer.URL, ClientID: "c"})
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		_, err = NewRegistry(Config{Name: "gh", Type: TypeOAuth2, ClientID: "c"})
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		_, err = NewRegistry(Config{Name: "a", DiscoveryURL: issuer.URL, ClientID: "c"}, Config{Name: "a", DiscoveryURL: issuer.URL, ClientID: "c"})
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+		assert.Equal(t, []string{"plain", "stub"}, registry.Names())
+	})
+
+	t.Run("sign in", func(t *testing.T) {
+		verifier := oauth2.GenerateVerifier()
+		code := authorize(t, p, "alice", "n0nce", verifier)
+		info, err := p.Exchange(ctx, code, verifier, "n0nce")
+		assert.NoError(t, err)
+		assert.Equal(t, UserInfo{Subject: "sub_alice", Email: "alice@b.c", EmailVerified: true, Name: "Alice"}, info)
+
+		// codes are single use
+		_, err = p.Exchange(ctx, code, verifier, "n0nce")
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		code := authorize(t, p, "alice", "n0nce", oauth2.GenerateVerifier())
+		_, err := p.Exchange(ctx, code, oauth2.GenerateVerifier(), "n0nce")
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		verifier := oauth2.GenerateVerifier()
+		code := authorize(t, p, "alice", "n0nce", verifier)
+		_, err := p.Exchange(ctx, code, verifier, "other")
+		assert.ErrorIs(t, err, ErrInvalidNonce)
+	})
+
+	t.Run("id token validation", func(t *testing.T) {
+		claims := func() IDTokenClaims {
+			return IDTokenClaims{
+				Claims: jwt.Claims{Issuer: issuer.URL, Subject: "sub_alice", Audience: jwt.Audience{"client"}, ExpiresAt: now.Add(time.Minute).Unix()},
+				Nonce:  "n0nce",
+			}
+		}
+		sign := func(c IDTokenClaims) string {
+			token, err := issuer.Sign(c)
+			assert.NoError(t, err)
+			return token
+		}
+
+		_, err := p.VerifyIDToken(ctx, sign(claims()), "n0nce")
+		assert.NoError(t, err)
+
+		c := claims()
+		c.Audience = jwt.Audience{"someone-else"}
+		_, err = p.VerifyIDToken(ctx, sign(c), "n0nce")
+		assert.ErrorIs(t, err, jwt.ErrInvalidAudience)
+
+		c = claims()
+		c.Audience = jwt.Audience{"client", "someone-else"}
+		_, err = p.VerifyIDToken(ctx, sign(c), "n0nce")
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+		c.AuthorizedParty = "client"
+		_, err = p.VerifyIDToken(ctx, sign(c), "n0nce")
+		assert.NoError(t, err)
+
+		c = claims()
+		c.Issuer = "https://evil.example.com"
+		_, err = p.VerifyIDToken(ctx, sign(c), "n0nce")
+		assert.ErrorIs(t, err, jwt.ErrInvalidIssuer)
+
+		c = claims()
+		c.ExpiresAt = now.Add(-time.Hour).Unix()
+		_, err = p.VerifyIDToken(ctx, sign(c), "n0nce")
+		assert.ErrorIs(t, err, jwt.ErrExpired)
+
+		// signed with a key the issuer never published
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		forged, err := jwt.Signer{Kid: "forged", Alg: jwt.RS256, Key: key}.Sign(claims())
+		assert.NoError(t, err)
+		_, err = p.VerifyIDToken(ctx, forged, "n0nce")
+		assert.ErrorIs(t, err, jwt.ErrUnknownKey)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		assert.NoError(t, issuer.Rotate())
+
+		// the key set was fetched moments ago, so it is not refetched yet
+		verifier := oauth2.GenerateVerifier()
+		code := authorize(t, p, "alice", "n0nce", verifier)
+		_, err := p.Exchange(ctx, code, verifier, "n0nce")
+		assert.ErrorIs(t, err, jwt.ErrUnknownKey)
+
+		now = now.Add(jwksRefreshInterval)
+		code = authorize(t, p, "alice", "n0nce", verifier)
+		_, err = p.Exchange(ctx, code, verifier, "n0nce")
+		assert.NoError(t, err)
+	})
+
+	t.Run("plain oauth2", func(t *testing.T) {
+		plain, _ := registry.Get("plain")
+		verifier := oauth2.GenerateVerifier()
+		code := authorize(t, plain, "alice", "", verifier)
+		info, err := plain.Exchange(ctx, code, verifier, "")
+		assert.NoError(t, err)
+		assert.Equal(t, UserInfo{Subject: "sub_alice", Email: "alice@b.c", Name: "Alice"}, info)
+	})
+}
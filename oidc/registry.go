@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Registry holds the configured providers by name.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(cfgs ...Config) (*Registry, error) {
+	r := &Registry{providers: map[string]*Provider{}}
+	for _, cfg := range cfgs {
+		if err := r.Add(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Registry) Add(cfg Config) error {
+	if _, ok := r.providers[cfg.Name]; ok {
+		return fmt.Errorf("%w. %s is configured twice", ErrInvalidConfig, cfg.Name)
+	}
+	p, err := NewProvider(cfg)
+	if err != nil {
+		return err
+	}
+	r.providers[cfg.Name] = p
+
+	return nil
+}
+
+func (r *Registry) Get(name string) (*Provider, bool) {
+	if r == nil {
+		return nil, false
+	}
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+func (r *Registry) Names() []string {
+	names := []string{}
+	if r == nil {
+		return names
+	}
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// LoadConfigs reads a JSON array of provider configs. Environment variables
+// in it are expanded, so secrets can stay out of the file.
+func LoadConfigs(path string) ([]Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read providers file. %w", err)
+	}
+
+	cfgs := []Config{}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(b))), &cfgs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal providers file. %w", err)
+	}
+
+	return cfgs, nil
+}
@@ -7,11 +7,23 @@ const (
 )
 
 type RequestContext struct {
-	Stop         bool
-	RequestID    string
+	Stop      bool
+	RequestID string
+	// UserID is the user the request acts as. RealUserID is who is making
+	// it, which is an admin rather than UserID during an impersonation.
 	UserID       string
+	RealUserID   string
 	ClientUserID string
 	Roles        []string
+	// Permissions are those the caller's roles grant, and
+	// RequiredPermission the one the route needs.
+	Permissions        []string
+	RequiredPermission string
+	SessionID          string
+	// RequiredScope is the scope a personal access token needs for the
+	// route, and Scopes those of the token the request was made with.
+	RequiredScope string
+	Scopes        []string
 }
 
 func WithRequestContext(ctx context.Context, rc *RequestContext) context.Context {
@@ -43,3 +55,12 @@ func GetStop(ctx context.Context) bool {
 	}
 	return rc.Stop
 }
+
+// IsImpersonating reports whether an admin is acting as another user.
+func IsImpersonating(ctx context.Context) bool {
+	rc := GetRequestContext(ctx)
+	if rc == nil {
+		return false
+	}
+	return rc.RealUserID != "" && rc.RealUserID != rc.UserID
+}
@@ -10,11 +10,32 @@ func RespondWithError(w http.ResponseWriter, code int, message string) {
 	RespondWithJSON(w, code, map[string]string{"errors": message})
 }
 
+// RespondWithErrorCode is RespondWithError with a code clients can switch on
+// instead of the message.
+func RespondWithErrorCode(w http.ResponseWriter, status int, code string, message string) {
+	RespondWithJSON(w, status, map[string]string{"errors": message, "code": code})
+}
+
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 
 	w.Header().Set("Content-Type", "application/json")
-	slog.Debug("RESPONSE", "status", code, "headers", w.Header())
+	slog.Debug("RESPONSE", "status", code, "headers", RedactHeaders(w.Header()))
 	w.WriteHeader(code)
 	_, _ = w.Write(response)
 }
+
+// sensitiveHeaders carry credentials, which must never reach the logs.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Csrf-Token"}
+
+// RedactHeaders returns a copy of h fit for logging, with the values of
+// headers that carry credentials replaced.
+func RedactHeaders(h http.Header) http.Header {
+	redacted := h.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := redacted[name]; ok {
+			redacted[name] = []string{"[REDACTED]"}
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,29 @@
+//go:build unit
+// +build unit
+
+package request
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer sandbox_pat_secret")
+	h.Set("Cookie", "sandbox-cookie=secret")
+	h.Set("X-CSRF-Token", "secret")
+	h.Set("Content-Type", "application/json")
+
+	redacted := RedactHeaders(h)
+	assert.Equal(t, "[REDACTED]", redacted.Get("Authorization"))
+	assert.Equal(t, "[REDACTED]", redacted.Get("Cookie"))
+	assert.Equal(t, "[REDACTED]", redacted.Get("X-CSRF-Token"))
+	assert.Equal(t, "application/json", redacted.Get("Content-Type"))
+	assert.NotContains(t, redacted.Get("Set-Cookie"), "secret")
+
+	// the request keeps its headers
+	assert.Equal(t, "Bearer sandbox_pat_secret", h.Get("Authorization"))
+}